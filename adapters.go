@@ -0,0 +1,63 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+//WriterTo wraps v so that WriteTo marshals it, the count returned by WriteTo is
+//the number of encoded bytes written. The returned value also implements io.Reader
+//so it can be the source of io.Copy, which then takes the WriteTo path
+func WriterTo(v interface{}, order binary.ByteOrder, length LengthType, opts ...Option) io.WriterTo {
+	return &writerTo{v: v, order: order, length: length, o: newOptions(opts)}
+}
+
+type writerTo struct {
+	v      interface{}
+	order  binary.ByteOrder
+	length LengthType
+	o      *options
+	//buf holds the encoding when read through Read
+	buf *bytes.Reader
+}
+
+func (t *writerTo) Read(p []byte) (int, error) {
+	if t.buf == nil {
+		b := new(bytes.Buffer)
+		if _, err := t.WriteTo(b); err != nil {
+			return 0, err
+		}
+		t.buf = bytes.NewReader(b.Bytes())
+	}
+	return t.buf.Read(p)
+}
+
+func (t *writerTo) WriteTo(w io.Writer) (int64, error) {
+	return encode(t.v, w, t.order, t.length(), t.o)
+}
+
+//ReaderFrom wraps v, which must be a pointer, so that ReadFrom unmarshals into it.
+//The count returned by ReadFrom is the number of bytes the value spanned, ReadFrom
+//reads one value rather than draining r
+func ReaderFrom(v interface{}, order binary.ByteOrder, length LengthType, opts ...Option) io.ReaderFrom {
+	return &readerFrom{v: v, order: order, length: length, o: newOptions(opts)}
+}
+
+type readerFrom struct {
+	v      interface{}
+	order  binary.ByteOrder
+	length LengthType
+	o      *options
+}
+
+func (f *readerFrom) ReadFrom(r io.Reader) (int64, error) {
+	return decode(f.v, r, f.order, f.length(), f.o)
+}
+
+//UnmarshalAt decodes one value into m from r starting at off, and reports how many
+//bytes the value spanned
+func UnmarshalAt(r io.ReaderAt, off int64, m interface{}, order binary.ByteOrder, length LengthType, opts ...Option) (n int64, err error) {
+	return decode(m, io.NewSectionReader(r, off, math.MaxInt64-off), order, length(), newOptions(opts))
+}
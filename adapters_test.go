@@ -0,0 +1,86 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestWriterToReaderFrom(t *testing.T) {
+	result := new(bytes.Buffer)
+	n, e := io.Copy(result, WriterTo(createTestObject(), binary.BigEndian, CompactLength).(io.Reader))
+	if e != nil {
+		t.Fatalf("copy: %v", e)
+	}
+	if n != int64(result.Len()) {
+		t.Errorf("WriteTo reported %d bytes, wrote %d", n, result.Len())
+	}
+	direct := new(bytes.Buffer)
+	if n, e := WriterTo(createTestObject(), binary.BigEndian, CompactLength).WriteTo(direct); e != nil || n != int64(direct.Len()) {
+		t.Errorf("WriteTo reported %d, %v for %d bytes", n, e, direct.Len())
+	}
+	if direct.Len() != result.Len() {
+		t.Errorf("WriteTo and Read produce %d and %d bytes", direct.Len(), result.Len())
+	}
+	//a second value after the first must be left unread
+	result.WriteString("tail")
+	var readBack Foo
+	n2, e := ReaderFrom(&readBack, binary.BigEndian, CompactLength).ReadFrom(result)
+	if e != nil {
+		t.Fatalf("ReadFrom: %v", e)
+	}
+	if n2 != n {
+		t.Errorf("ReadFrom reported %d bytes, want %d", n2, n)
+	}
+	if !reflect.DeepEqual(*createTestObject(), readBack) {
+		t.Errorf("proto and readBack are NOT equal")
+	}
+	if result.String() != "tail" {
+		t.Errorf("ReadFrom consumed bytes past the value, left %q", result.String())
+	}
+}
+
+func TestReaderFromShortInput(t *testing.T) {
+	var v struct{ A, B uint32 }
+	n, e := ReaderFrom(&v, binary.BigEndian, CompactLength).ReadFrom(bytes.NewReader([]byte{1, 2, 3, 4, 5}))
+	if e == nil {
+		t.Errorf("short input should fail")
+	}
+	if n != 5 {
+		t.Errorf("ReadFrom reported %d bytes, want 5", n)
+	}
+}
+
+func TestUnmarshalAt(t *testing.T) {
+	foos, stream := fooStream(t, 8, binary.BigEndian, BlobLength32)
+	index := make([]int64, len(foos))
+	var off int64
+	for i := range foos {
+		index[i] = off
+		n, e := Skip(bytes.NewReader(stream[off:]), Foo{}, binary.BigEndian, BlobLength32)
+		if e != nil {
+			t.Fatalf("Skip: %v", e)
+		}
+		off += n
+	}
+	r := bytes.NewReader(stream)
+	for _, i := range []int{7, 0, 3} {
+		var readBack Foo
+		n, e := UnmarshalAt(r, index[i], &readBack, binary.BigEndian, BlobLength32)
+		if e != nil {
+			t.Fatalf("UnmarshalAt %d: %v", i, e)
+		}
+		end := int64(len(stream))
+		if i+1 < len(index) {
+			end = index[i+1]
+		}
+		if index[i]+n != end {
+			t.Errorf("record %d spans %d bytes, want %d", i, n, end-index[i])
+		}
+		if e := firstDiff(reflect.ValueOf(foos[i]), reflect.ValueOf(readBack)); e != nil {
+			t.Errorf("record %d: %v", i, e)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package marshal
+
+import (
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+//ascii writes an integer field as exactly ft.ascii decimal characters, padded on the
+//left with zeros after the sign, or with spaces before it
+func (m *marshaler) ascii(v reflect.Value, ft *fieldTag) {
+	var digits string
+	neg := false
+	if k := v.Kind(); k >= reflect.Int && k <= reflect.Int64 {
+		digits = strconv.FormatInt(v.Int(), 10)
+		if neg = v.Int() < 0; neg {
+			digits = digits[1:]
+		}
+	} else {
+		digits = strconv.FormatUint(v.Uint(), 10)
+	}
+	width := ft.ascii
+	if neg {
+		width--
+	}
+	if len(digits) > width {
+		if k := v.Kind(); k >= reflect.Int && k <= reflect.Int64 {
+			panic(errorf(ErrLengthOverflow, "marshal: %d doesn't fit in ascii=%d", v.Int(), ft.ascii))
+		}
+		panic(errorf(ErrLengthOverflow, "marshal: %d doesn't fit in ascii=%d", v.Uint(), ft.ascii))
+	}
+	var b strings.Builder
+	b.Grow(ft.ascii)
+	if ft.padSpace {
+		b.WriteString(strings.Repeat(" ", width-len(digits)))
+	}
+	if neg {
+		b.WriteByte('-')
+	}
+	if !ft.padSpace {
+		b.WriteString(strings.Repeat("0", width-len(digits)))
+	}
+	b.WriteString(digits)
+	if _, err := io.WriteString(m.w, b.String()); err != nil {
+		panic(err)
+	}
+}
+
+//ascii parses ft.ascii decimal characters into an integer field, leading spaces and
+//zeros are accepted whatever the pad setting
+func (u *unmarshaler) ascii(v reflect.Value, ft *fieldTag) {
+	b := u.staged(ft.ascii)
+	if _, err := io.ReadFull(u.r, b); err != nil {
+		panic(err)
+	}
+	s := strings.TrimLeft(string(b), " ")
+	neg := strings.HasPrefix(s, "-")
+	digits := strings.TrimPrefix(s, "-")
+	if digits == "" || strings.Trim(digits, "0123456789") != "" {
+		panic(errorf(ErrMalformed, "unmarshal: bad ascii number %q", b))
+	}
+	if k := v.Kind(); k >= reflect.Int && k <= reflect.Int64 {
+		x, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			panic(errorf(ErrMalformed, "unmarshal: ascii number %q overflows %s", b, v.Type()))
+		}
+		v.SetInt(x)
+		return
+	}
+	if neg {
+		panic(errorf(ErrMalformed, "unmarshal: negative ascii number %q in %s", b, v.Type()))
+	}
+	x, err := strconv.ParseUint(digits, 10, v.Type().Bits())
+	if err != nil {
+		panic(errorf(ErrMalformed, "unmarshal: ascii number %q overflows %s", b, v.Type()))
+	}
+	v.SetUint(x)
+}
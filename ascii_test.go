@@ -0,0 +1,80 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestASCII(t *testing.T) {
+	type header struct {
+		Size  uint32 `marshal:"ascii=8"`
+		Delta int16  `marshal:"ascii=6"`
+		Count int32  `marshal:"ascii=5,pad=space"`
+	}
+	v := header{1234, -42, -7}
+	b, err := MarshalBytes(&v, binary.BigEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte("00001234-00042   -7"); !bytes.Equal(b, expected) {
+		t.Errorf("encoded %q, want %q", b, expected)
+	}
+	var readBack header
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, BlobLength8); err != nil {
+		t.Fatal(err)
+	}
+	if readBack != v {
+		t.Errorf("decoded %+v, want %+v", readBack, v)
+	}
+	if err := UnmarshalBytes(&readBack, []byte("    1234  -042   -7"), binary.BigEndian, BlobLength8); err != nil {
+		t.Fatal(err)
+	}
+	if want := (header{1234, -42, -7}); readBack != want {
+		t.Errorf("decoded %+v, want %+v", readBack, want)
+	}
+}
+
+func TestASCIIErrors(t *testing.T) {
+	type small struct {
+		N int8 `marshal:"ascii=4"`
+	}
+	type unsigned struct {
+		N uint8 `marshal:"ascii=3"`
+	}
+	if _, err := MarshalBytes(&small{-128}, binary.BigEndian, BlobLength8); err != nil {
+		t.Errorf("-128 fits in 4 characters: %v", err)
+	}
+	if _, err := MarshalBytes(&unsigned{255}, binary.BigEndian, BlobLength8); err != nil {
+		t.Errorf("255 fits in 3 characters: %v", err)
+	}
+	type narrow struct {
+		N int32 `marshal:"ascii=3"`
+	}
+	if _, err := MarshalBytes(&narrow{-100}, binary.BigEndian, BlobLength8); err == nil || err.Error() != "marshal: -100 doesn't fit in ascii=3" {
+		t.Errorf("expected an error for -100 in 3 characters, got %v", err)
+	}
+	type wide struct {
+		N uint16 `marshal:"ascii=2"`
+	}
+	if _, err := MarshalBytes(&wide{1000}, binary.BigEndian, BlobLength8); err == nil || err.Error() != "marshal: 1000 doesn't fit in ascii=2" {
+		t.Errorf("expected an error for 1000 in 2 characters, got %v", err)
+	}
+	var s small
+	var u unsigned
+	for _, tt := range []struct {
+		v    interface{}
+		wire string
+	}{
+		{&s, "12a4"},
+		{&s, "0200"},
+		{&s, "    "},
+		{&s, "1-23"},
+		{&u, "-01"},
+		{&u, "256"},
+	} {
+		if err := UnmarshalBytes(tt.v, []byte(tt.wire), binary.BigEndian, BlobLength8); err == nil {
+			t.Errorf("%q into %T: expected an error", tt.wire, tt.v)
+		}
+	}
+}
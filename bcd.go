@@ -0,0 +1,228 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+)
+
+//isInteger reports whether k is a signed or unsigned integer kind
+func isInteger(k reflect.Kind) bool {
+	return (k >= reflect.Int && k <= reflect.Int64) || (k >= reflect.Uint && k <= reflect.Uintptr)
+}
+
+//bcdBytes is the wire size of n packed BCD digits
+func bcdBytes(n int) int {
+	return (n + 1) / 2
+}
+
+//bcdDigits returns the decimal digits of the integer or digit string field v
+func bcdDigits(v reflect.Value) string {
+	switch k := v.Kind(); {
+	case k == reflect.String:
+		s := v.String()
+		for i := 0; i < len(s); i++ {
+			if s[i] < '0' || s[i] > '9' {
+				panic(errorf(ErrMalformed, "marshal: %q in bcd field is not a digit string", s))
+			}
+		}
+		return s
+	case k >= reflect.Int && k <= reflect.Int64:
+		if v.Int() < 0 {
+			panic(errorf(ErrMalformed, "marshal: negative value %d in bcd field", v.Int()))
+		}
+		return strconv.FormatInt(v.Int(), 10)
+	default:
+		return strconv.FormatUint(v.Uint(), 10)
+	}
+}
+
+//packBCD packs digits into bcdBytes(n) bytes behind leading zeros. An odd n leaves
+//a pad nibble in front, or behind when left-aligned, that is 0 or 0xF with padF
+func packBCD(digits string, n int, ft *fieldTag) []byte {
+	nibbles := make([]byte, 0, 2*bcdBytes(n))
+	pad := byte(0)
+	if ft.padF {
+		pad = 0xf
+	}
+	if n%2 == 1 && !ft.alignLeft {
+		nibbles = append(nibbles, pad)
+	}
+	for i := len(digits); i < n; i++ {
+		nibbles = append(nibbles, 0)
+	}
+	for i := 0; i < len(digits); i++ {
+		nibbles = append(nibbles, digits[i]-'0')
+	}
+	if len(nibbles)%2 == 1 {
+		nibbles = append(nibbles, pad)
+	}
+	b := make([]byte, len(nibbles)/2)
+	for i := range b {
+		b[i] = nibbles[2*i]<<4 | nibbles[2*i+1]
+	}
+	return b
+}
+
+//unpackBCD appends the n digits packed in b to dst, the pad nibble may be 0 or 0xF
+func unpackBCD(dst, b []byte, n int, ft *fieldTag) []byte {
+	pad := -1
+	if n%2 == 1 {
+		pad = 0
+		if ft.alignLeft {
+			pad = 2*len(b) - 1
+		}
+	}
+	digits := dst
+	for i := 0; i < 2*len(b); i++ {
+		d := b[i/2] >> 4
+		if i%2 == 1 {
+			d = b[i/2] & 0xf
+		}
+		if i == pad {
+			if d != 0 && d != 0xf {
+				panic(errorf(ErrMalformed, "unmarshal: bad bcd pad nibble %x", d))
+			}
+			continue
+		}
+		if d > 9 {
+			panic(errorf(ErrMalformed, "unmarshal: bad bcd digit %x", d))
+		}
+		digits = append(digits, '0'+d)
+	}
+	return digits
+}
+
+//bcd writes an integer field as ft.bcd packed BCD digits with leading zeros, or a
+//string field of exactly ft.bcd digits. A bcd tag without a digit count writes the
+//number of digits with the length type, then the digits
+func (m *marshaler) bcd(v reflect.Value, ft *fieldTag, length LengthTypeInstance) {
+	digits := bcdDigits(v)
+	n := ft.bcd
+	switch {
+	case ft.bcdVar:
+		n = len(digits)
+		m.putLength(length, v.Type(), n)
+	case v.Kind() == reflect.String && len(digits) != n:
+		panic(errorf(ErrMalformed, "marshal: %d digits in bcd=%d string", len(digits), n))
+	case len(digits) > n:
+		panic(errorf(ErrLengthOverflow, "marshal: %s doesn't fit in bcd=%d", digits, n))
+	}
+	if _, err := m.w.Write(packBCD(digits, n, ft)); err != nil {
+		panic(err)
+	}
+}
+
+//bcd reads packed BCD digits into an integer or string field
+func (u *unmarshaler) bcd(v reflect.Value, ft *fieldTag, order binary.ByteOrder, length LengthTypeInstance) {
+	n := ft.bcd
+	if ft.bcdVar {
+		n = u.getLength(length, order, v.Type())
+	}
+	nb := bcdBytes(n)
+	b := u.staged(nb)
+	if _, err := io.ReadFull(u.r, b); err != nil {
+		panic(err)
+	}
+	var dst []byte
+	if nb+n < stageSize {
+		//the digits follow the packed bytes in the stage
+		dst = u.stage[nb : nb : nb+n]
+	} else {
+		dst = make([]byte, 0, n)
+	}
+	digits := unpackBCD(dst, b, n, ft)
+	if v.Kind() == reflect.String {
+		v.SetString(string(digits))
+		return
+	}
+	var x uint64
+	for _, c := range digits {
+		d := uint64(c - '0')
+		if x > (1<<64-1-d)/10 {
+			panic(errorf(ErrMalformed, "unmarshal: bcd value overflows uint64"))
+		}
+		x = x*10 + d
+	}
+	setInteger(v, x, "bcd")
+}
+
+//setInteger stores the non-negative x in an integer field, failing when it doesn't fit
+func setInteger(v reflect.Value, x uint64, what string) {
+	if k := v.Kind(); k >= reflect.Int && k <= reflect.Int64 {
+		if x > 1<<63-1 || v.OverflowInt(int64(x)) {
+			panic(errorf(ErrMalformed, "unmarshal: %s value %d overflows %s", what, x, v.Type()))
+		}
+		v.SetInt(int64(x))
+		return
+	}
+	if v.OverflowUint(x) {
+		panic(errorf(ErrMalformed, "unmarshal: %s value %d overflows %s", what, x, v.Type()))
+	}
+	v.SetUint(x)
+}
+
+type bcdLength struct {
+	digits int
+	max    int
+	b      [2]byte
+}
+
+//BCDLength writes lengths as digits packed BCD digits, 1 to 4, in one byte or two
+//whatever the byte order, e.g. BCDLength(2) writes 19 as 0x19. Lengths of more
+//digits are an error
+func BCDLength(digits int) LengthType {
+	if digits < 1 || digits > 4 {
+		panic(fmt.Errorf("marshal: BCDLength(%d), want 1 to 4 digits", digits))
+	}
+	max := 1
+	for i := 0; i < digits; i++ {
+		max *= 10
+	}
+	return func() LengthTypeInstance {
+		return &bcdLength{digits: digits, max: max - 1}
+	}
+}
+
+//LLVAR and LLLVAR are the ISO 8583 length prefixes of two and three BCD digits,
+//with fields tagged bcd they count digits, otherwise bytes
+var (
+	LLVAR  = BCDLength(2)
+	LLLVAR = BCDLength(3)
+)
+
+func (d *bcdLength) PutLength(w io.Writer, order binary.ByteOrder, k reflect.Kind, v int) {
+	checkLength(v, 64)
+	if v > d.max {
+		panic(fmt.Errorf("%w: %d doesn't fit %d bcd digits", ErrLengthTooLarge, v, d.digits))
+	}
+	digits := strconv.Itoa(v)
+	for i := len(digits); i < 2*bcdBytes(d.digits); i++ {
+		digits = "0" + digits
+	}
+	bs := d.b[:bcdBytes(d.digits)]
+	for i := range bs {
+		bs[i] = (digits[2*i]-'0')<<4 | (digits[2*i+1] - '0')
+	}
+	if _, err := w.Write(bs); err != nil {
+		panic(err)
+	}
+}
+
+func (d *bcdLength) Length(r io.Reader, order binary.ByteOrder, k reflect.Kind) int {
+	bs := d.b[:bcdBytes(d.digits)]
+	if _, err := io.ReadFull(r, bs); err != nil {
+		panic(err)
+	}
+	l := 0
+	for i, c := range bs {
+		hi, lo := int(c>>4), int(c&0xf)
+		if hi > 9 || lo > 9 || (i == 0 && d.digits%2 == 1 && hi != 0) {
+			panic(errorf(ErrMalformed, "unmarshal: bad bcd length byte %#02x", c))
+		}
+		l = l*100 + hi*10 + lo
+	}
+	return l
+}
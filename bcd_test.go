@@ -0,0 +1,149 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestBCD(t *testing.T) {
+	type txn struct {
+		Amount uint32 `marshal:"bcd=6"`
+		Code   int16  `marshal:"bcd=3"`
+		MCC    uint16 `marshal:"bcd=3,align=left"`
+	}
+	v := txn{12345, 7, 581}
+	b, err := MarshalBytes(&v, binary.BigEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0x01, 0x23, 0x45, 0x00, 0x07, 0x58, 0x10}; !bytes.Equal(b, expected) {
+		t.Errorf("encoded % x, want % x", b, expected)
+	}
+	var readBack txn
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, BlobLength8); err != nil {
+		t.Fatal(err)
+	}
+	if readBack != v {
+		t.Errorf("decoded %+v, want %+v", readBack, v)
+	}
+	//0xF filler is accepted in the pad nibble
+	if err := UnmarshalBytes(&readBack, []byte{0x01, 0x23, 0x45, 0xf0, 0x07, 0x58, 0x1f}, binary.BigEndian, BlobLength8); err != nil || readBack != v {
+		t.Errorf("F filler: %+v, %v", readBack, err)
+	}
+}
+
+func TestBCDErrors(t *testing.T) {
+	type small struct {
+		N int8 `marshal:"bcd=4"`
+	}
+	if _, err := MarshalBytes(&small{-1}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for a negative value")
+	}
+	type narrow struct {
+		N uint32 `marshal:"bcd=2"`
+	}
+	if _, err := MarshalBytes(&narrow{100}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for a value too wide")
+	}
+	var v small
+	if err := UnmarshalBytes(&v, []byte{0x01, 0xa0}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for a nibble above 9")
+	}
+	if err := UnmarshalBytes(&v, []byte{0x02, 0x00}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for 200 overflowing int8")
+	}
+	type notInt struct {
+		S string `marshal:"bcd=4"`
+	}
+	if _, err := MarshalBytes(&notInt{}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for bcd on a string")
+	}
+}
+
+// iso8583Fields are fields 2, 3, 4, 11 and 32 of an authorization request
+type iso8583Fields struct {
+	PAN        string `marshal:"bcd"`
+	ProcCode   string `marshal:"bcd=6"`
+	Amount     uint64 `marshal:"bcd=12"`
+	STAN       uint32 `marshal:"bcd=6"`
+	AcquirerID string `marshal:"bcd,align=left,pad=f"`
+}
+
+func TestISO8583(t *testing.T) {
+	v := iso8583Fields{PAN: "4761739001010119", ProcCode: "000000", Amount: 10000, STAN: 123, AcquirerID: "12345"}
+	b, err := MarshalBytes(&v, binary.BigEndian, LLVAR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0x16, 0x47, 0x61, 0x73, 0x90, 0x01, 0x01, 0x01, 0x19,
+		0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x01, 0x00, 0x00,
+		0x00, 0x01, 0x23,
+		0x05, 0x12, 0x34, 0x5f,
+	}
+	if !bytes.Equal(b, want) {
+		t.Errorf("encoded % x, want % x", b, want)
+	}
+	var readBack iso8583Fields
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, LLVAR); err != nil {
+		t.Fatal(err)
+	}
+	if readBack != v {
+		t.Errorf("decoded %+v, want %+v", readBack, v)
+	}
+	//a 19 digit PAN is right-aligned behind a zero nibble
+	v.PAN = "6011000990139424123"
+	if b, err = MarshalBytes(&v, binary.BigEndian, LLVAR); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0x19, 0x06, 0x01, 0x10, 0x00, 0x99, 0x01, 0x39, 0x42, 0x41, 0x23}; !bytes.HasPrefix(b, want) {
+		t.Errorf("encoded % x, want it to start with % x", b, want)
+	}
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, LLVAR); err != nil || readBack.PAN != v.PAN {
+		t.Errorf("decoded %q, %v", readBack.PAN, err)
+	}
+	if _, err := MarshalBytes(&iso8583Fields{PAN: "4761-7390", ProcCode: "000000"}, binary.BigEndian, LLVAR); err == nil {
+		t.Errorf("expected an error for a PAN that isn't digits")
+	}
+	if _, err := MarshalBytes(&iso8583Fields{ProcCode: "0000"}, binary.BigEndian, LLVAR); err == nil {
+		t.Errorf("expected an error for a processing code of 4 digits")
+	}
+	if n, bounded, err := MaxSize(reflect.TypeOf(v), LLVAR); err != nil || !bounded || n != 1+50+3+6+3+1+50 {
+		t.Errorf("MaxSize %d, %v, %v", n, bounded, err)
+	}
+}
+
+func TestBCDLength(t *testing.T) {
+	var buf bytes.Buffer
+	l := LLLVAR()
+	l.PutLength(&buf, binary.LittleEndian, reflect.String, 123)
+	l.PutLength(&buf, binary.LittleEndian, reflect.String, 7)
+	if !bytes.Equal(buf.Bytes(), []byte{0x01, 0x23, 0x00, 0x07}) {
+		t.Errorf("encoded % x", buf.Bytes())
+	}
+	if n := l.Length(&buf, binary.LittleEndian, reflect.String); n != 123 {
+		t.Errorf("decoded %d", n)
+	}
+	//an LLLVAR string of 123 bytes
+	s := string(bytes.Repeat([]byte{'x'}, 123))
+	b, err := MarshalBytes(&s, binary.BigEndian, LLLVAR)
+	if err != nil || !bytes.HasPrefix(b, []byte{0x01, 0x23, 'x'}) {
+		t.Fatalf("encoded % x, %v", b[:3], err)
+	}
+	s = string(make([]byte, 100))
+	if _, err := MarshalBytes(&s, binary.BigEndian, LLVAR); !errors.Is(err, ErrLengthTooLarge) {
+		t.Errorf("got %v, want ErrLengthTooLarge", err)
+	}
+	for _, bad := range [][]byte{{0x1a}, {0xf9}} {
+		if err := UnmarshalBytes(&s, bad, binary.BigEndian, LLVAR); err == nil {
+			t.Errorf("expected an error for length % x", bad)
+		}
+	}
+	if err := UnmarshalBytes(&s, []byte{0x10, 0x00}, binary.BigEndian, LLLVAR); err == nil {
+		t.Errorf("expected an error for a nonzero pad nibble")
+	}
+}
@@ -0,0 +1,140 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"math/bits"
+	"reflect"
+)
+
+//indefiniteLength is the length BERLength reports for the indefinite form 0x80
+const indefiniteLength = -3
+
+//BERLength writes lengths in the definite form of X.690: below 128 in one byte,
+//otherwise 0x80 plus the number of big-endian bytes that follow, then those
+//bytes. Decoding also accepts long forms where a shorter one would do and the
+//indefinite form 0x80 for delimited structs, strings and byte slices. A struct
+//of indefinite length ends at the end-of-contents marker 00 00. A string or
+//byte slice of indefinite length is read as BER reads constructed strings: its
+//segments, each an identifier byte, a length, indefinite again or not, and the
+//bytes, are joined up to the marker
+func BERLength() LengthTypeInstance {
+	return &berLength{}
+}
+
+//DERLength is BERLength decoding only the lengths DER allows, definite ones in
+//the fewest bytes
+func DERLength() LengthTypeInstance {
+	return &berLength{der: true}
+}
+
+type berLength struct {
+	der bool
+	b   [9]byte
+}
+
+func (d *berLength) PutLength(w io.Writer, order binary.ByteOrder, k reflect.Kind, v int) {
+	checkLength(v, 64)
+	bs := d.b[:1]
+	if v < 0x80 {
+		bs[0] = byte(v)
+	} else {
+		n := (bits.Len64(uint64(v)) + 7) / 8
+		bs = d.b[:1+n]
+		bs[0] = 0x80 | byte(n)
+		for i := n; i > 0; i-- {
+			bs[i] = byte(v)
+			v >>= 8
+		}
+	}
+	if _, err := w.Write(bs); err != nil {
+		panic(err)
+	}
+}
+
+func (d *berLength) Length(r io.Reader, order binary.ByteOrder, k reflect.Kind) int {
+	bs := d.b[:1]
+	if _, err := io.ReadFull(r, bs); err != nil {
+		panic(err)
+	}
+	c := bs[0]
+	switch {
+	case c < 0x80:
+		return int(c)
+	case c == 0x80 && d.der:
+		panic(errorf(ErrMalformed, "der length: indefinite form"))
+	case c == 0x80:
+		return indefiniteLength
+	case c == 0xff:
+		panic(errorf(ErrMalformed, "ber length: reserved first byte 0xff"))
+	case c&0x7f > 8:
+		panic(errorf(ErrMalformed, "ber length: %d length bytes", c&0x7f))
+	}
+	bs = d.b[1 : 1+c&0x7f]
+	if _, err := io.ReadFull(r, bs); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		panic(err)
+	}
+	var v uint64
+	for _, b := range bs {
+		v = v<<8 | uint64(b)
+	}
+	if v > math.MaxInt {
+		panic(errorf(ErrLengthOverflow, "ber length: %d overflows int", v))
+	}
+	if d.der && (bs[0] == 0 || v < 0x80) {
+		panic(errorf(ErrMalformed, "der length: %d in %d bytes isn't minimal", v, len(bs)+1))
+	}
+	return int(v)
+}
+
+//eoc reads the end-of-contents marker closing the indefinite region of a value of type t
+func (u *unmarshaler) eoc(t reflect.Type) {
+	bs := u.buf[:2]
+	if _, err := io.ReadFull(u.r, bs); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		panic(err)
+	}
+	if bs[0] != 0 || bs[1] != 0 {
+		panic(errorf(ErrMalformed, "unmarshal: % x after indefinite %s, want end-of-contents 00 00", bs, t))
+	}
+}
+
+//segments appends to b the segments of a string or byte slice of type t with an
+//indefinite length, up to the end-of-contents marker
+func (u *unmarshaler) segments(length LengthTypeInstance, order binary.ByteOrder, t reflect.Type, b []byte) []byte {
+	id := u.buf[:1]
+	for {
+		if _, err := io.ReadFull(u.r, id); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			panic(err)
+		}
+		l := length.Length(u.r, order, t.Kind())
+		switch {
+		case id[0] == 0 && l == 0:
+			return b
+		case id[0] == 0:
+			panic(errorf(ErrMalformed, "unmarshal: segment of %s with identifier 0", t))
+		case l == indefiniteLength:
+			b = u.segments(length, order, t, b)
+		case l < 0:
+			panic(errorf(ErrMalformed, "unmarshal: segment of %s with length %d", t, l))
+		default:
+			n := len(b)
+			b = u.grow(b, l)
+			if _, err := io.ReadFull(u.r, b[n:]); err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				panic(err)
+			}
+		}
+	}
+}
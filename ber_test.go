@@ -0,0 +1,119 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type berInner struct {
+	N    uint8
+	Data []byte
+}
+
+type berOuter struct {
+	Tag   uint8
+	Inner berInner `marshal:"delimited"`
+	Name  string
+}
+
+type berNest struct {
+	Outer berOuter `marshal:"delimited"`
+	Last  uint8
+}
+
+func TestBERLength(t *testing.T) {
+	for _, c := range []struct {
+		v        int
+		expected []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x81, 0x80}},
+		{300, []byte{0x82, 0x01, 0x2c}},
+		{1 << 24, []byte{0x84, 0x01, 0, 0, 0}},
+	} {
+		for _, l := range []LengthTypeInstance{BERLength(), DERLength()} {
+			var buf bytes.Buffer
+			l.PutLength(&buf, binary.BigEndian, reflect.String, c.v)
+			if !bytes.Equal(buf.Bytes(), c.expected) {
+				t.Errorf("%d encoded % x, want % x", c.v, buf.Bytes(), c.expected)
+			}
+			if got := l.Length(&buf, binary.BigEndian, reflect.String); got != c.v {
+				t.Errorf("% x decoded %d, want %d", c.expected, got, c.v)
+			}
+		}
+	}
+	//BER takes the long form where the short one would do, DER doesn't
+	for _, in := range [][]byte{{0x81, 0x05}, {0x82, 0x00, 0x80}} {
+		var s string
+		in = append(in, bytes.Repeat([]byte{'x'}, 0x80)...)
+		if err := UnmarshalBytes(&s, in, binary.BigEndian, BERLength); err != nil {
+			t.Errorf("% x: %v", in[:3], err)
+		}
+		if err := UnmarshalBytes(&s, in, binary.BigEndian, DERLength); err == nil || !strings.Contains(err.Error(), "minimal") {
+			t.Errorf("% x: got %v, want a DER error", in[:3], err)
+		}
+	}
+	var s string
+	for _, bad := range [][]byte{{0xff}, {0x89, 1, 2, 3, 4, 5, 6, 7, 8, 9}, {0x82, 0x01}} {
+		if err := UnmarshalBytes(&s, bad, binary.BigEndian, BERLength); err == nil {
+			t.Errorf("% x: expected an error", bad)
+		}
+	}
+}
+
+func TestBERIndefinite(t *testing.T) {
+	v := berNest{Outer: berOuter{Tag: 0x30, Inner: berInner{N: 5, Data: []byte("abc")}, Name: "xyz"}, Last: 9}
+	//encoding is always definite
+	b, err := MarshalBytes(&v, binary.BigEndian, BERLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{11, 0x30, 5, 5, 3, 'a', 'b', 'c', 3, 'x', 'y', 'z', 9}
+	if !bytes.Equal(b, want) {
+		t.Errorf("encoded % x, want % x", b, want)
+	}
+	indefinite := []byte{
+		0x80, //Outer
+		0x30,
+		0x80, //Inner
+		5,
+		0x80,                 //Data, made of segments
+		0x04, 0x02, 'a', 'b', //a primitive segment
+		0x24, 0x80, 0x04, 0x01, 'c', 0x00, 0x00, //a constructed one
+		0x00, 0x00, //end of Data
+		0x00, 0x00, //end of Inner
+		0x80, 0x04, 0x01, 'x', 0x04, 0x02, 'y', 'z', 0x00, 0x00, //Name
+		0x00, 0x00, //end of Outer
+		9,
+	}
+	for _, in := range [][]byte{b, indefinite} {
+		var readBack berNest
+		if err := UnmarshalBytes(&readBack, in, binary.BigEndian, BERLength); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(readBack, v) {
+			t.Errorf("decoded %+v, want %+v", readBack, v)
+		}
+		if n, err := Skip(bytes.NewReader(in), &v, binary.BigEndian, BERLength); err != nil || n != int64(len(in)) {
+			t.Errorf("skipped %d of %d bytes, %v", n, len(in), err)
+		}
+	}
+	var readBack berNest
+	if err := UnmarshalBytes(&readBack, indefinite, binary.BigEndian, DERLength); err == nil || !strings.Contains(err.Error(), "indefinite") {
+		t.Errorf("got %v, want DER to reject the indefinite form", err)
+	}
+	//a missing end-of-contents, and an indefinite length where none may be
+	bad := append([]byte(nil), indefinite...)
+	bad[len(bad)-2] = 1
+	if err := UnmarshalBytes(&readBack, bad, binary.BigEndian, BERLength); err == nil {
+		t.Errorf("expected an error for a bad end-of-contents")
+	}
+	var ints []uint16
+	if err := UnmarshalBytes(&ints, []byte{0x80, 0, 0}, binary.BigEndian, BERLength); err == nil || !strings.Contains(err.Error(), "indefinite") {
+		t.Errorf("got %v, want an error for an indefinite []uint16", err)
+	}
+}
@@ -0,0 +1,66 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"io"
+	"reflect"
+)
+
+//bitmap writes a slice or array of pointers as its length (slices only), a presence
+//bitmap with bit i%8 of byte i/8 set for each non-nil element i, then the non-nil
+//elements in order. Unlike Optional values, which spend a byte per value, absent
+//elements cost one bit. NullableLength still decides whether the slice itself is nil.
+//With SharedPointers the elements are written as pointer tokens, which keeps their identity
+func (m *marshaler) bitmap(v reflect.Value, length LengthTypeInstance) {
+	n := v.Len()
+	if v.Kind() == reflect.Slice {
+		m.putLength(length, v.Type(), n)
+	}
+	bits := make([]byte, (n+7)/8)
+	for i := 0; i < n; i++ {
+		if !v.Index(i).IsNil() {
+			bits[i/8] |= 1 << (i % 8)
+		}
+	}
+	if _, err := m.w.Write(bits); err != nil {
+		panic(err)
+	}
+	elems := elemsOf(length)
+	for i := 0; i < n; i++ {
+		if e := v.Index(i); !e.IsNil() {
+			m.push(indexElem(i))
+			m.marshal(e, elems)
+			m.pop()
+		}
+	}
+}
+
+//bitmap reads a slice or array written by marshaler.bitmap, elements whose bit is
+//clear are nil
+func (u *unmarshaler) bitmap(v reflect.Value, order binary.ByteOrder, length LengthTypeInstance) {
+	n := v.Len()
+	if v.Kind() == reflect.Slice {
+		n = u.getLength(length, order, v.Type())
+		v.Set(reflect.MakeSlice(v.Type(), n, n))
+	}
+	bits := make([]byte, (n+7)/8)
+	if _, err := io.ReadFull(u.r, bits); err != nil {
+		panic(err)
+	}
+	elems := elemsOf(length)
+	for i := 0; i < n; i++ {
+		e := v.Index(i)
+		if bits[i/8]&(1<<(i%8)) == 0 {
+			e.Set(reflect.Zero(e.Type()))
+			continue
+		}
+		u.push(indexElem(i))
+		if u.shared != nil {
+			u.unmarshal(e, order, elems)
+		} else {
+			e.Set(reflect.New(e.Type().Elem()))
+			u.unmarshal(e.Elem(), order, elems)
+		}
+		u.pop()
+	}
+}
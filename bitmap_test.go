@@ -0,0 +1,66 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+type bitmapItem struct {
+	ID uint16
+}
+
+type bitmapTable struct {
+	Items []*bitmapItem `marshal:"bitmap"`
+	Slots [3]*uint8     `marshal:"bitmap"`
+}
+
+func TestBitmap(t *testing.T) {
+	items := make([]*bitmapItem, 10)
+	items[1], items[9] = &bitmapItem{7}, &bitmapItem{8}
+	one := uint8(1)
+	v := bitmapTable{Items: items, Slots: [3]*uint8{nil, nil, &one}}
+	b, err := MarshalBytes(&v, binary.BigEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{10, 0x02, 0x02, 0, 7, 0, 8, 0x04, 1}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("encoded % x, want % x", b, expected)
+	}
+	var readBack bitmapTable
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, BlobLength8); err != nil {
+		t.Fatal(err)
+	}
+	if len(readBack.Items) != 10 || readBack.Items[0] != nil || *readBack.Items[1] != *items[1] || *readBack.Items[9] != *items[9] {
+		t.Errorf("decoded %v", readBack.Items)
+	}
+	if readBack.Slots[0] != nil || readBack.Slots[2] == nil || *readBack.Slots[2] != 1 {
+		t.Errorf("decoded %v", readBack.Slots)
+	}
+}
+
+func TestBitmapShared(t *testing.T) {
+	it := &bitmapItem{5}
+	v := bitmapTable{Items: []*bitmapItem{it, nil, it}}
+	b, err := MarshalBytes(&v, binary.BigEndian, BlobLength8, SharedPointers())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var readBack bitmapTable
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, BlobLength8, SharedPointers()); err != nil {
+		t.Fatal(err)
+	}
+	if readBack.Items[0] == nil || readBack.Items[0] != readBack.Items[2] || readBack.Items[1] != nil {
+		t.Errorf("decoded %v", readBack.Items)
+	}
+}
+
+func TestBitmapErrors(t *testing.T) {
+	type notPointers struct {
+		S []uint8 `marshal:"bitmap"`
+	}
+	if _, err := MarshalBytes(&notPointers{}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for bitmap on a slice of values")
+	}
+}
@@ -0,0 +1,173 @@
+package marshal
+
+import (
+	"fmt"
+	"reflect"
+)
+
+//bitGroup is a run of adjacent bits= fields packed into size bytes. With msb the
+//first field takes the most significant bits of the first byte, with lsb the least
+//significant ones, as C compilers lay out bitfields on little endian machines.
+//Unused bits at the end of the group are zero
+type bitGroup struct {
+	fields []*fieldPlan
+	lsb    bool
+	size   int
+}
+
+//resolveBits groups adjacent bits= fields of the struct t. A group holds at most
+//64 bits, a longer run or a change of bit order starts a new group on a byte boundary
+func (p *typePlan) resolveBits(t reflect.Type) error {
+	var g *bitGroup
+	n := 0
+	//ordered is set once a field of g has named the bit order
+	ordered := false
+	for i := range p.fields {
+		f := &p.fields[i]
+		if f.tag == nil || f.tag.bits == 0 {
+			g = nil
+			continue
+		}
+		named := f.tag.lsb || f.tag.msb
+		if g != nil && ((named && ordered && f.tag.lsb != g.lsb) || n+f.tag.bits > 64) {
+			if n%8 != 0 {
+				return fmt.Errorf("marshal: %s.%s: bit group changes order or passes 64 bits in the middle of a byte", t, f.name)
+			}
+			g = nil
+		}
+		if g == nil {
+			g = &bitGroup{}
+			n, ordered = 0, false
+			p.bitfields = true
+		}
+		if named {
+			g.lsb, ordered = f.tag.lsb, true
+		}
+		g.fields = append(g.fields, f)
+		n += f.tag.bits
+		g.size = (n + 7) / 8
+		f.bits = g
+	}
+	return nil
+}
+
+//bitMask has the low n bits set
+func bitMask(n int) uint64 {
+	return ^uint64(0) >> (64 - n)
+}
+
+//bits writes the group g of the struct v
+func (m *marshaler) bits(v reflect.Value, g *bitGroup, length LengthTypeInstance) {
+	start := m.cw.n
+	var acc uint64
+	pos := 0
+	for _, f := range g.fields {
+		n := f.tag.bits
+		fv := m.fieldValue(v, f, length)
+		var x uint64
+		switch k := fv.Kind(); {
+		case k == reflect.Bool:
+			if fv.Bool() {
+				x = 1
+			}
+		case k >= reflect.Int && k <= reflect.Int64:
+			i := fv.Int()
+			if n < 64 && (i < -1<<(n-1) || i >= 1<<(n-1)) {
+				panic(errorf(ErrLengthOverflow, "marshal: %s value %d doesn't fit in %d bits", f.name, i, n))
+			}
+			x = uint64(i) & bitMask(n)
+		default:
+			x = fv.Uint()
+			if x&^bitMask(n) != 0 {
+				panic(errorf(ErrLengthOverflow, "marshal: %s value %d doesn't fit in %d bits", f.name, x, n))
+			}
+		}
+		if g.lsb {
+			acc |= x << pos
+		} else {
+			acc |= x << (8*g.size - pos - n)
+		}
+		pos += n
+	}
+	b := m.buf[:g.size]
+	for i := range b {
+		if g.lsb {
+			b[i] = byte(acc >> (8 * i))
+		} else {
+			b[i] = byte(acc >> (8 * (g.size - 1 - i)))
+		}
+	}
+	if _, err := m.w.Write(b); err != nil {
+		panic(err)
+	}
+	if m.trace != nil {
+		m.traceBits(v, g, start)
+	}
+}
+
+//bits reads the group g into the struct v, signed fields are sign extended
+func (u *unmarshaler) bits(v reflect.Value, g *bitGroup) {
+	start := u.cr.n
+	b := u.fetch(g.size)
+	var acc uint64
+	for i := range b {
+		if g.lsb {
+			acc |= uint64(b[i]) << (8 * i)
+		} else {
+			acc = acc<<8 | uint64(b[i])
+		}
+	}
+	pos := 0
+	for _, f := range g.fields {
+		n := f.tag.bits
+		var x uint64
+		if g.lsb {
+			x = acc >> pos & bitMask(n)
+		} else {
+			x = acc >> (8*g.size - pos - n) & bitMask(n)
+		}
+		pos += n
+		fv := v.Field(f.index)
+		switch k := fv.Kind(); {
+		case k == reflect.Bool:
+			fv.SetBool(x != 0)
+		case k >= reflect.Int && k <= reflect.Int64:
+			fv.SetInt(int64(x<<(64-n)) >> (64 - n))
+		default:
+			fv.SetUint(x)
+		}
+	}
+	if u.trace != nil {
+		u.traceBits(v, g, start)
+	}
+}
+
+//spans calls fn with the byte range each field of g touches
+func (g *bitGroup) spans(start int64, fn func(f *fieldPlan, off, n int64)) {
+	pos := 0
+	for _, f := range g.fields {
+		first, last := pos/8, (pos+f.tag.bits-1)/8
+		fn(f, start+int64(first), int64(last-first+1))
+		pos += f.tag.bits
+	}
+}
+
+func (m *marshaler) traceBits(v reflect.Value, g *bitGroup, start int64) {
+	g.spans(start, func(f *fieldPlan, off, n int64) {
+		m.push(fieldElem(f.name))
+		e := m.event(v.Field(f.index).Type(), off, false)
+		e.Len = n
+		m.trace(e)
+		m.pop()
+	})
+}
+
+func (u *unmarshaler) traceBits(v reflect.Value, g *bitGroup, start int64) {
+	g.spans(start, func(f *fieldPlan, off, n int64) {
+		u.push(fieldElem(f.name))
+		e := u.event(v.Field(f.index).Type(), off, false)
+		e.Len = n
+		u.trace(e)
+		u.pop()
+	})
+}
@@ -0,0 +1,160 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type ipv4Head struct {
+	Version uint8 `marshal:"bits=4"`
+	IHL     uint8 `marshal:"bits=4"`
+	DSCP    uint8 `marshal:"bits=6"`
+	ECN     uint8 `marshal:"bits=2"`
+	Length  uint16
+}
+
+type frameControl struct {
+	Version uint8 `marshal:"bits=2,lsb"`
+	Type    uint8 `marshal:"bits=2"`
+	Subtype uint8 `marshal:"bits=4"`
+	Flags   uint8
+}
+
+func TestBitsReference(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		v, back  interface{}
+		expected []byte
+	}{
+		//IPv4 packs network style, the version in the top nibble
+		{"ipv4", &ipv4Head{4, 5, 46, 0, 20}, &ipv4Head{}, []byte{0x45, 0xb8, 0x00, 0x14}},
+		//802.11 packs the protocol version in the low bits, a beacon is 0x80
+		{"802.11", &frameControl{0, 0, 8, 0}, &frameControl{}, []byte{0x80, 0x00}},
+	} {
+		b, err := MarshalBytes(c.v, binary.BigEndian, BlobLength8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, c.expected) {
+			t.Errorf("%s: encoded % x, want % x", c.name, b, c.expected)
+		}
+		if err := UnmarshalBytes(c.back, b, binary.BigEndian, BlobLength8); err != nil {
+			t.Fatal(err)
+		}
+		if d := firstDiff(reflect.ValueOf(c.back), reflect.ValueOf(c.v)); d != nil {
+			t.Errorf("%s: decoded %v", c.name, d)
+		}
+	}
+}
+
+type packedMSB struct {
+	A uint8  `marshal:"bits=3,msb"`
+	B uint8  `marshal:"bits=5"`
+	C uint16 `marshal:"bits=12"`
+	D bool   `marshal:"bits=1"`
+	E int8   `marshal:"bits=3"`
+}
+
+type packedLSB struct {
+	A uint8  `marshal:"bits=3,lsb"`
+	B uint8  `marshal:"bits=5"`
+	C uint16 `marshal:"bits=12"`
+	D bool   `marshal:"bits=1"`
+	E int8   `marshal:"bits=3"`
+}
+
+func TestBitsOrder(t *testing.T) {
+	msb := packedMSB{5, 0x13, 0xabc, true, -2}
+	b, err := MarshalBytes(&msb, binary.LittleEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0xb3, 0xab, 0xce}; !bytes.Equal(b, expected) {
+		t.Errorf("msb encoded % x, want % x", b, expected)
+	}
+	var msbBack packedMSB
+	if err := UnmarshalBytes(&msbBack, b, binary.LittleEndian, BlobLength8); err != nil || msbBack != msb {
+		t.Errorf("msb decoded %+v, %v", msbBack, err)
+	}
+	//the layout gcc gives struct { unsigned a:3, b:5, c:12, d:1; signed e:3; } on x86
+	lsb := packedLSB(msb)
+	b, err = MarshalBytes(&lsb, binary.BigEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0x9d, 0xbc, 0xda}; !bytes.Equal(b, expected) {
+		t.Errorf("lsb encoded % x, want % x", b, expected)
+	}
+	var lsbBack packedLSB
+	if err := UnmarshalBytes(&lsbBack, b, binary.BigEndian, BlobLength8); err != nil || lsbBack != lsb {
+		t.Errorf("lsb decoded %+v, %v", lsbBack, err)
+	}
+}
+
+func TestBitsPadding(t *testing.T) {
+	type flags struct {
+		A bool `marshal:"bits=1"`
+		B bool `marshal:"bits=1,lsb"`
+	}
+	b, err := MarshalBytes(&flags{true, true}, binary.BigEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0x03}; !bytes.Equal(b, expected) {
+		t.Errorf("encoded % x, want % x", b, expected)
+	}
+	type wide struct {
+		A uint64 `marshal:"bits=40"`
+		B uint32 `marshal:"bits=32"`
+	}
+	b, err = MarshalBytes(&wide{1, 2}, binary.BigEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0, 0, 0, 0, 1, 0, 0, 0, 2}; !bytes.Equal(b, expected) {
+		t.Errorf("byte aligned run past 64 bits encoded % x, want % x", b, expected)
+	}
+}
+
+func TestBitsDump(t *testing.T) {
+	var out strings.Builder
+	if err := DumpHex(&ipv4Head{4, 5, 46, 0, 20}, binary.BigEndian, BlobLength8, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"IHL uint8 len 1", "DSCP uint8 len 1", "Length uint16 len 2"} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("dump is missing %q:\n%s", s, out.String())
+		}
+	}
+}
+
+func TestBitsErrors(t *testing.T) {
+	if _, err := MarshalBytes(&ipv4Head{Version: 16}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for a value too wide")
+	}
+	if _, err := MarshalBytes(&packedMSB{E: 4}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for a signed value too wide")
+	}
+	type mixed struct {
+		A uint8 `marshal:"bits=3,msb"`
+		B uint8 `marshal:"bits=5,lsb"`
+	}
+	if _, err := MarshalBytes(&mixed{}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for an order change inside a byte")
+	}
+	type tooNarrow struct {
+		A uint8 `marshal:"bits=9"`
+	}
+	if _, err := MarshalBytes(&tooNarrow{}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for 9 bits in a uint8")
+	}
+	type orphan struct {
+		A uint8 `marshal:"msb"`
+	}
+	if _, err := MarshalBytes(&orphan{}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for msb without bits")
+	}
+}
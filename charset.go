@@ -0,0 +1,149 @@
+package marshal
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+//Charset converts string fields between Go's UTF-8 and a wire character set,
+//it is selected per field with the charset=<name> tag option
+type Charset interface {
+	//Encode converts s to wire bytes, failing on runes the charset can't represent
+	Encode(s string) ([]byte, error)
+	//Decode converts wire bytes to a UTF-8 string
+	Decode(b []byte) (string, error)
+}
+
+var (
+	charsetLock sync.RWMutex
+	charsets    = map[string]Charset{
+		"latin1":    latin1{},
+		"ebcdic037": NewSingleByteCharset("ebcdic037", cp037),
+	}
+)
+
+//RegisterCharset makes cs available to fields tagged charset=name, names are
+//case-insensitive and replace a charset registered before
+func RegisterCharset(name string, cs Charset) {
+	charsetLock.Lock()
+	charsets[strings.ToLower(name)] = cs
+	charsetLock.Unlock()
+	//plans cached before registration may hold the previous charset
+	planLock.Lock()
+	plans.Clear()
+	planLock.Unlock()
+}
+
+func lookupCharset(name string) (Charset, error) {
+	charsetLock.RLock()
+	cs, ok := charsets[strings.ToLower(name)]
+	charsetLock.RUnlock()
+	if ok {
+		return cs, nil
+	}
+	return nil, fmt.Errorf("unknown charset %q", name)
+}
+
+//spaceByte is the wire byte cs writes for a space, fixed strings tagged
+//trim=space or trim=both are padded with it. It is ' ' unless cs encodes a
+//space as another single byte, 0x40 in EBCDIC
+func spaceByte(cs Charset) byte {
+	if cs != nil {
+		if b, err := cs.Encode(" "); err == nil && len(b) == 1 {
+			return b[0]
+		}
+	}
+	return ' '
+}
+
+//latin1 is ISO 8859-1, every byte is the code point of the same value
+type latin1 struct{}
+
+func (latin1) Encode(s string) ([]byte, error) {
+	b := make([]byte, 0, len(s))
+	for i, r := range s {
+		//invalid UTF-8 decodes as U+FFFD and is rejected too
+		if r > 0xff {
+			return nil, fmt.Errorf("rune %q at %d not representable in latin1", r, i)
+		}
+		b = append(b, byte(r))
+	}
+	return b, nil
+}
+
+func (latin1) Decode(b []byte) (string, error) {
+	var s strings.Builder
+	s.Grow(len(b))
+	for _, c := range b {
+		s.WriteRune(rune(c))
+	}
+	return s.String(), nil
+}
+
+//singleByte is a code page mapping every byte to one rune
+type singleByte struct {
+	name   string
+	runes  [256]rune
+	encode map[rune]byte
+}
+
+//NewSingleByteCharset returns a Charset for a single byte code page, table[b]
+//is the rune byte b stands for and -1 marks bytes the code page leaves
+//undefined. Register it with RegisterCharset to use it in tags
+func NewSingleByteCharset(name string, table [256]rune) Charset {
+	cs := &singleByte{name: name, runes: table, encode: make(map[rune]byte, 256)}
+	for b := len(table) - 1; b >= 0; b-- {
+		//the lowest byte wins when two map to the same rune
+		if r := table[b]; r >= 0 {
+			cs.encode[r] = byte(b)
+		}
+	}
+	return cs
+}
+
+func (cs *singleByte) Encode(s string) ([]byte, error) {
+	b := make([]byte, 0, len(s))
+	for i, r := range s {
+		c, ok := cs.encode[r]
+		//invalid UTF-8 decodes as U+FFFD, which no table maps
+		if !ok {
+			return nil, fmt.Errorf("rune %q at %d not representable in %s", r, i, cs.name)
+		}
+		b = append(b, c)
+	}
+	return b, nil
+}
+
+func (cs *singleByte) Decode(b []byte) (string, error) {
+	var s strings.Builder
+	s.Grow(len(b))
+	for i, c := range b {
+		r := cs.runes[c]
+		if r < 0 {
+			return "", fmt.Errorf("byte 0x%02x at %d undefined in %s", c, i, cs.name)
+		}
+		s.WriteRune(r)
+	}
+	return s.String(), nil
+}
+
+//cp037 is EBCDIC code page 037, US/Canada, it maps onto the 256 code points of latin1
+var cp037 = [256]rune{
+	0x00, 0x01, 0x02, 0x03, 0x9c, 0x09, 0x86, 0x7f, 0x97, 0x8d, 0x8e, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f,
+	0x10, 0x11, 0x12, 0x13, 0x9d, 0x85, 0x08, 0x87, 0x18, 0x19, 0x92, 0x8f, 0x1c, 0x1d, 0x1e, 0x1f,
+	0x80, 0x81, 0x82, 0x83, 0x84, 0x0a, 0x17, 0x1b, 0x88, 0x89, 0x8a, 0x8b, 0x8c, 0x05, 0x06, 0x07,
+	0x90, 0x91, 0x16, 0x93, 0x94, 0x95, 0x96, 0x04, 0x98, 0x99, 0x9a, 0x9b, 0x14, 0x15, 0x9e, 0x1a,
+	0x20, 0xa0, 0xe2, 0xe4, 0xe0, 0xe1, 0xe3, 0xe5, 0xe7, 0xf1, 0xa2, 0x2e, 0x3c, 0x28, 0x2b, 0x7c,
+	0x26, 0xe9, 0xea, 0xeb, 0xe8, 0xed, 0xee, 0xef, 0xec, 0xdf, 0x21, 0x24, 0x2a, 0x29, 0x3b, 0xac,
+	0x2d, 0x2f, 0xc2, 0xc4, 0xc0, 0xc1, 0xc3, 0xc5, 0xc7, 0xd1, 0xa6, 0x2c, 0x25, 0x5f, 0x3e, 0x3f,
+	0xf8, 0xc9, 0xca, 0xcb, 0xc8, 0xcd, 0xce, 0xcf, 0xcc, 0x60, 0x3a, 0x23, 0x40, 0x27, 0x3d, 0x22,
+	0xd8, 0x61, 0x62, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69, 0xab, 0xbb, 0xf0, 0xfd, 0xfe, 0xb1,
+	0xb0, 0x6a, 0x6b, 0x6c, 0x6d, 0x6e, 0x6f, 0x70, 0x71, 0x72, 0xaa, 0xba, 0xe6, 0xb8, 0xc6, 0xa4,
+	0xb5, 0x7e, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7a, 0xa1, 0xbf, 0xd0, 0xdd, 0xde, 0xae,
+	0x5e, 0xa3, 0xa5, 0xb7, 0xa9, 0xa7, 0xb6, 0xbc, 0xbd, 0xbe, 0x5b, 0x5d, 0xaf, 0xa8, 0xb4, 0xd7,
+	0x7b, 0x41, 0x42, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49, 0xad, 0xf4, 0xf6, 0xf2, 0xf3, 0xf5,
+	0x7d, 0x4a, 0x4b, 0x4c, 0x4d, 0x4e, 0x4f, 0x50, 0x51, 0x52, 0xb9, 0xfb, 0xfc, 0xf9, 0xfa, 0xff,
+	0x5c, 0xf7, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0xb2, 0xd4, 0xd6, 0xd2, 0xd3, 0xd5,
+	0x30, 0x31, 0x32, 0x33, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39, 0xb3, 0xdb, 0xdc, 0xd9, 0xda, 0x9f,
+}
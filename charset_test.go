@@ -0,0 +1,85 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+type mainframeRecord struct {
+	Account string `marshal:"charset=ebcdic037,fixed=8,trim=space"`
+	Name    string `marshal:"charset=EBCDIC037"`
+	Code    string `marshal:"charset=ebcdic037,fixed=4,trim=space,truncate"`
+}
+
+func TestEBCDIC(t *testing.T) {
+	v := mainframeRecord{Account: "A-12", Name: "Zoë {x}", Code: "OK"}
+	b, err := MarshalBytes(&v, binary.BigEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0xc1, 0x60, 0xf1, 0xf2, 0x40, 0x40, 0x40, 0x40,
+		7, 0xe9, 0x96, 0x53, 0x40, 0xc0, 0xa7, 0xd0,
+		0xd6, 0xd2, 0x40, 0x40}
+	if !bytes.Equal(b, want) {
+		t.Errorf("encoded % x, want % x", b, want)
+	}
+	var readBack mainframeRecord
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, BlobLength8); err != nil {
+		t.Fatal(err)
+	}
+	if readBack != v {
+		t.Errorf("decoded %+v, want %+v", readBack, v)
+	}
+	//an ASCII space in the padding is data, not an EBCDIC space
+	copy(b[4:], []byte("    "))
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, BlobLength8); err != nil || readBack.Account != "A-12\u0080\u0080\u0080\u0080" {
+		t.Errorf("decoded %q, %v", readBack.Account, err)
+	}
+	if _, err := MarshalBytes(&mainframeRecord{Name: "→"}, binary.BigEndian, BlobLength8); err == nil || !strings.Contains(err.Error(), "ebcdic037") {
+		t.Errorf("expected an unmappable rune error, got %v", err)
+	}
+	if b, err = MarshalBytes(&mainframeRecord{Code: "ABCDEF"}, binary.BigEndian, BlobLength8); err != nil || !bytes.Equal(b[len(b)-4:], []byte{0xc1, 0xc2, 0xc3, 0xc4}) {
+		t.Errorf("truncated % x, %v", b, err)
+	}
+	//every byte round-trips
+	cs, _ := lookupCharset("ebcdic037")
+	all := make([]byte, 256)
+	for i := range all {
+		all[i] = byte(i)
+	}
+	s, err := cs.Decode(all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back, err := cs.Encode(s); err != nil || !bytes.Equal(back, all) {
+		t.Errorf("round trip % x, %v", back, err)
+	}
+}
+
+type sixBitRecord struct {
+	S string `marshal:"charset=test-sixbit,fixed=3,trim=space"`
+}
+
+func TestRegisterCharset(t *testing.T) {
+	var table [256]rune
+	for i := range table {
+		table[i] = -1
+	}
+	for i, r := range " ABCDEFGHIJKLMNOPQRSTUVWXYZ" {
+		table[i+1] = r
+	}
+	RegisterCharset("test-sixbit", NewSingleByteCharset("test-sixbit", table))
+	b, err := MarshalBytes(&sixBitRecord{"AZ"}, binary.BigEndian, BlobLength8)
+	if err != nil || !bytes.Equal(b, []byte{2, 27, 1}) {
+		t.Fatalf("encoded % x, %v", b, err)
+	}
+	var v sixBitRecord
+	if err := UnmarshalBytes(&v, b, binary.BigEndian, BlobLength8); err != nil || v.S != "AZ" {
+		t.Errorf("decoded %q, %v", v.S, err)
+	}
+	if err := UnmarshalBytes(&v, []byte{2, 0x40, 1}, binary.BigEndian, BlobLength8); err == nil || !strings.Contains(err.Error(), "undefined") {
+		t.Errorf("expected an undefined byte error, got %v", err)
+	}
+}
@@ -0,0 +1,345 @@
+package marshal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+//CHeader writes a C header declaring a struct for v's type and every struct type
+//it contains, so C code can read and write the encoding of v produced with order,
+//length and opts. Each C struct holds the fixed-size prefix of its Go struct,
+//fields after the first variable size one are listed in a comment as
+//pseudo-fields with how they are encoded. Without CLayout the structs are
+//declared under #pragma pack(1) since Marshal writes no padding
+func CHeader(v interface{}, order binary.ByteOrder, length LengthType, w io.Writer, opts ...Option) error {
+	s, err := Describe(v, opts...)
+	if err != nil {
+		return err
+	}
+	for s.Kind == reflect.Ptr {
+		s = s.Elem
+	}
+	if s.Kind != reflect.Struct || s.Custom || s.Optional {
+		return fmt.Errorf("cheader: %s is not a struct", s.Type)
+	}
+	g := cheader{names: map[reflect.Type]string{}}
+	g.collect(s, s.Type.Name())
+	bw := bufio.NewWriter(w)
+	guard := strings.ToUpper(s.Type.Name()) + "_H"
+	fmt.Fprintf(bw, "/* Generated from %s by marshal.CHeader, DO NOT EDIT.\n *\n", s.Type)
+	fmt.Fprintf(bw, " * Integers and floats are %s, %s.\n */\n", orderName(order), lengthNote(length))
+	fmt.Fprintf(bw, "#ifndef %s\n#define %s\n\n#include <stdint.h>\n\n", guard, guard)
+	pack := newOptions(opts).pack
+	switch pack {
+	case 0:
+		fmt.Fprintf(bw, "#pragma pack(push, 1)\n\n")
+	case 8:
+	default:
+		fmt.Fprintf(bw, "#pragma pack(push, %d)\n\n", pack)
+	}
+	for _, st := range g.order {
+		g.define(bw, st)
+	}
+	if pack != 8 {
+		fmt.Fprintf(bw, "#pragma pack(pop)\n\n")
+	}
+	fmt.Fprintf(bw, "#endif /* %s */\n", guard)
+	return bw.Flush()
+}
+
+type cheader struct {
+	//names are the C names of the struct types, anonymous ones are named after their field
+	names map[reflect.Type]string
+	//order has every struct after the structs it contains
+	order []*Schema
+}
+
+func (g *cheader) collect(s *Schema, name string) {
+	if s == nil || s.Custom || s.Optional {
+		return
+	}
+	switch s.Kind {
+	case reflect.Struct:
+		if _, ok := g.names[s.Type]; ok || len(s.Fields) == 0 {
+			return
+		}
+		if s.Type.Name() != "" {
+			name = s.Type.Name()
+		}
+		g.names[s.Type] = name
+		for _, f := range s.Fields {
+			g.collect(f.Schema, name+"_"+f.Name)
+		}
+		g.order = append(g.order, s)
+	case reflect.Map:
+		g.collect(s.Key, name+"_key")
+		g.collect(s.Elem, name+"_value")
+	default:
+		g.collect(s.Elem, name)
+	}
+}
+
+func (g *cheader) define(w io.Writer, s *Schema) {
+	fmt.Fprintf(w, "struct %s {\n", g.names[s.Type])
+	var tail []string
+	for i := 0; i < len(s.Fields); i++ {
+		f := &s.Fields[i]
+		name := f.Name
+		if name == "_" {
+			name = fmt.Sprintf("_reserved%d", i)
+		}
+		if f.Bits > 0 {
+			//C bitfield layout is up to the compiler, declare the group's bytes
+			j := i + 1
+			for j < len(s.Fields) && s.Fields[j].Bits > 0 && s.Fields[j].BitOffset > 0 {
+				j++
+			}
+			var widths []string
+			for _, b := range s.Fields[i:j] {
+				widths = append(widths, fmt.Sprintf("%s:%d", b.Name, b.Bits))
+			}
+			last := &s.Fields[j-1]
+			decl := fmt.Sprintf("uint8_t _bits%d[%d]", i, (last.BitOffset+last.Bits+7)/8)
+			note := fmt.Sprintf("bit fields %s, %s", strings.Join(widths, " "), bitOrder(s.Fields[i:j]))
+			if f.Offset >= 0 {
+				fmt.Fprintf(w, "\t%s; /* offset %d, %s */\n", decl, f.Offset, note)
+			} else {
+				tail = append(tail, decl+"; "+note)
+			}
+			i = j - 1
+			continue
+		}
+		decl, note := g.decl(f, name)
+		if f.Offset >= 0 && f.Size >= 0 && decl != "" {
+			if note != "" {
+				note = ", " + note
+			}
+			fmt.Fprintf(w, "\t%s; /* offset %d%s */\n", decl, f.Offset, note)
+			continue
+		}
+		if decl == "" {
+			decl = name
+		}
+		decl += ";"
+		if note != "" {
+			decl += " " + note
+		}
+		tail = append(tail, decl)
+	}
+	if len(tail) > 0 {
+		fmt.Fprintf(w, "\t/* variable size fields follow in this order:\n")
+		for _, t := range tail {
+			fmt.Fprintf(w, "\t *\t%s\n", t)
+		}
+		fmt.Fprintf(w, "\t */\n")
+	}
+	if s.Size >= 0 {
+		fmt.Fprintf(w, "}; /* sizeof %d */\n\n", s.Size)
+	} else {
+		fmt.Fprintf(w, "};\n\n")
+	}
+}
+
+//decl is the C declaration of the field f named name and a note on its encoding,
+//decl is empty when C has no type for it
+func (g *cheader) decl(f *SchemaField, name string) (decl, note string) {
+	ft := &fieldTag{}
+	if f.Tag != "" {
+		note = "marshal:\"" + f.Tag + "\""
+		//the tag was checked when the plan was built
+		ft, _ = parseTag(f.Tag)
+	}
+	ct, dims := g.ctype(f.Schema)
+	switch {
+	case f.Custom:
+		return "", joinNote("encoded by its codec", note)
+	case f.Optional:
+		return "", joinNote("presence byte, then the value when it is 1", note)
+	case ft.reserved > 0:
+		return fmt.Sprintf("uint8_t %s[%d]", name, f.Size), "reserved, zero"
+	case ft.magic != nil:
+		return fmt.Sprintf("uint8_t %s[%d]", name, f.Size), fmt.Sprintf("magic % x", ft.magic)
+	case ft.bcd > 0:
+		return fmt.Sprintf("uint8_t %s[%d]", name, f.Size), joinNote(fmt.Sprintf("%d packed BCD digits", ft.bcd), note)
+	case ft.bcdVar:
+		return fmt.Sprintf("uint8_t %s[]", name), joinNote("length prefix of the digit count, then packed BCD digits", note)
+	case ft.ascii > 0:
+		return fmt.Sprintf("char %s[%d]", name, f.Size), joinNote("decimal digits", note)
+	case ft.enum != nil:
+		return fmt.Sprintf("uint%d_t %s", ft.enum.bits, name), note
+	case ft.delta && f.Kind == reflect.Slice:
+		return "", joinNote("length prefix, then the first element and the differences to the one before as varints", note)
+	case ft.delta:
+		return "", joinNote(fmt.Sprintf("%d elements, the first and the differences to the one before as varints", f.Len), note)
+	case ft.packbits > 0 && f.Kind == reflect.Array:
+		return fmt.Sprintf("uint8_t %s[%d]", name, f.Size), joinNote(fmt.Sprintf("%d elements of %d bits, most significant bit first", f.Len, ft.packbits), note)
+	case ft.packbits > 0:
+		return fmt.Sprintf("uint8_t %s[]", name), joinNote(fmt.Sprintf("length prefix, then elements of %d bits, most significant bit first", ft.packbits), note)
+	case ft.quantize != reflect.Invalid:
+		q := fmt.Sprintf("%s values, (x-bias)/scale rounded", ft.quantize)
+		if ft.scale != "" {
+			q += fmt.Sprintf(", scale in %s, bias in %s", ft.scale, ft.bias)
+		} else {
+			q = "double scale, double bias, then " + q
+		}
+		if f.Kind == reflect.Slice {
+			q = "length prefix, then " + q
+		}
+		if f.Kind == reflect.Array && ft.scale != "" {
+			return fmt.Sprintf("%s_t %s[%d]", ft.quantize, name, f.Len), joinNote(q, note)
+		}
+		return "", joinNote(q, note)
+	case ft.rle && f.Kind == reflect.Slice:
+		return "", joinNote("length prefix, then runs of a count and the repeated element", note)
+	case ft.rle:
+		return "", joinNote(fmt.Sprintf("%d elements as runs of a count and the repeated element", f.Len), note)
+	case ft.trimZero:
+		return "", joinNote("length prefix of the field count, then the leading fields up to the last non-zero one", note)
+	case f.Kind == reflect.String && f.Size >= 0:
+		return fmt.Sprintf("char %s[%d]", name, f.Size), note
+	case f.Kind == reflect.String && f.Prefixed:
+		return fmt.Sprintf("char %s[]", name), joinNote("length prefix, then the bytes", note)
+	case f.Kind == reflect.String:
+		return fmt.Sprintf("char %s[]", name), note
+	case f.Size >= 0 && ct != "":
+		return ct + " " + name + dims, note
+	case f.Kind == reflect.Slice && f.Prefixed:
+		return g.elemDecl(f.Elem, name), joinNote("length prefix, then the elements", note)
+	case f.Kind == reflect.Slice:
+		return g.elemDecl(f.Elem, name), note
+	case f.Kind == reflect.Array:
+		return g.elemDecl(f.Elem, name), joinNote(fmt.Sprintf("%d elements of variable size", f.Len), note)
+	case f.Kind == reflect.Map:
+		return "", joinNote("length prefix, then the key and value of each entry", note)
+	case f.Kind == reflect.Struct:
+		return ct + " " + name, joinNote("variable size", note)
+	case f.Kind == reflect.Ptr:
+		return "", joinNote("the value pointed to", note)
+	case f.Kind == reflect.Interface:
+		return "", joinNote("registered type id, length, then the value", note)
+	}
+	return "", note
+}
+
+func (g *cheader) elemDecl(elem *Schema, name string) string {
+	ct, dims := g.ctype(elem)
+	if ct == "" {
+		return ""
+	}
+	return ct + " " + name + "[]" + dims
+}
+
+//ctype is the C type of s and the array dimensions following the declared name
+func (g *cheader) ctype(s *Schema) (string, string) {
+	if s.Custom || s.Optional {
+		return "", ""
+	}
+	switch s.Kind {
+	case reflect.Bool, reflect.Uint8:
+		return "uint8_t", ""
+	case reflect.Int8:
+		return "int8_t", ""
+	case reflect.Int16:
+		return "int16_t", ""
+	case reflect.Uint16:
+		return "uint16_t", ""
+	case reflect.Int32:
+		return "int32_t", ""
+	case reflect.Uint32:
+		return "uint32_t", ""
+	case reflect.Int64:
+		return "int64_t", ""
+	case reflect.Uint64:
+		return "uint64_t", ""
+	case reflect.Float32:
+		return "float", ""
+	case reflect.Float64:
+		return "double", ""
+	case reflect.Complex64:
+		return "float", "[2]"
+	case reflect.Complex128:
+		return "double", "[2]"
+	case reflect.Struct:
+		return "struct " + g.names[s.Type], ""
+	case reflect.Array:
+		ct, dims := g.ctype(s.Elem)
+		return ct, fmt.Sprintf("[%d]", s.Len) + dims
+	}
+	return "", ""
+}
+
+func joinNote(a, b string) string {
+	if b == "" {
+		return a
+	}
+	return a + ", " + b
+}
+
+//bitOrder names the bit order of the group of fields
+func bitOrder(fields []SchemaField) string {
+	for _, f := range fields {
+		for _, item := range strings.Split(f.Tag, ",") {
+			switch strings.TrimSpace(item) {
+			case "lsb":
+				return "least significant bit first"
+			case "msb":
+				return "most significant bit first"
+			}
+		}
+	}
+	return "most significant bit first"
+}
+
+func orderName(order binary.ByteOrder) string {
+	switch order {
+	case binary.BigEndian:
+		return "big-endian"
+	case binary.LittleEndian:
+		return "little-endian"
+	case PDPEndian:
+		return "PDP-11 middle-endian"
+	case NativeEndian:
+		if hostLittle {
+			return "little-endian, the byte order of the generating host"
+		}
+		return "big-endian, the byte order of the generating host"
+	}
+	return order.String()
+}
+
+//lengthNote describes the length prefixes of length
+func lengthNote(length LengthType) string {
+	s, o := lengthWidth(length, reflect.String), lengthWidth(length, reflect.Slice)
+	width := func(n int) string {
+		if n < 0 {
+			return "of variable width"
+		}
+		return fmt.Sprintf("%d bytes", n)
+	}
+	if s == o {
+		return "length prefixes are " + width(s)
+	}
+	return "string length prefixes are " + width(s) + ", others " + width(o)
+}
+
+//lengthWidth is the size of the length prefixes length writes for kind k, -1
+//when it depends on the length
+func lengthWidth(length LengthType, k reflect.Kind) (n int) {
+	defer func() {
+		if recover() != nil {
+			n = -1
+		}
+	}()
+	var short, long bytes.Buffer
+	length().PutLength(&short, binary.BigEndian, k, 0)
+	length().PutLength(&long, binary.BigEndian, k, 200)
+	if short.Len() != long.Len() {
+		return -1
+	}
+	return short.Len()
+}
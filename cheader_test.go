@@ -0,0 +1,136 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type cHeaderMsg struct {
+	Magic   [4]byte
+	Version uint16
+	_       struct{} `marshal:"reserved=2"`
+	Flags   schemaFlags
+	Code    string `marshal:"fixed=6"`
+	Point   cInner
+	Scale   float64
+	Name    string
+	Items   []cInner
+	Tail    uint32
+}
+
+func TestCHeader(t *testing.T) {
+	out := new(bytes.Buffer)
+	if err := CHeader(&cHeaderMsg{}, binary.LittleEndian, BlobLength32, out); err != nil {
+		t.Fatal(err)
+	}
+	expected := `/* Generated from marshal.cHeaderMsg by marshal.CHeader, DO NOT EDIT.
+ *
+ * Integers and floats are little-endian, length prefixes are 4 bytes.
+ */
+#ifndef CHEADERMSG_H
+#define CHEADERMSG_H
+
+#include <stdint.h>
+
+#pragma pack(push, 1)
+
+struct schemaFlags {
+	uint8_t _bits0[1]; /* offset 0, bit fields A:3 B:1 C:4, least significant bit first */
+}; /* sizeof 1 */
+
+struct cInner {
+	int16_t S; /* offset 0 */
+	uint8_t C; /* offset 2 */
+}; /* sizeof 3 */
+
+struct cHeaderMsg {
+	uint8_t Magic[4]; /* offset 0 */
+	uint16_t Version; /* offset 4 */
+	uint8_t _reserved2[2]; /* offset 6, reserved, zero */
+	struct schemaFlags Flags; /* offset 8 */
+	char Code[6]; /* offset 9, marshal:"fixed=6" */
+	struct cInner Point; /* offset 15 */
+	double Scale; /* offset 18 */
+	/* variable size fields follow in this order:
+	 *	char Name[]; length prefix, then the bytes
+	 *	struct cInner Items[]; length prefix, then the elements
+	 *	uint32_t Tail;
+	 */
+};
+
+#pragma pack(pop)
+
+#endif /* CHEADERMSG_H */
+`
+	if out.String() != expected {
+		t.Errorf("CHeader output:\n%s\nwant:\n%s", out, expected)
+	}
+	if err := CHeader(uint8(0), binary.LittleEndian, BlobLength32, out); err == nil {
+		t.Errorf("expected an error for a non-struct")
+	}
+}
+
+//cHeaderMain prints the fixed-size prefix of a cHeaderMsg read from stdin
+const cHeaderMain = `#include <stdio.h>
+#include "msg.h"
+
+int main(void)
+{
+	struct cHeaderMsg m;
+	if (fread(&m, sizeof m, 1, stdin) != 1)
+		return 1;
+	printf("%.4s %u %02x %.6s %d %u %g %zu\n", (char *)m.Magic, m.Version, m.Flags._bits0[0],
+	       m.Code, m.Point.S, m.Point.C, m.Scale, sizeof m);
+	return 0;
+}
+`
+
+//TestCHeaderCompiled has a C compiler read the fixed-size prefix of an encoded
+//message through the generated header
+func TestCHeaderCompiled(t *testing.T) {
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("no C compiler")
+	}
+	if binary.NativeEndian.Uint16([]byte{1, 0}) != 1 {
+		t.Skip("needs a little-endian machine")
+	}
+	dir := t.TempDir()
+	header := new(bytes.Buffer)
+	if err := CHeader(&cHeaderMsg{}, binary.LittleEndian, BlobLength32, header); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "msg.h"), header.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "main.c"), []byte(cHeaderMain), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(dir, "main")
+	if out, err := exec.Command(cc, "-o", bin, filepath.Join(dir, "main.c")).CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	v := cHeaderMsg{Magic: [4]byte{'M', 'S', 'G', '1'}, Version: 513, Flags: schemaFlags{A: 5, B: true, C: 9},
+		Code: "ABCDEF", Point: cInner{S: -300, C: 7}, Scale: 1.25, Name: "tail", Items: []cInner{{1, 2}}, Tail: 3}
+	b, err := MarshalBytes(&v, binary.LittleEndian, BlobLength32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(bin)
+	cmd.Stdin = bytes.NewReader(b)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "MSG1 513 9d ABCDEF -300 7 1.25 26\n"; string(out) != expected {
+		t.Errorf("C read %q, want %q", out, expected)
+	}
+	if !strings.Contains(header.String(), "}; /* sizeof 3 */") {
+		t.Errorf("missing size of cInner")
+	}
+}
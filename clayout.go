@@ -0,0 +1,98 @@
+package marshal
+
+import (
+	"fmt"
+	"reflect"
+)
+
+//CLayout makes Marshal and Unmarshal lay structs out the way a C compiler does:
+//zero padding goes before each field up to its alignment and after the last one
+//up to the struct's, so structs written with fwrite round-trip byte for byte.
+//
+//Alignments are those of the x86-64 and arm64 ABIs: the size of the integer or
+//float, the element's for arrays, the largest field's for structs. pack caps them
+//like #pragma pack(pack), it must be 1, 2, 4 or 8 and 0 means no cap.
+//Only fixed-size fields have a C counterpart, everything else is aligned to 1
+func CLayout(pack int) Option {
+	switch pack {
+	case 0:
+		pack = 8
+	case 1, 2, 4, 8:
+	default:
+		panic(fmt.Errorf("marshal: invalid C layout pack %d", pack))
+	}
+	return func(o *options) {
+		o.pack = pack
+	}
+}
+
+//cAlign is the C alignment of t capped at pack
+func cAlign(t reflect.Type, pack int) int {
+	a := 1
+	p := planFor(t)
+	if p.custom || p.optional {
+		return a
+	}
+	switch t.Kind() {
+	case reflect.Int16, reflect.Uint16:
+		a = 2
+	case reflect.Int32, reflect.Uint32, reflect.Float32, reflect.Complex64:
+		a = 4
+	case reflect.Int64, reflect.Uint64, reflect.Float64, reflect.Complex128:
+		a = 8
+	case reflect.Array:
+		return cAlign(t.Elem(), pack)
+	case reflect.Struct:
+		for i := range p.fields {
+			if fa := fieldAlign(t, &p.fields[i], pack); fa > a {
+				a = fa
+			}
+		}
+	}
+	return min(a, pack)
+}
+
+//fieldAlign is the C alignment of f, tagged fields have their own layout and aren't aligned
+func fieldAlign(t reflect.Type, f *fieldPlan, pack int) int {
+	if f.tag != nil || f.bits != nil {
+		return 1
+	}
+	return cAlign(t.Field(f.index).Type, pack)
+}
+
+//cSize is the C sizeof of the fixed-size type t, padding included
+func cSize(t reflect.Type, pack int) int {
+	p := planFor(t)
+	switch t.Kind() {
+	case reflect.Array:
+		return t.Len() * cSize(t.Elem(), pack)
+	case reflect.Struct:
+		n := 0
+		for i := range p.fields {
+			f := &p.fields[i]
+			n = alignUp(n, fieldAlign(t, f, pack))
+			n += cSize(t.Field(f.index).Type, pack)
+		}
+		return alignUp(n, cAlign(t, pack))
+	}
+	return p.size
+}
+
+func alignUp(n, a int) int {
+	return (n + a - 1) / a * a
+}
+
+//pad writes the zero padding that brings the struct of type t begun at start to alignment a
+func (m *marshaler) pad(t reflect.Type, start int64, a int) {
+	if n := int(m.cw.n - start); n%a != 0 {
+		m.reserved(t, alignUp(n, a)-n)
+	}
+}
+
+//pad consumes the padding that brings the struct of type t begun at start to
+//alignment a, with Strict it must be zero
+func (u *unmarshaler) pad(t reflect.Type, start int64, a int) {
+	if n := int(u.cr.n - start); n%a != 0 {
+		u.reserved(t, alignUp(n, a)-n)
+	}
+}
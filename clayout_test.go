@@ -0,0 +1,137 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type cMixed struct {
+	C uint8
+	I uint32
+	S uint16
+}
+
+type cWide struct {
+	C uint8
+	D float64
+	E [3]int8
+}
+
+type cInner struct {
+	S int16
+	C uint8
+}
+
+type cNested struct {
+	C  uint8
+	In [2]cInner
+	Q  uint64
+	F  float32
+}
+
+//cVectors are the output of testdata/clayout.c built with gcc on x86-64
+var cVectors = []struct {
+	name string
+	pack int
+	hex  string
+}{
+	{"mixed", 0, "110000005544332277660000"},
+	{"wide", 0, "1100000000000000000000000000f83f01fe030000000000"},
+	{"nested", 0, "1100feff22004433550000000000000008070605040302010000004000000000"},
+	{"mixed", 1, "11554433227766"},
+	{"wide", 1, "11000000000000f83f01fe03"},
+	{"nested", 1, "11feff22443355080706050403020100000040"},
+	{"mixed", 2, "1100554433227766"},
+	{"wide", 2, "1100000000000000f83f01fe0300"},
+	{"nested", 2, "1100feff220044335500080706050403020100000040"},
+	{"mixed", 4, "110000005544332277660000"},
+	{"wide", 4, "11000000000000000000f83f01fe0300"},
+	{"nested", 4, "1100feff2200443355000000080706050403020100000040"},
+}
+
+func TestCLayout(t *testing.T) {
+	values := map[string]interface{}{
+		"mixed":  &cMixed{C: 0x11, I: 0x22334455, S: 0x6677},
+		"wide":   &cWide{C: 0x11, D: 1.5, E: [3]int8{1, -2, 3}},
+		"nested": &cNested{C: 0x11, In: [2]cInner{{-2, 0x22}, {0x3344, 0x55}}, Q: 0x0102030405060708, F: 2},
+	}
+	for _, c := range cVectors {
+		expected, _ := hex.DecodeString(c.hex)
+		v := values[c.name]
+		packs := []int{c.pack}
+		if c.pack == 0 {
+			packs = append(packs, 8)
+		}
+		for _, pack := range packs {
+			b, err := MarshalBytes(v, binary.LittleEndian, BlobLength8, CLayout(pack))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, expected) {
+				t.Errorf("%s pack %d: encoded % x, want % x", c.name, pack, b, expected)
+			}
+			readBack := reflect.New(reflect.TypeOf(v).Elem())
+			if err := UnmarshalBytes(readBack.Interface(), expected, binary.LittleEndian, BlobLength8, CLayout(pack), Strict()); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(readBack.Interface(), v) {
+				t.Errorf("%s pack %d: decoded %+v, want %+v", c.name, pack, readBack.Elem(), v)
+			}
+			n, err := Skip(bytes.NewReader(expected), v, binary.LittleEndian, BlobLength8, CLayout(pack))
+			if err != nil || n != int64(len(expected)) {
+				t.Errorf("%s pack %d: skipped %d, %v, want %d", c.name, pack, n, err, len(expected))
+			}
+		}
+	}
+}
+
+func TestCLayoutVariableFields(t *testing.T) {
+	type message struct {
+		C    uint8
+		Name string
+		I    uint32
+	}
+	v := message{C: 1, Name: "ab", I: 0x02030405}
+	b, err := MarshalBytes(&v, binary.BigEndian, BlobLength8, CLayout(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the string is aligned to 1, I to 4 from the start of the struct
+	if expected := []byte{1, 2, 'a', 'b', 2, 3, 4, 5}; !bytes.Equal(b, expected) {
+		t.Errorf("encoded % x, want % x", b, expected)
+	}
+	v.Name = "abc"
+	b, err = MarshalBytes(&v, binary.BigEndian, BlobLength8, CLayout(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{1, 3, 'a', 'b', 'c', 0, 0, 0, 2, 3, 4, 5}; !bytes.Equal(b, expected) {
+		t.Errorf("encoded % x, want % x", b, expected)
+	}
+	var readBack message
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, BlobLength8, CLayout(0)); err != nil || readBack != v {
+		t.Errorf("decoded %+v, %v", readBack, err)
+	}
+}
+
+func TestCLayoutErrors(t *testing.T) {
+	dirty, _ := hex.DecodeString("110000ff5544332277660000")
+	var v cMixed
+	if err := UnmarshalBytes(&v, dirty, binary.LittleEndian, BlobLength8, CLayout(0)); err != nil {
+		t.Errorf("lenient decode: %v", err)
+	}
+	err := UnmarshalBytes(&v, dirty, binary.LittleEndian, BlobLength8, CLayout(0), Strict())
+	if err == nil || !strings.Contains(err.Error(), "offset 3") {
+		t.Errorf("expected an error naming offset 3, got %v", err)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a panic for pack 3")
+		}
+	}()
+	CLayout(3)
+}
@@ -0,0 +1,93 @@
+//Command marshal-cheader writes a C header for a Go message type, see marshal.CHeader.
+//
+//	marshal-cheader -type example.com/proto.Header -order little -length BlobLength16 > header.h
+//
+//It generates a small program calling marshal.CHeader on the type and runs it
+//with go run from the current directory, so the type's package must be
+//importable from there
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const marshalPath = "gitlab.yypm.com/marshal"
+
+var (
+	typeName = flag.String("type", "", "import path and name of the type, e.g. example.com/proto.Header")
+	order    = flag.String("order", "big", "byte order, big or little")
+	length   = flag.String("length", "BlobLength32", "name of the marshal LengthType")
+	pack     = flag.Int("pack", -1, "lay structs out like C with marshal.CLayout(pack), -1 for none")
+)
+
+var ident = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func main() {
+	flag.Parse()
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, "marshal-cheader:", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	dot := strings.LastIndex(*typeName, ".")
+	if dot < 0 || strings.LastIndex(*typeName, "/") > dot || !ident.MatchString((*typeName)[dot+1:]) {
+		return fmt.Errorf("bad -type %q, want importpath.Name", *typeName)
+	}
+	pkg, name := (*typeName)[:dot], (*typeName)[dot+1:]
+	var byteOrder string
+	switch *order {
+	case "big":
+		byteOrder = "binary.BigEndian"
+	case "little":
+		byteOrder = "binary.LittleEndian"
+	default:
+		return fmt.Errorf("bad -order %q, want big or little", *order)
+	}
+	if !ident.MatchString(*length) {
+		return fmt.Errorf("bad -length %q", *length)
+	}
+	opts := ""
+	if *pack >= 0 {
+		opts = fmt.Sprintf(", marshal.CLayout(%d)", *pack)
+	}
+	dir, err := os.MkdirTemp("", "marshal-cheader")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	prog := fmt.Sprintf(program, marshalPath, pkg, name, byteOrder, *length, opts)
+	file := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(file, []byte(prog), 0o644); err != nil {
+		return err
+	}
+	cmd := exec.Command("go", "run", file)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+const program = `package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+
+	marshal %q
+	pkg %q
+)
+
+func main() {
+	if err := marshal.CHeader(new(pkg.%s), %s, marshal.%s, os.Stdout%s); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+`
@@ -0,0 +1,86 @@
+package marshal
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"unsafe"
+)
+
+//coalesceSize is the largest payload copied after its length prefix so both go
+//out in one Write, longer ones are written as net.Buffers
+const coalesceSize = 256
+
+//stageWriter appends what is written to b
+type stageWriter struct {
+	b []byte
+}
+
+func (s *stageWriter) Write(p []byte) (int, error) {
+	s.b = append(s.b, p...)
+	return len(p), nil
+}
+
+//vectors are the buffers of a payload written as net.Buffers, kept with the
+//marshaler so writing them doesn't allocate
+type vectors struct {
+	bufs [3][]byte
+	v    net.Buffers
+}
+
+//payload writes the length prefix of the string or byte slice b of type t, b and
+//its trailer in a single Write. Payloads over coalesceSize are written as
+//net.Buffers instead of copied, a single writev on TCP and Unix connections
+func (m *marshaler) payload(length LengthTypeInstance, t reflect.Type, b []byte) {
+	if m.trace != nil {
+		//the prefix is an event of its own
+		m.putLength(length, t, len(b))
+		if _, err := m.w.Write(b); err != nil {
+			panic(err)
+		}
+		m.trailer(length, t)
+		return
+	}
+	m.stage.b = m.stage.b[:0]
+	m.putLengthTo(&m.stage, length, t, len(b))
+	trailer := hasTrailer(length, t)
+	if len(b) <= coalesceSize {
+		m.stage.b = append(m.stage.b, b...)
+		if trailer {
+			m.stage.b = append(m.stage.b, crlf...)
+		}
+		if _, err := m.w.Write(m.stage.b); err != nil {
+			panic(err)
+		}
+		return
+	}
+	m.vec.bufs[0], m.vec.bufs[1] = m.stage.b, b
+	m.vec.v = m.vec.bufs[:2]
+	if trailer {
+		m.vec.bufs[2] = crlf
+		m.vec.v = m.vec.bufs[:3]
+	}
+	//past the counter, which only sees writes through m.w
+	n, err := m.vec.v.WriteTo(m.cw.w)
+	m.cw.n += n
+	m.vec.bufs = [3][]byte{}
+	if err != nil {
+		panic(err)
+	}
+}
+
+//stringBytes returns the bytes of s without copying them, they must not be modified
+func stringBytes(s string) []byte {
+	return unsafe.Slice(unsafe.StringData(s), len(s))
+}
+
+//putLengthTo writes the length prefix l of a value of type t to w
+func (m *marshaler) putLengthTo(w io.Writer, length LengthTypeInstance, t reflect.Type, l int) {
+	//the sentinels are the only negative lengths the marshaler means to write
+	if l < 0 && l != restOfRegion && l != nullLength {
+		panic(fmt.Errorf("%w: %d for %s", ErrNegativeLength, l, t))
+	}
+	defer m.lengthError()
+	length.PutLength(w, m.order, t.Kind(), l)
+}
@@ -0,0 +1,54 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+type coalesceMsg struct {
+	ID   uint16
+	Name string
+	Data []byte
+	Code string `marshal:"charset=latin1"`
+}
+
+func TestCoalescePayload(t *testing.T) {
+	cases := []struct {
+		v      coalesceMsg
+		writes int
+	}{
+		//one Write per field
+		{coalesceMsg{1, "short", []byte{1, 2, 3}, "café"}, 4},
+		{coalesceMsg{}, 4},
+		//prefix and payload of the long string and slice, written one after the
+		//other since the writer doesn't take net.Buffers
+		{coalesceMsg{2, strings.Repeat("n", coalesceSize+1), bytes.Repeat([]byte{7}, 1000), "x"}, 6},
+	}
+	for _, c := range cases {
+		expected, err := MarshalBytes(&c.v, binary.BigEndian, BlobLength16, WithTrace(func(TraceEvent) {}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := new(countingWriter)
+		n, err := encode(&c.v, w, binary.BigEndian, BlobLength16(), newOptions(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(w.Bytes(), expected) || n != int64(len(expected)) {
+			t.Errorf("encoded % x, %d bytes, want % x", w.Bytes(), n, expected)
+		}
+		if w.writes != c.writes {
+			t.Errorf("%d writes, want %d", w.writes, c.writes)
+		}
+	}
+	//the trailer of RESPLength goes with the payload
+	w := new(countingWriter)
+	if err := Marshal("hello", w, binary.BigEndian, RESPLength('$', 9, true)); err != nil {
+		t.Fatal(err)
+	}
+	if w.String() != "$5\r\nhello\r\n" || w.writes != 1 {
+		t.Errorf("encoded %q in %d writes, want one", w.String(), w.writes)
+	}
+}
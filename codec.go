@@ -0,0 +1,128 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync/atomic"
+)
+
+//Codec bundles the byte order, length type and options of one wire format so
+//both sides of a protocol are configured in a single place
+type Codec struct {
+	order  binary.ByteOrder
+	length LengthType
+	opts   []Option
+	o      *options
+}
+
+//defaultCodec is the process-wide codec set by SetDefault
+var defaultCodec atomic.Pointer[Codec]
+
+//ErrNoDefault is returned by MarshalDefault and UnmarshalDefault before SetDefault is called
+var ErrNoDefault = errors.New("marshal: no default codec, call SetDefault")
+
+//SetDefault sets the byte order, length type and options MarshalDefault and
+//UnmarshalDefault use. It is meant to be called once during initialization,
+//but is safe to call concurrently with the functions reading it
+func SetDefault(order binary.ByteOrder, length LengthType, opts ...Option) {
+	defaultCodec.Store(NewCodec(order, length, opts...))
+}
+
+//MarshalDefault put binary presentation of v into w with the settings of SetDefault
+func MarshalDefault(v interface{}, w io.Writer) error {
+	c := defaultCodec.Load()
+	if c == nil {
+		return ErrNoDefault
+	}
+	return c.Marshal(v, w)
+}
+
+//UnmarshalDefault read binary presentation of data from r into m with the settings of SetDefault
+func UnmarshalDefault(m interface{}, r io.Reader) error {
+	c := defaultCodec.Load()
+	if c == nil {
+		return ErrNoDefault
+	}
+	return c.Unmarshal(m, r)
+}
+
+//NewCodec creates a Codec encoding with order and length, opts apply to every call
+func NewCodec(order binary.ByteOrder, length LengthType, opts ...Option) *Codec {
+	return &Codec{order: order, length: length, opts: opts, o: newOptions(opts)}
+}
+
+//Order returns the byte order of c
+func (c *Codec) Order() binary.ByteOrder {
+	return c.order
+}
+
+//Length returns the length type of c
+func (c *Codec) Length() LengthType {
+	return c.length
+}
+
+//Marshal put binary presentation of v into w, see Marshal
+func (c *Codec) Marshal(v interface{}, w io.Writer) error {
+	_, err := encode(v, w, c.order, c.length(), c.o)
+	return err
+}
+
+//Unmarshal read binary presentation of data from r into m, see Unmarshal
+func (c *Codec) Unmarshal(m interface{}, r io.Reader) error {
+	_, err := decode(m, r, c.order, c.length(), c.o)
+	return err
+}
+
+//MarshalBytes returns binary presentation of v
+func (c *Codec) MarshalBytes(v interface{}) ([]byte, error) {
+	return marshalBytes(v, c.order, c.length(), c.o)
+}
+
+//UnmarshalBytes read binary presentation of data from b into m
+func (c *Codec) UnmarshalBytes(m interface{}, b []byte) error {
+	_, err := decode(m, &sliceReader{b: b}, c.order, c.length(), c.o)
+	return err
+}
+
+//Size returns the number of bytes Marshal would write for v
+func (c *Codec) Size(v interface{}) (int, error) {
+	n, err := encode(v, io.Discard, c.order, c.length(), c.o)
+	return int(n), err
+}
+
+//NewEncoder returns an Encoder writing to w with the settings of c
+func (c *Codec) NewEncoder(w io.Writer) *Encoder {
+	return NewEncoder(w, c.order, c.length, c.opts...)
+}
+
+//NewDecoder returns a Decoder reading from r with the settings of c
+func (c *Codec) NewDecoder(r io.Reader) *Decoder {
+	return NewDecoder(r, c.order, c.length, c.opts...)
+}
+
+//MarshalBytes returns binary presentation of v encoded using specified byte order and length type
+func MarshalBytes(v interface{}, order binary.ByteOrder, length LengthType, opts ...Option) ([]byte, error) {
+	return marshalBytes(v, order, length(), newOptions(opts))
+}
+
+func marshalBytes(v interface{}, order binary.ByteOrder, length LengthTypeInstance, o *options) ([]byte, error) {
+	b := new(bytes.Buffer)
+	if _, err := encode(v, b, order, length, o); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+//UnmarshalBytes read binary presentation of data from b into m, see Unmarshal
+func UnmarshalBytes(m interface{}, b []byte, order binary.ByteOrder, length LengthType, opts ...Option) error {
+	_, err := decode(m, &sliceReader{b: b}, order, length(), newOptions(opts))
+	return err
+}
+
+//Size returns the number of bytes Marshal would write for v
+func Size(v interface{}, order binary.ByteOrder, length LengthType, opts ...Option) (int, error) {
+	n, err := encode(v, io.Discard, order, length(), newOptions(opts))
+	return int(n), err
+}
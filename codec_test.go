@@ -0,0 +1,80 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+//createStableObject returns a Foo whose encoding is deterministic, s_foo's map
+//has several keys and is encoded in map iteration order
+func createStableObject() *Foo {
+	f := s_foo
+	f.Bar.Prop = map[string]uint32{"abc": 1}
+	return &f
+}
+
+func TestCodec(t *testing.T) {
+	c := NewCodec(binary.BigEndian, BlobLength16)
+	proto := createStableObject()
+	b, e := c.MarshalBytes(proto)
+	if e != nil {
+		t.Fatalf("MarshalBytes: %v", e)
+	}
+	expected, _ := MarshalBytes(proto, binary.BigEndian, BlobLength16)
+	if !bytes.Equal(b, expected) {
+		t.Errorf("Codec.MarshalBytes differs from MarshalBytes")
+	}
+	size, e := c.Size(proto)
+	if e != nil || size != len(b) {
+		t.Errorf("Size: %d, %v, want %d", size, e, len(b))
+	}
+	var readBack Foo
+	if e := c.UnmarshalBytes(&readBack, b); e != nil {
+		t.Fatalf("UnmarshalBytes: %v", e)
+	}
+	if !reflect.DeepEqual(*proto, readBack) {
+		t.Errorf("proto and readBack are NOT equal")
+	}
+
+	stream := new(bytes.Buffer)
+	enc := c.NewEncoder(stream)
+	for i := 0; i < 3; i++ {
+		if e := enc.Encode(proto); e != nil {
+			t.Fatalf("Encode: %v", e)
+		}
+	}
+	if stream.Len() != 3*len(b) {
+		t.Errorf("encoded %d bytes, want %d", stream.Len(), 3*len(b))
+	}
+	dec := c.NewDecoder(stream)
+	for i := 0; i < 3; i++ {
+		var readBack Foo
+		if e := dec.Decode(&readBack); e != nil {
+			t.Fatalf("Decode %d: %v", i, e)
+		}
+		if !reflect.DeepEqual(*proto, readBack) {
+			t.Errorf("message %d: proto and readBack are NOT equal", i)
+		}
+	}
+}
+
+func TestDefault(t *testing.T) {
+	defer defaultCodec.Store(nil)
+	var buf bytes.Buffer
+	if err := MarshalDefault(uint16(1), &buf); err != ErrNoDefault {
+		t.Errorf("expected ErrNoDefault, got %v", err)
+	}
+	SetDefault(binary.BigEndian, BlobLength8)
+	if err := MarshalDefault("ab", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), []byte{2, 'a', 'b'}) {
+		t.Errorf("encoded % x", buf.Bytes())
+	}
+	var s string
+	if err := UnmarshalDefault(&s, &buf); err != nil || s != "ab" {
+		t.Errorf("decoded %q, %v", s, err)
+	}
+}
@@ -0,0 +1,80 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"io"
+	"reflect"
+)
+
+//columnar writes a slice or array of fixed-size structs column by column: the
+//element count (slices only), then the first field of every element, then the
+//second field of every element and so on
+func (m *marshaler) columnar(v reflect.Value, p *typePlan, length LengthTypeInstance) {
+	l := v.Len()
+	if v.Kind() == reflect.Slice {
+		m.putLength(length, v.Type(), l)
+	}
+	ep := p.elem
+	for i := range ep.fields {
+		f := &ep.fields[i]
+		sz := f.plan.size
+		if sz == 0 || l == 0 {
+			continue
+		}
+		bp := getScratch(l * sz)
+		b := *bp
+		for j := 0; j < l; j++ {
+			putFixed(b[j*sz:(j+1)*sz], v.Index(j).Field(f.index), f.plan, m.order)
+		}
+		_, err := m.w.Write(b)
+		scratchPool.Put(bp)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+//columnChunk is the most bytes of a column read at a time
+const columnChunk = 4096
+
+func (u *unmarshaler) columnar(v reflect.Value, p *typePlan, order binary.ByteOrder, length LengthTypeInstance) {
+	l := v.Len()
+	//a slice grows as its first column is read, so that a count the input
+	//doesn't hold can't allocate it
+	grow := v.Kind() == reflect.Slice
+	if grow {
+		l = u.getLength(length, order, v.Type())
+		v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+	}
+	ep := p.elem
+	for i := range ep.fields {
+		f := &ep.fields[i]
+		sz := f.plan.size
+		if sz == 0 || l == 0 {
+			continue
+		}
+		rows := max(columnChunk/sz, 1)
+		bp := getScratch(min(l, rows) * sz)
+		for j := 0; j < l; j += rows {
+			k := min(rows, l-j)
+			b := (*bp)[:k*sz]
+			if _, e := io.ReadFull(u.r, b); e != nil {
+				scratchPool.Put(bp)
+				panic(e)
+			}
+			if grow {
+				v.Grow(k)
+				v.SetLen(j + k)
+			}
+			for r := 0; r < k; r++ {
+				getFixed(b[r*sz:(r+1)*sz], v.Index(j+r).Field(f.index), f.plan, order)
+			}
+		}
+		scratchPool.Put(bp)
+		grow = false
+	}
+	if grow {
+		//no column has bytes
+		v.Set(reflect.MakeSlice(v.Type(), l, l))
+	}
+}
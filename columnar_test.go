@@ -0,0 +1,93 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+type sample struct {
+	Tick  uint32
+	Value int16
+	OK    bool
+}
+
+type series struct {
+	Name    string
+	Samples []sample `marshal:"columnar"`
+	Last    [2]sample `marshal:"columnar"`
+}
+
+func TestColumnar(t *testing.T) {
+	s := series{
+		Name:    "cpu",
+		Samples: []sample{{1, -1, true}, {2, 300, false}, {3, 7, true}},
+		Last:    [2]sample{{4, 5, true}, {6, 7, false}},
+	}
+	result := new(bytes.Buffer)
+	if e := Marshal(&s, result, binary.BigEndian, BlobLength8); e != nil {
+		t.Fatalf("marshal: %v", e)
+	}
+	expected := []byte{
+		3, 'c', 'p', 'u',
+		3,
+		0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 3,
+		0xff, 0xff, 0x01, 0x2c, 0, 7,
+		1, 0, 1,
+		0, 0, 0, 4, 0, 0, 0, 6,
+		0, 5, 0, 7,
+		1, 0,
+	}
+	if !bytes.Equal(result.Bytes(), expected) {
+		t.Errorf("columnar encoding\n got %x\nwant %x", result.Bytes(), expected)
+	}
+	var readBack series
+	if e := Unmarshal(&readBack, result, binary.BigEndian, BlobLength8); e != nil {
+		t.Fatalf("unmarshal: %v", e)
+	}
+	if !reflect.DeepEqual(s, readBack) {
+		t.Errorf("proto and readBack are NOT equal: %+v", readBack)
+	}
+}
+
+func TestColumnarLong(t *testing.T) {
+	var v struct {
+		Samples []sample `marshal:"columnar"`
+	}
+	for i := 0; i < 3000; i++ {
+		v.Samples = append(v.Samples, sample{uint32(i), int16(-i), i%3 == 0})
+	}
+	b, err := MarshalBytes(&v, binary.BigEndian, BlobLength32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readBack := v
+	readBack.Samples = nil
+	if err := Unmarshal(&readBack, bytes.NewReader(b), binary.BigEndian, BlobLength32); err != nil || !reflect.DeepEqual(readBack, v) {
+		t.Errorf("read back %d samples, %v", len(readBack.Samples), err)
+	}
+	//a count the input doesn't hold fails before allocating for it
+	short := []byte{0x10, 0, 0, 0, 0, 0, 0, 1}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if err := Unmarshal(&readBack, bytes.NewReader(short), binary.BigEndian, BlobLength32); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("got %v, want io.ErrUnexpectedEOF", err)
+	}
+	runtime.ReadMemStats(&after)
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Errorf("allocated %d bytes for %d bytes of input", n, len(short))
+	}
+}
+
+func TestColumnarRejectsVariableElements(t *testing.T) {
+	var v struct {
+		Bars []bar `marshal:"columnar"`
+	}
+	if e := Marshal(&v, new(bytes.Buffer), binary.BigEndian, BlobLength8); e == nil {
+		t.Errorf("columnar slice of variable length elements should fail")
+	}
+}
@@ -0,0 +1,124 @@
+package marshal
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+)
+
+//compareValues walks a and b, which have the same type, and calls fn with the path of
+//every differing value until fn returns false. Nil and empty slices, maps and pointers
+//to zero values compare equal because the wire can't tell them apart, NaN equals NaN
+//and skipped fields are left out
+func compareValues(path []pathElem, a, b reflect.Value, fn func(path []pathElem, a, b reflect.Value) bool) bool {
+	switch a.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			if a.IsNil() && b.IsNil() {
+				return true
+			}
+			if a.IsNil() {
+				a = reflect.Zero(a.Type().Elem())
+				b = b.Elem()
+			} else {
+				a = a.Elem()
+				b = reflect.Zero(b.Type().Elem())
+			}
+			if a.Type() != b.Type() {
+				return fn(path, a, b)
+			}
+			return compareValues(path, a, b, fn)
+		}
+		a, b = a.Elem(), b.Elem()
+		if a.Type() != b.Type() {
+			return fn(path, a, b)
+		}
+		return compareValues(path, a, b, fn)
+	case reflect.Struct:
+		//skipped fields aren't on the wire
+		for _, f := range planFor(a.Type()).fields {
+			p := append(path, fieldElem(f.name))
+			if !compareValues(p, a.Field(f.index), b.Field(f.index), fn) {
+				return false
+			}
+		}
+		return true
+	case reflect.Slice, reflect.Array:
+		if a.Len() != b.Len() {
+			return fn(path, a, b)
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !compareValues(append(path, indexElem(i)), a.Index(i), b.Index(i), fn) {
+				return false
+			}
+		}
+		return true
+	case reflect.Map:
+		if a.Len() != b.Len() {
+			return fn(path, a, b)
+		}
+		iter := a.MapRange()
+		for iter.Next() {
+			bv := b.MapIndex(iter.Key())
+			p := append(path, keyElem(iter.Key(), false))
+			if !bv.IsValid() {
+				if !fn(p, iter.Value(), reflect.Value{}) {
+					return false
+				}
+				continue
+			}
+			if !compareValues(p, iter.Value(), bv, fn) {
+				return false
+			}
+		}
+		return true
+	case reflect.Float32, reflect.Float64:
+		x, y := a.Float(), b.Float()
+		if x == y || (math.IsNaN(x) && math.IsNaN(y)) {
+			return true
+		}
+		return fn(path, a, b)
+	case reflect.Complex64, reflect.Complex128:
+		x, y := a.Complex(), b.Complex()
+		if x == y || (sameFloat(real(x), real(y)) && sameFloat(imag(x), imag(y))) {
+			return true
+		}
+		return fn(path, a, b)
+	case reflect.Bool:
+		if a.Bool() == b.Bool() {
+			return true
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if a.Int() == b.Int() {
+			return true
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if a.Uint() == b.Uint() {
+			return true
+		}
+	case reflect.String:
+		if a.String() == b.String() {
+			return true
+		}
+	default:
+		if !a.IsValid() && !b.IsValid() {
+			return true
+		}
+	}
+	return fn(path, a, b)
+}
+
+func sameFloat(x, y float64) bool {
+	return x == y || (math.IsNaN(x) && math.IsNaN(y))
+}
+
+//formatValue prints v for diagnostics, invalid values print as <missing>
+func formatValue(v reflect.Value) string {
+	if !v.IsValid() {
+		return "<missing>"
+	}
+	if v.Kind() == reflect.String {
+		return fmt.Sprintf("%q", v.String())
+	}
+	return fmt.Sprintf("%v", v)
+}
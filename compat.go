@@ -0,0 +1,120 @@
+package marshal
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+//TypeFingerprint returns a SHA-256 hash identifying the wire layout of values of
+//type t written with order, length and opts, for peers to compare once instead
+//of a Fingerprint header on every value. It covers what Fingerprint does: the
+//Describe output without type and field names, the byte order and the length
+//prefixes, so it changes exactly when the layout does
+func TypeFingerprint(t reflect.Type, order binary.ByteOrder, length LengthType, opts ...Option) (sum [32]byte, err error) {
+	if t == nil {
+		return sum, errors.New("marshal: TypeFingerprint(nil)")
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	s, err := describeType(t, newOptions(opts))
+	if err != nil {
+		return sum, err
+	}
+	h := sha256.New()
+	(&layoutHasher{h: h, seen: map[*Schema]int{}}).schema(s)
+	hashEncoding(h, order, length())
+	h.Sum(sum[:0])
+	return sum, nil
+}
+
+//CompatibleWith reports whether values written with the layout b decode as
+//values of the layout a. They are when their layouts are identical, names
+//aside, except that a delimited struct in b may have more trailing fields than
+//in a, since decoding skips the bytes a delimited struct leaves. The error
+//joins one error per difference, each naming its path in a and wrapping
+//ErrSchemaMismatch
+func CompatibleWith(a, b *Schema) error {
+	c := compatChecker{seen: map[[2]*Schema]bool{}}
+	c.compare(a, b, a.Type.String(), false)
+	return errors.Join(c.errs...)
+}
+
+type compatChecker struct {
+	//seen holds the pairs compared so far, recursive types meet them again
+	seen map[[2]*Schema]bool
+	errs []error
+}
+
+func (c *compatChecker) fail(path, format string, args ...interface{}) {
+	c.errs = append(c.errs, fmt.Errorf("%w: %s: %s", ErrSchemaMismatch, path, fmt.Sprintf(format, args...)))
+}
+
+//compare compares a and b found at path, tolerant allows trailing fields in b
+func (c *compatChecker) compare(a, b *Schema, path string, tolerant bool) {
+	if c.seen[[2]*Schema{a, b}] {
+		return
+	}
+	c.seen[[2]*Schema{a, b}] = true
+	switch {
+	case a.Kind != b.Kind:
+		c.fail(path, "%s in a, %s in b", a.Kind, b.Kind)
+		return
+	case a.Custom != b.Custom || (a.Custom && a.Type != b.Type):
+		c.fail(path, "%s in a, %s in b, custom encodings must be of the same type", a.Type, b.Type)
+		return
+	case a.Optional != b.Optional:
+		c.fail(path, "optional in only one of a and b")
+		return
+	case a.Prefixed != b.Prefixed:
+		c.fail(path, "length prefix in only one of a and b")
+	case a.Kind == reflect.Array && a.Len != b.Len:
+		c.fail(path, "%d elements in a, %d in b", a.Len, b.Len)
+	case a.Kind != reflect.Struct && a.Size != b.Size:
+		c.fail(path, "%d bytes in a, %d in b", a.Size, b.Size)
+	}
+	if a.Kind == reflect.Struct {
+		c.fields(a, b, path, tolerant)
+	}
+	if a.Key != nil && b.Key != nil {
+		c.compare(a.Key, b.Key, path+"[key]", false)
+	}
+	if a.Elem != nil && b.Elem != nil {
+		c.compare(a.Elem, b.Elem, path+"[]", false)
+	}
+}
+
+func (c *compatChecker) fields(a, b *Schema, path string, tolerant bool) {
+	n := min(len(a.Fields), len(b.Fields))
+	for i := 0; i < n; i++ {
+		fa, fb := &a.Fields[i], &b.Fields[i]
+		fpath := path + "." + fa.Name
+		if fa.Tag != fb.Tag {
+			c.fail(fpath, "tag %q in a, %q in b", fa.Tag, fb.Tag)
+			continue
+		}
+		if fa.Bits != fb.Bits || fa.BitOffset != fb.BitOffset {
+			c.fail(fpath, "bits %d at %d in a, %d at %d in b", fa.Bits, fa.BitOffset, fb.Bits, fb.BitOffset)
+			continue
+		}
+		delimited := false
+		if fa.Tag != "" {
+			//the tag was checked when the plan was built
+			ft, _ := parseTag(fa.Tag)
+			delimited = ft.delimited
+		}
+		c.compare(fa.Schema, fb.Schema, fpath, delimited)
+	}
+	switch {
+	case len(a.Fields) > n:
+		c.fail(path, "%d fields in a, %d in b", len(a.Fields), len(b.Fields))
+	case len(b.Fields) > n && !tolerant:
+		c.fail(path, "%d fields in a, %d in b, trailing fields need a delimited struct", len(a.Fields), len(b.Fields))
+	case len(b.Fields) > n:
+	case a.Size != b.Size:
+		c.fail(path, "%d bytes in a, %d in b", a.Size, b.Size)
+	}
+}
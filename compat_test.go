@@ -0,0 +1,98 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type compatInner struct {
+	A uint16
+	B string
+}
+
+type compatInnerV2 struct {
+	A uint16
+	B string
+	C []uint32
+}
+
+type compatMsg struct {
+	ID    uint32
+	Inner compatInner `marshal:"delimited"`
+	Tail  []compatInner
+}
+
+type compatMsgV2 struct {
+	Key   uint32
+	Inner compatInnerV2 `marshal:"delimited"`
+	Tail  []compatInner
+}
+
+type compatMsgBad struct {
+	ID    uint64
+	Inner compatInner `marshal:"delimited"`
+	Tail  []compatInnerV2
+}
+
+func TestTypeFingerprint(t *testing.T) {
+	sum := func(v interface{}, length LengthType) [32]byte {
+		s, err := TypeFingerprint(reflect.TypeOf(v), binary.BigEndian, length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	if sum(compatMsg{}, BlobLength8) != sum(&compatMsg{}, BlobLength8) {
+		t.Errorf("pointers change the fingerprint")
+	}
+	if sum(compatMsg{}, BlobLength8) == sum(compatMsg{}, BlobLength16) {
+		t.Errorf("the length type doesn't change the fingerprint")
+	}
+	if sum(compatMsg{}, BlobLength8) == sum(compatMsgV2{}, BlobLength8) {
+		t.Errorf("a new field doesn't change the fingerprint")
+	}
+	if sum(compatInner{}, BlobLength8) != sum(struct {
+		X uint16
+		Y string
+	}{}, BlobLength8) {
+		t.Errorf("names change the fingerprint")
+	}
+}
+
+func TestCompatibleWith(t *testing.T) {
+	describe := func(v interface{}) *Schema {
+		s, err := Describe(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	v1, v2 := describe(compatMsg{}), describe(compatMsgV2{})
+	if err := CompatibleWith(v1, v1); err != nil {
+		t.Errorf("a schema is incompatible with itself: %v", err)
+	}
+	if err := CompatibleWith(v1, v2); err != nil {
+		t.Errorf("trailing fields of a delimited struct: %v", err)
+	}
+	err := CompatibleWith(v2, v1)
+	if !errors.Is(err, ErrSchemaMismatch) || !strings.Contains(err.Error(), "compatMsgV2.Inner: 3 fields in a, 2 in b") {
+		t.Errorf("expected missing fields to be reported, got %v", err)
+	}
+	err = CompatibleWith(v1, describe(compatMsgBad{}))
+	if err == nil || !strings.Contains(err.Error(), "compatMsg.ID: uint32 in a, uint64 in b") ||
+		!strings.Contains(err.Error(), "compatMsg.Tail[]: 2 fields in a, 3 in b") {
+		t.Errorf("expected the ID and Tail differences, got %v", err)
+	}
+	//what CompatibleWith accepts decodes
+	b, err := MarshalBytes(&compatMsgV2{1, compatInnerV2{2, "x", []uint32{3}}, nil}, binary.BigEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var readBack compatMsg
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, BlobLength8); err != nil || readBack.Inner.B != "x" {
+		t.Errorf("decoded %+v, %v", readBack, err)
+	}
+}
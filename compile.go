@@ -0,0 +1,79 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+//TypeCodec encodes and decodes values of a single type with fixed settings, see CompileType
+type TypeCodec struct {
+	t reflect.Type
+	c *Codec
+}
+
+//CompileType returns a TypeCodec for values of type t, or of the type t points to.
+//The type is checked like Validate with opts and length and its plan is built up
+//front, so a type that can't be encoded or decoded fails here instead of on the
+//first value. The plan is the one
+//Marshal and Unmarshal use for t, compiling a type only makes it ready early
+func CompileType(t reflect.Type, order binary.ByteOrder, length LengthType, opts ...Option) (*TypeCodec, error) {
+	if t == nil {
+		return nil, errors.New("marshal: CompileType(nil)")
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if err := newValidator(opts, length).check(t); err != nil {
+		return nil, err
+	}
+	return &TypeCodec{t: t, c: NewCodec(order, length, opts...)}, nil
+}
+
+//Type returns the type c encodes
+func (c *TypeCodec) Type() reflect.Type {
+	return c.t
+}
+
+//check makes sure v is a value of c's type or a pointer to one, decoding needs the pointer
+func (c *TypeCodec) check(v interface{}, decoding bool) error {
+	t := reflect.TypeOf(v)
+	if (t == c.t && !decoding) || (t != nil && t.Kind() == reflect.Ptr && t.Elem() == c.t) {
+		return nil
+	}
+	return fmt.Errorf("marshal: %T given to the codec of %s", v, c.t)
+}
+
+//Encode writes binary presentation of v to w, v must be of c's type or point to it
+func (c *TypeCodec) Encode(w io.Writer, v interface{}) error {
+	if err := c.check(v, false); err != nil {
+		return err
+	}
+	return c.c.Marshal(v, w)
+}
+
+//Decode reads a value from r into m, which must point to a value of c's type
+func (c *TypeCodec) Decode(r io.Reader, m interface{}) error {
+	if err := c.check(m, true); err != nil {
+		return err
+	}
+	return c.c.Unmarshal(m, r)
+}
+
+//EncodeBytes returns binary presentation of v, see Encode
+func (c *TypeCodec) EncodeBytes(v interface{}) ([]byte, error) {
+	if err := c.check(v, false); err != nil {
+		return nil, err
+	}
+	return c.c.MarshalBytes(v)
+}
+
+//DecodeBytes reads a value from b into m, see Decode
+func (c *TypeCodec) DecodeBytes(b []byte, m interface{}) error {
+	if err := c.check(m, true); err != nil {
+		return err
+	}
+	return c.c.UnmarshalBytes(m, b)
+}
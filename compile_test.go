@@ -0,0 +1,105 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+	"unsafe"
+)
+
+func TestCompileType(t *testing.T) {
+	c, err := CompileType(reflect.TypeOf(&Foo{}), binary.BigEndian, BlobLength16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Type() != reflect.TypeOf(Foo{}) {
+		t.Errorf("codec type %s, want Foo", c.Type())
+	}
+	v := createStableObject()
+	b, err := c.EncodeBytes(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := MarshalBytes(v, binary.BigEndian, BlobLength16)
+	if !bytes.Equal(b, expected) {
+		t.Errorf("encoded % x, want % x", b, expected)
+	}
+	w := new(bytes.Buffer)
+	if err := c.Encode(w, *v); err != nil || !bytes.Equal(w.Bytes(), b) {
+		t.Errorf("Encode: % x, %v", w.Bytes(), err)
+	}
+	var readBack Foo
+	if err := c.DecodeBytes(b, &readBack); err != nil {
+		t.Fatal(err)
+	}
+	if d := firstDiff(reflect.ValueOf(v), reflect.ValueOf(&readBack)); d != nil {
+		t.Errorf("decoded value differs: %v", d)
+	}
+	if err := c.Decode(bytes.NewReader(b), &readBack); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Decode(bytes.NewReader(b), readBack); err == nil {
+		t.Errorf("expected an error decoding into a non-pointer")
+	}
+	if err := c.Encode(w, &Pod{}); err == nil {
+		t.Errorf("expected an error for a value of another type")
+	}
+}
+
+func TestCompileTypeErrors(t *testing.T) {
+	type bad struct {
+		Count uint8
+		Items []uint16 `marshal:"count=Missing"`
+	}
+	if _, err := CompileType(reflect.TypeOf(bad{}), binary.BigEndian, BlobLength16); err == nil {
+		t.Errorf("expected an error for a bad tag")
+	}
+	if _, err := CompileType(reflect.TypeOf(make(chan int)), binary.BigEndian, BlobLength16); err == nil {
+		t.Errorf("expected an error for a channel")
+	}
+	if _, err := CompileType(nil, binary.BigEndian, BlobLength16); err == nil {
+		t.Errorf("expected an error for nil")
+	}
+	//kinds without an encoding of their own, and pointers that don't decode
+	for _, v := range []interface{}{
+		struct{ A int }{},
+		struct{ B uint }{},
+		struct{ P uintptr }{},
+		struct{ F func() }{},
+		struct{ U unsafe.Pointer }{},
+		struct{ I []int }{},
+		map[string]uint{},
+		struct{ P *uint32 }{},
+		[]*string{},
+	} {
+		if _, err := CompileType(reflect.TypeOf(v), binary.BigEndian, BlobLength16); !errors.Is(err, ErrUnsupportedKind) {
+			t.Errorf("%T: got %v, want ErrUnsupportedKind", v, err)
+		}
+	}
+	type bitsInt struct {
+		A int  `marshal:"bits=4"`
+		B uint `marshal:"bits=4"`
+	}
+	type bitmap struct {
+		P []*uint32 `marshal:"bitmap"`
+	}
+	type pointer struct {
+		P *uint32
+	}
+	for _, c := range []struct {
+		v      interface{}
+		length LengthType
+		opts   []Option
+	}{
+		{&bitsInt{}, BlobLength16, nil},
+		{bitmap{}, BlobLength16, nil},
+		{pointer{}, BlobLength16, []Option{SharedPointers()}},
+		{struct{ S *string }{}, NullableLength(BlobLength16), nil},
+	} {
+		if _, err := CompileType(reflect.TypeOf(c.v), binary.BigEndian, c.length, c.opts...); err != nil {
+			t.Errorf("%T: %v", c.v, err)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package marshal
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//FieldConfig holds the options of a struct field set with ConfigureType, for
+//structs that can't carry tags, such as generated ones. Make one with Field
+type FieldConfig struct {
+	path   string
+	items  []string
+	length LengthType
+}
+
+//Field starts the options of the field at path, a field name or a dotted path
+//through struct fields such as "Header.ID". Pointers, slices and arrays on the way
+//stand for their elements
+func Field(path string) *FieldConfig {
+	return &FieldConfig{path: path}
+}
+
+//Tag adds options written as in a marshal tag, e.g. "delimited" or "bits=3"
+func (c *FieldConfig) Tag(options string) *FieldConfig {
+	c.items = append(c.items, options)
+	return c
+}
+
+//Fixed writes the string field in exactly n padded bytes, as the fixed= tag
+func (c *FieldConfig) Fixed(n int) *FieldConfig {
+	return c.Tag("fixed=" + strconv.Itoa(n))
+}
+
+//Max limits the string field to n bytes, as the max= tag
+func (c *FieldConfig) Max(n int) *FieldConfig {
+	return c.Tag("max=" + strconv.Itoa(n))
+}
+
+//Skip leaves the field out of the encoding, as the skip tag
+func (c *FieldConfig) Skip() *FieldConfig {
+	return c.Tag("skip")
+}
+
+//Length makes the field, and the values it holds, use length instead of the
+//length type of the call
+func (c *FieldConfig) Length(length LengthType) *FieldConfig {
+	c.length = length
+	return c
+}
+
+var (
+	configLock sync.RWMutex
+	configs    = map[reflect.Type]map[string]*FieldConfig{}
+)
+
+//ConfigureType sets options of fields of the struct t as their tags would. The
+//options come after those of a field's tag, so they win where both set the same
+//one, e.g. fixed=. A field configured again has the new options only. A dotted
+//path configures the field in the struct type it leads to, for every use of that
+//type. Unknown fields and options the fields can't take are errors, and leave
+//the configuration as it was
+func ConfigureType(t reflect.Type, fields ...*FieldConfig) error {
+	type target struct {
+		t    reflect.Type
+		name string
+	}
+	targets := make([]target, len(fields))
+	for i, c := range fields {
+		st, name, err := fieldOwner(t, c.path)
+		if err != nil {
+			return fmt.Errorf("marshal: ConfigureType(%s): %v", t, err)
+		}
+		targets[i] = target{st, name}
+	}
+	configLock.Lock()
+	prev := map[reflect.Type]map[string]*FieldConfig{}
+	for i, c := range fields {
+		tt := targets[i]
+		if _, ok := prev[tt.t]; !ok {
+			prev[tt.t] = configs[tt.t]
+		}
+		byName := map[string]*FieldConfig{}
+		for name, fc := range configs[tt.t] {
+			byName[name] = fc
+		}
+		byName[tt.name] = c
+		configs[tt.t] = byName
+	}
+	configLock.Unlock()
+	clearPlans()
+	for st := range prev {
+		if p := planFor(st); p.err != nil {
+			configLock.Lock()
+			for st, byName := range prev {
+				if byName == nil {
+					delete(configs, st)
+				} else {
+					configs[st] = byName
+				}
+			}
+			configLock.Unlock()
+			clearPlans()
+			return fmt.Errorf("marshal: ConfigureType(%s): %w", t, p.err)
+		}
+	}
+	return nil
+}
+
+//clearPlans drops the cached plans and fingerprint layouts, which may hold the
+//previous configuration
+func clearPlans() {
+	planLock.Lock()
+	plans.Clear()
+	planLock.Unlock()
+	layoutHashes.Clear()
+}
+
+//fieldOwner follows path from the type t to the struct type holding its last
+//field and returns them
+func fieldOwner(t reflect.Type, path string) (reflect.Type, string, error) {
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return nil, "", fmt.Errorf("%s in %q is a %s, not a struct", strings.Join(names[:i], "."), path, t)
+		}
+		f, ok := t.FieldByName(name)
+		if !ok || len(f.Index) != 1 {
+			return nil, "", fmt.Errorf("no field %q in %s", path, t)
+		}
+		if i == len(names)-1 {
+			return t, name, nil
+		}
+		t = f.Type
+	}
+	panic("unreachable")
+}
+
+//fieldTagOf returns the marshal tag of the field f of the struct t followed by
+//the options set with ConfigureType, the length type set and whether there is
+//either
+func fieldTagOf(t reflect.Type, f reflect.StructField) (tag string, length LengthType, ok bool) {
+	tag, ok = f.Tag.Lookup("marshal")
+	configLock.RLock()
+	c := configs[t][f.Name]
+	configLock.RUnlock()
+	if c == nil {
+		return tag, nil, ok
+	}
+	items := c.items
+	if tag != "" {
+		items = append([]string{tag}, items...)
+	}
+	return strings.Join(items, ","), c.length, true
+}
@@ -0,0 +1,175 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type cfgBar struct {
+	Id   string
+	Code uint16
+}
+
+type cfgFoo struct {
+	Uri  string `marshal:"max=300"`
+	Bar  cfgBar
+	Bars []cfgBar
+	Tick uint64
+	Tail string
+}
+
+//cfgTagged is cfgFoo written with tags
+type cfgTagged struct {
+	Uri  string `marshal:"fixed=255"`
+	Bar  cfgBar
+	Bars []cfgBar
+	Tail string
+}
+
+type cfgSized struct {
+	Name string
+	Data []byte
+}
+
+//unconfigure drops the configuration of the types once the test is over
+func unconfigure(t *testing.T, types ...reflect.Type) {
+	t.Cleanup(func() {
+		configLock.Lock()
+		for _, typ := range types {
+			delete(configs, typ)
+		}
+		configLock.Unlock()
+		clearPlans()
+	})
+}
+
+func TestConfigureType(t *testing.T) {
+	unconfigure(t, reflect.TypeOf(cfgFoo{}), reflect.TypeOf(cfgBar{}))
+	foo := cfgFoo{Uri: "/a", Bar: cfgBar{"x", 1}, Bars: []cfgBar{{"yz", 2}}, Tick: 9, Tail: "t"}
+	before, err := MarshalBytes(foo, binary.BigEndian, BlobLength16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ConfigureType(reflect.TypeOf(cfgFoo{}),
+		Field("Uri").Fixed(255),
+		Field("Bar.Id").Length(BlobLength8),
+		Field("Tick").Skip())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := MarshalBytes(foo, binary.BigEndian, BlobLength16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(b, before) {
+		t.Fatal("configuration didn't change the encoding")
+	}
+	//the fixed= of the configuration wins over the max= of the tag, and the
+	//length of Bar.Id holds in the slice too
+	want := []byte{}
+	want = append(want, "/a"...)
+	want = append(want, make([]byte, 253)...)
+	want = append(want, 1, 'x', 0, 1)
+	want = append(want, 0, 1, 2, 'y', 'z', 0, 2)
+	want = append(want, 0, 1, 't')
+	if !bytes.Equal(b, want) {
+		t.Fatalf("got % x\nwant % x", b, want)
+	}
+	tagged, err := MarshalBytes(cfgTagged{foo.Uri, foo.Bar, foo.Bars, foo.Tail}, binary.BigEndian, BlobLength16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the length of Bar.Id is configured in cfgBar, which cfgTagged uses too
+	if !bytes.Equal(tagged, b) {
+		t.Errorf("tagged struct encodes to % x", tagged)
+	}
+	//the skipped field keeps its value on decode
+	got := cfgFoo{Tick: 5}
+	if err := UnmarshalBytes(&got, b, binary.BigEndian, BlobLength16); err != nil {
+		t.Fatal(err)
+	}
+	foo.Tick = 5
+	if !reflect.DeepEqual(got, foo) {
+		t.Errorf("got %+v, want %+v", got, foo)
+	}
+	s, err := Describe(cfgFoo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Fields) != 4 || s.Fields[0].Tag != "max=300,fixed=255" {
+		t.Errorf("schema fields %+v", s.Fields)
+	}
+	//configuring a field again replaces its options
+	if err := ConfigureType(reflect.TypeOf(cfgFoo{}), Field("Tick")); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := MarshalBytes(foo, binary.BigEndian, BlobLength16); len(b) != len(want)+8 {
+		t.Errorf("Tick still skipped: % x", b)
+	}
+}
+
+func TestConfigureTypeMaxSize(t *testing.T) {
+	unconfigure(t, reflect.TypeOf(cfgSized{}))
+	type outer struct {
+		S cfgSized
+	}
+	if err := ConfigureType(reflect.TypeOf(outer{}), Field("S.Name").Max(10).Length(BlobLength8)); err != nil {
+		t.Fatal(err)
+	}
+	n, bounded, err := MaxSize(reflect.TypeOf(cfgSized{}), Bound32(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//Name takes 1+10 bytes, Data 4+20
+	if !bounded || n != 35 {
+		t.Errorf("MaxSize %d, %v, want 35", n, bounded)
+	}
+}
+
+func TestConfigureTypeErrors(t *testing.T) {
+	unconfigure(t, reflect.TypeOf(cfgFoo{}), reflect.TypeOf(cfgBar{}))
+	for _, c := range []struct {
+		typ    reflect.Type
+		fields []*FieldConfig
+		want   string
+	}{
+		{reflect.TypeOf(cfgFoo{}), []*FieldConfig{Field("Nope")}, `no field "Nope"`},
+		{reflect.TypeOf(cfgFoo{}), []*FieldConfig{Field("Bar.Nope")}, `no field "Bar.Nope"`},
+		{reflect.TypeOf(cfgFoo{}), []*FieldConfig{Field("Tick.X")}, "not a struct"},
+		{reflect.TypeOf(0), []*FieldConfig{Field("X")}, "not a struct"},
+		{reflect.TypeOf(cfgFoo{}), []*FieldConfig{Field("Uri").Fixed(4), Field("Bar.Code").Fixed(4)}, "fixed"},
+		{reflect.TypeOf(cfgFoo{}), []*FieldConfig{Field("Uri").Tag("bogus")}, "bogus"},
+	} {
+		if err := ConfigureType(c.typ, c.fields...); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s %v: got %v, want %q", c.typ, c.fields, err, c.want)
+		}
+	}
+	//failed configurations are rolled back
+	configLock.RLock()
+	n := len(configs[reflect.TypeOf(cfgFoo{})]) + len(configs[reflect.TypeOf(cfgBar{})])
+	configLock.RUnlock()
+	if n != 0 {
+		t.Errorf("%d fields left configured", n)
+	}
+	var foo cfgFoo
+	if err := UnmarshalBytes(&foo, []byte{0, 1, 'a', 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, binary.BigEndian, BlobLength16); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Describe(cfgFoo{}); err != nil {
+		t.Errorf("plan left broken: %v", err)
+	}
+}
+
+func TestConfigureTypeRoundTrip(t *testing.T) {
+	unconfigure(t, reflect.TypeOf(cfgSized{}))
+	if err := ConfigureType(reflect.TypeOf(cfgSized{}), Field("Data").Skip()); err != nil {
+		t.Fatal(err)
+	}
+	//the skipped field isn't on the wire, RoundTrip doesn't compare it
+	if err := RoundTrip(cfgSized{"n", []byte{1}}, binary.BigEndian, BlobLength8); err != nil {
+		t.Error(err)
+	}
+}
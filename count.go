@@ -0,0 +1,73 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+)
+
+//resolveCounts links count= tags of the struct t to the fields they name,
+//a count field must come first so it is decoded before the slice it counts
+func (p *typePlan) resolveCounts(t reflect.Type) error {
+	for i := range p.fields {
+		f := &p.fields[i]
+		if f.tag == nil || f.tag.count == "" {
+			continue
+		}
+		j := 0
+		for j < i && p.fields[j].name != f.tag.count {
+			j++
+		}
+		if j == i {
+			return fmt.Errorf("marshal: %s.%s: count field %s must be an earlier field", t, f.name, f.tag.count)
+		}
+		c := &p.fields[j]
+		if !isInteger(t.Field(c.index).Type.Kind()) {
+			return fmt.Errorf("marshal: %s.%s: count field %s must be an integer", t, f.name, c.name)
+		}
+		if c.countedBy != nil {
+			return fmt.Errorf("marshal: %s: field %s counts both %s and %s", t, c.name, c.countedBy.name, f.name)
+		}
+		f.tag.countIndex = c.index
+		c.countedBy = f
+		p.counted = true
+	}
+	return nil
+}
+
+//countValue returns a value of type t holding the count of l elements
+func countValue(t reflect.Type, l int, ft *fieldTag) reflect.Value {
+	n := uint64(l)
+	if ft.unit > 0 {
+		n *= uint64(ft.unit)
+	}
+	v := reflect.New(t).Elem()
+	if k := t.Kind(); k >= reflect.Int && k <= reflect.Int64 {
+		if n > 1<<63-1 || v.OverflowInt(int64(n)) {
+			panic(errorf(ErrLengthOverflow, "marshal: count %d overflows %s", n, t))
+		}
+		v.SetInt(int64(n))
+	} else {
+		if v.OverflowUint(n) {
+			panic(errorf(ErrLengthOverflow, "marshal: count %d overflows %s", n, t))
+		}
+		v.SetUint(n)
+	}
+	return v
+}
+
+//counted decodes a slice whose count was decoded into the field c
+func (u *unmarshaler) counted(v, c reflect.Value, ft *fieldTag, order binary.ByteOrder, length LengthTypeInstance) {
+	n := getInt(c)
+	unit := int64(max(ft.unit, 1))
+	if n < 0 || n%unit != 0 {
+		panic(errorf(ErrMalformed, "unmarshal: bad count %v for unit %d", c, unit))
+	}
+	l := int(n / unit)
+	if l == 0 {
+		v.Set(reflect.Zero(v.Type()))
+		return
+	}
+	u.makeSlice(v, l)
+	u.elements(v, order, length)
+}
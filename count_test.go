@@ -0,0 +1,81 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+type pair struct {
+	K, V uint8
+}
+
+type countedMsg struct {
+	N     uint8
+	Bytes uint16
+	Name  string
+	Items []uint16 `marshal:"count=N"`
+	Pairs []pair   `marshal:"count=Bytes,unit=2"`
+}
+
+func TestCount(t *testing.T) {
+	//N and Bytes are back-filled, the values in the struct are ignored
+	v := countedMsg{N: 99, Name: "x", Items: []uint16{1, 2, 3}, Pairs: []pair{{1, 2}, {3, 4}}}
+	b, err := MarshalBytes(&v, binary.BigEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{3, 0, 4, 1, 'x', 0, 1, 0, 2, 0, 3, 1, 2, 3, 4}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("encoded % x, want % x", b, expected)
+	}
+	var readBack countedMsg
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, BlobLength8); err != nil {
+		t.Fatal(err)
+	}
+	v.N, v.Bytes = 3, 4
+	if !reflect.DeepEqual(v, readBack) {
+		t.Errorf("decoded %+v, want %+v", readBack, v)
+	}
+
+	n, err := Skip(bytes.NewReader(b), countedMsg{}, binary.BigEndian, BlobLength8)
+	if err != nil || n != int64(len(b)) {
+		t.Errorf("Skip: %d, %v", n, err)
+	}
+	var head countedMsg
+	if err := UnmarshalFields(bytes.NewReader(b), &head, binary.BigEndian, BlobLength8, "Pairs"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(head.Pairs, v.Pairs) || head.N != 0 || head.Items != nil {
+		t.Errorf("UnmarshalFields: %+v", head)
+	}
+}
+
+func TestCountErrors(t *testing.T) {
+	var v countedMsg
+	//odd Bytes with unit=2
+	if err := UnmarshalBytes(&v, []byte{0, 0, 3, 0, 1, 2, 3}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for a count not divisible by unit")
+	}
+	if _, err := MarshalBytes(&countedMsg{Items: make([]uint16, 256)}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for a count overflowing uint8")
+	}
+	type later struct {
+		Items []uint8 `marshal:"count=N"`
+		N     uint8
+	}
+	type notInt struct {
+		N     string
+		Items []uint8 `marshal:"count=N"`
+	}
+	type twice struct {
+		N    uint8
+		A, B []uint8 `marshal:"count=N"`
+	}
+	for _, v := range []interface{}{&later{}, &notInt{}, &twice{}} {
+		if _, err := MarshalBytes(v, binary.BigEndian, BlobLength8); err == nil {
+			t.Errorf("%T: expected a tag error", v)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+//Marshaler is implemented by types that write their own encoding. The Writer
+//carries the byte order and length type of the enclosing call, so nested
+//strings and slices agree with the rest of the message
+type Marshaler interface {
+	MarshalWire(w *Writer) error
+}
+
+//Unmarshaler is implemented by types that read their own encoding, it is
+//called on a pointer to the value being decoded
+type Unmarshaler interface {
+	UnmarshalWire(r *Reader) error
+}
+
+//EncodeFunc writes v, a value of the registered type
+type EncodeFunc func(w *Writer, v interface{}) error
+
+//DecodeFunc reads into v, a pointer to a value of the registered type
+type DecodeFunc func(r *Reader, v interface{}) error
+
+type customCodec struct {
+	enc EncodeFunc
+	dec DecodeFunc
+}
+
+var (
+	codecLock   sync.RWMutex
+	codecs      = map[reflect.Type]customCodec{}
+	namedCodecs = map[string]customCodec{}
+)
+
+var (
+	marshalerType   = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+)
+
+//RegisterCodec encodes values of the named type t with enc and dec, for types
+//whose methods can't be changed. A registered codec takes precedence over
+//Marshaler and Unmarshaler methods
+func RegisterCodec(t reflect.Type, enc EncodeFunc, dec DecodeFunc) {
+	if t.PkgPath() == "" {
+		panic(fmt.Errorf("marshal: RegisterCodec: %s is not a named type", t))
+	}
+	codecLock.Lock()
+	codecs[t] = customCodec{enc, dec}
+	codecLock.Unlock()
+	//plans cached before registration don't know t is custom
+	planLock.Lock()
+	plans.Clear()
+	planLock.Unlock()
+}
+
+//RegisterNamedCodec registers enc and dec under name for fields tagged codec=name,
+//which are encoded by them whatever their type. The same type can so take different
+//wire forms in different fields. dec receives a pointer to the field
+func RegisterNamedCodec(name string, enc EncodeFunc, dec DecodeFunc) {
+	codecLock.Lock()
+	namedCodecs[name] = customCodec{enc, dec}
+	codecLock.Unlock()
+	//plans cached before registration may hold the previous codec
+	planLock.Lock()
+	plans.Clear()
+	planLock.Unlock()
+}
+
+func lookupNamedCodec(name string) (*customCodec, error) {
+	codecLock.RLock()
+	c, ok := namedCodecs[name]
+	codecLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("codec %q is not registered", name)
+	}
+	return &c, nil
+}
+
+func lookupCodec(t reflect.Type) (customCodec, bool) {
+	codecLock.RLock()
+	c, ok := codecs[t]
+	codecLock.RUnlock()
+	return c, ok
+}
+
+//isCustom reports whether t is encoded by a registered codec or its own methods
+func isCustom(t reflect.Type) bool {
+	if t.PkgPath() == "" {
+		return false
+	}
+	if _, ok := lookupCodec(t); ok {
+		return true
+	}
+	pt := reflect.PointerTo(t)
+	return pt.Implements(marshalerType) || pt.Implements(unmarshalerType)
+}
+
+func (m *marshaler) custom(v reflect.Value, length LengthTypeInstance) {
+	w := &Writer{w: m.w, order: m.order, length: length, m: m}
+	var err error
+	if c, ok := lookupCodec(v.Type()); ok {
+		if c.enc == nil {
+			panic(errorf(ErrUnsupportedKind, "marshal: %s has no registered encoder", v.Type()))
+		}
+		err = c.enc(w, v.Interface())
+	} else {
+		if !v.Type().Implements(marshalerType) && !v.CanAddr() {
+			//pointer receiver on a value that isn't addressable
+			p := reflect.New(v.Type())
+			p.Elem().Set(v)
+			v = p.Elem()
+		}
+		var mr Marshaler
+		if v.CanAddr() {
+			mr, _ = v.Addr().Interface().(Marshaler)
+		} else {
+			mr, _ = v.Interface().(Marshaler)
+		}
+		if mr == nil {
+			panic(errorf(ErrUnsupportedKind, "marshal: %s implements Unmarshaler but not Marshaler", v.Type()))
+		}
+		err = mr.MarshalWire(w)
+	}
+	if err != nil {
+		panic(err)
+	}
+}
+
+//namedCodec writes v with the codec a codec= tag selects
+func (m *marshaler) namedCodec(v reflect.Value, c *customCodec, length LengthTypeInstance) {
+	if c.enc == nil {
+		panic(errorf(ErrUnsupportedKind, "marshal: codec for %s has no encoder", v.Type()))
+	}
+	w := &Writer{w: m.w, order: m.order, length: length, m: m}
+	if err := c.enc(w, v.Interface()); err != nil {
+		panic(err)
+	}
+}
+
+//namedCodec reads v with the codec a codec= tag selects
+func (u *unmarshaler) namedCodec(v reflect.Value, c *customCodec, order binary.ByteOrder, length LengthTypeInstance) {
+	if c.dec == nil {
+		panic(errorf(ErrUnsupportedKind, "unmarshal: codec for %s has no decoder", v.Type()))
+	}
+	r := &Reader{r: u.r, order: order, length: length, u: u}
+	if err := c.dec(r, v.Addr().Interface()); err != nil {
+		panic(err)
+	}
+}
+
+func (u *unmarshaler) custom(v reflect.Value, order binary.ByteOrder, length LengthTypeInstance) {
+	r := &Reader{r: u.r, order: order, length: length, u: u}
+	var err error
+	if c, ok := lookupCodec(v.Type()); ok {
+		if c.dec == nil {
+			panic(errorf(ErrUnsupportedKind, "unmarshal: %s has no registered decoder", v.Type()))
+		}
+		err = c.dec(r, v.Addr().Interface())
+	} else {
+		um, ok := v.Addr().Interface().(Unmarshaler)
+		if !ok {
+			panic(errorf(ErrUnsupportedKind, "unmarshal: %s implements Marshaler but not Unmarshaler", v.Type()))
+		}
+		err = um.UnmarshalWire(r)
+	}
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,110 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+//label writes its text reversed followed by its items through the reflective encoder
+type label struct {
+	Text  string
+	Items []uint16
+}
+
+func (l *label) MarshalWire(w *Writer) error {
+	b := []byte(l.Text)
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	if err := w.PutString(string(b)); err != nil {
+		return err
+	}
+	return w.Marshal(l.Items)
+}
+
+func (l *label) UnmarshalWire(r *Reader) error {
+	s, err := r.String()
+	if err != nil {
+		return err
+	}
+	b := []byte(s)
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	l.Text = string(b)
+	return r.Unmarshal(&l.Items)
+}
+
+//tenths is registered to travel as an int16 count of tenths
+type tenths float64
+
+func init() {
+	RegisterCodec(reflect.TypeOf(tenths(0)), func(w *Writer, v interface{}) error {
+		return w.PutUint16(uint16(int16(v.(tenths) * 10)))
+	}, func(r *Reader, v interface{}) error {
+		x, err := r.Uint16()
+		*v.(*tenths) = tenths(int16(x)) / 10
+		return err
+	})
+}
+
+type customMsg struct {
+	Head  uint8
+	Label label
+	Temp  tenths
+	Tail  string
+}
+
+func TestCustomCodec(t *testing.T) {
+	v := customMsg{7, label{"abc", []uint16{1, 2}}, -2.5, "z"}
+	b, err := MarshalBytes(&v, binary.BigEndian, YYBlobType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//YYBlobType puts 16 bit lengths on strings and 32 bit ones on slices
+	expected := []byte{
+		7,
+		0, 3, 'c', 'b', 'a', 0, 0, 0, 2, 0, 1, 0, 2,
+		0xff, 0xe7,
+		0, 1, 'z',
+	}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("encoded % x, want % x", b, expected)
+	}
+	var readBack customMsg
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, YYBlobType); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v, readBack) {
+		t.Errorf("decoded %+v, want %+v", readBack, v)
+	}
+	n, err := Skip(bytes.NewReader(b), customMsg{}, binary.BigEndian, YYBlobType)
+	if err != nil || n != int64(len(b)) {
+		t.Errorf("Skip: %d, %v, want %d", n, err, len(b))
+	}
+
+	//a value that isn't addressable still reaches the pointer receiver
+	if b, err := MarshalBytes(v, binary.BigEndian, YYBlobType); err != nil || !bytes.Equal(b, expected) {
+		t.Errorf("by value: % x, %v", b, err)
+	}
+}
+
+func TestCustomCodecTrace(t *testing.T) {
+	var paths []string
+	v := customMsg{Label: label{"x", []uint16{5}}}
+	_, err := MarshalBytes(&v, binary.BigEndian, BlobLength8, WithTrace(func(e TraceEvent) {
+		paths = append(paths, e.Path)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "customMsg.Label[0]"
+	for _, p := range paths {
+		if p == want {
+			return
+		}
+	}
+	t.Errorf("nested Writer.Marshal not traced under %s: %q", want, paths)
+}
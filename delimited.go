@@ -0,0 +1,54 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"io"
+	"reflect"
+)
+
+//delimited writes the struct v behind a length prefix holding its encoded size,
+//the size is measured with a first pass that discards the bytes
+func (m *marshaler) delimited(v reflect.Value, length LengthTypeInstance) {
+	sub := m.sub(io.Discard)
+	defer putMarshaler(sub)
+	sub.marshal(v, length)
+	m.putLength(length, v.Type(), int(sub.cw.n))
+	m.marshal(v, length)
+}
+
+//delimited decodes the struct v from the number of bytes its length prefix holds.
+//Bytes the struct leaves are skipped, written by a newer version of the struct,
+//with Strict they are an error. An indefinite length has the struct end at an
+//end-of-contents marker instead
+func (u *unmarshaler) delimited(v reflect.Value, order binary.ByteOrder, length LengthTypeInstance) {
+	l := int64(u.regionLength(length, order, v.Type()))
+	if l == indefiniteLength {
+		u.unmarshal(v, order, length)
+		u.eoc(v.Type())
+		return
+	}
+	u.within(l, v.Type(), func() {
+		u.unmarshal(v, order, length)
+	})
+}
+
+//within runs decode, a decoder of a value of type t, on the next l bytes of input.
+//Bytes it leaves are skipped with a warning, or with Strict an error
+func (u *unmarshaler) within(l int64, t reflect.Type, decode func()) {
+	r := u.r
+	u.r = &io.LimitedReader{R: r, N: l}
+	start := u.cr.n
+	func() {
+		defer func() { u.r = r }()
+		decode()
+	}()
+	surplus := l - (u.cr.n - start)
+	if surplus == 0 {
+		return
+	}
+	if u.strict {
+		panic(errorf(ErrTrailingBytes, "unmarshal: %s left %d of %d delimited bytes", t, surplus, l))
+	}
+	u.warning(WarnTrailingBytes, u.cr.n, "%s left %d of %d delimited bytes", t, surplus, l)
+	u.discard(surplus)
+}
@@ -0,0 +1,64 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+type delimitedV1 struct {
+	Kind uint8
+	Ext  struct {
+		A uint16
+	} `marshal:"delimited"`
+	Tail uint8
+}
+
+type delimitedV2 struct {
+	Kind uint8
+	Ext  struct {
+		A uint16
+		B string
+	} `marshal:"delimited"`
+	Tail uint8
+}
+
+func TestDelimited(t *testing.T) {
+	var v delimitedV2
+	v.Kind, v.Ext.A, v.Ext.B, v.Tail = 1, 0x0203, "xy", 9
+	b, err := MarshalBytes(&v, binary.BigEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{1, 5, 2, 3, 2, 'x', 'y', 9}; !bytes.Equal(b, expected) {
+		t.Errorf("encoded % x, want % x", b, expected)
+	}
+	var readBack delimitedV2
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, BlobLength8); err != nil || readBack != v {
+		t.Errorf("decoded %+v, %v", readBack, err)
+	}
+	//an older reader skips the field it doesn't know and stays in step
+	var old delimitedV1
+	if err := UnmarshalBytes(&old, b, binary.BigEndian, BlobLength8); err != nil {
+		t.Fatal(err)
+	}
+	if old.Kind != 1 || old.Ext.A != 0x0203 || old.Tail != 9 {
+		t.Errorf("old reader decoded %+v", old)
+	}
+	if err := UnmarshalBytes(&old, b, binary.BigEndian, BlobLength8, Strict()); err == nil {
+		t.Errorf("expected an error for surplus bytes with Strict")
+	}
+	//a body shorter than the struct needs can't read past its prefix
+	if err := UnmarshalBytes(&readBack, []byte{1, 1, 2, 3, 0, 9}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for a body shorter than the struct")
+	}
+}
+
+func TestDelimitedErrors(t *testing.T) {
+	type notStruct struct {
+		N uint32 `marshal:"delimited"`
+	}
+	if _, err := MarshalBytes(&notStruct{}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for delimited on an integer")
+	}
+}
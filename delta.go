@@ -0,0 +1,87 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"io"
+	"reflect"
+)
+
+//isSigned reports whether k is a signed integer kind
+func isSigned(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Int64
+}
+
+//delta writes the integer slice or array v as varints, a slice behind its length
+//prefix: the first element as is, then the difference of each element to the one
+//before. Unsigned elements must not decrease, signed ones are zigzag encoded and
+//may go either way
+func (m *marshaler) delta(v reflect.Value, length LengthTypeInstance) {
+	if v.Kind() == reflect.Slice {
+		m.putLength(length, v.Type(), v.Len())
+	}
+	signed := isSigned(v.Type().Elem().Kind())
+	var b [binary.MaxVarintLen64]byte
+	var prev uint64
+	buf := make([]byte, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		var x uint64
+		if signed {
+			x = uint64(v.Index(i).Int())
+			//differences wrap around like the prefix sums reading them
+			d := int64(x - prev)
+			buf = append(buf, b[:binary.PutVarint(b[:], d)]...)
+		} else {
+			x = v.Index(i).Uint()
+			if x < prev {
+				panic(errorf(ErrMalformed, "marshal: delta %s decreases at element %d, %d after %d", v.Type(), i, x, prev))
+			}
+			buf = append(buf, b[:binary.PutUvarint(b[:], x-prev)]...)
+		}
+		prev = x
+	}
+	if _, err := m.w.Write(buf); err != nil {
+		panic(err)
+	}
+}
+
+//delta reads an integer slice or array written by marshaler.delta into v
+func (u *unmarshaler) delta(v reflect.Value, order binary.ByteOrder, length LengthTypeInstance) {
+	if v.Kind() == reflect.Slice {
+		l := u.getLength(length, order, v.Type())
+		if l == 0 {
+			return
+		}
+		v.Set(reflect.MakeSlice(v.Type(), l, l))
+	}
+	signed := isSigned(v.Type().Elem().Kind())
+	var prev uint64
+	for i := 0; i < v.Len(); i++ {
+		e := v.Index(i)
+		if signed {
+			prev += uint64(u.varint())
+			if x := int64(prev); e.OverflowInt(x) {
+				panic(errorf(ErrMalformed, "unmarshal: delta element %d, %d, overflows %s", i, x, e.Type()))
+			}
+			e.SetInt(int64(prev))
+			continue
+		}
+		d := u.uvarint()
+		if prev+d < prev || e.OverflowUint(prev+d) {
+			panic(errorf(ErrMalformed, "unmarshal: delta element %d overflows %s", i, e.Type()))
+		}
+		prev += d
+		e.SetUint(prev)
+	}
+}
+
+func (u *unmarshaler) varint() int64 {
+	start := u.cr.n
+	x, err := binary.ReadVarint(byteReader{u})
+	if err != nil {
+		if err == io.EOF && u.cr.n > start {
+			err = io.ErrUnexpectedEOF
+		}
+		panic(err)
+	}
+	return x
+}
@@ -0,0 +1,65 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type deltaSet struct {
+	IDs   []uint64 `marshal:"delta"`
+	Times []int64  `marshal:"delta"`
+	Small [3]uint8 `marshal:"delta"`
+}
+
+func TestDelta(t *testing.T) {
+	v := deltaSet{
+		IDs:   []uint64{1000, 1001, 1001, 1300},
+		Times: []int64{50, 40, 41, math.MinInt64, math.MaxInt64},
+		Small: [3]uint8{1, 2, 255},
+	}
+	b, err := MarshalBytes(&v, binary.BigEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []byte{4, 0xe8, 0x07, 1, 0, 0xab, 0x02}
+	if !bytes.HasPrefix(b, ids) {
+		t.Errorf("encoded % x, want it to start with % x", b, ids)
+	}
+	if small := []byte{1, 1, 253, 1}; !bytes.HasSuffix(b, small) {
+		t.Errorf("encoded % x, want it to end with % x", b, small)
+	}
+	var readBack deltaSet
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, BlobLength8); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(readBack, v) {
+		t.Errorf("decoded %+v, want %+v", readBack, v)
+	}
+	n, err := Skip(bytes.NewReader(b), &v, binary.BigEndian, BlobLength8)
+	if err != nil || n != int64(len(b)) {
+		t.Errorf("skipped %d, %v, want %d", n, err, len(b))
+	}
+}
+
+func TestDeltaErrors(t *testing.T) {
+	v := deltaSet{IDs: []uint64{5, 4}}
+	if _, err := MarshalBytes(&v, binary.BigEndian, BlobLength8); err == nil || !strings.Contains(err.Error(), "decreases at element 1") {
+		t.Errorf("expected an error for a decreasing slice, got %v", err)
+	}
+	//2 and 254 add up to more than a uint8
+	b := []byte{0, 0, 2, 254, 0}
+	var readBack deltaSet
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for an overflowing element")
+	}
+	var bad struct {
+		F []float64 `marshal:"delta"`
+	}
+	if _, err := MarshalBytes(&bad, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for delta on floats")
+	}
+}
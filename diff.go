@@ -0,0 +1,94 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+//FieldDiff is one difference found by Diff. A and B are the decoded values, nil
+//when the value is missing on that side, e.g. a map key only one buffer holds.
+//Differences that aren't about a single value, like trailing bytes, carry a Note
+type FieldDiff struct {
+	Path string
+	A, B interface{}
+	Note string
+}
+
+//String formats d like "Foo.Ssid: 5 != 6"
+func (d FieldDiff) String() string {
+	if d.Note != "" {
+		return d.Path + ": " + d.Note
+	}
+	return fmt.Sprintf("%s: %s != %s", d.Path, formatAny(d.A), formatAny(d.B))
+}
+
+//FieldDiffs is the result of Diff
+type FieldDiffs []FieldDiff
+
+//String formats one difference per line
+func (ds FieldDiffs) String() string {
+	var b strings.Builder
+	for _, d := range ds {
+		b.WriteString(d.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+//Diff decodes a and b as values of v's type and lists the fields whose values differ,
+//comparing like RoundTrip. A buffer with bytes left after the value, or one that fails
+//to decode while the other succeeds, is reported as a FieldDiff with a Note.
+//The error is only set when neither buffer decodes
+func Diff(a, b []byte, v interface{}, order binary.ByteOrder, length LengthType, opts ...Option) (FieldDiffs, error) {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return nil, errors.New("diff: invalid type nil")
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	o := newOptions(opts)
+	va, vb := reflect.New(t), reflect.New(t)
+	na, ea := decode(va.Interface(), &sliceReader{b: a}, order, length(), o)
+	nb, eb := decode(vb.Interface(), &sliceReader{b: b}, order, length(), o)
+	root := formatPath([]pathElem{rootElem(t)})
+	switch {
+	case ea != nil && eb != nil:
+		return nil, fmt.Errorf("diff: a: %v, b: %v", ea, eb)
+	case ea != nil:
+		return FieldDiffs{{Path: root, Note: fmt.Sprintf("a fails to decode after %d bytes: %v", na, ea)}}, nil
+	case eb != nil:
+		return FieldDiffs{{Path: root, Note: fmt.Sprintf("b fails to decode after %d bytes: %v", nb, eb)}}, nil
+	}
+	var diffs FieldDiffs
+	compareValues([]pathElem{rootElem(t)}, va.Elem(), vb.Elem(), func(path []pathElem, x, y reflect.Value) bool {
+		d := FieldDiff{Path: formatPath(path)}
+		if x.IsValid() {
+			d.A = x.Interface()
+		}
+		if y.IsValid() {
+			d.B = y.Interface()
+		}
+		diffs = append(diffs, d)
+		return true
+	})
+	//keys only b holds are missed when walking a's map entries
+	compareValues([]pathElem{rootElem(t)}, vb.Elem(), va.Elem(), func(path []pathElem, y, x reflect.Value) bool {
+		if !x.IsValid() {
+			diffs = append(diffs, FieldDiff{Path: formatPath(path), B: y.Interface()})
+		}
+		return true
+	})
+	if ra, rb := len(a)-int(na), len(b)-int(nb); ra != 0 || rb != 0 {
+		diffs = append(diffs, FieldDiff{Path: root, Note: fmt.Sprintf("trailing bytes: %d in a, %d in b", ra, rb)})
+	}
+	return diffs, nil
+}
+
+//formatAny prints a decoded value for a FieldDiff, nil prints as <missing>
+func formatAny(v interface{}) string {
+	return formatValue(reflect.ValueOf(v))
+}
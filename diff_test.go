@@ -0,0 +1,45 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	x := *createStableObject()
+	y := x
+	y.Ssid = 6
+	y.Bar.Prop = map[string]uint32{"xyz": 1}
+	a, _ := MarshalBytes(&x, binary.BigEndian, BlobLength16)
+	b, _ := MarshalBytes(&y, binary.BigEndian, BlobLength16)
+	diffs, err := Diff(a, append(b, 0xff), Foo{}, binary.BigEndian, BlobLength16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `Foo.Ssid: 5 != 6
+Foo.Bar.Prop[abc]: 1 != <missing>
+Foo.Bar.Prop[xyz]: <missing> != 1
+Foo: trailing bytes: 0 in a, 1 in b
+`
+	if diffs.String() != expected {
+		t.Errorf("got\n%s\nwant\n%s", diffs, expected)
+	}
+	if diffs[0].A != uint16(5) || diffs[0].B != uint16(6) {
+		t.Errorf("values: %#v", diffs[0])
+	}
+
+	if diffs, err := Diff(a, a, &x, binary.BigEndian, BlobLength16); err != nil || len(diffs) != 0 {
+		t.Errorf("identical buffers: %v, %v", diffs, err)
+	}
+}
+
+func TestDiffDecodeFailure(t *testing.T) {
+	a, _ := MarshalBytes(createStableObject(), binary.BigEndian, BlobLength16)
+	diffs, err := Diff(a, a[:10], Foo{}, binary.BigEndian, BlobLength16)
+	if err != nil || len(diffs) != 1 || diffs[0].Note == "" {
+		t.Errorf("truncated b: %v, %v", diffs, err)
+	}
+	if _, err := Diff(a[:10], a[:10], Foo{}, binary.BigEndian, BlobLength16); err == nil {
+		t.Errorf("expected an error when neither buffer decodes")
+	}
+}
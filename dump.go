@@ -0,0 +1,100 @@
+package marshal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+//dumpElide is the length above which the middle of a run of bytes is elided
+const dumpElide = 64
+
+//DumpHex marshals v and writes an offset/hex/ASCII dump of the encoding to w,
+//interleaved with a marker line where each field and length prefix starts.
+//Nested field labels are indented and the middle of long byte runs is elided
+func DumpHex(v interface{}, order binary.ByteOrder, length LengthType, w io.Writer, opts ...Option) error {
+	var events []TraceEvent
+	opts = append(opts[:len(opts):len(opts)], WithTrace(func(e TraceEvent) {
+		events = append(events, e)
+	}))
+	b, err := MarshalBytes(v, order, length, opts...)
+	if err != nil {
+		return err
+	}
+	return dumpHex(w, b, events)
+}
+
+func dumpHex(w io.Writer, b []byte, events []TraceEvent) error {
+	//outer values first, a value before its own length prefix
+	sort.SliceStable(events, func(i, j int) bool {
+		a, c := &events[i], &events[j]
+		if a.Offset != c.Offset {
+			return a.Offset < c.Offset
+		}
+		if a.Depth != c.Depth {
+			return a.Depth < c.Depth
+		}
+		return !a.Prefix && c.Prefix
+	})
+	bw := bufio.NewWriter(w)
+	pos := 0
+	for _, e := range events {
+		if off := int(e.Offset); off > pos {
+			dumpBytes(bw, b, pos, off)
+			pos = off
+		}
+		fmt.Fprintf(bw, "%08x  # %s%s %s len %d\n", e.Offset, strings.Repeat("  ", e.Depth), e.Name, e.what(), e.Len)
+		if e.Prefix {
+			//keep the prefix on its own rows, the payload follows unmarked
+			end := int(e.Offset + e.Len)
+			dumpBytes(bw, b, pos, end)
+			pos = end
+		}
+	}
+	dumpBytes(bw, b, pos, len(b))
+	return bw.Flush()
+}
+
+//dumpBytes writes b[from:to] as hex rows of 16 bytes
+func dumpBytes(w *bufio.Writer, b []byte, from, to int) {
+	if to-from > dumpElide {
+		//keep two leading rows and the trailing row
+		tail := to - 16
+		dumpRows(w, b, from, from+32)
+		fmt.Fprintf(w, "%8s  ... %d bytes ...\n", "", tail-from-32)
+		dumpRows(w, b, tail, to)
+		return
+	}
+	dumpRows(w, b, from, to)
+}
+
+func dumpRows(w *bufio.Writer, b []byte, from, to int) {
+	for row := from; row < to; row += 16 {
+		end := row + 16
+		if end > to {
+			end = to
+		}
+		fmt.Fprintf(w, "%08x  ", row)
+		for i := row; i < row+16; i++ {
+			if i < end {
+				fmt.Fprintf(w, "%02x ", b[i])
+			} else {
+				w.WriteString("   ")
+			}
+			if i == row+7 {
+				w.WriteByte(' ')
+			}
+		}
+		w.WriteString(" |")
+		for _, c := range b[row:end] {
+			if c < 0x20 || c > 0x7e {
+				c = '.'
+			}
+			w.WriteByte(c)
+		}
+		w.WriteString("|\n")
+	}
+}
@@ -0,0 +1,51 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+type dumpInner struct {
+	A uint16
+}
+
+type dumpMsg struct {
+	Kind  uint8
+	Name  string
+	Inner dumpInner
+	Blob  []byte
+}
+
+func TestDumpHex(t *testing.T) {
+	blob := make([]byte, 100)
+	for i := range blob {
+		blob[i] = byte(i)
+	}
+	out := new(bytes.Buffer)
+	e := DumpHex(&dumpMsg{Kind: 7, Name: "hi!", Inner: dumpInner{0x4142}, Blob: blob}, binary.BigEndian, BlobLength8, out)
+	if e != nil {
+		t.Fatalf("DumpHex: %v", e)
+	}
+	expected := `00000000  # dumpMsg marshal.dumpMsg len 108
+00000000  #   Kind uint8 len 1
+00000000  07                                                |.|
+00000001  #   Name string len 4
+00000001  #   Name length len 1
+00000001  03                                                |.|
+00000002  68 69 21                                          |hi!|
+00000005  #   Inner marshal.dumpInner len 2
+00000005  #     A uint16 len 2
+00000005  41 42                                             |AB|
+00000007  #   Blob []uint8 len 101
+00000007  #   Blob length len 1
+00000007  64                                                |d|
+00000008  00 01 02 03 04 05 06 07  08 09 0a 0b 0c 0d 0e 0f  |................|
+00000018  10 11 12 13 14 15 16 17  18 19 1a 1b 1c 1d 1e 1f  |................|
+          ... 52 bytes ...
+0000005c  54 55 56 57 58 59 5a 5b  5c 5d 5e 5f 60 61 62 63  |TUVWXYZ[\]^_` + "`" + `abc|
+`
+	if out.String() != expected {
+		t.Errorf("DumpHex output:\n%s\nwant:\n%s", out, expected)
+	}
+}
@@ -0,0 +1,122 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+//enumMap is a registered string <-> integer code mapping, see RegisterEnum
+type enumMap struct {
+	name  string
+	kind  reflect.Kind
+	bits  int
+	codes map[string]uint64
+	names map[uint64]string
+}
+
+var (
+	enumLock sync.RWMutex
+	enums    = map[string]*enumMap{}
+)
+
+//RegisterEnum registers codes, a map from strings to an unsigned integer type such as
+//map[string]uint8, under name. A string field tagged enum=name is written as the code
+//of its value in the width of the map's value type. Codes must be unique
+func RegisterEnum(name string, codes interface{}) {
+	v := reflect.ValueOf(codes)
+	if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+		panic(fmt.Errorf("marshal: RegisterEnum(%s): %T is not a map from strings", name, codes))
+	}
+	e := &enumMap{name: name, kind: v.Type().Elem().Kind(), codes: map[string]uint64{}, names: map[uint64]string{}}
+	switch e.kind {
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+	default:
+		panic(fmt.Errorf("marshal: RegisterEnum(%s): codes must be uint8, uint16, uint32 or uint64, not %s", name, v.Type().Elem()))
+	}
+	e.bits = v.Type().Elem().Bits()
+	iter := v.MapRange()
+	for iter.Next() {
+		s, c := iter.Key().String(), iter.Value().Uint()
+		if prev, dup := e.names[c]; dup {
+			panic(fmt.Errorf("marshal: RegisterEnum(%s): %q and %q share code %d", name, prev, s, c))
+		}
+		e.codes[s], e.names[c] = c, s
+	}
+	enumLock.Lock()
+	enums[name] = e
+	enumLock.Unlock()
+	//plans cached before registration may hold the previous mapping
+	planLock.Lock()
+	plans.Clear()
+	planLock.Unlock()
+}
+
+func lookupEnum(name string) (*enumMap, error) {
+	enumLock.RLock()
+	e, ok := enums[name]
+	enumLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("enum %q is not registered", name)
+	}
+	return e, nil
+}
+
+//code returns the code of s, with fallback a decimal number not in the map is its own code
+func (e *enumMap) code(s string, fallback bool) uint64 {
+	if c, ok := e.codes[s]; ok {
+		return c
+	}
+	if fallback {
+		if c, err := strconv.ParseUint(s, 10, e.bits); err == nil {
+			return c
+		}
+	}
+	panic(errorf(ErrMalformed, "marshal: %q is not a value of enum %s", s, e.name))
+}
+
+//value returns the string of code c, with fallback an unknown code decodes as its decimal form
+func (e *enumMap) value(c uint64, fallback bool) string {
+	if s, ok := e.names[c]; ok {
+		return s
+	}
+	if fallback {
+		return strconv.FormatUint(c, 10)
+	}
+	panic(errorf(ErrMalformed, "unmarshal: code %d is not a value of enum %s", c, e.name))
+}
+
+func (m *marshaler) enum(v reflect.Value, e *enumMap, fallback bool) {
+	c := e.code(v.String(), fallback)
+	switch e.kind {
+	case reflect.Uint8:
+		m.uint8(uint8(c))
+	case reflect.Uint16:
+		m.uint16(uint16(c))
+	case reflect.Uint32:
+		m.uint32(uint32(c))
+	default:
+		m.uint64(c)
+	}
+}
+
+func (u *unmarshaler) enum(v reflect.Value, e *enumMap, fallback bool, order binary.ByteOrder) {
+	start := u.cr.n
+	var c uint64
+	switch e.kind {
+	case reflect.Uint8:
+		c = uint64(u.fetch(1)[0])
+	case reflect.Uint16:
+		c = uint64(order.Uint16(u.fetch(2)))
+	case reflect.Uint32:
+		c = uint64(order.Uint32(u.fetch(4)))
+	default:
+		c = order.Uint64(u.fetch(8))
+	}
+	v.SetString(e.value(c, fallback))
+	if _, ok := e.names[c]; !ok && u.warn != nil {
+		u.warning(WarnUnknownEnum, start, "code %d is not a value of enum %s", c, e.name)
+	}
+}
@@ -0,0 +1,74 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func init() {
+	RegisterEnum("testStatus", map[string]uint8{"ok": 0, "busy": 1, "gone": 7})
+	RegisterEnum("testKind", map[string]uint16{"alpha": 0x100, "beta": 0x200})
+}
+
+type enumMsg struct {
+	Status string `marshal:"enum=testStatus"`
+	Kind   string `marshal:"enum=testKind,fallback"`
+}
+
+func TestEnum(t *testing.T) {
+	v := enumMsg{"gone", "beta"}
+	b, err := MarshalBytes(&v, binary.BigEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{7, 0x02, 0x00}; !bytes.Equal(b, expected) {
+		t.Errorf("encoded % x, want % x", b, expected)
+	}
+	var readBack enumMsg
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, BlobLength8); err != nil {
+		t.Fatal(err)
+	}
+	if readBack != v {
+		t.Errorf("decoded %+v, want %+v", readBack, v)
+	}
+}
+
+func TestEnumUnknown(t *testing.T) {
+	if _, err := MarshalBytes(&enumMsg{"lost", "alpha"}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for a string missing from the enum")
+	}
+	var v enumMsg
+	if err := UnmarshalBytes(&v, []byte{9, 1, 0}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for an unknown code")
+	}
+
+	//fallback passes unknowns through as numbers in both directions
+	b, err := MarshalBytes(&enumMsg{"ok", "4660"}, binary.BigEndian, BlobLength8)
+	if err != nil || !bytes.Equal(b, []byte{0, 0x12, 0x34}) {
+		t.Errorf("fallback encode: % x, %v", b, err)
+	}
+	if err := UnmarshalBytes(&v, []byte{1, 0x00, 0x05}, binary.BigEndian, BlobLength8); err != nil || v.Kind != "5" || v.Status != "busy" {
+		t.Errorf("fallback decode: %+v, %v", v, err)
+	}
+	if _, err := MarshalBytes(&enumMsg{"ok", "70000"}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for a fallback code wider than the enum")
+	}
+}
+
+func TestEnumTagErrors(t *testing.T) {
+	type unregistered struct {
+		S string `marshal:"enum=nope"`
+	}
+	type notString struct {
+		N uint8 `marshal:"enum=testStatus"`
+	}
+	type bareFallback struct {
+		S string `marshal:"fallback"`
+	}
+	for _, v := range []interface{}{&unregistered{}, &notString{}, &bareFallback{}} {
+		if _, err := MarshalBytes(v, binary.BigEndian, BlobLength8); err == nil {
+			t.Errorf("%T: expected a tag error", v)
+		}
+	}
+}
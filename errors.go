@@ -0,0 +1,56 @@
+package marshal
+
+import (
+	"errors"
+	"fmt"
+)
+
+//The errors of the package wrap one of these kinds where one applies, test for
+//them with errors.Is. The sentinels of particular length types, such as
+//ErrLengthTooLarge or ErrVarintTooLong, wrap their kind in turn
+var (
+	//ErrBoundExceeded is a length over the bound of a Bound32 or Bound64 length
+	//type, a string over its max= or fixed= size, or a batch over its maximum
+	ErrBoundExceeded = errors.New("marshal: bound exceeded")
+	//ErrLengthOverflow is a length or count that doesn't fit its prefix or the
+	//field holding it, a negative one, a decoded one that doesn't fit an int, or
+	//a value that doesn't fit the digits or bits its tag gives it
+	ErrLengthOverflow = errors.New("marshal: length overflow")
+	//ErrMalformed is input the length type or the tags don't allow: a bad length
+	//prefix, marker, padding, count, back-reference or region, a digit, code or
+	//type id that isn't one, or a number that overflows its field. Values to
+	//encode the tags don't allow, such as a NaN to quantize, are malformed too
+	ErrMalformed = errors.New("unmarshal: malformed input")
+	//ErrTrailingBytes is a delimited, sizeof= or region value that leaves bytes
+	//of its region unused
+	ErrTrailingBytes = errors.New("unmarshal: trailing bytes")
+	//ErrUnsupportedKind is a value of a kind that can't be encoded, such as a
+	//channel or a function, a map key that can't round trip, a type without an
+	//encoder or wire format, or a custom type that can't be decoded
+	ErrUnsupportedKind = errors.New("marshal: unsupported kind")
+	//ErrNilPointer is a nil pointer given to decode into
+	ErrNilPointer = errors.New("unmarshal: nil pointer")
+	//ErrChecksum is a frame whose checksum doesn't match its contents, such as a
+	//corrupt record in the middle of a log
+	ErrChecksum = errors.New("unmarshal: checksum mismatch")
+)
+
+//kindError is an error of the kind of one of the sentinels above, it keeps its
+//own message
+type kindError struct {
+	msg  string
+	kind error
+}
+
+func (e *kindError) Error() string {
+	return e.msg
+}
+
+func (e *kindError) Unwrap() error {
+	return e.kind
+}
+
+//errorf formats an error of the kind of the sentinel kind
+func errorf(kind error, format string, args ...interface{}) error {
+	return &kindError{fmt.Sprintf(format, args...), kind}
+}
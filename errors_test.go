@@ -0,0 +1,93 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type errorsInner struct {
+	A uint8
+}
+
+type errorsMsg struct {
+	Name  string
+	Inner errorsInner `marshal:"delimited"`
+}
+
+func TestErrorKinds(t *testing.T) {
+	type short struct {
+		S string `marshal:"max=2"`
+	}
+	type withChan struct {
+		C chan int
+	}
+	type nibbles struct {
+		Hi uint8 `marshal:"bits=4"`
+		Lo uint8 `marshal:"bits=4"`
+	}
+	type digits struct {
+		S string `marshal:"bcd=2"`
+	}
+	var readBack errorsMsg
+	var nilMsg *errorsMsg
+	cases := []struct {
+		name string
+		err  error
+		kind error
+	}{
+		{"bound", func() error {
+			_, err := MarshalBytes(make([]byte, 5), binary.BigEndian, Bound32(4))
+			return err
+		}(), ErrBoundExceeded},
+		{"max", func() error {
+			_, err := MarshalBytes(&short{"abc"}, binary.BigEndian, BlobLength8)
+			return err
+		}(), ErrBoundExceeded},
+		{"too large", func() error {
+			_, err := MarshalBytes(make([]byte, 256), binary.BigEndian, BlobLength8)
+			return err
+		}(), ErrLengthOverflow},
+		{"compact", func() error {
+			_, err := MarshalBytes(make([]byte, 0x400000), binary.BigEndian, StrictCompactLength)
+			return err
+		}(), ErrLengthOverflow},
+		{"der", UnmarshalBytes(&readBack, []byte{0x81, 0x01}, binary.BigEndian, DERLength), ErrMalformed},
+		{"resp", UnmarshalBytes(&readBack, []byte("x1\r\n"), binary.BigEndian, RESPLength('$', 10, true)), ErrMalformed},
+		{"trailing", UnmarshalBytes(&readBack, []byte{1, 'x', 2, 7, 0}, binary.BigEndian, BlobLength8, Strict()), ErrTrailingBytes},
+		{"chan", Validate(withChan{}), ErrUnsupportedKind},
+		{"bits", func() error {
+			_, err := MarshalBytes(nibbles{Hi: 16}, binary.BigEndian, BlobLength8)
+			return err
+		}(), ErrLengthOverflow},
+		{"bcd", func() error {
+			_, err := MarshalBytes(digits{"1x"}, binary.BigEndian, BlobLength8)
+			return err
+		}(), ErrMalformed},
+		{"negative", ErrNegativeLength, ErrLengthOverflow},
+		{"nil", UnmarshalBytes(nilMsg, []byte{0, 1, 0}, binary.BigEndian, BlobLength8), ErrNilPointer},
+	}
+	kinds := []error{ErrBoundExceeded, ErrLengthOverflow, ErrMalformed, ErrTrailingBytes, ErrUnsupportedKind, ErrNilPointer}
+	for _, c := range cases {
+		if c.err == nil {
+			t.Errorf("%s: expected an error", c.name)
+			continue
+		}
+		for _, k := range kinds {
+			if errors.Is(c.err, k) != (k == c.kind) {
+				t.Errorf("%s: errors.Is(%v, %v) = %v", c.name, c.err, k, !(k == c.kind))
+			}
+		}
+	}
+	//the sentinels of length types keep their own identity
+	_, err := MarshalBytes(make([]byte, 0x400000), binary.BigEndian, StrictCompactLength)
+	if !errors.Is(err, ErrCompactOverflow) {
+		t.Errorf("got %v, want ErrCompactOverflow", err)
+	}
+	//errors of lengths name the value they happened in
+	_, err = MarshalBytes(&errorsMsg{Name: strings.Repeat("x", 5)}, binary.BigEndian, Bound32(4))
+	if !errors.Is(err, ErrBoundExceeded) || !strings.Contains(err.Error(), "Name") {
+		t.Errorf("expected a bound error at Name, got %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+//ErrSchemaMismatch is returned by Unmarshal with Fingerprint when the input was
+//written for a different layout than the one of the destination
+var ErrSchemaMismatch = errors.New("unmarshal: schema fingerprint mismatch")
+
+//fingerprintSize is the size of the header Fingerprint adds
+const fingerprintSize = 8
+
+//Fingerprint makes Marshal write an 8 byte fingerprint of the layout of the value
+//before it and Unmarshal check the fingerprint against the layout of the
+//destination before decoding anything, failing with ErrSchemaMismatch. The
+//fingerprint is a FNV-1a hash of the Describe output without type and field
+//names, of the byte order and of the prefixes the length type writes, so
+//renaming keeps it while changing a field's kind, width, tag or position
+//doesn't. It applies to every value an Encoder or Decoder handles
+func Fingerprint() Option {
+	return func(o *options) {
+		o.fingerprint = true
+	}
+}
+
+type fingerprintKey struct {
+	t      reflect.Type
+	pack   int
+	shared bool
+}
+
+//layoutHashes caches the hash of the layout part of fingerprints
+var layoutHashes sync.Map
+
+//fingerprint is the fingerprint of values of type t encoded with order, length and o
+func fingerprint(t reflect.Type, order binary.ByteOrder, length LengthTypeInstance, o *options) uint64 {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	key := fingerprintKey{t, o.pack, o.shared}
+	layout, ok := layoutHashes.Load(key)
+	if !ok {
+		s, err := describeType(t, o)
+		if err != nil {
+			panic(err)
+		}
+		h := fnv.New64a()
+		(&layoutHasher{h: h, seen: map[*Schema]int{}}).schema(s)
+		layout, _ = layoutHashes.LoadOrStore(key, h.Sum64())
+	}
+	h := fnv.New64a()
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], layout.(uint64))
+	h.Write(b[:])
+	hashEncoding(h, order, length)
+	return h.Sum64()
+}
+
+//hashEncoding hashes the byte order and the prefixes length writes
+func hashEncoding(h io.Writer, order binary.ByteOrder, length LengthTypeInstance) {
+	//all widths, orders may agree on some, like PDPEndian and little-endian on 16 bits
+	var b [14]byte
+	order.PutUint16(b[:], 0x0102)
+	order.PutUint32(b[2:], 0x03040506)
+	order.PutUint64(b[6:], 0x0708090a0b0c0d0e)
+	h.Write(b[:])
+	for _, k := range []reflect.Kind{reflect.String, reflect.Slice, reflect.Map} {
+		for _, l := range []int{0, 200, 70000} {
+			hashLength(h, length, order, k, l)
+		}
+	}
+}
+
+//hashLength hashes the prefix length writes for l, or that it can't write it
+func hashLength(h io.Writer, length LengthTypeInstance, order binary.ByteOrder, k reflect.Kind, l int) {
+	defer func() {
+		if recover() != nil {
+			h.Write([]byte("!"))
+		}
+	}()
+	c := writeCounter{w: h}
+	length.PutLength(&c, order, k, l)
+	h.Write([]byte(strconv.FormatInt(c.n, 10)))
+}
+
+type layoutHasher struct {
+	h io.Writer
+	//seen numbers the schemas hashed so far, a recursive type hashes a reference
+	seen map[*Schema]int
+}
+
+func (lh *layoutHasher) put(items ...interface{}) {
+	for _, item := range items {
+		fmt.Fprint(lh.h, item, ";")
+	}
+}
+
+func (lh *layoutHasher) schema(s *Schema) {
+	if n, ok := lh.seen[s]; ok {
+		lh.put("ref", n)
+		return
+	}
+	lh.seen[s] = len(lh.seen)
+	lh.put(s.Kind.String(), s.Size, s.Prefixed, s.Len, s.Custom, s.Optional)
+	if s.Custom {
+		//the encoding is up to the codec, only its type tells it apart
+		lh.put(s.Type.String())
+	}
+	if s.Kind == reflect.Struct {
+		lh.put(len(s.Fields))
+		for _, f := range s.Fields {
+			lh.put(f.Tag, f.Offset, f.Bits, f.BitOffset)
+			lh.schema(f.Schema)
+		}
+	}
+	for _, sub := range []*Schema{s.Key, s.Elem} {
+		if sub == nil {
+			lh.put("-")
+		} else {
+			lh.schema(sub)
+		}
+	}
+}
+
+//putFingerprint writes the fingerprint of values of type t
+func (m *marshaler) putFingerprint(t reflect.Type, length LengthTypeInstance, o *options) {
+	var b [fingerprintSize]byte
+	binary.BigEndian.PutUint64(b[:], fingerprint(t, m.order, length, o))
+	if _, err := m.w.Write(b[:]); err != nil {
+		panic(err)
+	}
+}
+
+//checkFingerprint reads a fingerprint and fails unless it is the one of values of type t
+func (u *unmarshaler) checkFingerprint(t reflect.Type, order binary.ByteOrder, length LengthTypeInstance, o *options) {
+	var b [fingerprintSize]byte
+	if _, err := io.ReadFull(u.r, b[:]); err != nil {
+		panic(err)
+	}
+	if got, want := binary.BigEndian.Uint64(b[:]), fingerprint(t, order, length, o); got != want {
+		panic(fmt.Errorf("%w: input has %016x, %s has %016x", ErrSchemaMismatch, got, t, want))
+	}
+}
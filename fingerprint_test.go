@@ -0,0 +1,84 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type printV1 struct {
+	ID   uint32
+	Name string
+}
+
+//printRenamed has the layout of printV1 under other names
+type printRenamed struct {
+	Key   uint32
+	Title string
+}
+
+type printV2 struct {
+	ID   uint64
+	Name string
+}
+
+func TestFingerprint(t *testing.T) {
+	v := printV1{7, "seven"}
+	b, err := MarshalBytes(&v, binary.BigEndian, BlobLength8, Fingerprint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, _ := MarshalBytes(&v, binary.BigEndian, BlobLength8)
+	if len(b) != len(plain)+fingerprintSize || !bytes.Equal(b[fingerprintSize:], plain) {
+		t.Errorf("encoded % x, want 8 bytes and % x", b, plain)
+	}
+	var readBack printV1
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, BlobLength8, Fingerprint()); err != nil || readBack != v {
+		t.Errorf("decoded %+v, %v", readBack, err)
+	}
+	var renamed printRenamed
+	if err := UnmarshalBytes(&renamed, b, binary.BigEndian, BlobLength8, Fingerprint()); err != nil || renamed.Key != 7 {
+		t.Errorf("renamed decoded %+v, %v", renamed, err)
+	}
+	var v2 printV2
+	if err := UnmarshalBytes(&v2, b, binary.BigEndian, BlobLength8, Fingerprint()); !errors.Is(err, ErrSchemaMismatch) {
+		t.Errorf("expected ErrSchemaMismatch for another layout, got %v", err)
+	}
+	if v2 != (printV2{}) {
+		t.Errorf("decoded %+v despite the mismatch", v2)
+	}
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, BlobLength16, Fingerprint()); !errors.Is(err, ErrSchemaMismatch) {
+		t.Errorf("expected ErrSchemaMismatch for another length type, got %v", err)
+	}
+	if err := UnmarshalBytes(&readBack, b, binary.LittleEndian, BlobLength8, Fingerprint()); !errors.Is(err, ErrSchemaMismatch) {
+		t.Errorf("expected ErrSchemaMismatch for another byte order, got %v", err)
+	}
+	if err := UnmarshalBytes(&readBack, b[:5], binary.BigEndian, BlobLength8, Fingerprint()); err == nil {
+		t.Errorf("expected an error for a short header")
+	}
+}
+
+func TestFingerprintStream(t *testing.T) {
+	values := []printV1{{1, "a"}, {2, "bc"}}
+	var buf bytes.Buffer
+	e := NewEncoder(&buf, binary.BigEndian, BlobLength8, Fingerprint())
+	if err := e.EncodeAll(values); err != nil {
+		t.Fatal(err)
+	}
+	b := bytes.Clone(buf.Bytes())
+	d := NewDecoder(&buf, binary.BigEndian, BlobLength8, Fingerprint())
+	var readBack []printV1
+	if err := d.DecodeAll(&readBack, 2); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(readBack, values) {
+		t.Errorf("decoded %+v, want %+v", readBack, values)
+	}
+	d = NewBytesDecoder(b, binary.BigEndian, BlobLength8, Fingerprint())
+	var v2 printV2
+	if err := d.Decode(&v2); !errors.Is(err, ErrSchemaMismatch) {
+		t.Errorf("expected ErrSchemaMismatch, got %v", err)
+	}
+}
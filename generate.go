@@ -0,0 +1,409 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"slices"
+	"sort"
+)
+
+//generateMax is the most elements Generate puts in a string, slice or map
+const generateMax = 16
+
+//generateDepth is the nesting of slices, maps, pointers and interfaces from which
+//Generate leaves them empty, so recursive types end
+const generateDepth = 8
+
+//generateChars are the bytes of generated strings, every charset has them and no
+//fixed= padding is made of them
+const generateChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+//Generate returns a random value of type t that encodes with length and opts, for
+//property tests such as RoundTrip(v, order, length) on any generated v.
+//Numbers are uniform over their type or what their tag allows: bits=, bcd=,
+//ascii= and packbits= values fit, unsigned delta elements don't decrease.
+//Strings, slices and maps hold up to 16 elements within what the length type
+//writes and max= or fixed= allow, strings are letters and digits, enum strings
+//are values of their enum and interfaces hold nil or a registered type that
+//implements them. Custom types and codec= fields are left zero.
+//The value is encoded and decoded once before it is returned, so fields filled
+//in on encode, such as count= and sizeof= ones, hold what they decode to. When
+//it doesn't fit a length prefix it is generated again with fewer elements
+func Generate(t reflect.Type, r *rand.Rand, length LengthType, opts ...Option) (interface{}, error) {
+	if t == nil {
+		return nil, errors.New("marshal: Generate(nil)")
+	}
+	if p := planFor(t); p.err != nil {
+		return nil, p.err
+	}
+	for limit := generateMax; ; limit /= 2 {
+		v, err := generateOnce(t, r, length, limit, opts)
+		if limit == 0 || !(errors.Is(err, ErrLengthOverflow) || errors.Is(err, ErrBoundExceeded)) {
+			return v, err
+		}
+	}
+}
+
+//generateOnce generates a value of type t with up to limit elements per string,
+//slice or map and returns it as it decodes
+func generateOnce(t reflect.Type, r *rand.Rand, length LengthType, limit int, opts []Option) (v interface{}, err error) {
+	g := generator{r: r, length: length, limit: limit}
+	gv := reflect.New(t)
+	func() {
+		defer recoverError(&err)
+		g.value(gv.Elem(), nil, 0)
+	}()
+	if err != nil {
+		return nil, err
+	}
+	b, err := MarshalBytes(gv.Interface(), binary.BigEndian, length, opts...)
+	if err != nil {
+		return nil, err
+	}
+	back := reflect.New(t)
+	if err := UnmarshalBytes(back.Interface(), b, binary.BigEndian, length, opts...); err != nil {
+		return nil, err
+	}
+	return back.Elem().Interface(), nil
+}
+
+type generator struct {
+	r      *rand.Rand
+	length LengthType
+	limit  int
+}
+
+//value fills v at the nesting depth, the field tag ft applies to it when it isn't nil
+func (g *generator) value(v reflect.Value, ft *fieldTag, depth int) {
+	if depth > 4*generateDepth {
+		panic(fmt.Errorf("marshal: Generate: %s has no finite value", v.Type()))
+	}
+	p := planFor(v.Type())
+	if p.err != nil {
+		panic(p.err)
+	}
+	switch {
+	case p.custom:
+		return
+	case p.optional:
+		g.optional(v, depth)
+		return
+	case ft != nil && g.tagged(v, ft, depth):
+		return
+	}
+	switch k := v.Kind(); {
+	case k == reflect.Bool:
+		v.SetBool(g.r.Intn(2) == 1)
+	case isInteger(k):
+		g.integer(v, 0, true)
+	case k == reflect.Float32 || k == reflect.Float64:
+		v.SetFloat(g.float(k))
+	case k == reflect.Complex64:
+		v.SetComplex(complex(g.float(reflect.Float32), g.float(reflect.Float32)))
+	case k == reflect.Complex128:
+		v.SetComplex(complex(g.float(reflect.Float64), g.float(reflect.Float64)))
+	case k == reflect.String:
+		g.str(v, ft)
+	case k == reflect.Array || k == reflect.Slice:
+		g.elems(v, ft, depth, func(e reflect.Value) { g.value(e, nil, depth+1) })
+	case k == reflect.Map:
+		l := g.size(reflect.Map, g.limit, depth)
+		if l == 0 {
+			return
+		}
+		t := v.Type()
+		mv := reflect.MakeMapWithSize(t, l)
+		for i := 0; i < l; i++ {
+			key, e := reflect.New(t.Key()).Elem(), reflect.New(t.Elem()).Elem()
+			g.value(key, nil, depth+1)
+			g.value(e, nil, depth+1)
+			mv.SetMapIndex(key, e)
+		}
+		v.Set(mv)
+	case k == reflect.Ptr:
+		v.Set(reflect.New(v.Type().Elem()))
+		g.value(v.Elem(), ft, depth+1)
+	case k == reflect.Struct:
+		g.fields(v, p, depth)
+	case k == reflect.Interface:
+		g.iface(v, depth)
+	}
+	//other kinds stay zero and fail to encode
+}
+
+//tagged fills v as the tag ft requires, it reports false when v is filled as if
+//untagged
+func (g *generator) tagged(v reflect.Value, ft *fieldTag, depth int) bool {
+	k := v.Kind()
+	switch {
+	case ft.codec != nil:
+		return true
+	case ft.enum != nil:
+		names := make([]string, 0, len(ft.enum.codes))
+		for s := range ft.enum.codes {
+			names = append(names, s)
+		}
+		if len(names) > 0 {
+			sort.Strings(names)
+			v.SetString(names[g.r.Intn(len(names))])
+		}
+		return true
+	case (ft.bcd > 0 || ft.bcdVar) && k == reflect.String:
+		n := ft.bcd
+		if ft.bcdVar {
+			n = g.size(reflect.String, g.limit, 0)
+		}
+		digits := make([]byte, n)
+		for i := range digits {
+			digits[i] = byte('0' + g.r.Intn(10))
+		}
+		v.SetString(string(digits))
+		return true
+	case ft.bcdVar:
+		g.integer(v, 0, false)
+		return true
+	case ft.bcd > 0:
+		g.integer(v, pow10(ft.bcd), false)
+		return true
+	case ft.ascii > 0 && isSigned(k):
+		g.integer(v, pow10(ft.ascii-1), true)
+		return true
+	case ft.ascii > 0:
+		g.integer(v, pow10(ft.ascii), false)
+		return true
+	case ft.bits > 0 && k != reflect.Bool:
+		g.bitsValue(v, ft.bits)
+		return true
+	case ft.packbits > 0:
+		g.elems(v, ft, depth, func(e reflect.Value) { g.bitsValue(e, ft.packbits) })
+		return true
+	case ft.delta:
+		g.elems(v, ft, depth, func(e reflect.Value) { g.integer(e, 0, true) })
+		if !isSigned(v.Type().Elem().Kind()) {
+			s := v.Slice(0, v.Len()).Interface()
+			sort.Slice(s, func(i, j int) bool { return v.Index(i).Uint() < v.Index(j).Uint() })
+		}
+		return true
+	case ft.quantize != reflect.Invalid:
+		g.quantized(v, ft, depth)
+		return true
+	case ft.bitmap:
+		g.elems(v, ft, depth, func(e reflect.Value) {
+			if g.r.Intn(2) == 1 {
+				g.value(e, nil, depth+1)
+			}
+		})
+		return true
+	case ft.nullable && k == reflect.Ptr:
+		//nil is the null sentinel
+		return g.r.Intn(2) == 0
+	}
+	return false
+}
+
+//fields fills the fields of the struct v of plan p. The scale and bias fields of
+//quantize= slices are 1 and 0, which keeps whole numbers in range as they are
+func (g *generator) fields(v reflect.Value, p *typePlan, depth int) {
+	for i := range p.fields {
+		f := &p.fields[i]
+		fv := v.Field(f.index)
+		if !fv.CanSet() {
+			continue
+		}
+		if f.scales != nil {
+			if f.scales.tag.scaleIndex == f.index {
+				fv.SetFloat(1)
+			}
+			continue
+		}
+		g.value(fv, f.tag, depth)
+	}
+}
+
+//elems makes the slice v, or takes the array v, and fills its elements with fill
+func (g *generator) elems(v reflect.Value, ft *fieldTag, depth int, fill func(e reflect.Value)) {
+	if v.Kind() == reflect.Slice {
+		most := g.limit
+		if ft != nil && ft.count != "" {
+			//the count field may be as narrow as an int8
+			most = min(most, math.MaxInt8/max(ft.unit, 1))
+		}
+		l := g.size(reflect.Slice, most, depth)
+		v.Set(reflect.MakeSlice(v.Type(), l, l))
+	}
+	for i := 0; i < v.Len(); i++ {
+		fill(v.Index(i))
+	}
+}
+
+//quantized fills the float slice or array v of the quantize= tag ft with whole
+//numbers that come back exactly: with scale= fields the scale is 1 and the bias
+//0, see fields. Without them the smallest and largest element are the ends of
+//the integer range, so those are the scale and bias written, or all elements
+//are equal when the range is wider than a float32 holds exactly
+func (g *generator) quantized(v reflect.Value, ft *fieldTag, depth int) {
+	lo, hi := quantizeRange(ft.quantize)
+	whole := func(lo, hi float64) float64 {
+		lo, hi = max(lo, -1<<24), min(hi, 1<<24)
+		return lo + math.Floor(g.r.Float64()*(hi-lo+1))
+	}
+	same := whole(lo, hi)
+	g.elems(v, ft, depth, func(e reflect.Value) {
+		if ft.scale == "" && hi-lo > 1<<24 {
+			e.SetFloat(same)
+		} else {
+			e.SetFloat(whole(lo, hi))
+		}
+	})
+	if l := v.Len(); ft.scale == "" && hi-lo <= 1<<24 && l >= 2 {
+		i := g.r.Intn(l)
+		j := (i + 1 + g.r.Intn(l-1)) % l
+		v.Index(i).SetFloat(lo)
+		v.Index(j).SetFloat(hi)
+	}
+}
+
+//str fills the string v with letters and digits within the max= and fixed= sizes
+//of ft, empty when its charset can't encode them
+func (g *generator) str(v reflect.Value, ft *fieldTag) {
+	most := g.limit
+	if ft != nil && ft.max > 0 {
+		most = min(most, ft.max)
+	}
+	if ft != nil && ft.fixed > 0 {
+		most = min(most, ft.fixed)
+	}
+	b := make([]byte, g.size(reflect.String, most, 0))
+	for i := range b {
+		b[i] = generateChars[g.r.Intn(len(generateChars))]
+	}
+	s := string(b)
+	if ft != nil && ft.charset != nil {
+		if _, err := ft.charset.Encode(s); err != nil {
+			s = ""
+		}
+	}
+	v.SetString(s)
+}
+
+//size picks a length of up to most elements that the length type writes for a
+//value of kind k, 0 from generateDepth on
+func (g *generator) size(k reflect.Kind, most, depth int) int {
+	if depth >= generateDepth || most <= 0 {
+		return 0
+	}
+	l := g.r.Intn(most + 1)
+	for l > 0 && prefixSize(g.length, k, l) < 0 {
+		l--
+	}
+	return l
+}
+
+//integer sets the integer v to a uniform value below bound unless it is 0, negative
+//ones are left out of signed kinds unless neg is set
+func (g *generator) integer(v reflect.Value, bound uint64, neg bool) {
+	bits := v.Type().Bits()
+	if !isSigned(v.Kind()) {
+		x := g.r.Uint64() >> (64 - bits)
+		if bound > 0 {
+			x %= bound
+		}
+		v.SetUint(x)
+		return
+	}
+	if neg && bound == 0 {
+		v.SetInt(int64(g.r.Uint64()) >> (64 - bits))
+		return
+	}
+	x := g.r.Uint64() >> (65 - bits)
+	if bound > 0 {
+		x %= bound
+	}
+	if neg && g.r.Intn(2) == 1 {
+		v.SetInt(-int64(x))
+		return
+	}
+	v.SetInt(int64(x))
+}
+
+//bitsValue sets the integer v to a uniform value that fits in n bits
+func (g *generator) bitsValue(v reflect.Value, n int) {
+	x := g.r.Uint64() & bitMask(n)
+	if isSigned(v.Kind()) {
+		v.SetInt(int64(x<<(64-n)) >> (64 - n))
+	} else {
+		v.SetUint(x)
+	}
+}
+
+//float returns a float of kind k uniform over its bits, NaN left out
+func (g *generator) float(k reflect.Kind) float64 {
+	for {
+		var f float64
+		if k == reflect.Float32 {
+			f = float64(math.Float32frombits(g.r.Uint32()))
+		} else {
+			f = math.Float64frombits(g.r.Uint64())
+		}
+		if !math.IsNaN(f) {
+			return f
+		}
+	}
+}
+
+//optional fills the optional v, present or not
+func (g *generator) optional(v reflect.Value, depth int) {
+	if depth >= generateDepth || g.r.Intn(2) == 0 {
+		return
+	}
+	if v.Type().PkgPath() == "database/sql" {
+		v.Field(1).SetBool(true)
+		g.value(v.Field(0), nil, depth+1)
+		return
+	}
+	o := v.Addr().Interface().(Optional)
+	o.SetPresent(true)
+	g.value(reflect.ValueOf(o.Value()).Elem(), nil, depth+1)
+}
+
+//iface fills the interface v with nil or a value of a registered type implementing it
+func (g *generator) iface(v reflect.Value, depth int) {
+	if depth >= generateDepth {
+		return
+	}
+	var ids []uint64
+	typeLock.RLock()
+	for t, id := range typeIDs {
+		if t.Implements(v.Type()) {
+			ids = append(ids, id)
+		}
+	}
+	typeLock.RUnlock()
+	slices.Sort(ids)
+	i := g.r.Intn(len(ids) + 1)
+	if i == len(ids) {
+		return
+	}
+	typeLock.RLock()
+	t := idTypes[ids[i]]
+	typeLock.RUnlock()
+	e := reflect.New(t).Elem()
+	g.value(e, nil, depth+1)
+	v.Set(e)
+}
+
+//pow10 returns 10 to the n, 0 when it doesn't fit a uint64 and bounds nothing
+func pow10(n int) uint64 {
+	if n >= 20 {
+		return 0
+	}
+	x := uint64(1)
+	for ; n > 0; n-- {
+		x *= 10
+	}
+	return x
+}
@@ -0,0 +1,107 @@
+package marshal
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+type genInner struct {
+	F    float32
+	C    complex64
+	Keys map[uint16]string
+}
+
+type genMsg struct {
+	A      int64
+	B      bool
+	S      string `marshal:"max=5"`
+	Fixed  string `marshal:"fixed=4"`
+	Status string `marshal:"enum=testStatus"`
+	BCD    uint32 `marshal:"bcd=5"`
+	Digits string `marshal:"bcd=3"`
+	ASCII  int16  `marshal:"ascii=3"`
+	Flags  int8   `marshal:"bits=3"`
+	Mode   uint8  `marshal:"bits=5"`
+	N      uint8
+	Items  []uint16    `marshal:"count=N"`
+	Times  []uint32    `marshal:"delta"`
+	Packed []int8      `marshal:"packbits=3"`
+	Inner  genInner    `marshal:"delimited"`
+	Ptrs   []*genInner `marshal:"bitmap"`
+	Opt    sql.NullString
+	Event  ifaceEvent
+	Grid   [2][]byte
+	Levels []float64 `marshal:"quantize=int8"`
+}
+
+type genNode struct {
+	Next *genNode
+}
+
+func TestGenerate(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	lengths := map[string]LengthType{
+		"BlobLength8":  BlobLength8,
+		"Bound32":      Bound32(20),
+		"VarintLength": VarintLength(2),
+	}
+	events := map[reflect.Type]bool{}
+	for name, length := range lengths {
+		for i := 0; i < 200; i++ {
+			g, err := Generate(reflect.TypeOf(genMsg{}), r, length, Deterministic())
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			v := g.(genMsg)
+			if err := RoundTrip(v, binary.LittleEndian, length, Deterministic()); err != nil {
+				t.Fatalf("%s: %+v: %v", name, v, err)
+			}
+			b, _ := MarshalBytes(v, binary.BigEndian, length, Deterministic())
+			var readBack genMsg
+			if err := UnmarshalBytes(&readBack, b, binary.BigEndian, length); err != nil {
+				t.Fatal(err)
+			}
+			if again, _ := MarshalBytes(readBack, binary.BigEndian, length, Deterministic()); !bytes.Equal(again, b) {
+				t.Fatalf("%s: encoded % x, then % x", name, b, again)
+			}
+			if len(v.S) > 5 || len(v.Digits) != 3 || v.BCD > 99999 || v.Flags < -4 || v.Flags > 3 || v.Mode > 31 || int(v.N) != len(v.Items) {
+				t.Fatalf("%s: %+v breaks its tags", name, v)
+			}
+			events[reflect.TypeOf(v.Event)] = true
+		}
+	}
+	for _, e := range []ifaceEvent{nil, &ifaceClick{}, ifaceKey("")} {
+		if !events[reflect.TypeOf(e)] {
+			t.Errorf("no event of type %T generated", e)
+		}
+	}
+	//the same seed gives the same values
+	a, _ := Generate(reflect.TypeOf(genMsg{}), rand.New(rand.NewSource(7)), BlobLength8)
+	b, _ := Generate(reflect.TypeOf(genMsg{}), rand.New(rand.NewSource(7)), BlobLength8)
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("generated %+v, then %+v", a, b)
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	if _, err := Generate(reflect.TypeOf(struct{ C chan int }{}), r, BlobLength8); !errors.Is(err, ErrUnsupportedKind) {
+		t.Errorf("got %v, want ErrUnsupportedKind", err)
+	}
+	if _, err := Generate(reflect.TypeOf(genNode{}), r, BlobLength8); err == nil {
+		t.Errorf("expected an error for a type without a finite value")
+	}
+	//the delimited struct takes 13 bytes at least
+	if _, err := Generate(reflect.TypeOf(genMsg{}), r, Bound32(12)); !errors.Is(err, ErrBoundExceeded) {
+		t.Errorf("got %v, want ErrBoundExceeded", err)
+	}
+	//a string of 256 bytes fits nowhere
+	if _, err := Generate(reflect.TypeOf(""), r, OffsetLength(BlobLength8, -256)); err == nil {
+		t.Errorf("expected an error for a length type that can't write a length")
+	}
+}
@@ -0,0 +1,126 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+)
+
+var (
+	typeLock sync.RWMutex
+	typeIDs  = map[reflect.Type]uint64{}
+	idTypes  = map[uint64]reflect.Type{}
+)
+
+var rawElementType = reflect.TypeOf((*RawElement)(nil))
+
+//RegisterType registers the concrete type of v under id for interface values, e.g.
+//RegisterType(3, &Click{}) for a *Click stored in an Event interface. An interface
+//value, alone or as the element of a slice or map, is written as the uvarint id of its
+//concrete type, a length prefix and the encoded value, 0 stands for nil.
+//Ids start at 1 and must be the same on both sides
+func RegisterType(id uint64, v interface{}) {
+	t := reflect.TypeOf(v)
+	if id == 0 || t == nil {
+		panic(fmt.Errorf("marshal: RegisterType(%d, %T): id 0 and nil are reserved for nil", id, v))
+	}
+	typeLock.Lock()
+	defer typeLock.Unlock()
+	if prev, ok := idTypes[id]; ok && prev != t {
+		panic(fmt.Errorf("marshal: RegisterType: id %d is taken by %s", id, prev))
+	}
+	idTypes[id], typeIDs[t] = t, id
+}
+
+//RawElement holds an interface value whose type id isn't registered, see KeepUnknown.
+//Marshal writes it back unchanged
+type RawElement struct {
+	ID   uint64
+	Body []byte
+}
+
+//KeepUnknown makes Unmarshal decode interface values of unregistered type ids into
+//the value placeholder returns instead of failing, so the rest of a message survives.
+//The placeholder must be assignable to the interface. A nil placeholder stores a
+//*RawElement, which suits interface{} and other interfaces *RawElement implements
+func KeepUnknown(placeholder func(id uint64, body []byte) interface{}) Option {
+	if placeholder == nil {
+		placeholder = func(id uint64, body []byte) interface{} {
+			return &RawElement{id, body}
+		}
+	}
+	return func(o *options) {
+		o.unknown = placeholder
+	}
+}
+
+//iface writes the interface v as the id of its concrete type, the size and the value
+func (m *marshaler) iface(v reflect.Value, length LengthTypeInstance) {
+	if v.IsNil() {
+		m.uvarint(0)
+		return
+	}
+	c := v.Elem()
+	if c.Type() == rawElementType {
+		raw := c.Interface().(*RawElement)
+		m.uvarint(raw.ID)
+		m.payload(length, v.Type(), raw.Body)
+		return
+	}
+	typeLock.RLock()
+	id, ok := typeIDs[c.Type()]
+	typeLock.RUnlock()
+	if !ok {
+		panic(fmt.Errorf("marshal: %s in %s is not registered with RegisterType", c.Type(), v.Type()))
+	}
+	m.uvarint(id)
+	sub := m.sub(io.Discard)
+	defer putMarshaler(sub)
+	sub.marshal(c, length)
+	m.putLength(length, v.Type(), int(sub.cw.n))
+	m.marshal(c, length)
+}
+
+//iface reads an interface value written by marshaler.iface into v
+func (u *unmarshaler) iface(v reflect.Value, order binary.ByteOrder, length LengthTypeInstance) {
+	id := u.uvarint()
+	if id == 0 {
+		v.Set(reflect.Zero(v.Type()))
+		return
+	}
+	l := int64(u.getLength(length, order, v.Type()))
+	typeLock.RLock()
+	t, ok := idTypes[id]
+	typeLock.RUnlock()
+	if !ok {
+		if u.unknown == nil {
+			panic(errorf(ErrMalformed, "unmarshal: unknown type id %d for %s", id, v.Type()))
+		}
+		body := u.bytes(int(l))
+		if _, err := io.ReadFull(u.r, body); err != nil {
+			panic(err)
+		}
+		p := reflect.ValueOf(u.unknown(id, body))
+		if !p.IsValid() || !p.Type().AssignableTo(v.Type()) {
+			panic(fmt.Errorf("unmarshal: placeholder for unknown type id %d is not a %s", id, v.Type()))
+		}
+		v.Set(p)
+		u.warning(WarnUnknownType, u.cr.n-int64(l), "type id %d kept as %s", id, p.Type())
+		return
+	}
+	if !t.AssignableTo(v.Type()) {
+		panic(errorf(ErrMalformed, "unmarshal: type id %d is %s, not a %s", id, t, v.Type()))
+	}
+	c := reflect.New(t).Elem()
+	u.within(l, t, func() {
+		if t.Kind() == reflect.Ptr && u.shared == nil {
+			c.Set(reflect.New(t.Elem()))
+			u.unmarshal(c.Elem(), order, length)
+		} else {
+			u.unmarshal(c, order, length)
+		}
+	})
+	v.Set(c)
+}
@@ -0,0 +1,103 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+type ifaceEvent interface {
+	Kind() string
+}
+
+type ifaceClick struct {
+	X, Y uint16
+}
+
+func (*ifaceClick) Kind() string { return "click" }
+
+type ifaceKey string
+
+func (ifaceKey) Kind() string { return "key" }
+
+//ifaceUnknown keeps events of types this side doesn't know
+type ifaceUnknown struct {
+	RawElement
+}
+
+func (*ifaceUnknown) Kind() string { return "unknown" }
+
+func init() {
+	RegisterType(1, &ifaceClick{})
+	RegisterType(2, ifaceKey(""))
+}
+
+func TestInterfaceSlice(t *testing.T) {
+	events := []ifaceEvent{&ifaceClick{1, 2}, ifaceKey("q"), nil}
+	b, err := MarshalBytes(events, binary.BigEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{3, 1, 4, 0, 1, 0, 2, 2, 2, 1, 'q', 0}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("encoded % x, want % x", b, expected)
+	}
+	var readBack []ifaceEvent
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, BlobLength8); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(readBack, events) {
+		t.Errorf("decoded %#v, want %#v", readBack, events)
+	}
+	m := map[string]interface{}{"k": ifaceKey("z")}
+	b, err = MarshalBytes(m, binary.BigEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mBack map[string]interface{}
+	if err := UnmarshalBytes(&mBack, b, binary.BigEndian, BlobLength8); err != nil || !reflect.DeepEqual(mBack, m) {
+		t.Errorf("map decoded %#v, %v", mBack, err)
+	}
+}
+
+func TestInterfaceUnknown(t *testing.T) {
+	//an event of type 9 between two known ones
+	b := []byte{3, 2, 2, 1, 'a', 9, 2, 0xee, 0xff, 2, 2, 1, 'b'}
+	var events []ifaceEvent
+	if err := UnmarshalBytes(&events, b, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for an unknown type id")
+	}
+	keep := KeepUnknown(func(id uint64, body []byte) interface{} {
+		return &ifaceUnknown{RawElement{id, body}}
+	})
+	if err := UnmarshalBytes(&events, b, binary.BigEndian, BlobLength8, keep); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 || events[0] != ifaceKey("a") || events[1].Kind() != "unknown" || events[2] != ifaceKey("b") {
+		t.Errorf("decoded %#v", events)
+	}
+	//the default placeholder is a *RawElement, which round-trips
+	var any []interface{}
+	if err := UnmarshalBytes(&any, b, binary.BigEndian, BlobLength8, KeepUnknown(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if raw, ok := any[1].(*RawElement); !ok || raw.ID != 9 || !bytes.Equal(raw.Body, []byte{0xee, 0xff}) {
+		t.Errorf("decoded %#v", any[1])
+	}
+	if again, err := MarshalBytes(any, binary.BigEndian, BlobLength8); err != nil || !bytes.Equal(again, b) {
+		t.Errorf("re-encoded % x, %v", again, err)
+	}
+}
+
+func TestInterfaceErrors(t *testing.T) {
+	type unregistered struct{ A uint8 }
+	if _, err := MarshalBytes([]interface{}{unregistered{}}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for an unregistered type")
+	}
+	//type 1 is a *ifaceClick, which isn't a string
+	var s []interface{ String() string }
+	if err := UnmarshalBytes(&s, []byte{1, 1, 4, 0, 1, 0, 2}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for a type id not implementing the interface")
+	}
+}
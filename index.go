@@ -0,0 +1,27 @@
+package marshal
+
+import "reflect"
+
+//WithIndex makes Marshal append to *index the offset of every element of the top
+//level slice or array, counted from the first byte written and past its length
+//prefix. An Encoder records the offset of every message instead, counted from
+//the first byte it wrote. Together with UnmarshalAt this gives random access
+func WithIndex(index *[]int64) Option {
+	return func(o *options) {
+		o.index = index
+	}
+}
+
+//indexFixed records the offsets of fixed-size elements without visiting them,
+//it reports false when the elements must be indexed one by one
+func (m *marshaler) indexFixed(v reflect.Value) bool {
+	size := planFor(v.Type().Elem()).size
+	if size < 0 {
+		return false
+	}
+	start := m.cw.n
+	for i := 0; i < v.Len(); i++ {
+		*m.index = append(*m.index, start+int64(i*size))
+	}
+	return true
+}
@@ -0,0 +1,57 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestWithIndex(t *testing.T) {
+	type rec struct {
+		ID   uint16
+		Name string
+	}
+	recs := []rec{{1, "a"}, {2, "bcd"}, {3, ""}}
+	var index []int64
+	b, err := MarshalBytes(recs, binary.BigEndian, BlobLength16, WithIndex(&index))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//2 byte count, then 2+2+len(Name) per record
+	if expected := []int64{2, 7, 14}; !reflect.DeepEqual(index, expected) {
+		t.Fatalf("index %v, want %v", index, expected)
+	}
+	for i, off := range index {
+		var r rec
+		if _, err := UnmarshalAt(bytes.NewReader(b), off, &r, binary.BigEndian, BlobLength16); err != nil {
+			t.Fatal(err)
+		}
+		if r != recs[i] {
+			t.Errorf("record %d: %v, want %v", i, r, recs[i])
+		}
+	}
+
+	//fixed-size elements are indexed without visiting them
+	index = nil
+	if _, err := MarshalBytes([]uint32{1, 2, 3}, binary.BigEndian, BlobLength8, WithIndex(&index)); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []int64{1, 5, 9}; !reflect.DeepEqual(index, expected) {
+		t.Errorf("fixed index %v, want %v", index, expected)
+	}
+}
+
+func TestEncoderIndex(t *testing.T) {
+	var index []int64
+	stream := new(bytes.Buffer)
+	enc := NewEncoder(stream, binary.LittleEndian, BlobLength8, WithIndex(&index))
+	for _, s := range []string{"x", "", "hello"} {
+		if err := enc.Encode([]string{s, s}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if expected := []int64{0, 5, 8}; !reflect.DeepEqual(index, expected) {
+		t.Errorf("index %v, want %v", index, expected)
+	}
+}
@@ -0,0 +1,395 @@
+package marshal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+//Kaitai writes a Kaitai Struct definition (.ksy) of v's type, so Kaitai tools can
+//parse what Marshal writes for v with order, length and opts. Structs become
+//types, length prefixes become fields named after the value with a _len suffix.
+//Length types of variable width and values Kaitai can't bound, such as those of
+//custom codecs, end the definition with a doc comment and a region running to
+//the end of the stream; encodings it has no type for, such as bcd=, are opaque
+//byte regions with a doc comment. CLayout and SharedPointers aren't supported
+func Kaitai(v interface{}, order binary.ByteOrder, length LengthType, w io.Writer, opts ...Option) error {
+	o := newOptions(opts)
+	if o.pack != 0 || o.shared {
+		return fmt.Errorf("kaitai: CLayout and SharedPointers aren't supported")
+	}
+	endian, err := kaitaiEndian(order)
+	if err != nil {
+		return err
+	}
+	s, err := Describe(v, opts...)
+	if err != nil {
+		return err
+	}
+	for s.Kind == reflect.Ptr {
+		s = s.Elem
+	}
+	if s.Kind != reflect.Struct || s.Custom || s.Optional {
+		return fmt.Errorf("kaitai: %s is not a struct", s.Type)
+	}
+	g := kaitai{length: length, names: map[reflect.Type]string{}, used: map[string]bool{}}
+	root := g.typeName(s, s.Type.Name())
+	var seq []ksyEntry
+	if o.fingerprint {
+		seq = append(seq, ksyEntry{{"id", "fingerprint"}, {"size", "8"}, {"doc", strconv.Quote("layout fingerprint, see marshal.Fingerprint")}})
+	}
+	seq, _ = g.fields(seq, s)
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# Generated from %s by marshal.Kaitai, DO NOT EDIT.\n", s.Type)
+	fmt.Fprintf(bw, "meta:\n  id: %s\n  endian: %s\n", root, endian)
+	writeSeq(bw, "", seq)
+	if len(g.types) > 0 {
+		fmt.Fprintf(bw, "types:\n")
+		for _, t := range g.types {
+			fmt.Fprintf(bw, "  %s:\n", t.name)
+			writeSeq(bw, "    ", t.seq)
+		}
+	}
+	return bw.Flush()
+}
+
+func kaitaiEndian(order binary.ByteOrder) (string, error) {
+	switch {
+	case order == binary.BigEndian, order == NativeEndian && !hostLittle:
+		return "be", nil
+	case order == binary.LittleEndian, order == NativeEndian:
+		return "le", nil
+	}
+	return "", fmt.Errorf("kaitai: %s byte order has no Kaitai equivalent", orderName(order))
+}
+
+//ksyEntry is an attribute of a seq as its keys and values in order
+type ksyEntry [][2]string
+
+type ksyType struct {
+	name string
+	seq  []ksyEntry
+}
+
+type kaitai struct {
+	length LengthType
+	//names are the Kaitai type names of Go types, used the names taken
+	names map[reflect.Type]string
+	used  map[string]bool
+	//types are the types to declare, in the order they were met
+	types []*ksyType
+}
+
+func writeSeq(w io.Writer, indent string, seq []ksyEntry) {
+	fmt.Fprintf(w, "%sseq:\n", indent)
+	for _, e := range seq {
+		for i, kv := range e {
+			lead := "    "
+			if i == 0 {
+				lead = "  - "
+			}
+			fmt.Fprintf(w, "%s%s%s: %s\n", indent, lead, kv[0], kv[1])
+		}
+	}
+}
+
+//snakeCase turns a Go name into a Kaitai identifier, HTTPServer into http_server
+func snakeCase(name string) string {
+	r := []rune(name)
+	var b strings.Builder
+	for i, c := range r {
+		if unicode.IsUpper(c) && i > 0 && (!unicode.IsUpper(r[i-1]) || (i+1 < len(r) && unicode.IsLower(r[i+1]))) && r[i-1] != '_' {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToLower(c))
+	}
+	return b.String()
+}
+
+//typeName returns the name of the struct s, declaring it on first use. Anonymous
+//structs are named after name
+func (g *kaitai) typeName(s *Schema, name string) string {
+	if n, ok := g.names[s.Type]; ok {
+		return n
+	}
+	if s.Type.Name() != "" {
+		name = s.Type.Name()
+	}
+	n := g.unique(snakeCase(name))
+	g.names[s.Type] = n
+	t := &ksyType{name: n}
+	if len(g.names) > 1 {
+		//the root struct is the seq of the file
+		g.types = append(g.types, t)
+		t.seq, _ = g.fields(nil, s)
+	}
+	return n
+}
+
+//unique returns name, or name with a number when it is taken
+func (g *kaitai) unique(name string) string {
+	n := name
+	for i := 2; g.used[n]; i++ {
+		n = fmt.Sprintf("%s%d", name, i)
+	}
+	g.used[n] = true
+	return n
+}
+
+//fields appends the attributes of the fields of the struct s to seq, bounded is
+//false when a field ended the definition
+func (g *kaitai) fields(seq []ksyEntry, s *Schema) ([]ksyEntry, bool) {
+	for i := range s.Fields {
+		f := &s.Fields[i]
+		id := snakeCase(f.Name)
+		if f.Name == "_" {
+			id = fmt.Sprintf("reserved%d", i)
+		}
+		var ft *fieldTag
+		if f.Tag != "" {
+			//the tag was checked when the plan was built
+			ft, _ = parseTag(f.Tag)
+		}
+		if f.Bits > 0 {
+			bitOrder := "be"
+			if strings.Contains(bitOrderNote(s.Fields, i), "least") {
+				bitOrder = "le"
+			}
+			seq = append(seq, ksyEntry{{"id", id}, {"type", fmt.Sprintf("b%d%s", f.Bits, bitOrder)}})
+			continue
+		}
+		entries, bounded := g.attrs(id, f.Schema, ft, f.Tag, s.Type.Name()+"_"+f.Name)
+		seq = append(seq, entries...)
+		if !bounded {
+			return seq, false
+		}
+	}
+	return seq, true
+}
+
+//bitOrderNote is the bit order of the group holding field i
+func bitOrderNote(fields []SchemaField, i int) string {
+	start := i
+	for start > 0 && fields[start].BitOffset > 0 {
+		start--
+	}
+	end := i + 1
+	for end < len(fields) && fields[end].Bits > 0 && fields[end].BitOffset > 0 {
+		end++
+	}
+	return bitOrder(fields[start:end])
+}
+
+//opaque is a region of size bytes, or the rest of the stream when size is negative
+func opaque(id string, size int, doc string) ksyEntry {
+	if size < 0 {
+		return ksyEntry{{"id", id}, {"size-eos", "true"}, {"doc", strconv.Quote(doc + ", Kaitai can't tell where it ends so it takes the rest of the stream")}}
+	}
+	return ksyEntry{{"id", id}, {"size", strconv.Itoa(size)}, {"doc", strconv.Quote(doc)}}
+}
+
+//prefix returns the attribute of the length prefix of id, a value of kind k
+func (g *kaitai) prefix(id string, k reflect.Kind) (ksyEntry, bool) {
+	w := lengthWidth(g.length, k)
+	switch w {
+	case 1, 2, 4, 8:
+		return ksyEntry{{"id", id + "_len"}, {"type", fmt.Sprintf("u%d", w)}}, true
+	}
+	return opaque(id, -1, "length prefix of variable width"), false
+}
+
+//attrs returns the attributes of the value s named id, written with the field tag
+//ft parsed from tag. bounded is false when Kaitai can't tell where the value ends
+func (g *kaitai) attrs(id string, s *Schema, ft *fieldTag, tag, name string) (entries []ksyEntry, bounded bool) {
+	if ft != nil {
+		if e, ok := g.tagged(id, s, ft, name); ok {
+			return e, true
+		}
+		if ft.enum != nil {
+			return []ksyEntry{{{"id", id}, {"type", fmt.Sprintf("u%d", s.Size)}, {"doc", strconv.Quote("enum " + ft.enum.name)}}}, true
+		}
+		if opaqueTag(ft) {
+			return []ksyEntry{opaque(id, s.Size, "marshal:\""+tag+"\"")}, s.Size >= 0
+		}
+	}
+	if s.Custom || s.Optional {
+		what := "encoded by its codec"
+		if s.Optional {
+			what = "optional value"
+		}
+		return []ksyEntry{opaque(id, s.Size, what)}, s.Size >= 0
+	}
+	if t := g.scalar(s.Kind); t != "" {
+		return []ksyEntry{{{"id", id}, {"type", t}}}, true
+	}
+	switch s.Kind {
+	case reflect.Complex64, reflect.Complex128:
+		t := "f4"
+		if s.Kind == reflect.Complex128 {
+			t = "f8"
+		}
+		return []ksyEntry{{{"id", id}, {"type", t}, {"repeat", "expr"}, {"repeat-expr", "2"}, {"doc", strconv.Quote("real and imaginary parts")}}}, true
+	case reflect.String:
+		p, ok := g.prefix(id, reflect.String)
+		if !ok {
+			return []ksyEntry{p}, false
+		}
+		str := ksyEntry{{"id", id}, {"type", "str"}, {"size", id + "_len"}, {"encoding", kaitaiEncoding(ft)}}
+		return []ksyEntry{p, str}, true
+	case reflect.Struct:
+		return []ksyEntry{{{"id", id}, {"type", g.typeName(s, name)}}}, true
+	case reflect.Ptr:
+		return g.attrs(id, s.Elem, nil, "", name)
+	case reflect.Array:
+		if s.Elem.Kind == reflect.Uint8 && !s.Elem.Custom {
+			return []ksyEntry{{{"id", id}, {"size", strconv.Itoa(s.Len)}}}, true
+		}
+		t, ok := g.elemType(s.Elem, name+"_item")
+		if !ok {
+			return []ksyEntry{opaque(id, s.Size, "elements Kaitai can't bound")}, s.Size >= 0
+		}
+		return []ksyEntry{{{"id", id}, {"type", t}, {"repeat", "expr"}, {"repeat-expr", strconv.Itoa(s.Len)}}}, true
+	case reflect.Slice:
+		p, ok := g.prefix(id, reflect.Slice)
+		if !ok {
+			return []ksyEntry{p}, false
+		}
+		if s.Elem.Kind == reflect.Uint8 && !s.Elem.Custom {
+			return []ksyEntry{p, {{"id", id}, {"size", id + "_len"}}}, true
+		}
+		t, ok := g.elemType(s.Elem, name+"_item")
+		if !ok {
+			return []ksyEntry{p, opaque(id, -1, "elements Kaitai can't bound")}, false
+		}
+		return []ksyEntry{p, {{"id", id}, {"type", t}, {"repeat", "expr"}, {"repeat-expr", id + "_len"}}}, true
+	case reflect.Map:
+		p, ok := g.prefix(id, reflect.Map)
+		if !ok {
+			return []ksyEntry{p}, false
+		}
+		entry := &ksyType{name: g.unique(snakeCase(name + "_entry"))}
+		g.types = append(g.types, entry)
+		key, kb := g.attrs("key", s.Key, nil, "", name+"_key")
+		value, vb := g.attrs("value", s.Elem, nil, "", name+"_value")
+		entry.seq = append(key, value...)
+		if !kb || !vb {
+			return []ksyEntry{p, opaque(id, -1, "entries Kaitai can't bound")}, false
+		}
+		return []ksyEntry{p, {{"id", id}, {"type", entry.name}, {"repeat", "expr"}, {"repeat-expr", id + "_len"}}}, true
+	}
+	return []ksyEntry{opaque(id, -1, s.Kind.String()+" value")}, false
+}
+
+//tagged returns the attributes of the tagged encodings Kaitai can express, ok is
+//false for the others
+func (g *kaitai) tagged(id string, s *Schema, ft *fieldTag, name string) (entries []ksyEntry, ok bool) {
+	switch {
+	case ft.reserved > 0:
+		return []ksyEntry{{{"id", id}, {"size", strconv.Itoa(ft.reserved)}, {"doc", strconv.Quote("reserved, zero")}}}, true
+	case ft.magic != nil:
+		contents := make([]string, len(ft.magic))
+		for i, c := range ft.magic {
+			contents[i] = fmt.Sprintf("0x%02x", c)
+		}
+		return []ksyEntry{{{"id", id}, {"contents", "[" + strings.Join(contents, ", ") + "]"}}}, true
+	case ft.fixed > 0:
+		e := ksyEntry{{"id", id}, {"type", "str"}, {"size", strconv.Itoa(ft.fixed)}, {"encoding", kaitaiEncoding(ft)}}
+		switch ft.trim {
+		case trimNul:
+			e = append(e, [2]string{"pad-right", "0"})
+		case trimSpace:
+			e = append(e, [2]string{"pad-right", fmt.Sprintf("0x%02x", ft.space)})
+		}
+		return []ksyEntry{e}, true
+	case ft.delimited:
+		p, ok := g.prefix(id, reflect.Struct)
+		if !ok {
+			return nil, false
+		}
+		return []ksyEntry{p, {{"id", id}, {"size", id + "_len"}, {"type", g.typeName(s, name)}}}, true
+	case ft.count != "" && s.Kind == reflect.Slice:
+		t, ok := g.elemType(s.Elem, name+"_item")
+		if !ok {
+			return nil, false
+		}
+		n := snakeCase(ft.count)
+		if ft.unit > 1 {
+			n = fmt.Sprintf("%s / %d", n, ft.unit)
+		}
+		if s.Elem.Kind == reflect.Uint8 && !s.Elem.Custom {
+			return []ksyEntry{{{"id", id}, {"size", n}}}, true
+		}
+		return []ksyEntry{{{"id", id}, {"type", t}, {"repeat", "expr"}, {"repeat-expr", n}}}, true
+	}
+	return nil, false
+}
+
+//elemType returns the type of one element of s, declaring a type named name for
+//elements of more than one attribute
+func (g *kaitai) elemType(s *Schema, name string) (string, bool) {
+	entries, bounded := g.attrs("value", s, nil, "", name)
+	if !bounded {
+		return "", false
+	}
+	if len(entries) == 1 && len(entries[0]) == 2 && entries[0][1][0] == "type" {
+		return entries[0][1][1], true
+	}
+	t := &ksyType{name: g.unique(snakeCase(name)), seq: entries}
+	g.types = append(g.types, t)
+	return t.name, true
+}
+
+//scalar is the Kaitai type of numbers and booleans of kind k
+func (g *kaitai) scalar(k reflect.Kind) string {
+	switch k {
+	case reflect.Bool, reflect.Uint8:
+		return "u1"
+	case reflect.Int8:
+		return "s1"
+	case reflect.Uint16:
+		return "u2"
+	case reflect.Int16:
+		return "s2"
+	case reflect.Uint32:
+		return "u4"
+	case reflect.Int32:
+		return "s4"
+	case reflect.Uint64:
+		return "u8"
+	case reflect.Int64:
+		return "s8"
+	case reflect.Float32:
+		return "f4"
+	case reflect.Float64:
+		return "f8"
+	}
+	return ""
+}
+
+//kaitaiEncoding names the encoding of a string written with the field tag ft
+func kaitaiEncoding(ft *fieldTag) string {
+	if ft != nil {
+		switch cs := ft.charset.(type) {
+		case latin1:
+			return "ISO-8859-1"
+		case *singleByte:
+			if cs.name == "ebcdic037" {
+				return "IBM037"
+			}
+			return strings.ToUpper(cs.name)
+		}
+	}
+	return "UTF-8"
+}
+
+//opaqueTag reports whether ft selects an encoding Kaitai has no type for
+func opaqueTag(ft *fieldTag) bool {
+	return ft.bcd > 0 || ft.bcdVar || ft.ascii > 0 || ft.codec != nil || ft.count != "" || ft.offset != "" ||
+		ft.rest || ft.bitmap || ft.delta || ft.rle || ft.packbits > 0 || ft.quantize != reflect.Invalid ||
+		ft.parallel || ft.columnar || ft.delimited || ft.trimZero
+}
@@ -0,0 +1,147 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+type kaitaiMsg struct {
+	Header cHeaderMsg
+	Tags   map[string]uint16
+	Date   uint32 `marshal:"bcd=8"`
+	Text   string `marshal:"fixed=4,charset=ebcdic037,trim=space"`
+	Count  uint8
+	Rest   []byte `marshal:"count=Count"`
+}
+
+func TestKaitai(t *testing.T) {
+	out := new(bytes.Buffer)
+	if err := Kaitai(&kaitaiMsg{}, binary.LittleEndian, BlobLength16, out); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# Generated from marshal.kaitaiMsg by marshal.Kaitai, DO NOT EDIT.
+meta:
+  id: kaitai_msg
+  endian: le
+seq:
+  - id: header
+    type: c_header_msg
+  - id: tags_len
+    type: u2
+  - id: tags
+    type: kaitai_msg_tags_entry
+    repeat: expr
+    repeat-expr: tags_len
+  - id: date
+    size: 4
+    doc: "marshal:\"bcd=8\""
+  - id: text
+    type: str
+    size: 4
+    encoding: IBM037
+    pad-right: 0x40
+  - id: count
+    type: u1
+  - id: rest
+    size: count
+types:
+  c_header_msg:
+    seq:
+      - id: magic
+        size: 4
+      - id: version
+        type: u2
+      - id: reserved2
+        size: 2
+        doc: "reserved, zero"
+      - id: flags
+        type: schema_flags
+      - id: code
+        type: str
+        size: 6
+        encoding: UTF-8
+        pad-right: 0
+      - id: point
+        type: c_inner
+      - id: scale
+        type: f8
+      - id: name_len
+        type: u2
+      - id: name
+        type: str
+        size: name_len
+        encoding: UTF-8
+      - id: items_len
+        type: u2
+      - id: items
+        type: c_inner
+        repeat: expr
+        repeat-expr: items_len
+      - id: tail
+        type: u4
+  schema_flags:
+    seq:
+      - id: a
+        type: b3le
+      - id: b
+        type: b1le
+      - id: c
+        type: b4le
+  c_inner:
+    seq:
+      - id: s
+        type: s2
+      - id: c
+        type: u1
+  kaitai_msg_tags_entry:
+    seq:
+      - id: key_len
+        type: u2
+      - id: key
+        type: str
+        size: key_len
+        encoding: UTF-8
+      - id: value
+        type: u2
+`
+	if out.String() != expected {
+		t.Errorf("Kaitai output:\n%s\nwant:\n%s", out, expected)
+	}
+	if err := Kaitai(uint8(0), binary.LittleEndian, BlobLength16, out); err == nil {
+		t.Errorf("expected an error for a non-struct")
+	}
+	if err := Kaitai(&kaitaiMsg{}, PDPEndian, BlobLength16, out); err == nil {
+		t.Errorf("expected an error for PDPEndian")
+	}
+	if err := Kaitai(&kaitaiMsg{}, binary.LittleEndian, BlobLength16, out, CLayout(1)); err == nil {
+		t.Errorf("expected an error for CLayout")
+	}
+}
+
+type kaitaiVarint struct {
+	ID   uint16
+	Name string
+	Tail uint32
+}
+
+func TestKaitaiVariableLength(t *testing.T) {
+	out := new(bytes.Buffer)
+	if err := Kaitai(&kaitaiVarint{}, binary.BigEndian, VarintLength(4), out); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# Generated from marshal.kaitaiVarint by marshal.Kaitai, DO NOT EDIT.
+meta:
+  id: kaitai_varint
+  endian: be
+seq:
+  - id: id
+    type: u2
+  - id: name
+    size-eos: true
+    doc: "length prefix of variable width, Kaitai can't tell where it ends so it takes the rest of the stream"
+`
+	if out.String() != expected {
+		t.Errorf("Kaitai output:\n%s\nwant:\n%s", out, expected)
+	}
+}
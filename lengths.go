@@ -0,0 +1,401 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+)
+
+type offsetLength struct {
+	inner LengthTypeInstance
+	delta int
+}
+
+//OffsetLength stores lengths as their value plus delta in the inner length type,
+//e.g. OffsetLength(BlobLength8, -1) for formats where a prefix of 0 means one byte
+//and an empty value can't be written. A length whose stored or decoded form would
+//be negative is an error
+func OffsetLength(inner LengthType, delta int) LengthType {
+	return func() LengthTypeInstance {
+		return &offsetLength{inner: inner(), delta: delta}
+	}
+}
+
+func (d *offsetLength) PutLength(w io.Writer, order binary.ByteOrder, k reflect.Kind, v int) {
+	checkLength(v, 64)
+	if v+d.delta < 0 {
+		panic(errorf(ErrLengthOverflow, "offset length: %d%+d can't be stored", v, d.delta))
+	}
+	d.inner.PutLength(w, order, k, v+d.delta)
+}
+
+func (d *offsetLength) Length(r io.Reader, order binary.ByteOrder, k reflect.Kind) int {
+	l := d.inner.Length(r, order, k)
+	if l-d.delta < 0 {
+		panic(errorf(ErrMalformed, "offset length: stored %d%+d is negative", l, -d.delta))
+	}
+	return l - d.delta
+}
+
+type orderedLength struct {
+	inner LengthTypeInstance
+	order binary.ByteOrder
+}
+
+//WithOrder makes the inner length type use order whatever the byte order of the
+//message, for formats that write lengths and payload in different orders
+func WithOrder(inner LengthType, order binary.ByteOrder) LengthType {
+	return func() LengthTypeInstance {
+		return &orderedLength{inner: inner(), order: order}
+	}
+}
+
+func (d *orderedLength) PutLength(w io.Writer, _ binary.ByteOrder, k reflect.Kind, v int) {
+	d.inner.PutLength(w, d.order, k, v)
+}
+
+func (d *orderedLength) Length(r io.Reader, _ binary.ByteOrder, k reflect.Kind) int {
+	return d.inner.Length(r, d.order, k)
+}
+
+type elemLength struct {
+	outer, elems LengthTypeInstance
+}
+
+//ElemLength writes the length of a string, slice or map with outer and those of
+//the elements, keys and values inside it with elems, e.g. ElemLength(BlobLength32,
+//BlobLength8) for maps counted in 32 bits holding strings prefixed with 8. Struct
+//fields aren't inside a prefix and keep outer, a deeper level takes an ElemLength
+//as elems. Like the other combinators, it hides what outer is, e.g. its null length
+func ElemLength(outer, elems LengthType) LengthType {
+	return func() LengthTypeInstance {
+		return &elemLength{outer: outer(), elems: elems()}
+	}
+}
+
+func (d *elemLength) PutLength(w io.Writer, order binary.ByteOrder, k reflect.Kind, v int) {
+	d.outer.PutLength(w, order, k, v)
+}
+
+func (d *elemLength) Length(r io.Reader, order binary.ByteOrder, k reflect.Kind) int {
+	return d.outer.Length(r, order, k)
+}
+
+//elemsOf returns the length type of the values inside a container written with length
+func elemsOf(length LengthTypeInstance) LengthTypeInstance {
+	if d, ok := length.(*elemLength); ok {
+		return d.elems
+	}
+	return length
+}
+
+//restOfRegion is the length SentinelLength reports for its sentinel, the value
+//extends to the end of the enclosing delimited or sizeof region, or of the input
+const restOfRegion = -1
+
+type sentinelLength struct {
+	inner    LengthTypeInstance
+	sentinel int
+}
+
+//SentinelLength reserves the inner length value sentinel to mean that a string or
+//slice of fixed-size elements runs to the end of its enclosing region: a delimited
+//or sizeof field, or else a seekable input. The sentinel is written for fields
+//tagged rest, a real length equal to it is an error
+func SentinelLength(inner LengthType, sentinel int) LengthType {
+	return func() LengthTypeInstance {
+		return &sentinelLength{inner: inner(), sentinel: sentinel}
+	}
+}
+
+func (d *sentinelLength) PutLength(w io.Writer, order binary.ByteOrder, k reflect.Kind, v int) {
+	switch v {
+	case restOfRegion:
+		v = d.sentinel
+	case d.sentinel:
+		panic(errorf(ErrLengthOverflow, "sentinel length: %d is the sentinel, use the rest tag", v))
+	}
+	d.inner.PutLength(w, order, k, v)
+}
+
+func (d *sentinelLength) Length(r io.Reader, order binary.ByteOrder, k reflect.Kind) int {
+	l := d.inner.Length(r, order, k)
+	if l == d.sentinel {
+		return restOfRegion
+	}
+	return l
+}
+
+//rest writes a string or slice field tagged rest with the sentinel of length
+//in place of its length, the field must end its region
+func (m *marshaler) rest(v reflect.Value, length LengthTypeInstance) {
+	if _, ok := length.(*sentinelLength); !ok {
+		panic(fmt.Errorf("marshal: rest field of type %s needs a SentinelLength", v.Type()))
+	}
+	m.putLength(length, v.Type(), restOfRegion)
+	if v.Kind() == reflect.String {
+		if _, err := io.WriteString(m.w, v.String()); err != nil {
+			panic(err)
+		}
+		return
+	}
+	m.elements(v, length)
+}
+
+//restLength turns the rest of the current region into a length for values of type t
+func (u *unmarshaler) restLength(t reflect.Type) int {
+	var n int64
+	if lr, ok := u.r.(*io.LimitedReader); ok {
+		n = lr.N
+	} else if s, ok := u.cr.r.(io.Seeker); ok {
+		cur, err := s.Seek(0, io.SeekCurrent)
+		if err != nil {
+			panic(err)
+		}
+		end, err := s.Seek(0, io.SeekEnd)
+		if err != nil {
+			panic(err)
+		}
+		if _, err := s.Seek(cur, io.SeekStart); err != nil {
+			panic(err)
+		}
+		n = end - cur
+	} else {
+		panic(fmt.Errorf("unmarshal: %s runs to the end of its region, which needs a delimited field or a seekable input", t))
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return int(n)
+	case reflect.Slice:
+		if size := planFor(t.Elem()).size; size > 0 {
+			if n%int64(size) != 0 {
+				panic(errorf(ErrMalformed, "unmarshal: %d bytes to the end of region aren't whole %s elements", n, t.Elem()))
+			}
+			return int(n / int64(size))
+		}
+	}
+	panic(fmt.Errorf("unmarshal: %s can't run to the end of its region, only strings and slices of fixed-size elements", t))
+}
+
+type escapedLength struct {
+	width  int
+	escape uint64
+	wide   LengthTypeInstance
+	b      [4]byte
+}
+
+//EscapedLength writes lengths below the escape value in smallWidth bytes (1, 2 or 4),
+//larger ones as the escape value followed by the wide length type. The escape value
+//is the escape byte repeated over the small width, e.g. 0xff or 0xffff
+func EscapedLength(smallWidth int, escape byte, wide LengthType) LengthType {
+	if smallWidth != 1 && smallWidth != 2 && smallWidth != 4 {
+		panic(fmt.Errorf("marshal: EscapedLength: bad small width %d, want 1, 2 or 4", smallWidth))
+	}
+	var e uint64
+	for i := 0; i < smallWidth; i++ {
+		e = e<<8 | uint64(escape)
+	}
+	return func() LengthTypeInstance {
+		return &escapedLength{width: smallWidth, escape: e, wide: wide()}
+	}
+}
+
+func (d *escapedLength) PutLength(w io.Writer, order binary.ByteOrder, k reflect.Kind, v int) {
+	checkLength(v, 64)
+	small := uint64(v)
+	if small >= d.escape {
+		small = d.escape
+	}
+	bs := d.b[:d.width]
+	switch d.width {
+	case 1:
+		bs[0] = byte(small)
+	case 2:
+		order.PutUint16(bs, uint16(small))
+	default:
+		order.PutUint32(bs, uint32(small))
+	}
+	if _, err := w.Write(bs); err != nil {
+		panic(err)
+	}
+	if small == d.escape {
+		d.wide.PutLength(w, order, k, v)
+	}
+}
+
+func (d *escapedLength) Length(r io.Reader, order binary.ByteOrder, k reflect.Kind) int {
+	bs := d.b[:d.width]
+	if _, err := io.ReadFull(r, bs); err != nil {
+		panic(err)
+	}
+	var small uint64
+	switch d.width {
+	case 1:
+		small = uint64(bs[0])
+	case 2:
+		small = uint64(order.Uint16(bs))
+	default:
+		small = uint64(order.Uint32(bs))
+	}
+	if small == d.escape {
+		return d.wide.Length(r, order, k)
+	}
+	return int(small)
+}
+
+//OffsetVarintLength provides the offset encoding of Git packfiles: big endian groups
+//of 7 bits, the high bit set on all but the last byte, where every continuation also
+//adds 2^7, 2^14 and so on so each length has exactly one encoding
+func OffsetVarintLength() LengthTypeInstance {
+	return &offsetVarintLength{}
+}
+
+type offsetVarintLength struct {
+	b [10]byte
+}
+
+func (d *offsetVarintLength) PutLength(w io.Writer, order binary.ByteOrder, k reflect.Kind, v int) {
+	checkLength(v, 64)
+	n := uint64(v)
+	pos := len(d.b) - 1
+	d.b[pos] = byte(n & 0x7f)
+	for n >>= 7; n != 0; n >>= 7 {
+		n--
+		pos--
+		d.b[pos] = 0x80 | byte(n&0x7f)
+	}
+	if _, err := w.Write(d.b[pos:]); err != nil {
+		panic(err)
+	}
+}
+
+func (d *offsetVarintLength) Length(r io.Reader, order binary.ByteOrder, k reflect.Kind) int {
+	bs := d.b[:1]
+	if _, err := io.ReadFull(r, bs); err != nil {
+		panic(err)
+	}
+	v := uint64(bs[0] & 0x7f)
+	for bs[0]&0x80 != 0 {
+		if v >= (math.MaxInt>>7)-1 {
+			panic(errorf(ErrLengthOverflow, "offset varint: length overflows int"))
+		}
+		if _, err := io.ReadFull(r, bs); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			panic(err)
+		}
+		v = (v+1)<<7 | uint64(bs[0]&0x7f)
+	}
+	return int(v)
+}
+
+//ErrNonMinimalLength is wrapped by the errors StrictCompactLength returns for a
+//length written in more bytes than needed
+var ErrNonMinimalLength error = &kindError{"unmarshal: non-minimal compact length", ErrMalformed}
+
+//ErrCompactOverflow is wrapped by the errors of compact lengths above 0x3fffff,
+//the largest value 22 bits hold
+var ErrCompactOverflow error = &kindError{"marshal: compact length overflow", ErrLengthOverflow}
+
+//StrictCompactLength is CompactLength rejecting encodings other than the one
+//CompactLength writes: a last byte of 0 after a continuation makes the length
+//fit in fewer bytes. A length cut short is io.ErrUnexpectedEOF
+func StrictCompactLength() LengthTypeInstance {
+	return &strictCompactLength{}
+}
+
+type strictCompactLength struct {
+	compactLength
+}
+
+func (d *strictCompactLength) Length(r io.Reader, order binary.ByteOrder, k reflect.Kind) int {
+	bs := d.b[:1]
+	v := 0
+	for i := 0; ; i++ {
+		if _, err := io.ReadFull(r, bs); err != nil {
+			if i > 0 && err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			panic(err)
+		}
+		if i == 2 {
+			//all 8 bits count, the value can't exceed 22 bits
+			if bs[0] == 0 {
+				panic(fmt.Errorf("%w: byte 3 is 0 after a continuation", ErrNonMinimalLength))
+			}
+			return v | int(bs[0])<<14
+		}
+		v |= int(bs[0]&0x7f) << (7 * i)
+		if bs[0]&0x80 == 0 {
+			if i > 0 && bs[0] == 0 {
+				panic(fmt.Errorf("%w: byte %d is 0 after a continuation", ErrNonMinimalLength, i+1))
+			}
+			return v
+		}
+	}
+}
+
+//ErrVarintTooLong is wrapped by the errors of VarintLength lengths still continuing
+//after the last byte allowed
+var ErrVarintTooLong error = &kindError{"unmarshal: varint length too long", ErrMalformed}
+
+//VarintLength writes lengths as unsigned LEB128 varints of at most maxBytes bytes
+//(1 to 9), 7 bits per byte starting with the least significant ones and the high
+//bit set on all but the last byte. Lengths above 2^(7*maxBytes)-1 are an error,
+//and so is a last allowed byte with the high bit set
+func VarintLength(maxBytes int) LengthType {
+	if maxBytes < 1 || maxBytes > 9 {
+		panic(fmt.Errorf("marshal: VarintLength(%d), want 1 to 9 bytes", maxBytes))
+	}
+	return func() LengthTypeInstance {
+		return &varintLength{max: maxBytes}
+	}
+}
+
+//MQTTLength provides the Variable Byte Integer of MQTT, a varint of at most 4
+//bytes holding up to 268,435,455
+func MQTTLength() LengthTypeInstance {
+	return &varintLength{max: 4}
+}
+
+type varintLength struct {
+	max int
+	b   [9]byte
+}
+
+func (d *varintLength) PutLength(w io.Writer, order binary.ByteOrder, k reflect.Kind, v int) {
+	checkLength(v, 64)
+	if uint64(v)>>(7*d.max) != 0 {
+		panic(fmt.Errorf("%w: %d doesn't fit a varint of %d bytes", ErrLengthTooLarge, v, d.max))
+	}
+	n := 0
+	for ; v > 0x7f; v >>= 7 {
+		d.b[n] = 0x80 | byte(v&0x7f)
+		n++
+	}
+	d.b[n] = byte(v)
+	if _, err := w.Write(d.b[:n+1]); err != nil {
+		panic(err)
+	}
+}
+
+func (d *varintLength) Length(r io.Reader, order binary.ByteOrder, k reflect.Kind) int {
+	bs := d.b[:1]
+	v := 0
+	for i := 0; i < d.max; i++ {
+		if _, err := io.ReadFull(r, bs); err != nil {
+			if i > 0 && err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			panic(err)
+		}
+		v |= int(bs[0]&0x7f) << (7 * i)
+		if bs[0]&0x80 == 0 {
+			return v
+		}
+	}
+	panic(fmt.Errorf("%w: byte %d has the continuation bit set", ErrVarintTooLong, d.max))
+}
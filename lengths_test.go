@@ -0,0 +1,492 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOffsetLength(t *testing.T) {
+	length := OffsetLength(BlobLength8, -1)
+	for _, c := range []struct {
+		n      int
+		prefix byte
+	}{{1, 0}, {2, 1}, {256, 0xff}} {
+		s := strings.Repeat("x", c.n)
+		b, err := MarshalBytes(s, binary.BigEndian, length)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", c.n, err)
+		}
+		if b[0] != c.prefix || len(b) != c.n+1 {
+			t.Errorf("%d bytes: prefix %d, %d bytes, want prefix %d", c.n, b[0], len(b), c.prefix)
+		}
+		var readBack string
+		if err := UnmarshalBytes(&readBack, b, binary.BigEndian, length); err != nil || readBack != s {
+			t.Errorf("%d bytes: decoded %d bytes, %v", c.n, len(readBack), err)
+		}
+	}
+	if _, err := MarshalBytes("", binary.BigEndian, length); err == nil {
+		t.Errorf("expected an error for an empty string")
+	}
+	var s string
+	if err := UnmarshalBytes(&s, []byte{0}, binary.BigEndian, OffsetLength(BlobLength8, 1)); err == nil {
+		t.Errorf("expected an error for a stored length below delta")
+	}
+	b, err := MarshalBytes([]uint16{1, 2}, binary.BigEndian, OffsetLength(BlobLength16, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0, 5, 0, 1, 0, 2}; !bytes.Equal(b, expected) {
+		t.Errorf("encoded % x, want % x", b, expected)
+	}
+}
+
+func TestWithOrder(t *testing.T) {
+	v := []uint16{0x0102}
+	for _, c := range []struct {
+		length   LengthType
+		expected []byte
+	}{
+		{BlobLength16, []byte{0, 1, 2, 1}},
+		{BlobLength32, []byte{0, 0, 0, 1, 2, 1}},
+		{BlobLength64, []byte{0, 0, 0, 0, 0, 0, 0, 1, 2, 1}},
+		{BlobLength8, []byte{1, 2, 1}},
+	} {
+		length := WithOrder(c.length, binary.BigEndian)
+		b, err := MarshalBytes(v, binary.LittleEndian, length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, c.expected) {
+			t.Errorf("encoded % x, want % x", b, c.expected)
+		}
+		var readBack []uint16
+		if err := UnmarshalBytes(&readBack, b, binary.LittleEndian, length); err != nil || len(readBack) != 1 || readBack[0] != v[0] {
+			t.Errorf("decoded %v, %v", readBack, err)
+		}
+	}
+	//the order is fixed, the message order no longer reaches the prefix
+	length := WithOrder(BlobLength32, binary.LittleEndian)
+	b, err := MarshalBytes("ab", binary.BigEndian, length)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{2, 0, 0, 0, 'a', 'b'}; !bytes.Equal(b, expected) {
+		t.Errorf("encoded % x, want % x", b, expected)
+	}
+}
+
+type sentinelRecord struct {
+	Kind uint8
+	Body struct {
+		Tag  uint8
+		Data []byte `marshal:"rest"`
+	} `marshal:"delimited"`
+	Next uint8
+}
+
+func TestSentinelLength(t *testing.T) {
+	length := SentinelLength(BlobLength16, 0xffff)
+	var v sentinelRecord
+	v.Kind, v.Body.Tag, v.Body.Data, v.Next = 1, 2, []byte("abc"), 3
+	b, err := MarshalBytes(&v, binary.BigEndian, length)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{1, 0, 6, 2, 0xff, 0xff, 'a', 'b', 'c', 3}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("encoded % x, want % x", b, expected)
+	}
+	var readBack sentinelRecord
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, length); err != nil {
+		t.Fatal(err)
+	}
+	if readBack.Kind != 1 || readBack.Body.Tag != 2 || string(readBack.Body.Data) != "abc" || readBack.Next != 3 {
+		t.Errorf("decoded %+v", readBack)
+	}
+	//untagged values still carry their real length, but never the sentinel itself
+	b, err = MarshalBytes([]uint16{7, 8}, binary.BigEndian, length)
+	if err != nil || !bytes.Equal(b, []byte{0, 2, 0, 7, 0, 8}) {
+		t.Errorf("plain slice: % x, %v", b, err)
+	}
+	if _, err := MarshalBytes(make([]byte, 0xffff), binary.BigEndian, length); err == nil {
+		t.Errorf("expected an error for a length equal to the sentinel")
+	}
+}
+
+func TestSentinelLengthInput(t *testing.T) {
+	length := SentinelLength(BlobLength8, 0xff)
+	//at top level the region is the rest of a seekable input
+	var s []uint16
+	if err := UnmarshalBytes(&s, []byte{0xff, 0, 1, 0, 2}, binary.BigEndian, length); err != nil || len(s) != 2 || s[1] != 2 {
+		t.Errorf("decoded %v, %v", s, err)
+	}
+	if err := UnmarshalBytes(&s, []byte{0xff, 0, 1, 0}, binary.BigEndian, length); err == nil {
+		t.Errorf("expected an error for a partial element")
+	}
+	if err := Unmarshal(&s, onlyReader{bytes.NewReader([]byte{0xff, 0, 1})}, binary.BigEndian, length); err == nil {
+		t.Errorf("expected an error without a region or a seekable input")
+	}
+	type plain struct {
+		Data []byte `marshal:"rest"`
+	}
+	if _, err := MarshalBytes(&plain{[]byte("x")}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for rest without a SentinelLength")
+	}
+	type notSlice struct {
+		N uint32 `marshal:"rest"`
+	}
+	if _, err := MarshalBytes(&notSlice{}, binary.BigEndian, length); err == nil {
+		t.Errorf("expected an error for rest on an integer")
+	}
+}
+
+func TestEscapedLength(t *testing.T) {
+	length := EscapedLength(1, 0xff, BlobLength32)
+	for _, c := range []struct {
+		n      int
+		prefix []byte
+	}{
+		{0, []byte{0}},
+		{0xfe, []byte{0xfe}},
+		{0xff, []byte{0xff, 0, 0, 0, 0xff}},
+		{0x100, []byte{0xff, 0, 0, 1, 0}},
+	} {
+		s := strings.Repeat("x", c.n)
+		b, err := MarshalBytes(s, binary.BigEndian, length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b[:len(b)-c.n], c.prefix) {
+			t.Errorf("%d bytes: prefix % x, want % x", c.n, b[:len(b)-c.n], c.prefix)
+		}
+		var readBack string
+		if err := UnmarshalBytes(&readBack, b, binary.BigEndian, length); err != nil || readBack != s {
+			t.Errorf("%d bytes: decoded %d bytes, %v", c.n, len(readBack), err)
+		}
+	}
+	//a wide small form repeats the escape byte
+	b, err := MarshalBytes(make([]byte, 0xffff), binary.LittleEndian, EscapedLength(2, 0xff, BlobLength32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0xff, 0xff, 0xff, 0xff, 0, 0}; !bytes.Equal(b[:6], expected) {
+		t.Errorf("prefix % x, want % x", b[:6], expected)
+	}
+	b, err = MarshalBytes(make([]byte, 0xfffe), binary.LittleEndian, EscapedLength(2, 0xff, BlobLength32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0xfe, 0xff}; !bytes.Equal(b[:2], expected) || len(b) != 2+0xfffe {
+		t.Errorf("prefix % x, want % x", b[:2], expected)
+	}
+}
+
+func TestOffsetVarintLength(t *testing.T) {
+	//values at each byte count boundary, from the packfile format documentation
+	for _, c := range []struct {
+		v        int
+		expected []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x80, 0x00}},
+		{16511, []byte{0xff, 0x7f}},
+		{16512, []byte{0x80, 0x80, 0x00}},
+		{2113663, []byte{0xff, 0xff, 0x7f}},
+		{2113664, []byte{0x80, 0x80, 0x80, 0x00}},
+	} {
+		l := OffsetVarintLength()
+		var buf bytes.Buffer
+		l.PutLength(&buf, binary.BigEndian, reflect.String, c.v)
+		if !bytes.Equal(buf.Bytes(), c.expected) {
+			t.Errorf("%d encoded % x, want % x", c.v, buf.Bytes(), c.expected)
+		}
+		if got := l.Length(&buf, binary.BigEndian, reflect.String); got != c.v {
+			t.Errorf("% x decoded %d, want %d", c.expected, got, c.v)
+		}
+	}
+	var s string
+	overflow := append(bytes.Repeat([]byte{0xff}, 10), 0x7f)
+	if err := UnmarshalBytes(&s, overflow, binary.BigEndian, OffsetVarintLength); err == nil {
+		t.Errorf("expected an overflow error")
+	}
+	if err := UnmarshalBytes(&s, []byte{0x80}, binary.BigEndian, OffsetVarintLength); err == nil {
+		t.Errorf("expected an error for a missing continuation")
+	}
+}
+
+func TestMQTTLength(t *testing.T) {
+	//the boundaries of the MQTT specification's Variable Byte Integer table
+	for _, c := range []struct {
+		v        int
+		expected []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x80, 0x01}},
+		{16383, []byte{0xff, 0x7f}},
+		{16384, []byte{0x80, 0x80, 0x01}},
+		{2097151, []byte{0xff, 0xff, 0x7f}},
+		{2097152, []byte{0x80, 0x80, 0x80, 0x01}},
+		{268435455, []byte{0xff, 0xff, 0xff, 0x7f}},
+	} {
+		l := MQTTLength()
+		var buf bytes.Buffer
+		l.PutLength(&buf, binary.BigEndian, reflect.Slice, c.v)
+		if !bytes.Equal(buf.Bytes(), c.expected) {
+			t.Errorf("%d encoded % x, want % x", c.v, buf.Bytes(), c.expected)
+		}
+		if got := l.Length(&buf, binary.BigEndian, reflect.Slice); got != c.v {
+			t.Errorf("% x decoded %d, want %d", c.expected, got, c.v)
+		}
+	}
+	var v []byte
+	for _, c := range []struct {
+		in  []byte
+		err error
+	}{
+		{[]byte{0xff, 0xff, 0xff, 0xff, 0x7f}, ErrVarintTooLong},
+		{[]byte{0x80, 0x80, 0x80, 0x80, 0x00}, ErrVarintTooLong},
+		{[]byte{0x80}, io.ErrUnexpectedEOF},
+		{[]byte{}, io.EOF},
+	} {
+		if err := UnmarshalBytes(&v, c.in, binary.BigEndian, MQTTLength); !errors.Is(err, c.err) {
+			t.Errorf("% x: got %v, want %v", c.in, err, c.err)
+		}
+	}
+	//a generic varint of 2 bytes stops at 16383
+	if err := UnmarshalBytes(&v, []byte{0x80, 0x80, 0x01}, binary.BigEndian, VarintLength(2)); !errors.Is(err, ErrVarintTooLong) {
+		t.Errorf("got %v, want ErrVarintTooLong", err)
+	}
+	b, err := MarshalBytes([]byte("hello"), binary.BigEndian, MQTTLength)
+	if err != nil || !bytes.Equal(b, []byte("\x05hello")) {
+		t.Errorf("encoded % x, %v", b, err)
+	}
+	if n, bounded, _ := MaxSize(reflect.TypeOf(""), MQTTLength); !bounded || n != 4+268435455 {
+		t.Errorf("MaxSize %d, %v", n, bounded)
+	}
+}
+
+func TestStrictCompactLength(t *testing.T) {
+	//every value round trips through both, and only its own encoding is accepted
+	strict, lenient := StrictCompactLength(), CompactLength()
+	var buf bytes.Buffer
+	r := bytes.NewReader(nil)
+	for v := 0; v <= 0x3fffff; v++ {
+		buf.Reset()
+		strict.PutLength(&buf, binary.BigEndian, reflect.Slice, v)
+		want := 1
+		if v > 0x3fff {
+			want = 3
+		} else if v > 0x7f {
+			want = 2
+		}
+		if buf.Len() != want {
+			t.Fatalf("%d encoded in %d bytes, want %d", v, buf.Len(), want)
+		}
+		r.Reset(buf.Bytes())
+		if l := strict.Length(r, binary.BigEndian, reflect.Slice); l != v || r.Len() != 0 {
+			t.Fatalf("%d decoded as %d", v, l)
+		}
+		r.Reset(buf.Bytes())
+		if l := lenient.Length(r, binary.BigEndian, reflect.Slice); l != v {
+			t.Fatalf("%d decoded leniently as %d", v, l)
+		}
+	}
+	if _, err := MarshalBytes(make([]byte, 0x400000), binary.BigEndian, StrictCompactLength); !errors.Is(err, ErrCompactOverflow) {
+		t.Errorf("expected ErrCompactOverflow, got %v", err)
+	}
+	for _, c := range []struct {
+		in      []byte
+		lenient int
+		err     error
+	}{
+		{[]byte{0x80, 0x00}, 0, ErrNonMinimalLength},
+		{[]byte{0xff, 0x00}, 0x7f, ErrNonMinimalLength},
+		{[]byte{0x80, 0x80, 0x00}, 0, ErrNonMinimalLength},
+		{[]byte{0xff, 0xff, 0x00}, 0x3fff, ErrNonMinimalLength},
+		{[]byte{0x80}, -1, io.ErrUnexpectedEOF},
+		{[]byte{0xff, 0xff}, -1, io.ErrUnexpectedEOF},
+		{[]byte{}, -1, io.EOF},
+	} {
+		var v []byte
+		err := UnmarshalBytes(&v, c.in, binary.BigEndian, StrictCompactLength)
+		if !errors.Is(err, c.err) {
+			t.Errorf("% x: got %v, want %v", c.in, err, c.err)
+		}
+		if c.lenient < 0 {
+			continue
+		}
+		r.Reset(c.in)
+		if l := lenient.Length(r, binary.BigEndian, reflect.Slice); l != c.lenient {
+			t.Errorf("% x: lenient decoding gave %d, want %d", c.in, l, c.lenient)
+		}
+	}
+}
+
+func TestNegativeLength(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		length LengthType
+	}{
+		{"BlobLength8", BlobLength8}, {"BlobLength16", BlobLength16},
+		{"BlobLength32", BlobLength32}, {"BlobLength64", BlobLength64},
+		{"Bound32", Bound32(10)}, {"Bound64", Bound64(10)},
+		{"CompactLength", CompactLength}, {"StrictCompactLength", StrictCompactLength},
+		{"YYBlobType", YYBlobType}, {"RFBLength", RFBLength},
+		{"OffsetLength", OffsetLength(BlobLength8, 2)},
+		{"EscapedLength", EscapedLength(1, 0xff, BlobLength32)},
+		{"OffsetVarintLength", OffsetVarintLength},
+		{"MQTTLength", MQTTLength}, {"VarintLength", VarintLength(2)},
+		{"SentinelLength", SentinelLength(BlobLength16, 0xffff)},
+		{"NullableLength", NullableLength(BlobLength32)},
+	} {
+		for _, k := range []reflect.Kind{reflect.String, reflect.Slice} {
+			var buf bytes.Buffer
+			err := NewWriter(&buf, binary.BigEndian, c.length()).PutLength(k, -5)
+			if !errors.Is(err, ErrNegativeLength) {
+				t.Errorf("%s, %s: got %v, want ErrNegativeLength", c.name, k, err)
+			}
+			if buf.Len() != 0 {
+				t.Errorf("%s, %s: wrote % x", c.name, k, buf.Bytes())
+			}
+		}
+	}
+}
+
+func TestLengthTooLarge(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		length LengthType
+		max    int
+	}{
+		{"BlobLength8", BlobLength8, 0xff},
+		{"BlobLength16", BlobLength16, 0xffff},
+		{"BlobLength32", BlobLength32, 0xffffffff},
+		{"MQTTLength", MQTTLength, 268435455},
+		{"VarintLength", VarintLength(1), 127},
+	} {
+		for _, k := range []reflect.Kind{reflect.String, reflect.Slice} {
+			w := NewWriter(io.Discard, binary.BigEndian, c.length())
+			if err := w.PutLength(k, c.max); err != nil {
+				t.Errorf("%s: %d: %v", c.name, c.max, err)
+			}
+			if err := w.PutLength(k, c.max+1); !errors.Is(err, ErrLengthTooLarge) {
+				t.Errorf("%s: %d: got %v, want ErrLengthTooLarge", c.name, c.max+1, err)
+			}
+		}
+	}
+	w := NewWriter(io.Discard, binary.BigEndian, YYBlobType())
+	if err := w.PutLength(reflect.String, 0x10000); !errors.Is(err, ErrLengthTooLarge) {
+		t.Errorf("YYBlobType string: got %v, want ErrLengthTooLarge", err)
+	}
+	if err := w.PutLength(reflect.Slice, 0x10000); err != nil {
+		t.Errorf("YYBlobType slice: %v", err)
+	}
+	//the error names the field
+	v := struct {
+		Name string
+		Tags []string
+	}{"x", []string{strings.Repeat("y", 300)}}
+	_, err := MarshalBytes(&v, binary.BigEndian, BlobLength8)
+	if !errors.Is(err, ErrLengthTooLarge) || !strings.Contains(err.Error(), ".Tags[0]: ") || !strings.Contains(err.Error(), "300 doesn't fit 8 bits") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestElemLength(t *testing.T) {
+	for _, c := range []struct {
+		v        interface{}
+		length   LengthType
+		expected []byte
+	}{
+		{map[string]string{"k": "vv"}, ElemLength(BlobLength32, BlobLength8), []byte{0, 0, 0, 1, 1, 'k', 2, 'v', 'v'}},
+		{map[string][]string{"a": {"x"}}, ElemLength(BlobLength32, ElemLength(BlobLength16, BlobLength8)), []byte{0, 0, 0, 1, 0, 1, 'a', 0, 1, 1, 'x'}},
+		{[][]uint8{{7}}, ElemLength(BlobLength16, BlobLength8), []byte{0, 1, 1, 7}},
+		{[2][]string{{"x"}}, ElemLength(BlobLength16, BlobLength8), []byte{1, 1, 'x', 0}},
+		//struct fields aren't inside a prefix, they keep the outer length type
+		{struct {
+			Name string
+			M    map[string]string
+		}{"n", map[string]string{"k": ""}}, ElemLength(BlobLength16, BlobLength8), []byte{0, 1, 'n', 0, 1, 1, 'k', 0}},
+	} {
+		b, err := MarshalBytes(c.v, binary.BigEndian, c.length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, c.expected) {
+			t.Errorf("%v: encoded % x, want % x", c.v, b, c.expected)
+		}
+		if err := RoundTrip(c.v, binary.BigEndian, c.length); err != nil {
+			t.Error(err)
+		}
+		n, err := Skip(bytes.NewReader(b), c.v, binary.BigEndian, c.length)
+		if err != nil || n != int64(len(b)) {
+			t.Errorf("%v: skipped %d of %d bytes, %v", c.v, n, len(b), err)
+		}
+	}
+	//the elements are bound by their own length type
+	_, err := MarshalBytes([]string{strings.Repeat("y", 256)}, binary.BigEndian, ElemLength(BlobLength32, BlobLength8))
+	if !errors.Is(err, ErrLengthTooLarge) || !strings.Contains(err.Error(), "[0]") {
+		t.Errorf("got %v, want ErrLengthTooLarge", err)
+	}
+	n, _, err := MaxSize(reflect.TypeOf([]string{}), ElemLength(Bound32(3), BlobLength8))
+	if err != nil || n != 4+3*(1+255) {
+		t.Errorf("MaxSize %d, %v", n, err)
+	}
+}
+
+func TestLengthTags(t *testing.T) {
+	type msg struct {
+		Attrs  map[string]string `marshal:"len=32,elemlen=8"`
+		Names  []string          `marshal:"elemlen=8"`
+		Sorted map[string]uint8  `marshal:"parallel,elemlen=8"`
+		Short  string            `marshal:"len=8"`
+		Tail   string
+	}
+	v := msg{map[string]string{"k": "v"}, []string{"ab"}, map[string]uint8{"x": 1}, "s", "t"}
+	b, err := MarshalBytes(v, binary.BigEndian, BlobLength16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{
+		0, 0, 0, 1, 1, 'k', 1, 'v',
+		0, 1, 2, 'a', 'b',
+		0, 1, 1, 'x', 1,
+		1, 's',
+		0, 1, 't',
+	}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("encoded % x, want % x", b, expected)
+	}
+	if err := RoundTrip(v, binary.BigEndian, BlobLength16); err != nil {
+		t.Error(err)
+	}
+	if n, err := Skip(bytes.NewReader(b), msg{}, binary.BigEndian, BlobLength16); err != nil || n != int64(len(b)) {
+		t.Errorf("skipped %d of %d bytes, %v", n, len(b), err)
+	}
+	type sized struct {
+		A [2]string `marshal:"elemlen=8"`
+		S string    `marshal:"len=8"`
+	}
+	if n, _, err := MaxSize(reflect.TypeOf(sized{}), BlobLength32); err != nil || n != 2*(1+255)+1+255 {
+		t.Errorf("MaxSize %d, %v", n, err)
+	}
+	for _, v := range []interface{}{
+		struct {
+			S string `marshal:"elemlen=8"`
+		}{},
+		struct {
+			S string `marshal:"len=12"`
+		}{},
+	} {
+		if _, err := MarshalBytes(v, binary.BigEndian, BlobLength16); err == nil {
+			t.Errorf("%T: expected an error", v)
+		}
+	}
+}
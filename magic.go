@@ -0,0 +1,199 @@
+package marshal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+//magic writes the bytes of a magic= placeholder of type t
+func (m *marshaler) magic(t reflect.Type, b []byte) {
+	start := m.cw.n
+	if _, err := m.w.Write(b); err != nil {
+		panic(err)
+	}
+	if m.trace != nil {
+		e := m.event(t, start, false)
+		e.Magic = true
+		m.trace(e)
+	}
+}
+
+//magic reads the bytes of a magic= placeholder of type t, they must be b. It
+//reports io.EOF when there is no input left for a value that starts with it
+func (u *unmarshaler) magic(t reflect.Type, b []byte) {
+	start := u.cr.n
+	bp := getScratch(len(b))
+	defer scratchPool.Put(bp)
+	if _, err := io.ReadFull(u.r, *bp); err != nil {
+		//a stream that ends before the first byte of a value ends cleanly
+		if err == io.EOF && start > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		panic(err)
+	}
+	if !bytes.Equal(*bp, b) {
+		panic(errorf(ErrMalformed, "unmarshal: %s: magic % x at offset %d, want % x", formatPath(u.path), *bp, start, b))
+	}
+	if u.trace != nil {
+		e := u.event(t, start, false)
+		e.Magic = true
+		u.trace(e)
+	}
+}
+
+//leadingMagic returns the bytes of the magic= placeholder values of type t start
+//with, nil when they start with something else
+func leadingMagic(t reflect.Type) []byte {
+	for t != nil {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return nil
+		}
+		p := planFor(t)
+		if p.err != nil || len(p.fields) == 0 {
+			return nil
+		}
+		f := &p.fields[0]
+		if f.tag != nil {
+			return f.tag.magic
+		}
+		t = t.Field(f.index).Type
+	}
+	return nil
+}
+
+//Resync skips to the next occurrence of the magic the type last decoded by d
+//starts with, a magic= placeholder as its first field, so that decoding can go on
+//after a corrupt value:
+//
+//	for {
+//		err := d.Decode(&rec)
+//		if err == io.EOF {
+//			break
+//		} else if err != nil {
+//			skipped, err := d.Resync()
+//			...
+//		}
+//	}
+//
+//After a failed Decode the search starts at the byte after the first one of the
+//failed value, so a corrupt length that made it read into the values after it
+//loses none of them. It reports the number of bytes skipped from there, and
+//io.EOF with them when the stream ends before another magic. Magic bytes in the
+//middle of a value are found too and make the next Decode fail, which another
+//Resync gets past
+func (d *Decoder) Resync() (skipped int64, err error) {
+	magic := leadingMagic(d.last)
+	if magic == nil {
+		return 0, fmt.Errorf("marshal: Resync: %v doesn't start with a magic= field", d.last)
+	}
+	if f := d.failed; len(f) > 1 {
+		if d.mem != nil {
+			d.mem.off -= len(f) - 1
+		} else {
+			if d.replay == nil {
+				d.replay = d.reuse()
+			}
+			p := d.replay
+			p.buf, p.pos = append(f[1:], p.buf[p.pos:]...), 0
+		}
+	}
+	d.failed = nil
+	for {
+		b, err := d.Peek(max(len(magic), d.buffered()))
+		if i := bytes.Index(b, magic); i >= 0 {
+			d.discard(i)
+			return skipped + int64(i), nil
+		}
+		//a magic may start in the last bytes
+		n := len(b) - len(magic) + 1
+		if err != nil {
+			n = len(b)
+		}
+		if n > 0 {
+			d.discard(n)
+			skipped += int64(n)
+		}
+		if err != nil {
+			return skipped, err
+		}
+	}
+}
+
+//mark starts keeping the bytes of the value Decode is about to read when its
+//type starts with a magic, so that Resync can search them again. It returns the
+//offset the value starts at in the input of a Decoder made by NewBytesDecoder
+func (d *Decoder) mark() int {
+	d.failed = nil
+	//without ResumeOnTimeout a replay only holds bytes Resync gave back
+	if p := d.replay; p != nil && !d.o.resume && len(p.buf) == 0 {
+		d.replay, d.spare = nil, p
+	}
+	if leadingMagic(d.last) == nil {
+		return -1
+	}
+	if d.mem != nil {
+		return d.mem.off
+	}
+	if d.replay == nil {
+		d.replay = d.reuse()
+	}
+	return 0
+}
+
+//fail keeps the bytes of the value marked at start when decoding it failed
+//with err, other than at the end of the stream or on a timeout it resumes after
+func (d *Decoder) fail(start int, err error) {
+	if start < 0 || err == nil || err == io.EOF || (isTimeout(err) && d.o.resume) {
+		return
+	}
+	if d.mem != nil {
+		d.failed = d.mem.b[start:d.mem.off]
+	} else {
+		p := d.replay
+		d.failed = append([]byte(nil), p.buf[:p.pos]...)
+	}
+}
+
+//reuse returns an empty replay of the input of d
+func (d *Decoder) reuse() *replay {
+	p := d.spare
+	d.spare = nil
+	if p == nil {
+		p = &replay{}
+	}
+	p.r, p.buf, p.pos = d.r, p.buf[:0], 0
+	return p
+}
+
+//buffered is the number of bytes d can peek without reading
+func (d *Decoder) buffered() int {
+	if d.mem != nil {
+		return len(d.mem.b) - d.mem.off
+	}
+	n := d.r.Buffered()
+	if d.replay != nil {
+		n += len(d.replay.buf) - d.replay.pos
+	}
+	return n
+}
+
+//discard drops the next n bytes of input, which d has peeked
+func (d *Decoder) discard(n int) {
+	if d.mem != nil {
+		d.mem.off += n
+		return
+	}
+	if p := d.replay; p != nil {
+		k := min(n, len(p.buf)-p.pos)
+		p.pos += k
+		n -= k
+		//what is left isn't part of a value
+		d.settle(nil)
+	}
+	d.r.Discard(n)
+}
@@ -0,0 +1,185 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type magicRec struct {
+	_ struct{} `marshal:"magic=cafe"`
+	N uint16
+	S string
+}
+
+//magicOuter starts with a magicRec, so with its magic
+type magicOuter struct {
+	Rec  magicRec
+	Flag bool
+}
+
+func TestMagic(t *testing.T) {
+	b, err := MarshalBytes(magicRec{N: 1, S: "a"}, binary.BigEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0xca, 0xfe, 0, 1, 1, 'a'}; !bytes.Equal(b, expected) {
+		t.Errorf("encoded % x, want % x", b, expected)
+	}
+	var rec magicRec
+	if err := UnmarshalBytes(&rec, b, binary.BigEndian, BlobLength8); err != nil || rec.N != 1 || rec.S != "a" {
+		t.Errorf("decoded %+v, %v", rec, err)
+	}
+	b[1] = 0xff
+	if err := UnmarshalBytes(&rec, b, binary.BigEndian, BlobLength8); !errors.Is(err, ErrMalformed) || !strings.Contains(err.Error(), "magic ca ff at offset 0") {
+		t.Errorf("got %v, want ErrMalformed", err)
+	}
+	if _, err := Skip(bytes.NewReader(b), magicRec{}, binary.BigEndian, BlobLength8); !errors.Is(err, ErrMalformed) {
+		t.Errorf("Skip: got %v, want ErrMalformed", err)
+	}
+	if !bytes.Equal(leadingMagic(reflect.TypeOf(&magicOuter{})), []byte{0xca, 0xfe}) {
+		t.Errorf("no magic leading magicOuter")
+	}
+	for _, v := range []interface{}{
+		struct {
+			M uint8 `marshal:"magic=01"`
+		}{},
+		struct {
+			M struct{} `marshal:"magic=xyz"`
+		}{},
+	} {
+		if _, err := MarshalBytes(v, binary.BigEndian, BlobLength8); err == nil {
+			t.Errorf("%T: expected an error", v)
+		}
+	}
+}
+
+func TestResync(t *testing.T) {
+	var stream []byte
+	put := func(n uint16, s string) {
+		b, err := MarshalBytes(magicRec{N: n, S: s}, binary.BigEndian, BlobLength8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream = append(stream, b...)
+	}
+	put(1, "a")
+	stream = append(stream, 1, 2, 3)
+	put(2, "b")
+	//a record with a damaged magic
+	stream = append(stream, 0xca, 0xff, 0, 3, 1, 'c')
+	put(4, "d")
+	stream = append(stream, 0, 0xca)
+	for name, d := range map[string]*Decoder{
+		"reader": NewDecoder(bytes.NewReader(stream), binary.BigEndian, BlobLength8),
+		"bytes":  NewBytesDecoder(stream, binary.BigEndian, BlobLength8),
+		"resume": NewDecoder(bytes.NewReader(stream), binary.BigEndian, BlobLength8, ResumeOnTimeout()),
+	} {
+		var got []uint16
+		var skips []int64
+		for {
+			var rec magicRec
+			err := d.Decode(&rec)
+			if err == nil {
+				got = append(got, rec.N)
+				continue
+			}
+			if err == io.EOF {
+				break
+			}
+			skipped, err := d.Resync()
+			skips = append(skips, skipped)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+		//the skips count from the byte after the start of the failed value
+		if !reflect.DeepEqual(got, []uint16{1, 2, 4}) || !reflect.DeepEqual(skips, []int64{2, 5, 1}) {
+			t.Errorf("%s: decoded %v, skipped %v", name, got, skips)
+		}
+	}
+	//a stream of whole records ends with io.EOF
+	clean := stream[:len(stream)-2]
+	for name, d := range map[string]*Decoder{
+		"reader": NewDecoder(bytes.NewReader(clean), binary.BigEndian, BlobLength8),
+		"bytes":  NewBytesDecoder(clean, binary.BigEndian, BlobLength8),
+	} {
+		var err error
+		for i := 0; i < 10 && err != io.EOF; i++ {
+			var rec magicRec
+			if err = d.Decode(&rec); err != nil && err != io.EOF {
+				d.Resync()
+			}
+		}
+		if err != io.EOF {
+			t.Errorf("%s: got %v at the end of the stream, want io.EOF", name, err)
+		}
+	}
+	//a magic cut short is unexpected
+	if err := UnmarshalBytes(new(magicRec), stream[:1], binary.BigEndian, BlobLength8); err != io.ErrUnexpectedEOF {
+		t.Errorf("got %v for a cut magic, want io.ErrUnexpectedEOF", err)
+	}
+	//the magic is found across reads
+	r := io.MultiReader(bytes.NewReader([]byte{9, 9, 0xca}), bytes.NewReader(stream[1:]))
+	d := NewDecoder(r, binary.BigEndian, BlobLength8)
+	d.last = reflect.TypeOf(magicRec{})
+	if skipped, err := d.Resync(); err != nil || skipped != 2 {
+		t.Errorf("skipped %d, %v", skipped, err)
+	}
+	var rec magicRec
+	if err := d.Decode(&rec); err != nil || rec.N != 1 {
+		t.Errorf("decoded %+v, %v", rec, err)
+	}
+	//without a magic there is nothing to look for
+	d = NewBytesDecoder([]byte{1}, binary.BigEndian, BlobLength8)
+	var n uint16
+	d.Decode(&n)
+	if _, err := d.Resync(); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestResyncCorruptLength(t *testing.T) {
+	var stream []byte
+	var want []uint16
+	for i := uint16(0); i < 10; i++ {
+		b, err := MarshalBytes(magicRec{N: i, S: "abc"}, binary.BigEndian, BlobLength8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 4 {
+			//the length of S runs past the end of the stream
+			b[4] = 0xff
+		} else {
+			want = append(want, i)
+		}
+		stream = append(stream, b...)
+	}
+	for name, d := range map[string]*Decoder{
+		"reader": NewDecoder(bytes.NewReader(stream), binary.BigEndian, BlobLength8),
+		"bytes":  NewBytesDecoder(stream, binary.BigEndian, BlobLength8),
+		"resume": NewDecoder(bytes.NewReader(stream), binary.BigEndian, BlobLength8, ResumeOnTimeout()),
+	} {
+		var got []uint16
+		for i := 0; i < 20; i++ {
+			var rec magicRec
+			err := d.Decode(&rec)
+			if err == io.EOF {
+				break
+			} else if err == nil {
+				got = append(got, rec.N)
+			} else if _, err := d.Resync(); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: decoded %v, want %v", name, got, want)
+		}
+	}
+}
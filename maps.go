@@ -0,0 +1,133 @@
+package marshal
+
+import (
+	"cmp"
+	"encoding/binary"
+	"reflect"
+	"slices"
+)
+
+var (
+	stringType = reflect.TypeOf("")
+	bytesType  = reflect.TypeOf([]byte(nil))
+)
+
+//fastMap writes the maps of the types most schemas use with Go code instead of
+//reflection, in the same bytes. It reports whether v was one of them
+func (m *marshaler) fastMap(v reflect.Value, length LengthTypeInstance) bool {
+	if m.trace != nil || !v.CanInterface() || (m.deterministic && hasKeyLess(v.Type())) {
+		return false
+	}
+	elems := elemsOf(length)
+	str := func(s string) { m.payload(elems, stringType, stringBytes(s)) }
+	switch mv := v.Interface().(type) {
+	case map[string]string:
+		putMap(m, mv, v.Type(), length, str, str)
+	case map[string]uint32:
+		putMap(m, mv, v.Type(), length, str, m.uint32)
+	case map[uint32][]byte:
+		putMap(m, mv, v.Type(), length, m.uint32, func(b []byte) { m.payload(elems, bytesType, b) })
+	default:
+		return false
+	}
+	return true
+}
+
+//putMap writes the map mv of type t, its keys with key and its values with value
+func putMap[K cmp.Ordered, V any](m *marshaler, mv map[K]V, t reflect.Type, length LengthTypeInstance, key func(K), value func(V)) {
+	m.putLength(length, t, len(mv))
+	//the path refers to k, which holds the key being written
+	var k K
+	kv := reflect.ValueOf(&k).Elem()
+	entry := func(v V) {
+		m.push(keyElem(kv, true))
+		key(k)
+		m.pop()
+		m.push(keyElem(kv, false))
+		value(v)
+		m.pop()
+	}
+	if !m.deterministic {
+		for e, v := range mv {
+			k = e
+			entry(v)
+		}
+		return
+	}
+	keys := make([]K, 0, len(mv))
+	for k := range mv {
+		keys = append(keys, k)
+	}
+	//compareKeys orders strings bytewise and numbers numerically, as cmp does
+	slices.Sort(keys)
+	for _, k = range keys {
+		entry(mv[k])
+	}
+}
+
+//hasKeyLess reports whether the keys of maps of type t are ordered by a function
+//registered with RegisterKeyLess
+func hasKeyLess(t reflect.Type) bool {
+	keyLessLock.RLock()
+	defer keyLessLock.RUnlock()
+	return keyLess[t] != nil
+}
+
+//fastMap reads the maps fastMap of the marshaler writes
+func (u *unmarshaler) fastMap(v reflect.Value, order binary.ByteOrder, length LengthTypeInstance) bool {
+	if u.trace != nil || !v.CanAddr() || !v.CanInterface() {
+		return false
+	}
+	elems := elemsOf(length)
+	str := func() string {
+		s, _ := u.stringValue(elems, order, stringType)
+		return s
+	}
+	num := func() uint32 { return order.Uint32(u.fetch(4)) }
+	switch p := v.Addr().Interface().(type) {
+	case *map[string]string:
+		getMap(u, p, v.Type(), order, length, str, str)
+	case *map[string]uint32:
+		getMap(u, p, v.Type(), order, length, str, num)
+	case *map[uint32][]byte:
+		getMap(u, p, v.Type(), order, length, num, func() []byte { return u.byteSlice(elems, order, bytesType) })
+	default:
+		return false
+	}
+	return true
+}
+
+//getMap reads into *p a map of type t, its keys with key and its values with value
+func getMap[K comparable, V any](u *unmarshaler, p *map[K]V, t reflect.Type, order binary.ByteOrder, length LengthTypeInstance, key func() K, value func() V) {
+	l := u.getLength(length, order, t)
+	if l == 0 {
+		return
+	}
+	mv := map[K]V{}
+	*p = mv
+	var k K
+	kv := reflect.ValueOf(&k).Elem()
+	for i := 0; i < l; i++ {
+		u.push(pathElem{index: i, isKey: true})
+		k = key()
+		u.pop()
+		u.push(keyElem(kv, false))
+		mv[k] = value()
+		u.pop()
+	}
+}
+
+//byteSlice reads a byte slice of type t with its length prefix, nil when it is empty
+func (u *unmarshaler) byteSlice(length LengthTypeInstance, order binary.ByteOrder, t reflect.Type) []byte {
+	l := u.regionLength(length, order, t)
+	if l == indefiniteLength {
+		return u.segments(length, order, t, []byte{})
+	}
+	var b []byte
+	if l != 0 {
+		b = u.bytes(l)
+		u.readInto(b)
+	}
+	u.trailer(length, t)
+	return b
+}
@@ -0,0 +1,121 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type namedMap map[string]string
+
+type mapsMsg struct {
+	Labels  map[string]string
+	Counts  map[string]uint32
+	Blobs   map[uint32][]byte
+	Named   namedMap
+	Empty   map[string]string
+	Missing map[uint32][]byte
+}
+
+func TestFastMaps(t *testing.T) {
+	v := mapsMsg{
+		Labels:  map[string]string{"b": "two", "a": "one", "": "", "c": strings.Repeat("x", 300)},
+		Counts:  map[string]uint32{"x": 1, "y": 0xdeadbeef},
+		Blobs:   map[uint32][]byte{7: {1, 2}, 1: nil, 300: bytes.Repeat([]byte{9}, 300)},
+		Named:   namedMap{"k": "v"},
+		Empty:   map[string]string{},
+		Missing: nil,
+	}
+	lengths := map[string]LengthType{
+		"BlobLength16": BlobLength16,
+		"VarintLength": VarintLength(5),
+		"RESPLength":   RESPLength('$', 10, true),
+	}
+	for name, length := range lengths {
+		for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+			b, err := MarshalBytes(&v, order, length, Deterministic())
+			if err != nil {
+				t.Fatalf("%s %s: %v", name, order, err)
+			}
+			//tracing makes marshal walk the maps with reflection
+			want, err := MarshalBytes(&v, order, length, Deterministic(), WithTrace(func(TraceEvent) {}))
+			if err != nil {
+				t.Fatalf("%s %s: %v", name, order, err)
+			}
+			if !bytes.Equal(b, want) {
+				t.Errorf("%s %s: encoded % x, want % x", name, order, b, want)
+			}
+			var readBack, traced mapsMsg
+			if err := UnmarshalBytes(&readBack, b, order, length); err != nil {
+				t.Fatalf("%s %s: %v", name, order, err)
+			}
+			if err := UnmarshalBytes(&traced, b, order, length, WithTrace(func(TraceEvent) {})); err != nil {
+				t.Fatalf("%s %s: %v", name, order, err)
+			}
+			if !reflect.DeepEqual(readBack, traced) {
+				t.Errorf("%s %s: read back %+v, want %+v", name, order, readBack, traced)
+			}
+			if !reflect.DeepEqual(readBack.Labels, v.Labels) || !reflect.DeepEqual(readBack.Counts, v.Counts) {
+				t.Errorf("%s %s: read back %+v, want %+v", name, order, readBack, v)
+			}
+		}
+	}
+	//without Deterministic the entries may come in any order, but they all come back
+	b, err := MarshalBytes(v.Blobs, binary.BigEndian, BlobLength32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var blobs map[uint32][]byte
+	if err := UnmarshalBytes(&blobs, b, binary.BigEndian, BlobLength32); err != nil || !reflect.DeepEqual(blobs, v.Blobs) {
+		t.Errorf("read back %v, want %v, %v", blobs, v.Blobs, err)
+	}
+	//errors name the entry they happened in
+	_, err = MarshalBytes(&v, binary.BigEndian, BlobLength8)
+	if err == nil || !strings.Contains(err.Error(), "Labels[c]") {
+		t.Errorf("expected an error at Labels[c], got %v", err)
+	}
+}
+
+func TestFastMapsKeyLess(t *testing.T) {
+	RegisterKeyLess(reflect.TypeOf(map[string]uint32(nil)), func(a, b reflect.Value) bool { return a.String() > b.String() })
+	defer func() {
+		keyLessLock.Lock()
+		delete(keyLess, reflect.TypeOf(map[string]uint32(nil)))
+		keyLessLock.Unlock()
+	}()
+	b, err := MarshalBytes(map[string]uint32{"a": 1, "b": 2}, binary.BigEndian, BlobLength8, Deterministic())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{2, 1, 'b', 0, 0, 0, 2, 1, 'a', 0, 0, 0, 1}
+	if !bytes.Equal(b, want) {
+		t.Errorf("encoded % x, want % x", b, want)
+	}
+}
+
+func BenchmarkMapStringString(b *testing.B) {
+	v := map[string]string{}
+	for i := 0; i < 64; i++ {
+		v["key"+strconv.Itoa(i)] = "value" + strconv.Itoa(i)
+	}
+	buf, err := MarshalBytes(v, binary.LittleEndian, BlobLength16)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Run("encode", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			MarshalBytes(v, binary.LittleEndian, BlobLength16)
+		}
+	})
+	b.Run("decode", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var readBack map[string]string
+			UnmarshalBytes(&readBack, buf, binary.LittleEndian, BlobLength16)
+		}
+	})
+}
@@ -2,6 +2,67 @@
 //except it support varibale length string and array
 //array and string length format is defined by LengthType and ByteOrder
 //This package depends on encoding/binary.ByteOrder
+//
+//Struct fields may carry a `marshal:"..."` tag, a comma separated list of options:
+//
+//	codec=name    field of any type is encoded by the codec registered with RegisterNamedCodec
+//	columnar      slice or array of fixed-size structs is written column by column
+//	charset=name  string is converted to the named character set: latin1, ebcdic037 or
+//	              one added with RegisterCharset
+//	enum=name     string is written as its code in the mapping registered with RegisterEnum
+//	fallback      with enum, values missing from the mapping travel as decimal codes
+//	max=n         string longer than n bytes is an error
+//	truncate      with max or fixed, string is clipped on a UTF-8 boundary instead
+//	fixed=n       string takes exactly n padded bytes and has no length prefix
+//	trim=mode     with fixed, padding stripped on decode: nul (default), space, both or none,
+//	              spaces are in the charset, 0x40 for ebcdic037
+//	bcd=n         integer is written as n packed BCD digits with leading zeros, a string
+//	              must hold exactly n digits
+//	bcd           integer or digit string is written as its digit count, then packed BCD
+//	              digits, see LLVAR
+//	align=left    with bcd, an odd digit count is padded after the digits instead of before
+//	pad=f         with bcd, the pad nibble of an odd digit count is 0xF instead of 0
+//	ascii=n       integer is written as n decimal characters, zero padded after any '-'
+//	pad=space     with ascii, pad with leading spaces instead of zeros
+//	count=Field   slice has no length prefix, the earlier integer Field holds its length
+//	unit=n        with count, Field holds the length times n
+//	reserved=n    on a struct{} placeholder, n zero bytes; verified on decode with Strict
+//	magic=hex     on a struct{} placeholder, the hex bytes, verified on decode; a magic
+//	              starting a message lets Decoder.Resync find the next one
+//	bits=n        packs an integer or bool into n bits shared with adjacent bits fields
+//	msb, lsb      bit order of a bits group, most significant bit first by default
+//	parallel      map is written as its length, all keys sorted, then all values in key order
+//	rest          with SentinelLength, string or slice runs to the end of its region
+//	bitmap        slice or array of pointers is written as a presence bitmap and the non-nil elements
+//	delta         integer slice or array is written as varints, the first element then the
+//	              difference to the one before; unsigned ones must not decrease, signed
+//	              differences are zigzag encoded
+//	rle           slice or array of fixed-size elements is written as runs of a count and the
+//	              repeated element, counts use the length type, which bounds the decoded size
+//	packbits=n    integer slice or array is packed n bits per element, most significant bit first
+//	quantize=int16  float slice or array is written as rounded integers of that type,
+//	              (x-bias)/scale; the scale and bias mapping the values onto the integer range
+//	              are written as float64 before them unless scale=F,bias=G name earlier float
+//	              fields holding them
+//	saturate      with quantize, values outside the integer range are clamped instead of an error
+//	nullable      with NullableLength, a nil slice is written as the null length
+//	delimited     struct is prefixed with its encoded size, decoding skips bytes it leaves
+//	trimzero      struct is written as the count of its fields up to the last non-zero one,
+//	              then those fields; decoding zeroes the fields left out. Structs inside
+//	              are written whole unless their own fields are trimzero
+//	sizeof=Field  integer is the encoded size of the later Field, filled in on encode
+//	              and checked against the bytes Field consumes on decode
+//	offset=F,size=G  value is stored after the fixed header at offset F from the
+//	              start of the message, in G bytes; strings and slices fill the region
+//	              without a length prefix. Decoding needs an io.Seeker
+//	skip          field is left out of the encoding and untouched by decoding
+//	len=w         field and what it holds use length prefixes of w whatever the length
+//	              type of the call: 8, 16, 32 or 64 bits, or compact
+//	elemlen=w     with a map, slice or array, the elements, keys and values use w, the
+//	              field's own prefix len= or the length type of the call, see ElemLength
+//
+//Types that can't carry tags, such as generated ones, get the same options with
+//ConfigureType
 package marshal
 
 import (
@@ -11,10 +72,14 @@ import (
 	"io"
 	"math"
 	"reflect"
+	"time"
+	"unsafe"
 )
 
 //LengthTypeInstance let you define a new length format,
-//you can put instance-wise buffer in each instance to speed up and avoid GCs
+//you can put instance-wise buffer in each instance to speed up and avoid GCs.
+//An instance is used by one goroutine at a time but may serve any number of
+//calls in turn, the built-in ones keep nothing but scratch buffers between calls
 type LengthTypeInstance interface {
 	Length(io.Reader, binary.ByteOrder, reflect.Kind) int
 	PutLength(io.Writer, binary.ByteOrder, reflect.Kind, int)
@@ -23,6 +88,24 @@ type LengthTypeInstance interface {
 //Function to create LengthTypeInstance, see BlobLength64 for detail
 type LengthType func() LengthTypeInstance
 
+//ErrNegativeLength is wrapped by the errors of built-in length types asked to
+//write a negative length, which fixed-width ones would otherwise wrap around
+var ErrNegativeLength error = &kindError{"marshal: negative length", ErrLengthOverflow}
+
+//ErrLengthTooLarge is wrapped by the errors of built-in fixed-width length types
+//asked to write a length their width can't hold
+var ErrLengthTooLarge error = &kindError{"marshal: length too large", ErrLengthOverflow}
+
+//checkLength panics unless v is a length bits wide at most
+func checkLength(v int, bits uint) {
+	if v < 0 {
+		panic(fmt.Errorf("%w: %d", ErrNegativeLength, v))
+	}
+	if bits < 64 && uint64(v)>>bits != 0 {
+		panic(fmt.Errorf("%w: %d doesn't fit %d bits", ErrLengthTooLarge, v, bits))
+	}
+}
+
 //BlobLength64 array and string length is present with 64 bit word
 func BlobLength64() LengthTypeInstance {
 	return &blobLength64{}
@@ -33,6 +116,7 @@ type blobLength64 struct {
 }
 
 func (d *blobLength64) PutLength(w io.Writer, order binary.ByteOrder, k reflect.Kind, v int) {
+	checkLength(v, 64)
 	var bs []byte
 	bs = d.b[:8]
 	order.PutUint64(bs, uint64(v))
@@ -67,14 +151,14 @@ func Bound64(bound int) LengthType {
 func (d *bound64) Length(r io.Reader, order binary.ByteOrder, k reflect.Kind) int {
 	l := d.length.Length(r, order, k)
 	if l > d.bound {
-		panic(fmt.Errorf("bound length overflow: %d > %d", l, d.bound))
+		panic(errorf(ErrBoundExceeded, "bound length overflow: %d > %d", l, d.bound))
 	}
 	return l
 }
 
 func (d *bound64) PutLength(w io.Writer, order binary.ByteOrder, k reflect.Kind, v int) {
 	if v > d.bound {
-		panic(fmt.Errorf("bound length overflow: %d > %d", v, d.bound))
+		panic(errorf(ErrBoundExceeded, "bound length overflow: %d > %d", v, d.bound))
 	}
 	d.length.PutLength(w, order, k, v)
 }
@@ -89,6 +173,7 @@ type blobLength32 struct {
 }
 
 func (d *blobLength32) PutLength(w io.Writer, order binary.ByteOrder, k reflect.Kind, v int) {
+	checkLength(v, 32)
 	var bs []byte
 	bs = d.b[:4]
 	order.PutUint32(bs, uint32(v))
@@ -123,14 +208,14 @@ func Bound32(bound int) LengthType {
 func (d *bound32) Length(r io.Reader, order binary.ByteOrder, k reflect.Kind) int {
 	l := d.length.Length(r, order, k)
 	if l > d.bound {
-		panic(fmt.Errorf("bound length overflow: %d > %d", l, d.bound))
+		panic(errorf(ErrBoundExceeded, "bound length overflow: %d > %d", l, d.bound))
 	}
 	return l
 }
 
 func (d *bound32) PutLength(w io.Writer, order binary.ByteOrder, k reflect.Kind, v int) {
 	if v > d.bound {
-		panic(fmt.Errorf("bound length overflow: %d > %d", v, d.bound))
+		panic(errorf(ErrBoundExceeded, "bound length overflow: %d > %d", v, d.bound))
 	}
 	d.length.PutLength(w, order, k, v)
 }
@@ -145,6 +230,7 @@ type blobLength16 struct {
 }
 
 func (d *blobLength16) PutLength(w io.Writer, order binary.ByteOrder, k reflect.Kind, v int) {
+	checkLength(v, 16)
 	var bs []byte
 	bs = d.b[:2]
 	order.PutUint16(bs, uint16(v))
@@ -173,6 +259,7 @@ type blobLength8 struct {
 }
 
 func (d *blobLength8) PutLength(w io.Writer, order binary.ByteOrder, k reflect.Kind, v int) {
+	checkLength(v, 8)
 	var bs []byte
 	bs = d.b[:1]
 	bs[0] = uint8(v)
@@ -191,7 +278,8 @@ func (d *blobLength8) Length(r io.Reader, order binary.ByteOrder, k reflect.Kind
 	return int(bs[0])
 }
 
-//CompactLength provide compact length in Tight-VNC encoding
+//CompactLength provide compact length in Tight-VNC encoding. Decoding is
+//lenient, see StrictCompactLength
 func CompactLength() LengthTypeInstance {
 	return &compactLength{}
 }
@@ -202,6 +290,7 @@ type compactLength struct {
 
 func (d *compactLength) PutLength(w io.Writer, order binary.ByteOrder, k reflect.Kind, v int) {
 	var bs []byte
+	checkLength(v, 64)
 	d.b[0] = byte(v & 0x7f)
 	if v > 0x7f {
 		d.b[0] |= 0x80
@@ -210,7 +299,7 @@ func (d *compactLength) PutLength(w io.Writer, order binary.ByteOrder, k reflect
 			d.b[1] |= 0x80
 			d.b[2] = byte(v >> 14)
 			if v > 0x3fffff {
-				panic(errors.New("compactLen overflow, value=" + string(v)))
+				panic(fmt.Errorf("%w, value=%d", ErrCompactOverflow, v))
 			} else {
 				bs = d.b[:]
 			}
@@ -274,6 +363,7 @@ func (d *YYBlobTypeInstance) PutLength(w io.Writer, order binary.ByteOrder, k re
 	if k != reflect.String {
 		d.length.PutLength(w, order, k, v)
 	} else {
+		checkLength(v, 16)
 		var bs []byte
 		bs = d.length.b[:2]
 		order.PutUint16(bs, uint16(v))
@@ -287,7 +377,25 @@ func (d *YYBlobTypeInstance) PutLength(w io.Writer, order binary.ByteOrder, k re
 type marshaler struct {
 	buf   [8]byte
 	w     io.Writer
+	cw    writeCounter
 	order binary.ByteOrder
+	path  []pathElem
+	trace func(TraceEvent)
+	index *[]int64
+	//deterministic sorts map keys, see Deterministic
+	deterministic bool
+	//shared numbers the pointers written so far, nil unless SharedPointers is set
+	shared map[sharedKey]int
+	//pack pads structs like a C compiler, see CLayout
+	pack int
+	//fields counts the struct fields written, see Stats
+	fields int
+	warn   func(Warning)
+	//stage and vec write length prefixes with their payload, see payload
+	stage stageWriter
+	vec   vectors
+	//frames is the stack of nested, kept for the next call
+	frames []frame
 }
 
 func (m *marshaler) flush(sz int) {
@@ -326,68 +434,214 @@ func (m *marshaler) int32(x int32) { m.uint32(uint32(x)) }
 
 func (m *marshaler) int64(x int64) { m.uint64(uint64(x)) }
 
+//fixed encodes a fixed-size value with a single Write
+func (m *marshaler) fixed(v reflect.Value, p *typePlan) {
+	m.fields += p.nfields
+	bp := getScratch(p.size)
+	if v.CanAddr() {
+		putFixedAt(*bp, v.Addr().UnsafePointer(), p, m.order)
+	} else {
+		putFixed(*bp, v, p, m.order)
+	}
+	_, err := m.w.Write(*bp)
+	scratchPool.Put(bp)
+	if err != nil {
+		panic(err)
+	}
+}
+
+//structFields writes the first n fields of the struct v of plan p
+func (m *marshaler) structFields(v reflect.Value, p *typePlan, n int, length LengthTypeInstance) {
+	start := m.cw.n
+	// loop through the struct's fields and set the map
+	for i := range p.fields[:n] {
+		f := &p.fields[i]
+		if m.pack != 0 {
+			m.pad(v.Type(), start, fieldAlign(v.Type(), f, m.pack))
+		}
+		if f.bits != nil {
+			if f == f.bits.fields[0] {
+				m.bits(v, f.bits, length)
+			}
+			continue
+		}
+		m.push(fieldElem(f.name))
+		m.field(m.fieldValue(v, f, length), v, f, length)
+		m.pop()
+	}
+	if m.pack != 0 {
+		m.pad(v.Type(), start, cAlign(v.Type(), m.pack))
+	}
+}
+
 //Marshal put binary presentation of v into w. Bytes written to w are encoded using specified byte order and length type
-func Marshal(v interface{}, w io.Writer, order binary.ByteOrder, length LengthType) (err error) {
-	defer func() {
-		if e := recover(); e != nil {
-			switch v := e.(type) {
-			case error:
-				err = v
-			case string:
-				err = errors.New("marshal error:" + v)
-			default:
-				panic(e) //repanic
+func Marshal(v interface{}, w io.Writer, order binary.ByteOrder, length LengthType, opts ...Option) (err error) {
+	_, err = encode(v, w, order, length(), newOptions(opts))
+	return
+}
+
+//MarshalWith is Marshal with a length type instance, which a caller encoding
+//many values keeps instead of creating one per call. inst must not be used by
+//other goroutines until MarshalWith returns
+func MarshalWith(v interface{}, w io.Writer, order binary.ByteOrder, inst LengthTypeInstance, opts ...Option) (err error) {
+	_, err = encode(v, w, order, inst, newOptions(opts))
+	return
+}
+
+//encode is the common entry of every encoding API, it reports the number of bytes written to w
+func encode(v interface{}, w io.Writer, order binary.ByteOrder, length LengthTypeInstance, o *options) (n int64, err error) {
+	m := getMarshaler(w, order, o)
+	defer putMarshaler(m)
+	if o.stats != nil {
+		defer m.collect(o.stats, reflect.TypeOf(v), time.Now(), &err)
+	}
+	defer recoverError(&err)
+	defer func() { n = m.cw.n }()
+	rv := reflect.ValueOf(v)
+	if rv.IsValid() {
+		m.push(rootElem(rv.Type()))
+		if o.fingerprint {
+			m.putFingerprint(rv.Type(), length, o)
+		}
+	}
+	if o.proto {
+		m.pbMessage(rv)
+		return
+	}
+	if m.shared != nil {
+		//the top level pointer isn't part of the value, see SharedPointers
+		for rv.Kind() == reflect.Ptr {
+			rv = rv.Elem()
+		}
+	}
+	m.marshal(rv, length)
+	return
+}
+
+//putLength writes the length prefix of a value of kind
+func (m *marshaler) putLength(length LengthTypeInstance, t reflect.Type, l int) {
+	if m.trace != nil {
+		start := m.cw.n
+		m.putLengthTo(m.w, length, t, l)
+		m.emit(t, start, true)
+		return
+	}
+	m.putLengthTo(m.w, length, t, l)
+}
+
+//lengthError adds the path of the value to the error of a length that can't be
+//written: too large for its prefix or its bound, or negative
+func (m *marshaler) lengthError() {
+	if e := recover(); e != nil {
+		if err, ok := e.(error); ok && (errors.Is(err, ErrLengthOverflow) || errors.Is(err, ErrBoundExceeded)) {
+			e = fmt.Errorf("%s: %w", formatPath(m.path), err)
+		}
+		panic(e)
+	}
+}
+
+//mapKeys returns the keys of the map v once its key type and keys are known to
+//round trip
+func (m *marshaler) mapKeys(v reflect.Value) []reflect.Value {
+	p := planFor(v.Type())
+	if p.err != nil {
+		panic(p.err)
+	}
+	keys := v.MapKeys()
+	if p.floatKey {
+		for _, k := range keys {
+			//only NaN isn't equal to itself
+			if !k.Equal(k) {
+				panic(errorf(ErrUnsupportedKind, "marshal: %s: NaN key %v can't be looked up once decoded", formatPath(m.path), k))
 			}
 		}
-	}()
-	m := &marshaler{w: w, order: order}
-	m.marshal(reflect.ValueOf(v), length())
-	return nil
+	}
+	return keys
 }
 
 func (m *marshaler) marshal(v reflect.Value, length LengthTypeInstance) {
+	if m.shared != nil && v.Kind() == reflect.Ptr {
+		m.sharedPointer(v, length)
+		return
+	}
 	for v.Kind() == reflect.Ptr {
 		v = v.Elem()
 	}
+	if m.trace != nil && v.IsValid() {
+		start := m.cw.n
+		m.marshalValue(v, length)
+		m.emit(v.Type(), start, false)
+		return
+	}
+	m.marshalValue(v, length)
+}
+
+func (m *marshaler) marshalValue(v reflect.Value, length LengthTypeInstance) {
+	if v.IsValid() && v.Type().PkgPath() != "" {
+		if p := planFor(v.Type()); p.custom {
+			m.custom(v, length)
+			return
+		} else if p.optional {
+			m.optional(v, length)
+			return
+		}
+	}
 	kind := v.Kind()
 	switch kind {
 	case reflect.String:
-		l := v.Len()
-		length.PutLength(m.w, m.order, kind, l)
-		if l != 0 {
-			if _, e := m.w.Write([]byte(v.String())); nil != e {
-				panic(e)
-			}
-		}
+		m.payload(length, v.Type(), stringBytes(v.String()))
 	case reflect.Struct:
-		// loop through the struct's fields and set the map
-		for i := 0; i < v.NumField(); i++ {
-			m.marshal(v.Field(i), length)
+		p := planFor(v.Type())
+		if p.err != nil {
+			panic(p.err)
+		}
+		if p.size > 0 && m.trace == nil && m.pack == 0 {
+			m.fixed(v, p)
+			return
+		}
+		m.fields += len(p.fields)
+		if p.regions {
+			m.regions(v, p, length)
+			return
 		}
+		m.structFields(v, p, len(p.fields), length)
 	case reflect.Map:
-		l := v.Len()
-		length.PutLength(m.w, m.order, kind, l)
-		keys := v.MapKeys()
+		if m.fastMap(v, length) {
+			break
+		}
+		keys := m.mapKeys(v)
+		l := len(keys)
+		m.putLength(length, v.Type(), l)
+		if m.deterministic {
+			sortKeys(v.Type(), keys)
+		}
+		elems := elemsOf(length)
 		for i := 0; i < l; i++ {
-			m.marshal(keys[i], length)
-			m.marshal(v.MapIndex(keys[i]), length)
+			m.push(keyElem(keys[i], true))
+			m.marshal(keys[i], elems)
+			m.pop()
+			m.push(keyElem(keys[i], false))
+			m.marshal(v.MapIndex(keys[i]), elems)
+			m.pop()
 		}
 	case reflect.Array, reflect.Slice:
-		l := v.Len()
+		if bs := byteView(v); bs != nil && v.Kind() == reflect.Slice {
+			m.payload(length, v.Type(), bs)
+			break
+		}
+		if p := planFor(v.Type()); p.nested > 0 && m.flat() && elemsOf(length) == length {
+			m.nested(v, p.nested, length)
+			break
+		}
 		if v.Kind() == reflect.Slice {
-			length.PutLength(m.w, m.order, kind, l)
+			m.putLength(length, v.Type(), v.Len())
 		}
-		kind := v.Type().Elem().Kind()
-		if kind == reflect.Uint8 || kind == reflect.Int8 {
-			//fast path for []byte
-			if _, e := m.w.Write(v.Slice(0, l).Bytes()); nil != e {
-				panic(e)
-			}
-		} else {
-			for i := 0; i < l; i++ {
-				m.marshal(v.Index(i), length)
-			}
+		m.elements(v, length)
+		if v.Kind() == reflect.Slice {
+			m.trailer(length, v.Type())
 		}
+	case reflect.Interface:
+		m.iface(v, length)
 	case reflect.Bool:
 		if v.Bool() {
 			m.uint8(1)
@@ -438,41 +692,245 @@ func (m *marshaler) marshal(v reflect.Value, length LengthTypeInstance) {
 			m.uint64(math.Float64bits(imag(x)))
 
 		default:
-			panic(errors.New("unsupport type" + v.Type().Name()))
+			panic(errorf(ErrUnsupportedKind, "unsupport type%s", v.Type().Name()))
+		}
+	}
+}
+
+//elements writes the elements of an array or slice without a length prefix
+func (m *marshaler) elements(v reflect.Value, length LengthTypeInstance) {
+	index := m.index != nil && len(m.path) == 1
+	if index {
+		index = !m.indexFixed(v)
+	}
+	if bs := byteView(v); bs != nil {
+		//fast path for []byte
+		if _, e := m.w.Write(bs); nil != e {
+			panic(e)
+		}
+	} else if p := planFor(v.Type()); p.size > 0 && m.trace == nil && m.pack == 0 {
+		m.fixed(v, p)
+	} else if bs := nativeView(v, m.order); bs != nil && m.trace == nil && !index {
+		//the memory of numbers in the host's order is their encoding
+		if _, e := m.w.Write(bs); nil != e {
+			panic(e)
+		}
+	} else if bs, size := swapView(v, m.order); bs != nil && m.trace == nil && !index {
+		m.swapped(bs, size)
+	} else {
+		elems := elemsOf(length)
+		for i := 0; i < v.Len(); i++ {
+			if index {
+				*m.index = append(*m.index, m.cw.n)
+			}
+			m.push(indexElem(i))
+			m.marshal(v.Index(i), elems)
+			m.pop()
 		}
 	}
 }
 
 //Unmarshal read binary presentation of data from r into m. Bytes read from r must be encoded using specified byte order and length type.
 //When reading into struct, all non-blank field must be exported
-func Unmarshal(m interface{}, r io.Reader, order binary.ByteOrder, length LengthType) (err error) {
+func Unmarshal(m interface{}, r io.Reader, order binary.ByteOrder, length LengthType, opts ...Option) (err error) {
+	_, err = decode(m, r, order, length(), newOptions(opts))
+	return
+}
+
+//UnmarshalWith is Unmarshal with a length type instance, see MarshalWith
+func UnmarshalWith(m interface{}, r io.Reader, order binary.ByteOrder, inst LengthTypeInstance, opts ...Option) (err error) {
+	_, err = decode(m, r, order, inst, newOptions(opts))
+	return
+}
+
+//decode is the common entry of every decoding API, it reports the number of bytes read from r
+func decode(m interface{}, r io.Reader, order binary.ByteOrder, length LengthTypeInstance, o *options) (n int64, err error) {
+	v, err := destination(m)
+	if err != nil {
+		//the failure is reported before any reading starts
+		if o.stats != nil {
+			o.stats.Collect(Stats{Type: reflect.TypeOf(m), Decode: true, Err: err})
+		}
+		return 0, err
+	}
+	u := getUnmarshaler(r, o)
+	defer putUnmarshaler(u)
+	if o.stats != nil {
+		defer u.collect(o.stats, v.Type(), time.Now(), &err)
+	}
+	defer recoverError(&err)
+	defer func() { n = u.cr.n }()
+	u.push(rootElem(v.Type()))
+	if o.fingerprint {
+		u.checkFingerprint(v.Type(), order, length, o)
+	}
+	if o.proto {
+		u.pbMessage(v.Elem())
+		return
+	}
+	u.unmarshal(v.Elem(), order, length)
+	return
+}
+
+//destination is the value of m, a non-nil pointer decoding writes through
+func destination(m interface{}) (reflect.Value, error) {
+	const msg = "unmarshal: destination must be a pointer to a settable value; got "
 	v := reflect.ValueOf(m)
-	if v.Kind() != reflect.Ptr {
-		return errors.New("unmarshal: invalid type " + v.Type().String())
-	}
-	defer func() {
-		if e := recover(); e != nil {
-			switch v := e.(type) {
-			case error:
-				err = v
-			case string:
-				err = errors.New("unmarshal error:" + v)
-			default:
-				panic(e) //repanic
-			}
+	switch {
+	case !v.IsValid():
+		return v, errorf(ErrNilPointer, "%snil", msg)
+	case v.Type() == reflect.TypeOf(reflect.Value{}):
+		rv := v.Interface().(reflect.Value)
+		if rv.IsValid() && !rv.CanAddr() {
+			return v, fmt.Errorf(msg+"reflect.Value of %s that isn't addressable, such as a map element", rv.Type())
 		}
-	}()
-	u := &unmarshaler{r: r}
-	u.unmarshal(v.Elem(), order, length())
-	return nil
+		return v, errors.New(msg + "reflect.Value, pass v.Addr().Interface()")
+	case v.Kind() != reflect.Ptr:
+		return v, errors.New(msg + v.Type().String())
+	case v.IsNil():
+		return v, errorf(ErrNilPointer, "%snil %s", msg, v.Type())
+	}
+	return v, nil
 }
 
 type unmarshaler struct {
-	buf [8]byte
-	r   io.Reader
+	buf    [8]byte
+	r      io.Reader
+	cr     readCounter
+	alloc  Allocator
+	path   []pathElem
+	trace  func(TraceEvent)
+	strict bool
+	//shared holds the pointers decoded so far, nil unless SharedPointers is set
+	shared  []reflect.Value
+	unknown func(id uint64, body []byte) interface{}
+	pack    int
+	//src is the input when decoding from memory, see take
+	src *sliceReader
+	//fields counts the struct fields read, see Stats
+	fields int
+	warn   func(Warning)
+	//stage holds short payloads before they become strings or values, see staged
+	stage [stageSize]byte
+	//frames is the stack of nested, kept for the next call
+	frames []frame
+}
+
+//getLength reads the length prefix of a value of type t
+func (u *unmarshaler) getLength(length LengthTypeInstance, order binary.ByteOrder, t reflect.Type) int {
+	l := u.regionLength(length, order, t)
+	if l == indefiniteLength {
+		panic(errorf(ErrMalformed, "unmarshal: indefinite length for %s, only delimited structs, strings and byte slices take one", t))
+	}
+	return l
+}
+
+//regionLength is getLength for the values that may have an indefinite length, see BERLength
+func (u *unmarshaler) regionLength(length LengthTypeInstance, order binary.ByteOrder, t reflect.Type) int {
+	start := u.cr.n
+	l := length.Length(u.r, order, t.Kind())
+	if u.trace != nil {
+		u.emit(t, start, true)
+	}
+	switch l {
+	case restOfRegion:
+		return u.restLength(t)
+	case nullLength:
+		panic(errorf(ErrMalformed, "unmarshal: null length for %s, which isn't nullable", t))
+	}
+	return l
+}
+
+//bytes returns a buffer of l bytes for decoded payload, taken from the allocator when one is set
+func (u *unmarshaler) bytes(l int) []byte {
+	if u.alloc == nil {
+		return make([]byte, l)
+	}
+	bs := u.alloc.Alloc(l)
+	if len(bs) < l {
+		panic(fmt.Errorf("allocator returned %d bytes, want %d", len(bs), l))
+	}
+	return bs[:l:l]
+}
+
+//grow extends b by l bytes, moving it to a buffer from the allocator when one
+//is set and b lacks the room
+func (u *unmarshaler) grow(b []byte, l int) []byte {
+	if u.alloc == nil {
+		return append(b, make([]byte, l)...)
+	}
+	n := len(b)
+	if cap(b)-n < l {
+		nb := u.bytes(max(2*cap(b), n+l))
+		copy(nb, b)
+		b = nb
+	}
+	return b[:n+l]
+}
+
+//stageSize is the length under which payloads are read into the stage of the
+//unmarshaler rather than a buffer of their own, see BenchmarkUnmarshalShortStrings
+const stageSize = 64
+
+//stringValue reads a string of type t with its length prefix, ok is false for an
+//empty one of definite length, which leaves the value it is read into as it was
+func (u *unmarshaler) stringValue(length LengthTypeInstance, order binary.ByteOrder, t reflect.Type) (s string, ok bool) {
+	l := u.regionLength(length, order, t)
+	if l == indefiniteLength {
+		return u.adopt(u.segments(length, order, t, nil)), true
+	}
+	if l != 0 && u.direct() && u.alloc == nil {
+		s, ok = string(u.take(l)), true
+	} else if l != 0 {
+		s, ok = u.readString(l), true
+	}
+	u.trailer(length, t)
+	return
+}
+
+//readString reads a string of l bytes with a single allocation
+func (u *unmarshaler) readString(l int) string {
+	bs := u.scratch(l)
+	if _, e := io.ReadFull(u.r, bs); e != nil {
+		panic(e)
+	}
+	return u.adopt(bs)
+}
+
+//scratch returns a buffer of l bytes to read a string into before adopt
+func (u *unmarshaler) scratch(l int) []byte {
+	if l < stageSize && u.alloc == nil {
+		return u.stage[:l]
+	}
+	return u.bytes(l)
+}
+
+//staged returns a buffer of l bytes for a payload that is only read, in the
+//stage when it is short. It is valid until the stage is used again
+func (u *unmarshaler) staged(l int) []byte {
+	if l < stageSize {
+		return u.stage[:l]
+	}
+	return make([]byte, l)
+}
+
+//adopt returns b as a string, copying it only when it is in the stage: other
+//buffers were made for the string, or come from the arena, and are used nowhere
+//else
+func (u *unmarshaler) adopt(b []byte) string {
+	switch {
+	case len(b) == 0:
+		return ""
+	case &b[:cap(b)][cap(b)-1] == &u.stage[stageSize-1]:
+		return string(b)
+	}
+	return unsafe.String(&b[0], len(b))
 }
 
 func (u *unmarshaler) fetch(b int) (bs []byte) {
+	if u.direct() {
+		return u.take(b)
+	}
 	bs = u.buf[:b]
 	if _, e := io.ReadFull(u.r, bs); e != nil {
 		panic(e)
@@ -480,59 +938,188 @@ func (u *unmarshaler) fetch(b int) (bs []byte) {
 	return
 }
 
+//fixed decodes a fixed-size value with a single ReadFull
+func (u *unmarshaler) fixed(v reflect.Value, p *typePlan, order binary.ByteOrder) {
+	if !p.settable {
+		u.settable(v, p)
+	}
+	u.fields += p.nfields
+	var b []byte
+	if u.direct() {
+		b = u.take(p.size)
+	} else {
+		bp := getScratch(p.size)
+		defer scratchPool.Put(bp)
+		if _, e := io.ReadFull(u.r, *bp); e != nil {
+			panic(e)
+		}
+		b = *bp
+	}
+	if v.CanSet() && p.settable {
+		getFixedAt(b, v.Addr().UnsafePointer(), p, order)
+	} else {
+		getFixed(b, v, p, order)
+	}
+}
+
+//settable fails with the path of the first field of the fixed-size value v of
+//plan p that can't be set, blank padding aside, which is copied whole
+func (u *unmarshaler) settable(v reflect.Value, p *typePlan) {
+	switch v.Kind() {
+	case reflect.Array:
+		if v.Len() > 0 && !p.elem.settable {
+			u.push(indexElem(0))
+			u.settable(v.Index(0), p.elem)
+			u.pop()
+		}
+	case reflect.Struct:
+		for i := range p.fields {
+			f := &p.fields[i]
+			if f.plan.size == 0 || f.name == "_" {
+				continue
+			}
+			u.push(fieldElem(f.name))
+			if !v.Field(f.index).CanSet() {
+				panic(fmt.Errorf("unmarshal: cannot decode into %s: unexported field", formatPath(u.path)))
+			}
+			if !f.plan.settable {
+				u.settable(v.Field(f.index), f.plan)
+			}
+			u.pop()
+		}
+	}
+}
+
+//structFields decodes the first n fields of the struct v of plan p
+func (u *unmarshaler) structFields(v reflect.Value, p *typePlan, n int, order binary.ByteOrder, length LengthTypeInstance) {
+	start := u.cr.n
+	// loop through the struct's fields and set the map
+	for i := range p.fields[:n] {
+		f := &p.fields[i]
+		//zero-size fields such as reserved= placeholders aren't written
+		if f.plan.size != 0 && !v.Field(f.index).CanSet() {
+			u.push(fieldElem(f.name))
+			panic(fmt.Errorf("unmarshal: cannot decode into %s: unexported field", formatPath(u.path)))
+		}
+		if u.pack != 0 {
+			u.pad(v.Type(), start, fieldAlign(v.Type(), f, u.pack))
+		}
+		if f.bits != nil {
+			if f == f.bits.fields[0] {
+				u.bits(v, f.bits)
+			}
+			continue
+		}
+		u.push(fieldElem(f.name))
+		if f.sizedBy != nil {
+			u.sized(v.Field(f.index), v, f, order, length)
+		} else {
+			u.field(v.Field(f.index), v, f, order, length)
+		}
+		u.pop()
+	}
+	if u.pack != 0 {
+		u.pad(v.Type(), start, cAlign(v.Type(), u.pack))
+	}
+}
+
 func (u *unmarshaler) unmarshal(v reflect.Value, order binary.ByteOrder, length LengthTypeInstance) {
+	if u.shared != nil && v.Kind() == reflect.Ptr {
+		u.sharedPointer(v, order, length)
+		return
+	}
+	if u.trace != nil {
+		start := u.cr.n
+		u.unmarshalValue(v, order, length)
+		u.emit(v.Type(), start, false)
+		return
+	}
+	u.unmarshalValue(v, order, length)
+}
+
+func (u *unmarshaler) unmarshalValue(v reflect.Value, order binary.ByteOrder, length LengthTypeInstance) {
+	if v.Type().PkgPath() != "" {
+		if p := planFor(v.Type()); p.custom {
+			u.custom(v, order, length)
+			return
+		} else if p.optional {
+			u.optional(v, order, length)
+			return
+		}
+	}
 	kind := v.Kind()
 	switch kind {
 	case reflect.String:
-		l := length.Length(u.r, order, kind)
-		if l != 0 {
-			bs := make([]byte, l)
-			if _, e := io.ReadFull(u.r, bs); e != nil {
-				panic(e)
-			}
-			v.SetString(string(bs))
+		if s, ok := u.stringValue(length, order, v.Type()); ok {
+			v.SetString(s)
 		}
 	case reflect.Struct:
-		// loop through the struct's fields and set the map
-		for i := 0; i < v.NumField(); i++ {
-			u.unmarshal(v.Field(i), order, length)
+		p := planFor(v.Type())
+		if p.err != nil {
+			panic(p.err)
+		}
+		if p.size > 0 && u.trace == nil && u.pack == 0 {
+			u.fixed(v, p, order)
+			return
 		}
+		u.fields += len(p.fields)
+		if p.regions {
+			u.regions(v, p, order, length)
+			return
+		}
+		u.structFields(v, p, len(p.fields), order, length)
 	case reflect.Map:
-		l := length.Length(u.r, order, kind)
+		if p := planFor(v.Type()); p.err != nil {
+			panic(p.err)
+		}
+		if u.fastMap(v, order, length) {
+			break
+		}
+		l := u.getLength(length, order, v.Type())
 		if l != 0 {
 			v.Set(reflect.MakeMap(v.Type()))
 			keyType := v.Type().Key()
 			elemType := v.Type().Elem()
+			elems := elemsOf(length)
 			for i := 0; i < l; i++ {
 				key := reflect.New(keyType)
-				u.unmarshal(key.Elem(), order, length)
+				u.push(pathElem{index: i, isKey: true})
+				u.unmarshal(key.Elem(), order, elems)
+				u.pop()
 				elem := reflect.New(elemType)
-				u.unmarshal(elem.Elem(), order, length)
+				u.push(keyElem(key.Elem(), false))
+				u.unmarshal(elem.Elem(), order, elems)
+				u.pop()
 				v.SetMapIndex(key.Elem(), elem.Elem())
 			}
 		}
 	case reflect.Array, reflect.Slice:
+		if p := planFor(v.Type()); p.nested > 0 && u.trace == nil && u.shared == nil && elemsOf(length) == length {
+			u.nested(v, p.nested, order, length)
+			break
+		}
 		var l int
-		if reflect.Slice == v.Kind() {
-			l = length.Length(u.r, order, kind)
+		if reflect.Slice == v.Kind() && v.Type().Elem().Kind() == reflect.Uint8 {
+			if l = u.regionLength(length, order, v.Type()); l == indefiniteLength {
+				v.SetBytes(u.segments(length, order, v.Type(), []byte{}))
+				break
+			}
+		} else if reflect.Slice == v.Kind() {
+			l = u.getLength(length, order, v.Type())
 		} else {
 			l = v.Len()
 		}
 		if l != 0 {
 			if v.Kind() == reflect.Slice {
-				v.Set(reflect.MakeSlice(v.Type(), l, l))
-			}
-			kind := v.Type().Elem().Kind()
-			if kind == reflect.Uint8 || kind == reflect.Int8 {
-				//fast path for []byte
-				buf := v.Slice(0, l).Bytes()
-				u.r.Read(buf)
-			} else {
-				for i := 0; i < l; i++ {
-					u.unmarshal(v.Index(i), order, length)
-				}
+				u.makeSlice(v, l)
 			}
+			u.elements(v, order, length)
+		}
+		if v.Kind() == reflect.Slice {
+			u.trailer(length, v.Type())
 		}
+	case reflect.Interface:
+		u.iface(v, order, length)
 	case reflect.Bool:
 		v.SetBool(u.fetch(1)[0] != 0)
 	case reflect.Int8:
@@ -568,6 +1155,48 @@ func (u *unmarshaler) unmarshal(v reflect.Value, order binary.ByteOrder, length
 			math.Float64frombits(order.Uint64(u.fetch(8))),
 		))
 	default:
-		panic(errors.New("unsupport type" + v.Type().Name()))
+		panic(errorf(ErrUnsupportedKind, "unsupport type%s", v.Type().Name()))
+	}
+}
+
+//makeSlice sets v to a new slice of l elements
+func (u *unmarshaler) makeSlice(v reflect.Value, l int) {
+	elem := v.Type().Elem()
+	if kind := elem.Kind(); kind == reflect.Uint8 || kind == reflect.Int8 {
+		bs := u.bytes(l)
+		v.Set(reflect.SliceAt(elem, unsafe.Pointer(&bs[0]), l).Convert(v.Type()))
+	} else {
+		v.Set(reflect.MakeSlice(v.Type(), l, l))
+	}
+}
+
+//readInto fills buf from the input
+func (u *unmarshaler) readInto(buf []byte) {
+	if u.direct() {
+		copy(buf, u.take(len(buf)))
+	} else if _, e := io.ReadFull(u.r, buf); e != nil {
+		panic(e)
+	}
+}
+
+//elements reads every element of a non-empty array or slice, there is no length prefix
+func (u *unmarshaler) elements(v reflect.Value, order binary.ByteOrder, length LengthTypeInstance) {
+	l := v.Len()
+	if kind := v.Type().Elem().Kind(); kind == reflect.Uint8 || kind == reflect.Int8 {
+		//fast path for []byte
+		u.readInto(unsafe.Slice((*byte)(v.Index(0).Addr().UnsafePointer()), l))
+	} else if p := planFor(v.Type()); p.size > 0 && u.trace == nil && u.pack == 0 {
+		u.fixed(v, p, order)
+	} else if buf := nativeView(v, order); buf != nil && u.trace == nil {
+		u.readInto(buf)
+	} else if buf, size := swapView(v, order); buf != nil && u.trace == nil {
+		u.swapped(buf, size)
+	} else {
+		elems := elemsOf(length)
+		for i := 0; i < l; i++ {
+			u.push(indexElem(i))
+			u.unmarshal(v.Index(i), order, elems)
+			u.pop()
+		}
 	}
 }
@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"encoding/binary"
 	"reflect"
+	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 // Data Model
@@ -61,7 +63,7 @@ func BenchmarkBinary(b *testing.B) {
 }
 
 func TestMarshal(t *testing.T) {
-	orders := []binary.ByteOrder{binary.LittleEndian, binary.BigEndian}
+	orders := []binary.ByteOrder{binary.LittleEndian, binary.BigEndian, PDPEndian}
 	lengths := []LengthType{BlobLength8, BlobLength16, BlobLength32, BlobLength64, CompactLength, Bound64(0xFFFFFFFF), Bound32(0xFFFFFFFF), YYBlobType}
 	for _, o := range orders {
 		for _, l := range lengths {
@@ -82,6 +84,86 @@ func TestBound(t *testing.T) {
 	}
 }
 
+func TestMarshalWith(t *testing.T) {
+	lengths := []LengthType{BlobLength8, BlobLength16, BlobLength32, BlobLength64, CompactLength, Bound64(0xFFFFFFFF), Bound32(0xFFFFFFFF), YYBlobType}
+	for _, l := range lengths {
+		//one instance serves every call in turn
+		inst := l()
+		for i := 0; i < 3; i++ {
+			result := new(bytes.Buffer)
+			if err := MarshalWith(createTestObject(), result, binary.BigEndian, inst, Deterministic()); err != nil {
+				t.Fatal(err)
+			}
+			want, err := MarshalBytes(createTestObject(), binary.BigEndian, l, Deterministic())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(result.Bytes(), want) {
+				t.Fatalf("call %d encoded % x, want % x", i, result.Bytes(), want)
+			}
+			var readBack Foo
+			if err := UnmarshalWith(&readBack, result, binary.BigEndian, inst); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(&readBack, createTestObject()) {
+				t.Errorf("call %d decoded %+v", i, readBack)
+			}
+		}
+	}
+}
+
+type hiddenField struct {
+	A    uint8
+	name string
+}
+
+type fixedHidden struct {
+	A uint8
+	b uint8
+}
+
+type paddedField struct {
+	A uint8
+	_ [2]byte
+	B uint8
+}
+
+func TestUnmarshalDestination(t *testing.T) {
+	var (
+		h     hiddenField
+		nilP  *hiddenField
+		m     = map[string]hiddenField{"x": {}}
+		inner struct{ H hiddenField }
+		fh    fixedHidden
+		fhs   [2]struct{ F fixedHidden }
+	)
+	for _, c := range []struct {
+		name string
+		dst  interface{}
+		msg  string
+	}{
+		{"nil", nil, "destination must be a pointer to a settable value; got nil"},
+		{"value", h, "destination must be a pointer to a settable value; got marshal.hiddenField"},
+		{"nil pointer", nilP, "destination must be a pointer to a settable value; got nil *marshal.hiddenField"},
+		{"map element", reflect.ValueOf(m).MapIndex(reflect.ValueOf("x")), "got reflect.Value of marshal.hiddenField that isn't addressable, such as a map element"},
+		{"reflect.Value", reflect.ValueOf(&h).Elem(), "got reflect.Value, pass v.Addr().Interface()"},
+		{"unexported field", &h, "cannot decode into hiddenField.name: unexported field"},
+		{"nested unexported field", &inner, ".H.name: unexported field"},
+		{"fixed-size unexported field", &fh, "cannot decode into fixedHidden.b: unexported field"},
+		{"unexported field in an array", &fhs, "[0].F.b: unexported field"},
+	} {
+		err := UnmarshalBytes(c.dst, []byte{1, 1, 'x'}, binary.BigEndian, BlobLength8)
+		if err == nil || !strings.Contains(err.Error(), c.msg) {
+			t.Errorf("%s: got %v, want %q", c.name, err, c.msg)
+		}
+	}
+	//fixed-size structs are copied whole, blank padding included
+	var p paddedField
+	if err := UnmarshalBytes(&p, []byte{1, 2, 3, 4}, binary.BigEndian, BlobLength8); err != nil || p.A != 1 || p.B != 4 {
+		t.Errorf("decoded %+v, %v", p, err)
+	}
+}
+
 func testBoundUnmarshal(t *testing.T, order binary.ByteOrder, length LengthType, srcLength LengthType) {
 	t.Logf("order:%s, length:%s", reflect.TypeOf(order).Name(), reflect.TypeOf(length).Name())
 	// iterate through the attributes of a Data Model instance
@@ -95,7 +177,7 @@ func testBoundUnmarshal(t *testing.T, order binary.ByteOrder, length LengthType,
 
 	e := Marshal(proto, result, order, srcLength)
 	if e != nil {
-		t.Error("error: %v\n", e)
+		t.Errorf("error: %v\n", e)
 	}
 
 	//buf := result.Bytes()
@@ -186,7 +268,7 @@ func testCombination(t *testing.T, order binary.ByteOrder, length LengthType) {
 
 	e := Marshal(proto, result, order, length)
 	if e != nil {
-		t.Error("error: %v\n", e)
+		t.Errorf("error: %v\n", e)
 	}
 
 	//buf := result.Bytes()
@@ -251,3 +333,55 @@ func deepPrint(m interface{}, t *testing.T, indent int) {
 		}
 	}
 }
+
+func TestUnmarshalStringAllocs(t *testing.T) {
+	for _, n := range []int{10, stageSize, 300} {
+		s := strings.Repeat("x", n)
+		b, err := MarshalBytes(s, binary.BigEndian, BlobLength16)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out, empty string
+		decode := func(b []byte, out *string) {
+			//a reader returning short reads
+			if err := Unmarshal(out, iotest.OneByteReader(bytes.NewReader(b)), binary.BigEndian, BlobLength16); err != nil {
+				t.Fatal(err)
+			}
+		}
+		none := []byte{0, 0}
+		base := testing.AllocsPerRun(50, func() { decode(none, &empty) })
+		allocs := testing.AllocsPerRun(50, func() { decode(b, &out) })
+		if out != s {
+			t.Errorf("decoded %q, want %q", out, s)
+		}
+		if allocs != base+1 {
+			t.Errorf("decoding a string of %d bytes allocates %v times over an empty one, want 1", n, allocs-base)
+		}
+	}
+}
+
+type shortStrings struct {
+	A, B, C, D, E, F, G, H, I, J string
+}
+
+func BenchmarkUnmarshalShortStrings(b *testing.B) {
+	v := shortStrings{"alpha", "beta", "gamma", "delta", "epsilon", "zeta", "eta", "theta", "iota", "kappa"}
+	buf, err := MarshalBytes(&v, binary.LittleEndian, BlobLength8)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Run("reader", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var readBack shortStrings
+			Unmarshal(&readBack, bytes.NewReader(buf), binary.LittleEndian, BlobLength8)
+		}
+	})
+	b.Run("bytes", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var readBack shortStrings
+			UnmarshalBytes(&readBack, buf, binary.LittleEndian, BlobLength8)
+		}
+	})
+}
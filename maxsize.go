@@ -0,0 +1,219 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+)
+
+//MaxSize reports the largest encoding of a value of type t with length. Strings,
+//slices and maps hold at most the bound of a Bound32 or Bound64 length type, or
+//else the largest length a fixed-width prefix can carry; max= tags bound strings
+//further. bounded is false and n is -1 when some value has no bound, e.g. with
+//CompactLength, for recursive types, interfaces, custom codecs or count= slices
+func MaxSize(t reflect.Type, length LengthType) (n int, bounded bool, err error) {
+	if t == nil {
+		return -1, false, errors.New("marshal: MaxSize(nil)")
+	}
+	s, err := describeType(t, noOptions)
+	if err != nil {
+		return -1, false, err
+	}
+	ms := maxSizer{length: length, busy: map[*Schema]bool{}}
+	n = ms.size(s, nil)
+	return n, n >= 0, nil
+}
+
+type maxSizer struct {
+	length LengthType
+	//busy holds the structs being sized, meeting one again means the type is recursive
+	busy map[*Schema]bool
+}
+
+//size is the largest encoding of s written with the field tag ft, -1 when there is none
+func (ms *maxSizer) size(s *Schema, ft *fieldTag) int {
+	if s.Size >= 0 {
+		return s.Size
+	}
+	if ft != nil && (ft.count != "" || ft.rest || ft.bitmap || ft.offset != "" || ft.codec != nil) {
+		return -1
+	}
+	if s.Custom || s.Optional {
+		return -1
+	}
+	if ft != nil && ft.bcdVar {
+		//a uint64 has at most 20 digits
+		b := 20
+		if s.Kind == reflect.String {
+			b = ms.bound(reflect.String)
+		}
+		if b < 0 {
+			return -1
+		}
+		return addSize(prefixSize(ms.length, s.Kind, b), bcdBytes(b))
+	}
+	if ft != nil && ft.delta {
+		//every element is a varint
+		if s.Kind == reflect.Array {
+			return mulSize(s.Len, binary.MaxVarintLen64)
+		}
+		return ms.prefixed(reflect.Slice, ms.bound(reflect.Slice), binary.MaxVarintLen64)
+	}
+	if ft != nil && ft.packbits > 0 && s.Kind == reflect.Slice {
+		l := ms.bound(reflect.Slice)
+		if l < 0 {
+			return -1
+		}
+		return addSize(prefixSize(ms.length, reflect.Slice, l), packbitsBytes(l, ft.packbits))
+	}
+	if ft != nil && ft.quantize != reflect.Invalid && s.Kind == reflect.Slice {
+		n := ms.prefixed(reflect.Slice, ms.bound(reflect.Slice), quantizeSize(ft.quantize))
+		if ft.scale == "" {
+			//the scale and bias
+			n = addSize(n, 16)
+		}
+		return n
+	}
+	if ft != nil && ft.rle {
+		//every element may be a run of its own
+		l := s.Len
+		if s.Kind == reflect.Slice {
+			l = ms.bound(reflect.Slice)
+		}
+		run := addSize(prefixSize(ms.length, reflect.Slice, max(l, 1)), ms.elems().size(s.Elem, nil))
+		if s.Kind == reflect.Array {
+			return mulSize(l, run)
+		}
+		return ms.prefixed(reflect.Slice, l, run)
+	}
+	switch s.Kind {
+	case reflect.String:
+		b := ms.bound(reflect.String)
+		if ft != nil && ft.max > 0 && (b < 0 || ft.max < b) {
+			b = ft.max
+		}
+		return ms.prefixed(reflect.String, b, 1)
+	case reflect.Slice:
+		return ms.prefixed(reflect.Slice, ms.bound(reflect.Slice), ms.elems().size(s.Elem, nil))
+	case reflect.Map:
+		elems := ms.elems()
+		k, v := elems.size(s.Key, nil), elems.size(s.Elem, nil)
+		if k < 0 || v < 0 {
+			return -1
+		}
+		return ms.prefixed(reflect.Map, ms.bound(reflect.Map), addSize(k, v))
+	case reflect.Array:
+		return mulSize(s.Len, ms.elems().size(s.Elem, nil))
+	case reflect.Ptr:
+		return ms.size(s.Elem, nil)
+	case reflect.Struct:
+		if ms.busy[s] {
+			return -1
+		}
+		ms.busy[s] = true
+		defer delete(ms.busy, s)
+		n := 0
+		//the fields of the schema are those of the plan
+		fields := planFor(s.Type).fields
+		for i, f := range s.Fields {
+			fft := fields[i].tag
+			if f.Bits > 0 {
+				//a group's bytes are counted at its last field
+				if i+1 == len(s.Fields) || s.Fields[i+1].BitOffset == 0 {
+					n = addSize(n, (f.BitOffset+f.Bits+7)/8)
+				}
+				continue
+			}
+			if fft != nil && (fft.length != nil || fft.elemLength != nil) {
+				//the field and what it holds use length types of their own
+				own := maxSizer{length: ms.length, busy: ms.busy}
+				if fft.length != nil {
+					own.length = fft.length
+				}
+				if fft.elemLength != nil {
+					own.length = ElemLength(own.length, fft.elemLength)
+				}
+				n = addSize(n, own.size(f.Schema, fft))
+				continue
+			}
+			n = addSize(n, ms.size(f.Schema, fft))
+		}
+		if n >= 0 && ft != nil && ft.delimited {
+			return addSize(prefixSize(ms.length, reflect.Struct, n), n)
+		}
+		if n >= 0 && ft != nil && ft.trimZero {
+			return addSize(prefixSize(ms.length, reflect.Struct, len(s.Fields)), n)
+		}
+		return n
+	}
+	return -1
+}
+
+//prefixed is the size of a length prefix holding up to l elements of at most elem bytes each and the elements
+func (ms *maxSizer) prefixed(k reflect.Kind, l, elem int) int {
+	if l < 0 || elem < 0 {
+		return -1
+	}
+	return addSize(prefixSize(ms.length, k, l), mulSize(l, elem))
+}
+
+//elems is the maxSizer of the values inside a container, see ElemLength
+func (ms *maxSizer) elems() *maxSizer {
+	d, ok := ms.length().(*elemLength)
+	if !ok {
+		return ms
+	}
+	return &maxSizer{length: func() LengthTypeInstance { return d.elems }, busy: ms.busy}
+}
+
+//bound is the largest length the length type writes for kind k, -1 when there is none
+func (ms *maxSizer) bound(k reflect.Kind) int {
+	length := ms.length()
+	if d, ok := length.(*elemLength); ok {
+		length = d.outer
+	}
+	switch b := length.(type) {
+	case *bound64:
+		return b.bound
+	case *bound32:
+		return b.bound
+	case *bcdLength:
+		return b.max
+	case *varintLength:
+		return 1<<(7*b.max) - 1
+	}
+	if w := lengthWidth(ms.length, k); w > 0 && w < 8 {
+		return 1<<(8*w) - 1
+	}
+	return -1
+}
+
+//prefixSize is the size of the length prefix length writes for l, -1 when it can't write l
+func prefixSize(length LengthType, k reflect.Kind, l int) (n int) {
+	defer func() {
+		if recover() != nil {
+			n = -1
+		}
+	}()
+	c := writeCounter{w: io.Discard}
+	length().PutLength(&c, binary.BigEndian, k, l)
+	return int(c.n)
+}
+
+//addSize adds sizes, the sum is -1 when either is or it overflows
+func addSize(a, b int) int {
+	if a < 0 || b < 0 || a > math.MaxInt-b {
+		return -1
+	}
+	return a + b
+}
+
+//mulSize multiplies a count and a size, the product is -1 when either is or it overflows
+func mulSize(l, n int) int {
+	if l < 0 || n < 0 || (n != 0 && l > math.MaxInt/n) {
+		return -1
+	}
+	return l * n
+}
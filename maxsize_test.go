@@ -0,0 +1,60 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type maxSizeMsg struct {
+	Kind  uint8
+	Flags schemaFlags
+	Name  string `marshal:"max=10"`
+	Tags  []uint16
+	Inner struct {
+		A [2]int32
+	} `marshal:"delimited"`
+}
+
+type maxSizeTree struct {
+	Children []maxSizeTree
+}
+
+func TestMaxSize(t *testing.T) {
+	cases := []struct {
+		v       interface{}
+		length  LengthType
+		n       int
+		bounded bool
+	}{
+		{Pod{}, BlobLength8, planFor(reflect.TypeOf(Pod{})).size, true},
+		{"", Bound32(100), 104, true},
+		{"", BlobLength8, 256, true},
+		{"", CompactLength, -1, false},
+		{[]uint32{}, BlobLength16, 2 + 65535*4, true},
+		{map[uint8]string{}, Bound64(3), 8 + 3*(1+8+3), true},
+		//kind, flags, name, tags, inner with its prefix
+		{maxSizeMsg{}, BlobLength8, 1 + 1 + (1 + 10) + (1 + 255*2) + (1 + 8), true},
+		{maxSizeMsg{}, Bound32(8), 1 + 1 + (4 + 8) + (4 + 8*2) + (4 + 8), true},
+		{maxSizeTree{}, BlobLength8, -1, false},
+		{[]interface{}{}, BlobLength8, -1, false},
+	}
+	for _, c := range cases {
+		n, bounded, err := MaxSize(reflect.TypeOf(c.v), c.length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != c.n || bounded != c.bounded {
+			t.Errorf("%T: got %d, %v, want %d, %v", c.v, n, bounded, c.n, c.bounded)
+		}
+	}
+	long := maxSizeMsg{Name: strings.Repeat("x", 8), Tags: make([]uint16, 8)}
+	n, _, _ := MaxSize(reflect.TypeOf(long), Bound32(8))
+	if size, err := Size(&long, binary.BigEndian, Bound32(8)); err != nil || size != n {
+		t.Errorf("largest value takes %d, %v, want %d", size, err, n)
+	}
+	if _, _, err := MaxSize(nil, BlobLength8); err == nil {
+		t.Errorf("expected an error for nil")
+	}
+}
@@ -0,0 +1,66 @@
+package marshal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+//sliceReader reads an in-memory input. The unmarshaler takes bytes straight out
+//of b instead of calling Read when it decodes from a sliceReader, see take
+type sliceReader struct {
+	b   []byte
+	off int
+}
+
+func (s *sliceReader) Read(p []byte) (int, error) {
+	if s.off >= len(s.b) {
+		if len(p) == 0 {
+			return 0, nil
+		}
+		return 0, io.EOF
+	}
+	n := copy(p, s.b[s.off:])
+	s.off += n
+	return n, nil
+}
+
+func (s *sliceReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += int64(s.off)
+	case io.SeekEnd:
+		offset += int64(len(s.b))
+	}
+	if offset < 0 {
+		return 0, errors.New("marshal: seek to a negative offset")
+	}
+	s.off = int(min(offset, int64(len(s.b))))
+	return offset, nil
+}
+
+//direct reports whether u can take its input straight from memory, bounded
+//reads such as delimited structs go through Read
+func (u *unmarshaler) direct() bool {
+	return u.src != nil && u.r == &u.cr
+}
+
+//take returns the next n input bytes without copying them, it must only be used
+//when direct. A short input fails like io.ReadFull, with the offset and path of
+//the value cut short when some of its bytes are there
+func (u *unmarshaler) take(n int) []byte {
+	s := u.src
+	if left := len(s.b) - s.off; n > left {
+		s.off = len(s.b)
+		start := u.cr.n
+		u.cr.n += int64(left)
+		if left == 0 {
+			panic(io.EOF)
+		}
+		panic(fmt.Errorf("unmarshal: %s: %d of %d bytes at offset %d: %w", formatPath(u.path), left, n, start, io.ErrUnexpectedEOF))
+	}
+	b := s.b[s.off : s.off+n : s.off+n]
+	s.off += n
+	u.cr.n += int64(n)
+	return b
+}
@@ -0,0 +1,99 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnmarshalBytesTruncated(t *testing.T) {
+	b, err := MarshalBytes(createStableObject(), binary.LittleEndian, BlobLength16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var full Foo
+	if err := UnmarshalBytes(&full, b, binary.LittleEndian, BlobLength16); err != nil {
+		t.Fatal(err)
+	}
+	if d := firstDiff(reflect.ValueOf(createStableObject()), reflect.ValueOf(&full)); d != nil {
+		t.Errorf("decoded value differs: %v", d)
+	}
+	//every cut fails like it does when reading through an io.Reader
+	for i := 0; i < len(b); i++ {
+		var fromBytes, fromReader Foo
+		e1 := UnmarshalBytes(&fromBytes, b[:i], binary.LittleEndian, BlobLength16)
+		e2 := Unmarshal(&fromReader, onlyReader{bytes.NewReader(b[:i])}, binary.LittleEndian, BlobLength16)
+		if e1 == nil || !errors.Is(e1, e2) {
+			t.Fatalf("cut at %d: got %v, reading gives %v", i, e1, e2)
+		}
+	}
+	//a value cut short names its path and offset
+	var cut Foo
+	err = UnmarshalBytes(&cut, b[:1], binary.LittleEndian, BlobLength16)
+	if !errors.Is(err, io.ErrUnexpectedEOF) || !strings.Contains(err.Error(), "Foo.Uri: 1 of 255 bytes at offset 0") {
+		t.Errorf("got %v", err)
+	}
+	//a clean end of input is io.EOF itself
+	if err := UnmarshalBytes(&full, nil, binary.LittleEndian, BlobLength16); err != io.EOF {
+		t.Errorf("got %v, want io.EOF", err)
+	}
+}
+
+func TestBytesDecoder(t *testing.T) {
+	foos, stream := fooStream(t, 4, binary.BigEndian, BlobLength32)
+	d := NewBytesDecoder(stream, binary.BigEndian, BlobLength32)
+	var first Foo
+	if !d.More() {
+		t.Fatal("expected values")
+	}
+	if err := d.Decode(&first); err != nil {
+		t.Fatal(err)
+	}
+	var rest []Foo
+	if err := d.DecodeAll(&rest, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := append([]Foo{first}, rest...); len(got) != len(foos) {
+		t.Errorf("decoded %d values, want %d", len(got), len(foos))
+	} else if d := firstDiff(reflect.ValueOf(got), reflect.ValueOf(foos)); d != nil {
+		t.Errorf("decoded values differ: %v", d)
+	}
+	if d.More() {
+		t.Errorf("expected the end of the input")
+	}
+	if err := d.Decode(&first); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+	d = NewBytesDecoder(stream[:len(stream)-1], binary.BigEndian, BlobLength32)
+	rest = nil
+	if err := d.DecodeAll(&rest, 0); err == nil || len(rest) != 3 {
+		t.Errorf("expected a failure after 3 values, got %d, %v", len(rest), err)
+	}
+	d.Reset(bytes.NewReader(stream))
+	if err := d.Decode(&first); err != nil || firstDiff(reflect.ValueOf(first), reflect.ValueOf(foos[0])) != nil {
+		t.Errorf("decode after Reset: %+v, %v", first, err)
+	}
+}
+
+func BenchmarkUnmarshalBytes(b *testing.B) {
+	buf, err := MarshalBytes(createPodObject(), binary.LittleEndian, BlobLength32)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Run("reader", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var readBack Pod
+			Unmarshal(&readBack, bytes.NewReader(buf), binary.LittleEndian, BlobLength32)
+		}
+	})
+	b.Run("bytes", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var readBack Pod
+			UnmarshalBytes(&readBack, buf, binary.LittleEndian, BlobLength32)
+		}
+	})
+}
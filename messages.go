@@ -0,0 +1,92 @@
+package marshal
+
+import (
+	"fmt"
+	"io"
+	"iter"
+	"reflect"
+)
+
+//ReuseValues makes Messages and Decoder.Values decode every value into the same
+//one, zeroed first, so a yielded value is only valid until the loop continues
+func ReuseValues() Option {
+	return func(o *options) {
+		o.reuse = true
+	}
+}
+
+//Messages yields the values left in the stream of d, each decoded into a new *T
+//or into the same one with ReuseValues, until the stream ends. A failure is
+//yielded once with a nil value and ends the sequence, a value cut short is
+//reported as io.ErrUnexpectedEOF
+func Messages[T any](d *Decoder) iter.Seq2[*T, error] {
+	return func(yield func(*T, error) bool) {
+		var v *T
+		for {
+			if v == nil || !d.o.reuse {
+				v = new(T)
+			} else {
+				var zero T
+				*v = zero
+			}
+			if err := d.next(v); err == io.EOF {
+				return
+			} else if err != nil {
+				yield(nil, err)
+				return
+			}
+			if !yield(v, nil) {
+				return
+			}
+		}
+	}
+}
+
+//Values is Messages for a type known at run time: it yields the values left in
+//the stream as pointers to values of proto's type, or of the type proto points to
+func (d *Decoder) Values(proto interface{}) iter.Seq2[interface{}, error] {
+	return func(yield func(interface{}, error) bool) {
+		t := reflect.TypeOf(proto)
+		if t == nil {
+			yield(nil, fmt.Errorf("unmarshal: Values of nil"))
+			return
+		}
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		var v reflect.Value
+		for {
+			if !v.IsValid() || !d.o.reuse {
+				v = reflect.New(t)
+			} else {
+				v.Elem().SetZero()
+			}
+			if err := d.next(v.Interface()); err == io.EOF {
+				return
+			} else if err != nil {
+				yield(nil, err)
+				return
+			}
+			if !yield(v.Interface(), nil) {
+				return
+			}
+		}
+	}
+}
+
+//next decodes the next value of the stream into m, it reports io.EOF only at the
+//end of the stream
+func (d *Decoder) next(m interface{}) error {
+	if err := d.peek(); err != nil {
+		return err
+	}
+	d.last = reflect.TypeOf(m)
+	start := d.mark()
+	n, err := decode(m, d.input(), d.order, d.length, d.o)
+	d.fail(start, err)
+	d.settle(err)
+	if err == io.EOF && n > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,86 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestMessages(t *testing.T) {
+	foos, stream := fooStream(t, 4, binary.BigEndian, BlobLength16)
+	var got []*Foo
+	for v, err := range Messages[Foo](NewDecoder(bytes.NewReader(stream), binary.BigEndian, BlobLength16)) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, v)
+	}
+	if len(got) != len(foos) {
+		t.Fatalf("got %d values, want %d", len(got), len(foos))
+	}
+	for i := range got {
+		if d := firstDiff(reflect.ValueOf(*got[i]), reflect.ValueOf(foos[i])); d != nil {
+			t.Errorf("value %d differs: %v", i, d)
+		}
+	}
+	//reused values are the same pointer, decoded over
+	var first *Foo
+	count := 0
+	for v, err := range Messages[Foo](NewBytesDecoder(stream, binary.BigEndian, BlobLength16, ReuseValues())) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first == nil {
+			first = v
+		} else if v != first {
+			t.Errorf("value %d isn't reused", count)
+		}
+		if d := firstDiff(reflect.ValueOf(*v), reflect.ValueOf(foos[count])); d != nil {
+			t.Errorf("reused value %d differs: %v", count, d)
+		}
+		count++
+	}
+	//stopping early and errors
+	for range Messages[Foo](NewBytesDecoder(stream, binary.BigEndian, BlobLength16)) {
+		break
+	}
+	var errs []error
+	count = 0
+	for v, err := range Messages[Foo](NewBytesDecoder(stream[:len(stream)-1], binary.BigEndian, BlobLength16)) {
+		if err != nil {
+			errs = append(errs, err)
+			if v != nil {
+				t.Errorf("value yielded with an error")
+			}
+		} else {
+			count++
+		}
+	}
+	if count != 3 || len(errs) != 1 || errs[0] != io.ErrUnexpectedEOF {
+		t.Errorf("got %d values and errors %v, want 3 and io.ErrUnexpectedEOF", count, errs)
+	}
+}
+
+func TestDecoderValues(t *testing.T) {
+	foos, stream := fooStream(t, 3, binary.LittleEndian, BlobLength32)
+	i := 0
+	for v, err := range NewBytesDecoder(stream, binary.LittleEndian, BlobLength32).Values(&Foo{}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := firstDiff(reflect.ValueOf(v.(*Foo)), reflect.ValueOf(&foos[i])); d != nil {
+			t.Errorf("value %d differs: %v", i, d)
+		}
+		i++
+	}
+	if i != len(foos) {
+		t.Errorf("got %d values, want %d", i, len(foos))
+	}
+	for _, err := range NewBytesDecoder(stream, binary.LittleEndian, BlobLength32).Values(nil) {
+		if err == nil {
+			t.Errorf("expected an error for nil")
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+)
+
+var (
+	messageLock  sync.RWMutex
+	messageIDs   = map[reflect.Type]uint32{}
+	messageTypes = map[uint32]reflect.Type{}
+)
+
+//RegisterMessage registers the type of prototype, or the type it points to,
+//under id for ReadAny and WriteAny, e.g. RegisterMessage(7, &Login{}).
+//Ids are independent of those of RegisterType
+func RegisterMessage(id uint32, prototype interface{}) {
+	t := reflect.TypeOf(prototype)
+	if t == nil {
+		panic(fmt.Errorf("marshal: RegisterMessage(%d, nil)", id))
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	messageLock.Lock()
+	defer messageLock.Unlock()
+	if prev, ok := messageTypes[id]; ok && prev != t {
+		panic(fmt.Errorf("marshal: RegisterMessage: id %d is taken by %s", id, prev))
+	}
+	messageTypes[id], messageIDs[t] = t, id
+}
+
+//MessageID sets the width in bytes, 1, 2 or 4, and the byte order of the
+//message ids of ReadAny and WriteAny. They are 2 bytes in the order of the
+//message by default, a nil order keeps that order
+func MessageID(width int, order binary.ByteOrder) Option {
+	switch width {
+	case 1, 2, 4:
+	default:
+		panic(fmt.Errorf("marshal: MessageID width %d, want 1, 2 or 4", width))
+	}
+	return func(o *options) {
+		o.idWidth, o.idOrder = width, order
+	}
+}
+
+//WriteAny writes the id msg's type is registered under with RegisterMessage,
+//then msg. A *RawElement returned by ReadAny is written back unchanged
+func WriteAny(w io.Writer, msg interface{}, order binary.ByteOrder, length LengthType, opts ...Option) error {
+	o := newOptions(opts)
+	if raw, ok := msg.(*RawElement); ok {
+		if err := putMessageID(w, raw.ID, order, o); err != nil {
+			return err
+		}
+		_, err := w.Write(raw.Body)
+		return err
+	}
+	t := reflect.TypeOf(msg)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	messageLock.RLock()
+	id, ok := messageIDs[t]
+	messageLock.RUnlock()
+	if !ok {
+		return fmt.Errorf("marshal: %T is not registered with RegisterMessage", msg)
+	}
+	if err := putMessageID(w, uint64(id), order, o); err != nil {
+		return err
+	}
+	_, err := encode(msg, w, order, length(), o)
+	return err
+}
+
+//ReadAny reads a message id and decodes the message of the type registered
+//under it, returning a pointer to it. The body of an unregistered id is the
+//rest of r, it is returned as a *RawElement, so ReadAny on a stream needs each
+//message framed, e.g. by an io.LimitReader. io.EOF is returned only when r
+//ends before the id
+func ReadAny(r io.Reader, order binary.ByteOrder, length LengthType, opts ...Option) (id uint32, msg interface{}, err error) {
+	return readAny(r, order, length(), newOptions(opts))
+}
+
+//ReadAny is ReadAny on the stream of d with its settings. The body of an
+//unregistered id is the rest of the stream
+func (d *Decoder) ReadAny() (id uint32, msg interface{}, err error) {
+	id, msg, err = readAny(d.input(), d.order, d.length, d.o)
+	d.settle(err)
+	return
+}
+
+//PeekID returns the id of the next message of the stream, as ReadAny reads it,
+//without consuming it. Dispatch code can pick the type to Decode from it, the
+//type decodes the id as part of its value
+func (d *Decoder) PeekID() (uint32, error) {
+	width, idOrder := messageIDFormat(d.order, d.o)
+	b, err := d.Peek(width)
+	if err == io.EOF && len(b) > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return 0, err
+	}
+	return messageID(b, width, idOrder), nil
+}
+
+//messageID decodes the message id of width bytes at the start of b
+func messageID(b []byte, width int, idOrder binary.ByteOrder) uint32 {
+	switch width {
+	case 1:
+		return uint32(b[0])
+	case 2:
+		return uint32(idOrder.Uint16(b))
+	}
+	return idOrder.Uint32(b)
+}
+
+//readAny is ReadAny with a length type instance and its options
+func readAny(r io.Reader, order binary.ByteOrder, length LengthTypeInstance, o *options) (id uint32, msg interface{}, err error) {
+	width, idOrder := messageIDFormat(order, o)
+	var b [4]byte
+	if _, err := io.ReadFull(r, b[:width]); err != nil {
+		return 0, nil, err
+	}
+	id = messageID(b[:], width, idOrder)
+	messageLock.RLock()
+	t, ok := messageTypes[id]
+	messageLock.RUnlock()
+	if !ok {
+		body, err := io.ReadAll(r)
+		if err != nil {
+			return id, nil, err
+		}
+		return id, &RawElement{ID: uint64(id), Body: body}, nil
+	}
+	v := reflect.New(t)
+	if _, err := decode(v.Interface(), r, order, length, o); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return id, nil, err
+	}
+	return id, v.Interface(), nil
+}
+
+func messageIDFormat(order binary.ByteOrder, o *options) (int, binary.ByteOrder) {
+	width, idOrder := o.idWidth, o.idOrder
+	if width == 0 {
+		width = 2
+	}
+	if idOrder == nil {
+		idOrder = order
+	}
+	return width, idOrder
+}
+
+func putMessageID(w io.Writer, id uint64, order binary.ByteOrder, o *options) error {
+	width, idOrder := messageIDFormat(order, o)
+	if id>>(8*width) != 0 {
+		return fmt.Errorf("marshal: message id %d does not fit in %d bytes", id, width)
+	}
+	var b [4]byte
+	switch width {
+	case 1:
+		b[0] = byte(id)
+	case 2:
+		idOrder.PutUint16(b[:], uint16(id))
+	default:
+		idOrder.PutUint32(b[:], uint32(id))
+	}
+	_, err := w.Write(b[:width])
+	return err
+}
@@ -0,0 +1,121 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"testing"
+)
+
+type muxLogin struct {
+	User string
+	Code uint16
+}
+
+type muxPing struct {
+	Seq uint32
+}
+
+func init() {
+	RegisterMessage(1, &muxLogin{})
+	RegisterMessage(2, muxPing{})
+}
+
+func TestReadAny(t *testing.T) {
+	messages := []interface{}{&muxLogin{"ann", 7}, &muxPing{0x01020304}}
+	var buf bytes.Buffer
+	for _, msg := range messages {
+		if err := WriteAny(&buf, msg, binary.BigEndian, BlobLength8); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []byte{0, 1, 3, 'a', 'n', 'n', 0, 7, 0, 2, 1, 2, 3, 4}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("encoded % x, want % x", buf.Bytes(), expected)
+	}
+	for i, msg := range messages {
+		id, readBack, err := ReadAny(&buf, binary.BigEndian, BlobLength8)
+		if err != nil || id != uint32(i+1) || !reflect.DeepEqual(readBack, msg) {
+			t.Errorf("read %d %#v, %v, want %d %#v", id, readBack, err, i+1, msg)
+		}
+	}
+	if _, _, err := ReadAny(&buf, binary.BigEndian, BlobLength8); err != io.EOF {
+		t.Errorf("expected io.EOF at the end, got %v", err)
+	}
+	if err := WriteAny(&buf, &Foo{}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for an unregistered type")
+	}
+}
+
+func TestDecoderReadAny(t *testing.T) {
+	var buf bytes.Buffer
+	messages := []interface{}{&muxPing{9}, &muxLogin{"bo", 1}}
+	for _, msg := range messages {
+		if err := WriteAny(&buf, msg, binary.LittleEndian, BlobLength8, MessageID(1, nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := NewDecoder(io.MultiReader(&buf), binary.LittleEndian, BlobLength8, MessageID(1, nil))
+	for i, msg := range messages {
+		id, err := d.PeekID()
+		want := []uint32{2, 1}[i]
+		if err != nil || id != want {
+			t.Fatalf("peeked id %d, %v, want %d", id, err, want)
+		}
+		id, readBack, err := d.ReadAny()
+		if err != nil || id != want || !reflect.DeepEqual(readBack, msg) {
+			t.Errorf("read %d %#v, %v, want %d %#v", id, readBack, err, want, msg)
+		}
+	}
+	if _, err := d.PeekID(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+	if _, _, err := d.ReadAny(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+	d = NewBytesDecoder([]byte{1}, binary.LittleEndian, BlobLength8)
+	if _, err := d.PeekID(); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF for a short id, got %v", err)
+	}
+}
+
+func TestReadAnyUnknown(t *testing.T) {
+	b := []byte{0x99, 0, 0, 0, 0xee, 0xff}
+	id, msg, err := ReadAny(bytes.NewReader(b), binary.LittleEndian, BlobLength8, MessageID(4, nil))
+	if err != nil || id != 0x99 {
+		t.Fatalf("read %d, %v", id, err)
+	}
+	raw, ok := msg.(*RawElement)
+	if !ok || !bytes.Equal(raw.Body, []byte{0xee, 0xff}) {
+		t.Fatalf("read %#v, want the raw body", msg)
+	}
+	var buf bytes.Buffer
+	if err := WriteAny(&buf, raw, binary.LittleEndian, BlobLength8, MessageID(4, nil)); err != nil || !bytes.Equal(buf.Bytes(), b) {
+		t.Errorf("forwarded % x, %v, want % x", buf.Bytes(), err, b)
+	}
+}
+
+func TestMessageID(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteAny(&buf, &muxPing{5}, binary.LittleEndian, BlobLength8, MessageID(1, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{2, 5, 0, 0, 0}; !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("encoded % x, want % x", buf.Bytes(), expected)
+	}
+	buf.Reset()
+	if err := WriteAny(&buf, &muxPing{5}, binary.LittleEndian, BlobLength8, MessageID(2, binary.BigEndian)); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0, 2, 5, 0, 0, 0}; !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("encoded % x, want % x", buf.Bytes(), expected)
+	}
+	raw := &RawElement{ID: 300}
+	if err := WriteAny(&buf, raw, binary.LittleEndian, BlobLength8, MessageID(1, nil)); err == nil {
+		t.Errorf("expected an error for an id wider than 1 byte")
+	}
+	if _, _, err := ReadAny(bytes.NewReader([]byte{0, 1, 2}), binary.BigEndian, BlobLength8); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF for a short message, got %v", err)
+	}
+}
@@ -0,0 +1,33 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+//MustMarshalBytes is like MarshalBytes but panics if v can't be encoded.
+//It simplifies initialization of constant wire fixtures
+func MustMarshalBytes(v interface{}, order binary.ByteOrder, length LengthType, opts ...Option) []byte {
+	b, err := MarshalBytes(v, order, length, opts...)
+	if err != nil {
+		panic(fmt.Errorf("marshal: MustMarshalBytes(%T): %w", v, err))
+	}
+	return b
+}
+
+//MustUnmarshal is like Unmarshal but panics if r can't be decoded into m
+func MustUnmarshal(m interface{}, r io.Reader, order binary.ByteOrder, length LengthType, opts ...Option) {
+	if err := Unmarshal(m, r, order, length, opts...); err != nil {
+		panic(fmt.Errorf("marshal: MustUnmarshal(%T): %w", m, err))
+	}
+}
+
+//MustSize is like Size but panics if v can't be encoded
+func MustSize(v interface{}, order binary.ByteOrder, length LengthType, opts ...Option) int {
+	n, err := Size(v, order, length, opts...)
+	if err != nil {
+		panic(fmt.Errorf("marshal: MustSize(%T): %w", v, err))
+	}
+	return n
+}
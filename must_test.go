@@ -0,0 +1,35 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestMust(t *testing.T) {
+	b := MustMarshalBytes(uint32(7), binary.BigEndian, BlobLength8)
+	if !bytes.Equal(b, []byte{0, 0, 0, 7}) {
+		t.Errorf("MustMarshalBytes: %x", b)
+	}
+	if n := MustSize("abc", binary.BigEndian, BlobLength16); n != 5 {
+		t.Errorf("MustSize: %d, want 5", n)
+	}
+	var v uint32
+	MustUnmarshal(&v, bytes.NewReader(b), binary.BigEndian, BlobLength8)
+	if v != 7 {
+		t.Errorf("MustUnmarshal: %d, want 7", v)
+	}
+}
+
+func TestMustPanics(t *testing.T) {
+	defer func() {
+		e, ok := recover().(error)
+		if !ok || !errors.Is(e, io.ErrUnexpectedEOF) {
+			t.Errorf("MustUnmarshal panicked with %v, want wrapped %v", e, io.ErrUnexpectedEOF)
+		}
+	}()
+	var v uint32
+	MustUnmarshal(&v, bytes.NewReader([]byte{1, 2}), binary.BigEndian, BlobLength8)
+}
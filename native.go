@@ -0,0 +1,73 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"reflect"
+	"unsafe"
+)
+
+//NativeEndian is the byte order of the host, binary.NativeEndian. Values encoded
+//with it are copies of their memory, which suits shared memory and files mapped
+//by programs on the same machine. Arrays and slices of fixed-size numbers are
+//copied whole with it, or with the one of binary.LittleEndian and
+//binary.BigEndian that matches the host
+var NativeEndian = binary.NativeEndian
+
+//hostLittle is set on little-endian hosts
+var hostLittle = binary.NativeEndian.Uint16([]byte{1, 0}) == 1
+
+//isNative reports whether order encodes numbers as the host stores them
+func isNative(order binary.ByteOrder) bool {
+	switch order {
+	case NativeEndian:
+		return true
+	case binary.LittleEndian:
+		return hostLittle
+	case binary.BigEndian:
+		return !hostLittle
+	}
+	return false
+}
+
+//nativeView returns the memory of an addressable array or a slice of fixed-size
+//numbers when order is the host's, it is their encoding. nil when v can't be
+//viewed that way
+func nativeView(v reflect.Value, order binary.ByteOrder) []byte {
+	if !isNative(order) {
+		return nil
+	}
+	bs, _ := numberMemory(v)
+	return bs
+}
+
+//numberMemory returns the memory of an addressable array or a slice of fixed-size
+//numbers and the size of the words making up the numbers, nil when v can't be
+//viewed that way
+func numberMemory(v reflect.Value) ([]byte, int) {
+	elem := v.Type().Elem()
+	word := numberWord(elem)
+	if word == 0 || v.Len() == 0 || (v.Kind() == reflect.Array && !v.CanAddr()) {
+		return nil, 0
+	}
+	return unsafe.Slice((*byte)(v.Index(0).Addr().UnsafePointer()), v.Len()*int(elem.Size())), word
+}
+
+//numberWord is the size of the words making up numbers of type t, 0 when t isn't
+//a fixed-size number encoded as stored
+func numberWord(t reflect.Type) int {
+	switch t.Kind() {
+	case reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+	case reflect.Complex64, reflect.Complex128:
+		if isCustom(t) {
+			return 0
+		}
+		return int(t.Size()) / 2
+	default:
+		return 0
+	}
+	if isCustom(t) {
+		return 0
+	}
+	return int(t.Size())
+}
@@ -0,0 +1,84 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+	"unsafe"
+)
+
+type nativeSamples struct {
+	ID      uint16
+	Samples []float32
+	Wide    []int64
+	Pair    [2]complex128
+	Counts  [3]uint32
+	Levels  []level
+}
+
+//level is custom, its elements can't be copied
+type level uint16
+
+func (l level) MarshalWire(w *Writer) error {
+	return w.PutUint8(uint8(l))
+}
+
+func (l *level) UnmarshalWire(r *Reader) error {
+	b, err := r.Uint8()
+	*l = level(b)
+	return err
+}
+
+func TestNativeEndian(t *testing.T) {
+	host := binary.ByteOrder(binary.BigEndian)
+	if x := uint16(1); *(*byte)(unsafe.Pointer(&x)) == 1 {
+		host = binary.LittleEndian
+	}
+	if !isNative(NativeEndian) || !isNative(host) || isNative(PDPEndian) {
+		t.Errorf("isNative is wrong for %v", host)
+	}
+	v := nativeSamples{
+		ID:      7,
+		Samples: []float32{1.5, float32(math.Inf(1)), -0},
+		Wide:    []int64{-1, math.MaxInt64},
+		Pair:    [2]complex128{complex(1, -2), 3},
+		Counts:  [3]uint32{1, 2, 0xffffffff},
+		Levels:  []level{1, 2},
+	}
+	b, err := MarshalBytes(&v, NativeEndian, BlobLength16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the same bytes as the explicit order of the host, without the copies
+	want, err := MarshalBytes(&v, host, BlobLength16, WithTrace(func(TraceEvent) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, want) {
+		t.Errorf("encoded % x, want % x", b, want)
+	}
+	for _, order := range []binary.ByteOrder{NativeEndian, host} {
+		var readBack nativeSamples
+		if err := UnmarshalBytes(&readBack, b, order, BlobLength16); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(readBack, v) {
+			t.Errorf("%v decoded %+v, want %+v", order, readBack, v)
+		}
+		//from a reader too, not only from memory
+		readBack = nativeSamples{}
+		if err := Unmarshal(&readBack, bytes.NewReader(b), order, BlobLength16); err != nil || !reflect.DeepEqual(readBack, v) {
+			t.Errorf("%v decoded %+v, %v from a reader", order, readBack, err)
+		}
+	}
+	//the elements of a slice are its memory
+	s := []uint32{0x01020304, 5}
+	if got := nativeView(reflect.ValueOf(s), NativeEndian); len(got) != 8 || &got[0] != (*byte)(unsafe.Pointer(&s[0])) {
+		t.Errorf("viewed % x", got)
+	}
+	if nativeView(reflect.ValueOf([]level{1}), NativeEndian) != nil {
+		t.Errorf("viewed custom elements")
+	}
+}
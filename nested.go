@@ -0,0 +1,180 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"reflect"
+	"unsafe"
+)
+
+//frame is an array or slice being walked by nested, i is its next element
+type frame struct {
+	v reflect.Value
+	i int
+}
+
+//nestedLevels is the nested count of the array or slice type t of plan p: the
+//levels from t down that have a variable size and hold arrays or slices
+func nestedLevels(t reflect.Type, p *typePlan) int {
+	e := t.Elem()
+	if p.size >= 0 || (e.Kind() != reflect.Array && e.Kind() != reflect.Slice) || p.elem.custom || p.elem.optional {
+		return 0
+	}
+	return 1 + p.elem.nested
+}
+
+//nestedLeaf returns the size of the words of the numbers held by the slices below
+//the levels top levels of the nested type t, 0 unless their memory is their
+//encoding in order, or its byte swap
+func nestedLeaf(t reflect.Type, levels int, order binary.ByteOrder) int {
+	for ; levels > 0; levels-- {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Slice || (!isNative(order) && !isSwapped(order)) {
+		return 0
+	}
+	if p := planFor(t); p.custom || p.optional {
+		return 0
+	}
+	return numberWord(t.Elem())
+}
+
+//sliceMemory returns the memory of the elements of the slice v of numbers
+func sliceMemory(v reflect.Value) []byte {
+	return unsafe.Slice((*byte)(v.UnsafePointer()), v.Len()*int(v.Type().Elem().Size()))
+}
+
+//flat reports whether nested can stand for the calls per level of marshal: the
+//options that act on every value, or on the elements of the top level, are off
+func (m *marshaler) flat() bool {
+	return m.trace == nil && m.shared == nil && m.index == nil
+}
+
+//nested writes the array or slice v whose levels top levels hold arrays and
+//slices, walking those levels with a stack of open containers rather than a
+//call per level. The containers below them are written by marshalValue
+func (m *marshaler) nested(v reflect.Value, levels int, length LengthTypeInstance) {
+	//a container below may be nested too and take a stack of its own
+	stack := m.frames
+	m.frames = nil
+	word := nestedLeaf(v.Type(), levels, m.order)
+	m.open(v, length)
+	stack = append(stack, frame{v: v})
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		if top.i == top.v.Len() {
+			stack[len(stack)-1] = frame{}
+			stack = stack[:len(stack)-1]
+			if len(stack) > 0 {
+				m.pop()
+			}
+			continue
+		}
+		e := top.v.Index(top.i)
+		m.push(indexElem(top.i))
+		top.i++
+		if len(stack) == levels && word > 0 {
+			m.numbers(e, length, word)
+			m.pop()
+			continue
+		}
+		if len(stack) == levels {
+			m.marshalValue(e, length)
+			m.pop()
+			continue
+		}
+		m.open(e, length)
+		stack = append(stack, frame{v: e})
+	}
+	m.frames = stack
+}
+
+//open writes the length prefix of a slice about to be walked by nested
+func (m *marshaler) open(v reflect.Value, length LengthTypeInstance) {
+	if v.Kind() == reflect.Slice {
+		m.putLength(length, v.Type(), v.Len())
+	}
+}
+
+//numbers writes the slice v of numbers made of words of size bytes as
+//marshalValue does, without looking its type up again
+func (m *marshaler) numbers(v reflect.Value, length LengthTypeInstance, size int) {
+	m.putLength(length, v.Type(), v.Len())
+	if v.Len() == 0 {
+		return
+	}
+	if bs := sliceMemory(v); isNative(m.order) {
+		if _, err := m.w.Write(bs); err != nil {
+			panic(err)
+		}
+	} else {
+		m.swapped(bs, size)
+	}
+}
+
+//nested reads what marshaler.nested writes
+func (u *unmarshaler) nested(v reflect.Value, levels int, order binary.ByteOrder, length LengthTypeInstance) {
+	stack := u.frames
+	u.frames = nil
+	word := nestedLeaf(v.Type(), levels, order)
+	if u.open(v, order, length) {
+		stack = append(stack, frame{v: v})
+	}
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		if top.i == top.v.Len() {
+			stack[len(stack)-1] = frame{}
+			stack = stack[:len(stack)-1]
+			if len(stack) > 0 {
+				u.pop()
+			}
+			continue
+		}
+		e := top.v.Index(top.i)
+		u.push(indexElem(top.i))
+		top.i++
+		if len(stack) == levels && word > 0 {
+			u.numbers(e, order, length, word)
+			u.pop()
+			continue
+		}
+		if len(stack) == levels {
+			u.unmarshalValue(e, order, length)
+			u.pop()
+			continue
+		}
+		if u.open(e, order, length) {
+			stack = append(stack, frame{v: e})
+		} else {
+			u.pop()
+		}
+	}
+	u.frames = stack
+}
+
+//open reads the length prefix of a slice about to be walked by nested and makes
+//it, it reports whether v has elements to read
+func (u *unmarshaler) open(v reflect.Value, order binary.ByteOrder, length LengthTypeInstance) bool {
+	if v.Kind() != reflect.Slice {
+		return v.Len() > 0
+	}
+	l := u.getLength(length, order, v.Type())
+	if l == 0 {
+		return false
+	}
+	u.makeSlice(v, l)
+	return true
+}
+
+//numbers reads what marshaler.numbers writes
+func (u *unmarshaler) numbers(v reflect.Value, order binary.ByteOrder, length LengthTypeInstance, size int) {
+	l := u.getLength(length, order, v.Type())
+	if l == 0 {
+		return
+	}
+	u.makeSlice(v, l)
+	if bs := sliceMemory(v); isNative(order) {
+		u.readInto(bs)
+	} else {
+		u.swapped(bs, size)
+	}
+}
@@ -0,0 +1,131 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type nestedMsg struct {
+	Cube   [][][]uint16
+	Rows   [][2][]string
+	Fixed  [][2][3]int32
+	Tensor [2][][]float32
+	Empty  [][]int8
+	Inner  [][]nestedMsg
+}
+
+func TestNestedLevels(t *testing.T) {
+	cases := []struct {
+		v      interface{}
+		levels int
+	}{
+		{[][][]uint16{}, 2},
+		{[][2][]string{}, 2},
+		{[][2][3]int32{}, 1},
+		{[2][3]int32{}, 0},
+		{[]uint16{}, 0},
+		{[][]byte{}, 1},
+		{[]nestedRow{}, 1},
+	}
+	for _, c := range cases {
+		if n := planFor(reflect.TypeOf(c.v)).nested; n != c.levels {
+			t.Errorf("%T: %d nested levels, want %d", c.v, n, c.levels)
+		}
+	}
+}
+
+type nestedRow []float64
+
+func TestNested(t *testing.T) {
+	v := nestedMsg{
+		Cube:   [][][]uint16{{{1, 2}, {}}, nil, {{3}}},
+		Rows:   [][2][]string{{{"a", "b"}, nil}, {{}, {"c"}}},
+		Fixed:  [][2][3]int32{{{1, 2, 3}, {4, 5, 6}}},
+		Tensor: [2][][]float32{{{1.5}}, {{}, {2, 3}}},
+		Empty:  [][]int8{},
+		Inner:  [][]nestedMsg{{{Cube: [][][]uint16{{{9}}}}}},
+	}
+	b, err := MarshalBytes(&v, binary.BigEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//tracing makes marshal walk one level per call
+	want, err := MarshalBytes(&v, binary.BigEndian, BlobLength8, WithTrace(func(TraceEvent) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, want) {
+		t.Errorf("encoded % x, want % x", b, want)
+	}
+	var readBack, traced nestedMsg
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, BlobLength8); err != nil {
+		t.Fatal(err)
+	}
+	if err := UnmarshalBytes(&traced, b, binary.BigEndian, BlobLength8, WithTrace(func(TraceEvent) {})); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(readBack, traced) {
+		t.Errorf("read back %+v, want %+v", readBack, traced)
+	}
+	//empty slices come back nil
+	if again, err := MarshalBytes(&readBack, binary.BigEndian, BlobLength8); err != nil || !bytes.Equal(again, b) {
+		t.Errorf("read back %+v, encoded again % x, %v", readBack, again, err)
+	}
+	//and in the host's order too
+	le, err := MarshalBytes(&v, binary.LittleEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := MarshalBytes(&v, binary.LittleEndian, BlobLength8, WithTrace(func(TraceEvent) {})); !bytes.Equal(le, want) {
+		t.Errorf("encoded % x little-endian, want % x", le, want)
+	}
+	readBack = nestedMsg{}
+	if err := UnmarshalBytes(&readBack, le, binary.LittleEndian, BlobLength8); err != nil || !reflect.DeepEqual(readBack, traced) {
+		t.Errorf("read back %+v little-endian, want %+v, %v", readBack, traced, err)
+	}
+	//errors name the element they happened in
+	v.Cube[2] = append(v.Cube[2], make([]uint16, 300))
+	_, err = MarshalBytes(&v, binary.BigEndian, BlobLength8)
+	if err == nil || !strings.Contains(err.Error(), "Cube[2][1]") {
+		t.Errorf("expected an error at Cube[2][1], got %v", err)
+	}
+	if err := UnmarshalBytes(&readBack, b[:9], binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for a cut input")
+	}
+}
+
+func BenchmarkNested(b *testing.B) {
+	leaf := make([][]float32, 4)
+	for i := range leaf {
+		leaf[i] = []float32{1, 2, 3, 4}
+	}
+	//six levels, four containers wide
+	var v interface{} = leaf
+	for i := 0; i < 4; i++ {
+		level := reflect.MakeSlice(reflect.SliceOf(reflect.TypeOf(v)), 4, 4)
+		for j := 0; j < 4; j++ {
+			level.Index(j).Set(reflect.ValueOf(v))
+		}
+		v = level.Interface()
+	}
+	buf, err := MarshalBytes(v, binary.LittleEndian, BlobLength32)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Run("encode", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			MarshalBytes(v, binary.LittleEndian, BlobLength32)
+		}
+	})
+	b.Run("decode", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			readBack := reflect.New(reflect.TypeOf(v))
+			UnmarshalBytes(readBack.Interface(), buf, binary.LittleEndian, BlobLength32)
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+//nullLength is the length NullableLength reports for its null sentinel
+const nullLength = -2
+
+type nullableLength struct {
+	inner LengthTypeInstance
+	//ones is the all-ones length of inner, null its decoded form
+	ones []byte
+	null int
+}
+
+//NullableLength reserves the all-ones value of the inner length type, e.g.
+//0xffffffff for BlobLength32, to mark an absent string or slice. A nil *string,
+//*[]T, or a nil slice tagged nullable is written as that value and decodes back
+//to nil, an empty one has length 0. The sentinel is an error for any other value.
+//inner must be a fixed-width type such as BlobLength32
+func NullableLength(inner LengthType) LengthType {
+	//learn how the inner type reads back all ones of its width
+	width := lengthWidth(inner, reflect.String)
+	if width <= 0 {
+		panic(errors.New("marshal: NullableLength needs a fixed-width inner length type"))
+	}
+	ones := bytes.Repeat([]byte{0xff}, width)
+	null := inner().Length(bytes.NewReader(ones), binary.BigEndian, reflect.String)
+	return func() LengthTypeInstance {
+		return &nullableLength{inner: inner(), ones: ones, null: null}
+	}
+}
+
+func (d *nullableLength) PutLength(w io.Writer, order binary.ByteOrder, k reflect.Kind, v int) {
+	switch v {
+	case nullLength:
+		if _, err := w.Write(d.ones); err != nil {
+			panic(err)
+		}
+		return
+	case d.null:
+		panic(errorf(ErrLengthOverflow, "nullable length: %d is the null sentinel", v))
+	}
+	d.inner.PutLength(w, order, k, v)
+}
+
+func (d *nullableLength) Length(r io.Reader, order binary.ByteOrder, k reflect.Kind) int {
+	l := d.inner.Length(r, order, k)
+	if l == d.null {
+		return nullLength
+	}
+	return l
+}
+
+//isNullable reports whether a field of kind k is written by nullable with length
+func isNullable(k reflect.Kind, length LengthTypeInstance) bool {
+	if k != reflect.Ptr {
+		return false
+	}
+	return hasNull(length)
+}
+
+//hasNull reports whether length writes a null length for nil values, see nullable
+func hasNull(length LengthTypeInstance) bool {
+	switch length.(type) {
+	case *nullableLength, *respLength:
+		return true
+	}
+	return false
+}
+
+//nullable writes a *string, *[]T or []T with the null length when it is nil
+func (m *marshaler) nullable(v reflect.Value, length LengthTypeInstance) {
+	if !hasNull(length) {
+		panic(fmt.Errorf("marshal: nullable %s needs a NullableLength or RESPLength", v.Type()))
+	}
+	start := m.cw.n
+	if v.IsNil() {
+		m.putLength(length, v.Type(), nullLength)
+	} else {
+		if v.Kind() == reflect.Ptr {
+			v = v.Elem()
+		}
+		m.marshalValue(v, length)
+	}
+	if m.trace != nil {
+		m.emit(v.Type(), start, false)
+	}
+}
+
+//nullable reads a value written by marshaler.nullable, the null length leaves v nil
+//while length 0 gives an empty value
+func (u *unmarshaler) nullable(v reflect.Value, order binary.ByteOrder, length LengthTypeInstance) {
+	start := u.cr.n
+	t := v.Type()
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	l := length.Length(u.r, order, t.Kind())
+	if u.trace != nil {
+		u.emit(t, start, true)
+	}
+	if l == nullLength {
+		v.Set(reflect.Zero(v.Type()))
+		return
+	}
+	if l == restOfRegion {
+		l = u.restLength(t)
+	}
+	if v.Kind() == reflect.Ptr {
+		p := reflect.New(t)
+		v.Set(p)
+		v = p.Elem()
+	}
+	switch {
+	case t.Kind() == reflect.String:
+		v.SetString(u.readString(l))
+	case l == 0:
+		v.Set(reflect.MakeSlice(t, 0, 0))
+	default:
+		u.makeSlice(v, l)
+		u.elements(v, order, length)
+	}
+	u.trailer(length, t)
+	if u.trace != nil {
+		u.emit(v.Type(), start, false)
+	}
+}
@@ -0,0 +1,88 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+type nullableMsg struct {
+	Name  *string
+	Data  *[]uint16
+	Bytes []byte `marshal:"nullable"`
+}
+
+func TestNullableLength(t *testing.T) {
+	length := NullableLength(BlobLength32)
+	empty, name := "", "ab"
+	data := []uint16{7}
+	for _, c := range []struct {
+		v        nullableMsg
+		expected []byte
+	}{
+		{nullableMsg{}, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{nullableMsg{&empty, &[]uint16{}, []byte{}}, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+		{nullableMsg{&name, &data, []byte{9}}, []byte{0, 0, 0, 2, 'a', 'b', 0, 0, 0, 1, 0, 7, 0, 0, 0, 1, 9}},
+	} {
+		b, err := MarshalBytes(&c.v, binary.BigEndian, length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, c.expected) {
+			t.Errorf("encoded % x, want % x", b, c.expected)
+		}
+		var readBack nullableMsg
+		if err := UnmarshalBytes(&readBack, b, binary.BigEndian, length); err != nil {
+			t.Fatal(err)
+		}
+		if (readBack.Name == nil) != (c.v.Name == nil) || (readBack.Name != nil && *readBack.Name != *c.v.Name) {
+			t.Errorf("Name decoded %v, want %v", readBack.Name, c.v.Name)
+		}
+		if (readBack.Data == nil) != (c.v.Data == nil) || (readBack.Data != nil && len(*readBack.Data) != len(*c.v.Data)) {
+			t.Errorf("Data decoded %v, want %v", readBack.Data, c.v.Data)
+		}
+		if (readBack.Bytes == nil) != (c.v.Bytes == nil) || !bytes.Equal(readBack.Bytes, c.v.Bytes) {
+			t.Errorf("Bytes decoded %#v, want %#v", readBack.Bytes, c.v.Bytes)
+		}
+	}
+}
+
+func TestNullableLengthRejects(t *testing.T) {
+	length := NullableLength(BlobLength8)
+	var s string
+	if err := UnmarshalBytes(&s, []byte{0xff}, binary.BigEndian, length); err == nil {
+		t.Errorf("expected an error for null in a plain string")
+	}
+	if _, err := MarshalBytes(make([]byte, 0xff), binary.BigEndian, length); err == nil {
+		t.Errorf("expected an error for a length equal to the sentinel")
+	}
+	type tagged struct {
+		B []byte `marshal:"nullable"`
+	}
+	if _, err := MarshalBytes(&tagged{}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for nullable without a NullableLength")
+	}
+	type notSlice struct {
+		S string `marshal:"nullable"`
+	}
+	if _, err := MarshalBytes(&notSlice{}, binary.BigEndian, length); err == nil {
+		t.Errorf("expected an error for nullable on a string")
+	}
+}
+
+func TestNullableSkip(t *testing.T) {
+	length := NullableLength(BlobLength16)
+	name := "x"
+	b, err := MarshalBytes(&nullableMsg{Name: &name}, binary.BigEndian, length)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b = append(b, 5)
+	r := bytes.NewReader(b)
+	if _, err := Skip(r, &nullableMsg{}, binary.BigEndian, length); err != nil {
+		t.Fatal(err)
+	}
+	if r.Len() != 1 {
+		t.Errorf("skip left %d bytes, want 1", r.Len())
+	}
+}
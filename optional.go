@@ -0,0 +1,79 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"reflect"
+	"time"
+)
+
+//Optional is implemented by pointers to wrappers of a value that may be absent,
+//such as an Optional[T] of your own. They are written as a presence byte, 1 or 0,
+//followed by the value only when it is present.
+//The database/sql Null types, including sql.Null[T], are written the same way,
+//except sql.NullTime since time.Time has no wire form
+type Optional interface {
+	//Present reports whether the value is set
+	Present() bool
+	//SetPresent marks the value as set or absent
+	SetPresent(bool)
+	//Value returns a pointer to the wrapped value
+	Value() interface{}
+}
+
+var (
+	optionalType = reflect.TypeOf((*Optional)(nil)).Elem()
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+//isOptional reports whether t is a database/sql Null type or implements Optional
+func isOptional(t reflect.Type) bool {
+	if t.Kind() == reflect.Struct && t.PkgPath() == "database/sql" && t.NumField() == 2 &&
+		t.Field(1).Name == "Valid" && t.Field(0).Type != timeType {
+		return true
+	}
+	return reflect.PointerTo(t).Implements(optionalType)
+}
+
+//optionalParts returns whether the optional v is present and its wrapped value,
+//which is addressable when v is
+func optionalParts(v reflect.Value) (bool, reflect.Value) {
+	if v.Type().PkgPath() == "database/sql" {
+		return v.Field(1).Bool(), v.Field(0)
+	}
+	if !v.CanAddr() {
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		v = p.Elem()
+	}
+	o := v.Addr().Interface().(Optional)
+	return o.Present(), reflect.ValueOf(o.Value()).Elem()
+}
+
+func (m *marshaler) optional(v reflect.Value, length LengthTypeInstance) {
+	present, value := optionalParts(v)
+	if !present {
+		m.uint8(0)
+		return
+	}
+	m.uint8(1)
+	m.marshal(value, length)
+}
+
+func (u *unmarshaler) optional(v reflect.Value, order binary.ByteOrder, length LengthTypeInstance) {
+	v.Set(reflect.Zero(v.Type()))
+	switch b := u.fetch(1)[0]; b {
+	case 0:
+		return
+	case 1:
+	default:
+		panic(errorf(ErrMalformed, "unmarshal: bad presence byte %d for %s", b, v.Type()))
+	}
+	if v.Type().PkgPath() == "database/sql" {
+		v.Field(1).SetBool(true)
+		u.unmarshal(v.Field(0), order, length)
+		return
+	}
+	o := v.Addr().Interface().(Optional)
+	o.SetPresent(true)
+	u.unmarshal(reflect.ValueOf(o.Value()).Elem(), order, length)
+}
@@ -0,0 +1,63 @@
+package marshal
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"testing"
+)
+
+//maybe is a user wrapper implementing Optional
+type maybe[T any] struct {
+	v  T
+	ok bool
+}
+
+func (m *maybe[T]) Present() bool      { return m.ok }
+func (m *maybe[T]) SetPresent(ok bool) { m.ok = ok }
+func (m *maybe[T]) Value() interface{} { return &m.v }
+
+type optionalRow struct {
+	ID    sql.NullInt64
+	Name  sql.NullString
+	Port  sql.Null[uint16]
+	Score maybe[int32]
+}
+
+func TestOptional(t *testing.T) {
+	v := optionalRow{
+		ID:    sql.NullInt64{Int64: 5, Valid: true},
+		Port:  sql.Null[uint16]{V: 80, Valid: true},
+		Score: maybe[int32]{-1, true},
+	}
+	b, err := MarshalBytes(&v, binary.BigEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{1, 0, 0, 0, 0, 0, 0, 0, 5, 0, 1, 0, 80, 1, 0xff, 0xff, 0xff, 0xff}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("encoded % x, want % x", b, expected)
+	}
+	readBack := optionalRow{Name: sql.NullString{String: "stale", Valid: true}}
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, BlobLength8); err != nil {
+		t.Fatal(err)
+	}
+	if readBack != v {
+		t.Errorf("decoded %+v, want %+v", readBack, v)
+	}
+	//absent values are a single zero byte each
+	b, err = MarshalBytes(&optionalRow{Name: sql.NullString{String: "ab", Valid: true}}, binary.BigEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0, 1, 2, 'a', 'b', 0, 0}; !bytes.Equal(b, expected) {
+		t.Errorf("encoded % x, want % x", b, expected)
+	}
+	r := bytes.NewReader(append(b, 9))
+	if _, err := Skip(r, &optionalRow{}, binary.BigEndian, BlobLength8); err != nil || r.Len() != 1 {
+		t.Errorf("skip left %d bytes, %v", r.Len(), err)
+	}
+	if err := UnmarshalBytes(&readBack, []byte{2}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for a bad presence byte")
+	}
+}
@@ -0,0 +1,85 @@
+package marshal
+
+import "encoding/binary"
+
+//Option tunes a single Marshal or Unmarshal call, options that do not apply to
+//the direction being performed are ignored
+type Option func(*options)
+
+type options struct {
+	alloc Allocator
+	trace func(TraceEvent)
+	index *[]int64
+	//strict makes Unmarshal verify what it would otherwise tolerate
+	strict bool
+	//deterministic makes Marshal sort map keys
+	deterministic bool
+	//shared writes pointers as tokens, see SharedPointers
+	shared bool
+	//unknown builds placeholders for unregistered type ids, see KeepUnknown
+	unknown func(id uint64, body []byte) interface{}
+	//pack is the C layout alignment cap, 0 unless CLayout is set
+	pack int
+	//reuse makes Messages decode every value into the same one
+	reuse bool
+	//resume makes a Decoder decode a value cut short by a timeout again, see ResumeOnTimeout
+	resume bool
+	//idWidth and idOrder format the ids of ReadAny and WriteAny, see MessageID
+	idWidth int
+	idOrder binary.ByteOrder
+	//fingerprint puts a fingerprint of the layout before every value, see Fingerprint
+	fingerprint bool
+	//stats receives the Stats of every value, see WithStats
+	stats StatsCollector
+	//warn receives anomalies that don't fail the call, see OnWarning
+	warn func(Warning)
+	//proto writes structs in the protobuf wire format, see ProtobufWire
+	proto bool
+}
+
+var noOptions = &options{}
+
+func newOptions(opts []Option) *options {
+	if len(opts) == 0 {
+		return noOptions
+	}
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+//Allocator supplies the memory Unmarshal uses for string buffers and byte slice
+//backing arrays. Alloc must return a slice of at least n bytes.
+//
+//Strings decoded with an Allocator share its memory instead of copying it,
+//so they must not outlive the arena unless copied (e.g. with strings.Clone)
+type Allocator interface {
+	Alloc(n int) []byte
+}
+
+//WithAllocator makes Unmarshal take string buffers and byte slice backing arrays
+//from a instead of make
+func WithAllocator(a Allocator) Option {
+	return func(o *options) {
+		o.alloc = a
+	}
+}
+
+//Strict makes Unmarshal reject input it would otherwise accept, such as
+//reserved regions that aren't zero
+func Strict() Option {
+	return func(o *options) {
+		o.strict = true
+	}
+}
+
+//Deterministic makes Marshal write map entries ordered by key, so equal values
+//always encode to the same bytes. Keys are ordered by value unless a less
+//function is registered for the map type with RegisterKeyLess
+func Deterministic() Option {
+	return func(o *options) {
+		o.deterministic = true
+	}
+}
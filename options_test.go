@@ -0,0 +1,84 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"unsafe"
+)
+
+type testArena struct {
+	buf []byte
+	off int
+}
+
+func (a *testArena) Alloc(n int) []byte {
+	b := a.buf[a.off : a.off+n]
+	a.off += n
+	return b
+}
+
+func (a *testArena) owns(p unsafe.Pointer) bool {
+	start := uintptr(unsafe.Pointer(&a.buf[0]))
+	return uintptr(p) >= start && uintptr(p) < start+uintptr(len(a.buf))
+}
+
+func TestAllocator(t *testing.T) {
+	result := new(bytes.Buffer)
+	if e := Marshal(createTestObject(), result, binary.LittleEndian, BlobLength32); e != nil {
+		t.Fatalf("marshal: %v", e)
+	}
+	arena := &testArena{buf: make([]byte, 4096)}
+	var readBack Foo
+	e := Unmarshal(&readBack, bytes.NewReader(result.Bytes()), binary.LittleEndian, BlobLength32, WithAllocator(arena))
+	if e != nil {
+		t.Fatalf("unmarshal: %v", e)
+	}
+	if !reflect.DeepEqual(*createTestObject(), readBack) {
+		t.Errorf("proto and readBack are NOT equal")
+	}
+	if !arena.owns(unsafe.Pointer(&readBack.Version[0])) {
+		t.Errorf("byte slice not allocated from arena")
+	}
+	if !arena.owns(unsafe.Pointer(unsafe.StringData(readBack.Bar.Id))) {
+		t.Errorf("string not allocated from arena")
+	}
+	for k := range readBack.Bar.Prop {
+		if !arena.owns(unsafe.Pointer(unsafe.StringData(k))) {
+			t.Errorf("map key %q not allocated from arena", k)
+		}
+	}
+}
+
+func TestAllocatorSegments(t *testing.T) {
+	//Data and Name are made of segments
+	in := []byte{
+		0x80, 0x30,
+		0x80, 5, 0x80, 0x04, 0x02, 'a', 'b', 0x04, 0x01, 'c', 0x00, 0x00, 0x00, 0x00,
+		0x80, 0x04, 0x01, 'x', 0x04, 0x02, 'y', 'z', 0x00, 0x00,
+		0x00, 0x00,
+		9,
+	}
+	arena := &testArena{buf: make([]byte, 256)}
+	var readBack berNest
+	if err := UnmarshalBytes(&readBack, in, binary.BigEndian, BERLength, WithAllocator(arena)); err != nil {
+		t.Fatal(err)
+	}
+	if string(readBack.Outer.Inner.Data) != "abc" || readBack.Outer.Name != "xyz" {
+		t.Fatalf("decoded %+v", readBack)
+	}
+	if !arena.owns(unsafe.Pointer(&readBack.Outer.Inner.Data[0])) || !arena.owns(unsafe.Pointer(unsafe.StringData(readBack.Outer.Name))) {
+		t.Errorf("segments not allocated from arena")
+	}
+	//the body of an unknown interface value too
+	arena = &testArena{buf: make([]byte, 256)}
+	var events []interface{}
+	b := []byte{1, 9, 2, 0xee, 0xff}
+	if err := UnmarshalBytes(&events, b, binary.BigEndian, BlobLength8, KeepUnknown(nil), WithAllocator(arena)); err != nil {
+		t.Fatal(err)
+	}
+	if raw, ok := events[0].(*RawElement); !ok || !bytes.Equal(raw.Body, []byte{0xee, 0xff}) || !arena.owns(unsafe.Pointer(&raw.Body[0])) {
+		t.Errorf("unknown body %#v not allocated from arena", events[0])
+	}
+}
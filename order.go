@@ -0,0 +1,62 @@
+package marshal
+
+//PDPEndian is the byte order of the PDP-11: 16 bit words are little-endian and
+//wider values put their most significant word first, so 0x0a0b0c0d is written
+//0b 0a 0d 0c. Any binary.ByteOrder works with the package, this one is provided
+//for the instruments and file formats that still use it
+var PDPEndian pdpEndian
+
+type pdpEndian struct{}
+
+func (pdpEndian) Uint16(b []byte) uint16 {
+	_ = b[1]
+	return uint16(b[0]) | uint16(b[1])<<8
+}
+
+func (pdpEndian) PutUint16(b []byte, v uint16) {
+	_ = b[1]
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+}
+
+func (e pdpEndian) Uint32(b []byte) uint32 {
+	_ = b[3]
+	return uint32(e.Uint16(b))<<16 | uint32(e.Uint16(b[2:]))
+}
+
+func (e pdpEndian) PutUint32(b []byte, v uint32) {
+	_ = b[3]
+	e.PutUint16(b, uint16(v>>16))
+	e.PutUint16(b[2:], uint16(v))
+}
+
+func (e pdpEndian) Uint64(b []byte) uint64 {
+	_ = b[7]
+	return uint64(e.Uint32(b))<<32 | uint64(e.Uint32(b[4:]))
+}
+
+func (e pdpEndian) PutUint64(b []byte, v uint64) {
+	_ = b[7]
+	e.PutUint32(b, uint32(v>>32))
+	e.PutUint32(b[4:], uint32(v))
+}
+
+func (e pdpEndian) AppendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v), byte(v>>8))
+}
+
+func (e pdpEndian) AppendUint32(b []byte, v uint32) []byte {
+	return e.AppendUint16(e.AppendUint16(b, uint16(v>>16)), uint16(v))
+}
+
+func (e pdpEndian) AppendUint64(b []byte, v uint64) []byte {
+	return e.AppendUint32(e.AppendUint32(b, uint32(v>>32)), uint32(v))
+}
+
+func (pdpEndian) String() string {
+	return "PDPEndian"
+}
+
+func (pdpEndian) GoString() string {
+	return "marshal.PDPEndian"
+}
@@ -0,0 +1,84 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+//interface checks, AppendByteOrder is optional
+var (
+	_ binary.ByteOrder       = PDPEndian
+	_ binary.AppendByteOrder = PDPEndian
+)
+
+func TestPDPEndian(t *testing.T) {
+	b := make([]byte, 8)
+	PDPEndian.PutUint16(b, 0x0a0b)
+	if !bytes.Equal(b[:2], []byte{0x0b, 0x0a}) || PDPEndian.Uint16(b) != 0x0a0b {
+		t.Errorf("uint16 % x", b[:2])
+	}
+	PDPEndian.PutUint32(b, 0x0a0b0c0d)
+	if !bytes.Equal(b[:4], []byte{0x0b, 0x0a, 0x0d, 0x0c}) || PDPEndian.Uint32(b) != 0x0a0b0c0d {
+		t.Errorf("uint32 % x", b[:4])
+	}
+	PDPEndian.PutUint64(b, 0x0102030405060708)
+	if !bytes.Equal(b, []byte{2, 1, 4, 3, 6, 5, 8, 7}) || PDPEndian.Uint64(b) != 0x0102030405060708 {
+		t.Errorf("uint64 % x", b)
+	}
+	a := PDPEndian.AppendUint64(PDPEndian.AppendUint32(PDPEndian.AppendUint16(nil, 0x0a0b), 0x0a0b0c0d), 0x0102030405060708)
+	if !bytes.Equal(a, []byte{0x0b, 0x0a, 0x0b, 0x0a, 0x0d, 0x0c, 2, 1, 4, 3, 6, 5, 8, 7}) {
+		t.Errorf("appended % x", a)
+	}
+}
+
+type pdpRecord struct {
+	A uint32
+	B int16
+	F float32
+	D float64
+	S []uint32
+	G [2]float64
+	P Pod
+}
+
+func TestPDPEndianCodec(t *testing.T) {
+	v := pdpRecord{A: 0x0a0b0c0d, B: -2, F: 1.5, D: -0.25, S: []uint32{1, 0x01020304}, G: [2]float64{math.Pi, math.Inf(-1)}, P: *createPodObject()}
+	b, err := MarshalBytes(&v, PDPEndian, BlobLength16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//every value is written as PDPEndian.PutUintN writes it, fixed-size paths included
+	var want []byte
+	want = PDPEndian.AppendUint32(want, v.A)
+	want = PDPEndian.AppendUint16(want, uint16(v.B))
+	want = PDPEndian.AppendUint32(want, math.Float32bits(v.F))
+	want = PDPEndian.AppendUint64(want, math.Float64bits(v.D))
+	want = PDPEndian.AppendUint16(want, 2)
+	for _, x := range v.S {
+		want = PDPEndian.AppendUint32(want, x)
+	}
+	for _, x := range v.G {
+		want = PDPEndian.AppendUint64(want, math.Float64bits(x))
+	}
+	if !bytes.HasPrefix(b, want) {
+		t.Errorf("encoded % x, want it to start with % x", b[:len(want)], want)
+	}
+	c := v.P.C[1]
+	if at := len(want) + len(v.P.A) + len(v.P.B) + 4; PDPEndian.Uint32(b[at:]) != c {
+		t.Errorf("Pod.C[1] encoded % x, want %d", b[at:at+4], c)
+	}
+	var readBack pdpRecord
+	if err := UnmarshalBytes(&readBack, b, PDPEndian, BlobLength16); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(readBack, v) {
+		t.Errorf("decoded %+v, want %+v", readBack, v)
+	}
+	//the orders agree on 16 bits, the fingerprints must not
+	if fingerprint(reflect.TypeOf(v), PDPEndian, BlobLength16(), noOptions) == fingerprint(reflect.TypeOf(v), binary.LittleEndian, BlobLength16(), noOptions) {
+		t.Errorf("PDPEndian and little-endian have the same fingerprint")
+	}
+}
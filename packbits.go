@@ -0,0 +1,125 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"reflect"
+)
+
+//packbitsBytes is the wire size of l values of n bits, -1 when it overflows
+func packbitsBytes(l, n int) int {
+	if l > (math.MaxInt-7)/n {
+		return -1
+	}
+	return (l*n + 7) / 8
+}
+
+//packbits writes the integer slice or array v, a slice behind its length prefix,
+//as n bits per element, most significant bit first, without gaps between
+//elements. The last byte is padded with zero bits. Signed elements are written
+//in two's complement
+func (m *marshaler) packbits(v reflect.Value, n int, length LengthTypeInstance) {
+	l := v.Len()
+	if v.Kind() == reflect.Slice {
+		m.putLength(length, v.Type(), l)
+	}
+	size := packbitsBytes(l, n)
+	if size < 0 {
+		panic(errorf(ErrLengthOverflow, "marshal: %d elements of %d bits are too many", l, n))
+	}
+	bp := getScratch(size)
+	defer scratchPool.Put(bp)
+	out := (*bp)[:0]
+	signed := isSigned(v.Type().Elem().Kind())
+	//acc holds pending bits in its low bits, fewer than 8 between elements
+	var acc uint64
+	pending := 0
+	for i := 0; i < l; i++ {
+		var x uint64
+		if signed {
+			s := v.Index(i).Int()
+			if n < 64 && (s < -1<<(n-1) || s >= 1<<(n-1)) {
+				panic(errorf(ErrLengthOverflow, "marshal: element %d, %d, doesn't fit in %d bits", i, s, n))
+			}
+			x = uint64(s) & bitMask(n)
+		} else {
+			x = v.Index(i).Uint()
+			if x&^bitMask(n) != 0 {
+				panic(errorf(ErrLengthOverflow, "marshal: element %d, %d, doesn't fit in %d bits", i, x, n))
+			}
+		}
+		for left := n; left > 0; {
+			take := min(left, 56)
+			left -= take
+			acc = acc<<take | x>>left&bitMask(take)
+			pending += take
+			for ; pending >= 8; pending -= 8 {
+				out = append(out, byte(acc>>(pending-8)))
+			}
+		}
+	}
+	if pending > 0 {
+		out = append(out, byte(acc<<(8-pending)))
+	}
+	if _, err := m.w.Write(out); err != nil {
+		panic(err)
+	}
+}
+
+//packbits reads an integer slice or array written by marshaler.packbits into v,
+//signed elements are sign extended. Strict rejects padding bits that aren't zero
+func (u *unmarshaler) packbits(v reflect.Value, n int, order binary.ByteOrder, length LengthTypeInstance) {
+	if v.Kind() == reflect.Slice {
+		l := u.getLength(length, order, v.Type())
+		if l == 0 {
+			return
+		}
+		if packbitsBytes(l, n) < 0 {
+			panic(errorf(ErrLengthOverflow, "unmarshal: %d elements of %d bits are too many", l, n))
+		}
+		v.Set(reflect.MakeSlice(v.Type(), l, l))
+	}
+	l := v.Len()
+	size := packbitsBytes(l, n)
+	var b []byte
+	if u.direct() {
+		b = u.take(size)
+	} else {
+		bp := getScratch(size)
+		defer scratchPool.Put(bp)
+		b = *bp
+		if _, err := io.ReadFull(u.r, b); err != nil {
+			panic(err)
+		}
+	}
+	signed := isSigned(v.Type().Elem().Kind())
+	var acc uint64
+	pending, next := 0, 0
+	for i := 0; i < l; i++ {
+		var x uint64
+		for left := n; left > 0; {
+			for pending < 8 && next < len(b) {
+				acc = acc<<8 | uint64(b[next])
+				pending += 8
+				next++
+			}
+			take := min(left, pending)
+			left -= take
+			pending -= take
+			x = x<<take | acc>>pending&bitMask(take)
+		}
+		e := v.Index(i)
+		if signed {
+			e.SetInt(int64(x<<(64-n)) >> (64 - n))
+		} else {
+			e.SetUint(x)
+		}
+	}
+	if pending > 0 && acc&bitMask(pending) != 0 {
+		if u.strict {
+			panic(errorf(ErrMalformed, "unmarshal: packbits padding of %s is not zero", v.Type()))
+		}
+		u.warning(WarnPaddingNotZero, u.cr.n-1, "%d padding bits are 0x%x", pending, acc&bitMask(pending))
+	}
+}
@@ -0,0 +1,100 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+type packedSamples struct {
+	S []uint16 `marshal:"packbits=12"`
+	D [3]int8  `marshal:"packbits=4"`
+}
+
+func TestPackbits(t *testing.T) {
+	v := packedSamples{S: []uint16{0xabc, 0x123, 0xfff}, D: [3]int8{-8, 7, -1}}
+	b, err := MarshalBytes(&v, binary.BigEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{3, 0xab, 0xc1, 0x23, 0xff, 0xf0, 0x87, 0xf0}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("encoded % x, want % x", b, expected)
+	}
+	var readBack packedSamples
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, BlobLength8, Strict()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(readBack, v) {
+		t.Errorf("decoded %+v, want %+v", readBack, v)
+	}
+	s, err := Describe(packedSamples{})
+	if err != nil || s.Fields[1].Size != 2 {
+		t.Errorf("described %+v, %v, want a 2 byte array", s, err)
+	}
+}
+
+func TestPackbitsWidths(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for n := 1; n <= 64; n++ {
+		tag := reflect.StructTag(`marshal:"packbits=` + strconv.Itoa(n) + `"`)
+		unsigned := reflect.StructOf([]reflect.StructField{{Name: "V", Type: reflect.TypeOf([]uint64{}), Tag: tag}})
+		signed := reflect.StructOf([]reflect.StructField{{Name: "V", Type: reflect.TypeOf([]int64{}), Tag: tag}})
+		for _, st := range []reflect.Type{unsigned, signed} {
+			v := reflect.New(st)
+			l := 1 + rng.Intn(20)
+			values := reflect.MakeSlice(st.Field(0).Type, l, l)
+			for i := 0; i < l; i++ {
+				x := rng.Uint64() & bitMask(n)
+				if st == signed {
+					values.Index(i).SetInt(int64(x<<(64-n)) >> (64 - n))
+				} else {
+					values.Index(i).SetUint(x)
+				}
+			}
+			v.Elem().Field(0).Set(values)
+			b, err := MarshalBytes(v.Interface(), binary.BigEndian, BlobLength8)
+			if err != nil {
+				t.Fatalf("%d bits: %v", n, err)
+			}
+			if len(b) != 1+(l*n+7)/8 {
+				t.Errorf("%d bits: %d elements take %d bytes", n, l, len(b))
+			}
+			readBack := reflect.New(st)
+			if err := UnmarshalBytes(readBack.Interface(), b, binary.BigEndian, BlobLength8, Strict()); err != nil {
+				t.Fatalf("%d bits: %v", n, err)
+			}
+			if !reflect.DeepEqual(readBack.Interface(), v.Interface()) {
+				t.Errorf("%d bits: decoded %v, want %v", n, readBack.Elem(), v.Elem())
+			}
+		}
+	}
+}
+
+func TestPackbitsErrors(t *testing.T) {
+	v := packedSamples{S: []uint16{0x1000}}
+	if _, err := MarshalBytes(&v, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for a value wider than 12 bits")
+	}
+	v = packedSamples{D: [3]int8{8}}
+	if _, err := MarshalBytes(&v, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for a signed value wider than 4 bits")
+	}
+	dirty := []byte{1, 0xab, 0xc1, 0x87, 0xf0}
+	var readBack packedSamples
+	if err := UnmarshalBytes(&readBack, dirty, binary.BigEndian, BlobLength8); err != nil {
+		t.Errorf("lenient decode: %v", err)
+	}
+	if err := UnmarshalBytes(&readBack, dirty, binary.BigEndian, BlobLength8, Strict()); err == nil {
+		t.Errorf("expected Strict to reject padding bits")
+	}
+	var bad struct {
+		V []uint8 `marshal:"packbits=9"`
+	}
+	if _, err := MarshalBytes(&bad, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for packbits wider than the element")
+	}
+}
@@ -0,0 +1,140 @@
+package marshal
+
+import (
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+var (
+	keyLessLock sync.RWMutex
+	keyLess     = map[reflect.Type]func(a, b reflect.Value) bool{}
+)
+
+//RegisterKeyLess makes Deterministic and parallel maps of type t order their keys
+//with less instead of by value, to match a peer with its own ordering rule
+func RegisterKeyLess(t reflect.Type, less func(a, b reflect.Value) bool) {
+	if t.Kind() != reflect.Map {
+		panic(fmt.Errorf("marshal: RegisterKeyLess: %s is not a map", t))
+	}
+	keyLessLock.Lock()
+	keyLess[t] = less
+	keyLessLock.Unlock()
+}
+
+//sortKeys orders the keys of a map of type t with its registered less function,
+//by default by value, see compareKeys
+func sortKeys(t reflect.Type, keys []reflect.Value) {
+	keyLessLock.RLock()
+	less := keyLess[t]
+	keyLessLock.RUnlock()
+	if less != nil {
+		sort.Slice(keys, func(i, j int) bool { return less(keys[i], keys[j]) })
+		return
+	}
+	sort.Slice(keys, func(i, j int) bool { return compareKeys(keys[i], keys[j]) < 0 })
+}
+
+//compareKeys orders numbers numerically, strings bytewise, false before true,
+//and arrays and structs element by element
+func compareKeys(a, b reflect.Value) int {
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return cmp.Compare(a.Int(), b.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return cmp.Compare(a.Uint(), b.Uint())
+	case reflect.Float32, reflect.Float64:
+		return cmp.Compare(a.Float(), b.Float())
+	case reflect.String:
+		return cmp.Compare(a.String(), b.String())
+	case reflect.Bool:
+		switch {
+		case a.Bool() == b.Bool():
+			return 0
+		case b.Bool():
+			return -1
+		}
+		return 1
+	case reflect.Array:
+		for i := 0; i < a.Len(); i++ {
+			if c := compareKeys(a.Index(i), b.Index(i)); c != 0 {
+				return c
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if c := compareKeys(a.Field(i), b.Field(i)); c != 0 {
+				return c
+			}
+		}
+	case reflect.Ptr, reflect.Interface:
+		switch {
+		case a.IsNil() || b.IsNil():
+			return cmp.Compare(btoi(!a.IsNil()), btoi(!b.IsNil()))
+		case a.Kind() == reflect.Interface && a.Elem().Type() != b.Elem().Type():
+			return cmp.Compare(a.Elem().Type().String(), b.Elem().Type().String())
+		}
+		return compareKeys(a.Elem(), b.Elem())
+	}
+	return 0
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+//parallel writes the map v as its length, all keys in sorted order, then all
+//values in the same order
+func (m *marshaler) parallel(v reflect.Value, length LengthTypeInstance) {
+	keys := m.mapKeys(v)
+	m.putLength(length, v.Type(), len(keys))
+	sortKeys(v.Type(), keys)
+	elems := elemsOf(length)
+	for _, k := range keys {
+		m.push(keyElem(k, true))
+		m.marshal(k, elems)
+		m.pop()
+	}
+	for _, k := range keys {
+		m.push(keyElem(k, false))
+		m.marshal(v.MapIndex(k), elems)
+		m.pop()
+	}
+}
+
+//parallel reads a map written by marshaler.parallel, a key repeated in the key block
+//would leave fewer entries than the count and is an error
+func (u *unmarshaler) parallel(v reflect.Value, order binary.ByteOrder, length LengthTypeInstance) {
+	l := u.getLength(length, order, v.Type())
+	if l == 0 {
+		return
+	}
+	keys := reflect.MakeSlice(reflect.SliceOf(v.Type().Key()), 0, 0)
+	elems := elemsOf(length)
+	for i := 0; i < l; i++ {
+		key := reflect.New(v.Type().Key()).Elem()
+		u.push(pathElem{index: i, isKey: true})
+		u.unmarshal(key, order, elems)
+		u.pop()
+		keys = reflect.Append(keys, key)
+	}
+	mv := reflect.MakeMapWithSize(v.Type(), l)
+	for i := 0; i < l; i++ {
+		key := keys.Index(i)
+		if mv.MapIndex(key).IsValid() {
+			panic(errorf(ErrMalformed, "unmarshal: key %v repeats in %d map keys", key, l))
+		}
+		elem := reflect.New(v.Type().Elem()).Elem()
+		u.push(keyElem(key, false))
+		u.unmarshal(elem, order, elems)
+		u.pop()
+		mv.SetMapIndex(key, elem)
+	}
+	v.Set(mv)
+}
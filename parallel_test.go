@@ -0,0 +1,120 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type parallelIndex struct {
+	Names map[string]uint16 `marshal:"parallel"`
+	Next  uint8
+}
+
+func TestParallel(t *testing.T) {
+	v := parallelIndex{Names: map[string]uint16{"b": 2, "a": 1, "c": 3}, Next: 7}
+	b, err := MarshalBytes(&v, binary.BigEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{3, 1, 'a', 1, 'b', 1, 'c', 0, 1, 0, 2, 0, 3, 7}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("encoded % x, want % x", b, expected)
+	}
+	var readBack parallelIndex
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, BlobLength8); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(readBack, v) {
+		t.Errorf("decoded %+v, want %+v", readBack, v)
+	}
+	dup := []byte{2, 1, 'a', 1, 'a', 0, 1, 0, 2, 7}
+	if err := UnmarshalBytes(&readBack, dup, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for a repeated key")
+	}
+}
+
+func TestParallelIntKeys(t *testing.T) {
+	type table struct {
+		M map[int16]bool `marshal:"parallel"`
+	}
+	b, err := MarshalBytes(&table{map[int16]bool{5: true, -1: false, 300: true}}, binary.LittleEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//keys sort numerically, not by their encoded bytes
+	expected := []byte{3, 0xff, 0xff, 5, 0, 0x2c, 1, 0, 1, 1}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("encoded % x, want % x", b, expected)
+	}
+}
+
+func TestParallelErrors(t *testing.T) {
+	type notMap struct {
+		S []uint8 `marshal:"parallel"`
+	}
+	if _, err := MarshalBytes(&notMap{}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for parallel on a slice")
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	v := createTestObject()
+	first, err := MarshalBytes(v, binary.BigEndian, BlobLength32, Deterministic())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		b, err := MarshalBytes(v, binary.BigEndian, BlobLength32, Deterministic())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, first) {
+			t.Fatalf("encoding %d differs", i)
+		}
+	}
+	b, err := MarshalBytes(map[uint8]uint8{3: 0, 1: 0, 2: 0}, binary.BigEndian, BlobLength8, Deterministic())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{3, 1, 0, 2, 0, 3, 0}; !bytes.Equal(b, expected) {
+		t.Errorf("encoded % x, want % x", b, expected)
+	}
+}
+
+//ciMap orders its keys case-insensitively
+type ciMap map[string]uint8
+
+func TestRegisterKeyLess(t *testing.T) {
+	RegisterKeyLess(reflect.TypeOf(ciMap{}), func(a, b reflect.Value) bool {
+		return strings.ToLower(a.String()) < strings.ToLower(b.String())
+	})
+	b, err := MarshalBytes(ciMap{"b": 2, "A": 1, "c": 3}, binary.BigEndian, BlobLength8, Deterministic())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{3, 1, 'A', 1, 1, 'b', 2, 1, 'c', 3}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("encoded % x, want % x", b, expected)
+	}
+	//unregistered types keep the default bytewise order
+	b, err = MarshalBytes(map[string]uint8{"b": 2, "A": 1}, binary.BigEndian, BlobLength8, Deterministic())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{2, 1, 'A', 1, 1, 'b', 2}; !bytes.Equal(b, expected) {
+		t.Errorf("encoded % x, want % x", b, expected)
+	}
+	type index struct {
+		M ciMap `marshal:"parallel"`
+	}
+	b, err = MarshalBytes(&index{ciMap{"b": 2, "A": 1}}, binary.BigEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{2, 1, 'A', 1, 'b', 1, 2}; !bytes.Equal(b, expected) {
+		t.Errorf("parallel encoded % x, want % x", b, expected)
+	}
+}
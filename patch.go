@@ -0,0 +1,95 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+//FieldOffset locates the encoding of the field named by path inside an encoded value of
+//type t. Nested fields are named with dots and array elements with brackets, e.g.
+//"Header.Seq" or "Slots[2]". Everything encoded before the field and the field itself
+//must be fixed-size, so the result depends on neither byte order nor length type. A
+//struct holding the field may be variable-length after it
+func FieldOffset(t reflect.Type, path string) (off, size int, err error) {
+	off, p, _, err := locate(t, path)
+	if err != nil {
+		return 0, 0, err
+	}
+	return off, p.size, nil
+}
+
+//Patch overwrites the field named by path, see FieldOffset, inside buf which holds an
+//encoded value of type t. value must be assignable to the field's type
+func Patch(buf []byte, t reflect.Type, path string, value interface{}, order binary.ByteOrder) error {
+	off, p, ft, err := locate(t, path)
+	if err != nil {
+		return err
+	}
+	v := reflect.ValueOf(value)
+	if !v.IsValid() || !v.Type().AssignableTo(ft) {
+		return fmt.Errorf("marshal: Patch %s.%s: value of type %T, want %s", t, path, value, ft)
+	}
+	if off+p.size > len(buf) {
+		return fmt.Errorf("marshal: Patch %s.%s: field ends at %d, buffer holds %d bytes", t, path, off+p.size, len(buf))
+	}
+	x := reflect.New(ft).Elem()
+	x.Set(v)
+	putFixed(buf[off:off+p.size], x, p, order)
+	return nil
+}
+
+//locate walks path through t, adding up the encoded sizes of everything before it
+func locate(t reflect.Type, path string) (off int, p *typePlan, ft reflect.Type, err error) {
+	ft = t
+	names := strings.Split(path, ".")
+	for k, name := range names {
+		name, index, indexed := strings.Cut(name, "[")
+		if ft.Kind() != reflect.Struct {
+			return 0, nil, nil, fmt.Errorf("marshal: %s: %s is not a struct", path, ft)
+		}
+		sp := planFor(ft)
+		if sp.err != nil {
+			return 0, nil, nil, sp.err
+		}
+		if _, ok := ft.FieldByName(name); !ok {
+			return 0, nil, nil, fmt.Errorf("marshal: %s: %s has no field %s", path, ft, name)
+		}
+		found := false
+		for i := range sp.fields {
+			f := &sp.fields[i]
+			fixed := f.tag == nil && f.plan.size >= 0
+			if f.name == name {
+				//a struct on the way only needs the fields before the target fixed-size
+				through := f.tag == nil && !f.plan.custom && !indexed && k < len(names)-1 && ft.Field(f.index).Type.Kind() == reflect.Struct
+				if !fixed && !through {
+					return 0, nil, nil, fmt.Errorf("marshal: %s: %s.%s is variable-length", path, ft, f.name)
+				}
+				ft, p, found = ft.Field(f.index).Type, f.plan, true
+				break
+			}
+			if !fixed {
+				return 0, nil, nil, fmt.Errorf("marshal: %s: %s.%s before it is variable-length", path, ft, f.name)
+			}
+			off += f.plan.size
+		}
+		if !found {
+			//promoted through an embedded struct, callers must name the embedded field
+			return 0, nil, nil, fmt.Errorf("marshal: %s: %s has no direct field %s", path, ft, name)
+		}
+		for indexed {
+			var rest string
+			index, rest, _ = strings.Cut(index, "]")
+			i, e := strconv.Atoi(index)
+			if e != nil || ft.Kind() != reflect.Array || i < 0 || i >= ft.Len() {
+				return 0, nil, nil, fmt.Errorf("marshal: %s: bad index [%s] into %s", path, index, ft)
+			}
+			off += i * p.elem.size
+			ft, p = ft.Elem(), p.elem
+			index, indexed = strings.CutPrefix(rest, "[")
+		}
+	}
+	return off, p, ft, nil
+}
@@ -0,0 +1,110 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+type stamped struct {
+	Kind   uint8
+	Slots  [3]uint16
+	Header struct {
+		Flags uint8
+		Seq   uint32
+	}
+	Body string
+}
+
+//stampedTail holds a fixed-size field before a variable-length one
+type stampedTail struct {
+	Kind uint8
+	In   struct {
+		X    uint16
+		Name string
+		Y    uint16
+	}
+}
+
+func TestPatch(t *testing.T) {
+	typ := reflect.TypeOf(stamped{})
+	tests := []struct {
+		path      string
+		off, size int
+	}{
+		{"Kind", 0, 1},
+		{"Slots", 1, 6},
+		{"Slots[2]", 5, 2},
+		{"Header.Seq", 8, 4},
+	}
+	for _, tt := range tests {
+		off, size, err := FieldOffset(typ, tt.path)
+		if err != nil || off != tt.off || size != tt.size {
+			t.Errorf("FieldOffset(%s): %d, %d, %v, want %d, %d", tt.path, off, size, err, tt.off, tt.size)
+		}
+	}
+
+	v := stamped{Kind: 1, Body: "payload"}
+	v.Header.Seq = 1
+	buf, err := MarshalBytes(&v, binary.LittleEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Patch(buf, typ, "Header.Seq", uint32(0xdeadbeef), binary.LittleEndian); err != nil {
+		t.Fatal(err)
+	}
+	if err := Patch(buf, typ, "Slots[1]", uint16(7), binary.LittleEndian); err != nil {
+		t.Fatal(err)
+	}
+	v.Header.Seq = 0xdeadbeef
+	v.Slots[1] = 7
+	expected, _ := MarshalBytes(&v, binary.LittleEndian, BlobLength8)
+	if !bytes.Equal(buf, expected) {
+		t.Errorf("patched % x, want % x", buf, expected)
+	}
+}
+
+func TestPatchVariableStruct(t *testing.T) {
+	typ := reflect.TypeOf(stampedTail{})
+	if off, size, err := FieldOffset(typ, "In.X"); err != nil || off != 1 || size != 2 {
+		t.Errorf("FieldOffset(In.X): %d, %d, %v, want 1, 2", off, size, err)
+	}
+	for _, path := range []string{"In", "In.Name", "In.Y"} {
+		if _, _, err := FieldOffset(typ, path); err == nil {
+			t.Errorf("FieldOffset(%s): expected an error", path)
+		}
+	}
+	var v stampedTail
+	v.In.Name = "abc"
+	buf, err := MarshalBytes(&v, binary.BigEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Patch(buf, typ, "In.X", uint16(0x102), binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	v.In.X = 0x102
+	if expected, _ := MarshalBytes(&v, binary.BigEndian, BlobLength8); !bytes.Equal(buf, expected) {
+		t.Errorf("patched % x, want % x", buf, expected)
+	}
+}
+
+func TestPatchErrors(t *testing.T) {
+	typ := reflect.TypeOf(stamped{})
+	buf := make([]byte, 16)
+	for _, path := range []string{"Body", "Nope", "Slots[3]", "Kind.X", "Kind[0]"} {
+		if _, _, err := FieldOffset(typ, path); err == nil {
+			t.Errorf("FieldOffset(%s): expected an error", path)
+		}
+	}
+	if _, _, err := FieldOffset(reflect.TypeOf(Foo{}), "Ssid"); err == nil {
+		t.Errorf("expected an error for a field after a variable-length one")
+	}
+	if err := Patch(buf, typ, "Header.Seq", 5, binary.BigEndian); err == nil {
+		t.Errorf("expected an error for a value of the wrong type")
+	}
+	if err := Patch(buf[:4], typ, "Header.Seq", uint32(5), binary.BigEndian); err == nil {
+		t.Errorf("expected an error for a short buffer")
+	}
+}
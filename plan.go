@@ -0,0 +1,440 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"sync"
+	"unsafe"
+)
+
+//typePlan caches what marshaler and unmarshaler need to know about a type,
+//it is built once per type and shared by all goroutines
+type typePlan struct {
+	//size is the encoded size of a fully fixed-size type, -1 for variable length types
+	size   int
+	fields []fieldPlan
+	elem   *typePlan
+	//err reports a malformed struct tag or a map key type that can't round
+	//trip, the type can't be encoded
+	err error
+	//custom types are encoded by a registered codec or their own methods
+	custom bool
+	//optional types are a presence byte and the value when present, see Optional
+	optional bool
+	//counted structs have fields whose length is carried by another field
+	counted bool
+	//regions structs have payloads placed by offset and size fields after a
+	//fixed header of header bytes
+	regions bool
+	header  int
+	//bitfields structs pack adjacent bits= fields into shared bytes
+	bitfields bool
+	//nullable pointers to strings and slices are nil when NullableLength says null
+	nullable bool
+	//floatKey maps have keys that may hold floats, which must not be NaN
+	floatKey bool
+	//nfields is the number of fields in a fixed-size value, for Stats
+	nfields int
+	//nested is the number of levels of variable size arrays and slices holding
+	//arrays and slices from this one down, see marshaler.nested
+	nested int
+	//kind and mem are the kind and the size in memory of the type, fixed-size
+	//values are encoded from their memory with them, see putFixedAt
+	kind reflect.Kind
+	mem  uintptr
+	//word is the size of the words of the numbers of an array, see numberWord
+	word int
+	//settable fixed-size types have no unexported fields, getFixedAt may write them
+	settable bool
+}
+
+type fieldPlan struct {
+	index int
+	name  string
+	//offset of the field inside its struct encoding, only valid when the struct is fixed-size
+	offset int
+	plan   *typePlan
+	//tag is nil for fields without a marshal tag
+	tag *fieldTag
+	//countedBy is the field whose element count this field carries, see count=
+	countedBy *fieldPlan
+	//scales is the field whose quantize scale or bias this field holds, see scale=
+	scales *fieldPlan
+	//offsetFor and sizeFor are the payload whose region this field carries, see offset=
+	offsetFor, sizeFor *fieldPlan
+	//sizeOf is the field whose encoded size this field holds and sizedBy the
+	//field holding this field's size, see sizeof=
+	sizeOf, sizedBy *fieldPlan
+	//bits is the group of bits= fields this field is packed in
+	bits *bitGroup
+	//addr is the offset of the field in the memory of its struct
+	addr uintptr
+}
+
+var plans sync.Map //reflect.Type -> *typePlan
+
+var planLock sync.Mutex
+
+func planFor(t reflect.Type) *typePlan {
+	if p, ok := plans.Load(t); ok {
+		return p.(*typePlan)
+	}
+	planLock.Lock()
+	defer planLock.Unlock()
+	return buildPlan(t, map[reflect.Type]*typePlan{})
+}
+
+func buildPlan(t reflect.Type, building map[reflect.Type]*typePlan) *typePlan {
+	if p, ok := plans.Load(t); ok {
+		return p.(*typePlan)
+	}
+	if p, ok := building[t]; ok {
+		//recursive type, can't be fixed-size
+		return p
+	}
+	p := &typePlan{size: -1, kind: t.Kind(), mem: t.Size(), settable: true}
+	building[t] = p
+	if isCustom(t) {
+		p.custom = true
+		plans.Store(t, p)
+		return p
+	}
+	if isOptional(t) {
+		p.optional = true
+		plans.Store(t, p)
+		return p
+	}
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int8, reflect.Uint8:
+		p.size = 1
+	case reflect.Int16, reflect.Uint16:
+		p.size = 2
+	case reflect.Int32, reflect.Uint32, reflect.Float32:
+		p.size = 4
+	case reflect.Int64, reflect.Uint64, reflect.Float64, reflect.Complex64:
+		p.size = 8
+	case reflect.Complex128:
+		p.size = 16
+	case reflect.Array:
+		p.elem = buildPlan(t.Elem(), building)
+		if p.elem.size >= 0 {
+			p.size = p.elem.size * t.Len()
+		}
+		p.nested = nestedLevels(t, p)
+		p.word = numberWord(t.Elem())
+		p.settable = p.elem.settable
+	case reflect.Slice, reflect.Ptr:
+		p.elem = buildPlan(t.Elem(), building)
+		if k := t.Elem().Kind(); t.Kind() == reflect.Ptr && (k == reflect.String || k == reflect.Slice) {
+			p.nullable = !isCustom(t.Elem())
+		}
+		if t.Kind() == reflect.Slice {
+			p.nested = nestedLevels(t, p)
+		}
+	case reflect.Map:
+		floatKey, err := checkMapKey(t.Key())
+		if err != nil {
+			p.err = fmt.Errorf("marshal: %s: %w", t, err)
+		}
+		p.floatKey = floatKey
+	case reflect.Struct:
+		p.fields = make([]fieldPlan, 0, t.NumField())
+		size := 0
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			fp := buildPlan(f.Type, building)
+			fld := fieldPlan{index: i, name: f.Name, offset: size, plan: fp, addr: f.Offset}
+			if tag, length, ok := fieldTagOf(t, f); ok {
+				ft, err := parseTag(tag)
+				if err == nil {
+					if length != nil {
+						//ConfigureType wins over len=
+						ft.length = length
+					}
+					err = ft.check(f, fp)
+				}
+				if err != nil && p.err == nil {
+					p.err = fmt.Errorf("marshal: %s.%s: %v", t, f.Name, err)
+				}
+				if ft != nil && ft.skip {
+					continue
+				}
+				fld.tag = ft
+				size = -1
+			}
+			p.settable = p.settable && f.IsExported() && fp.settable
+			p.fields = append(p.fields, fld)
+			if size >= 0 && fp.size >= 0 {
+				size += fp.size
+			} else {
+				size = -1
+			}
+		}
+		p.size = size
+		if err := p.resolveCounts(t); err != nil && p.err == nil {
+			p.err = err
+		}
+		if err := p.resolveScales(t); err != nil && p.err == nil {
+			p.err = err
+		}
+		if err := p.resolveRegions(t); err != nil && p.err == nil {
+			p.err = err
+		}
+		if err := p.resolveSizes(t); err != nil && p.err == nil {
+			p.err = err
+		}
+		if err := p.resolveBits(t); err != nil && p.err == nil {
+			p.err = err
+		}
+	}
+	if p.size > 0 {
+		p.nfields = fieldCount(t, p)
+	}
+	plans.Store(t, p)
+	return p
+}
+
+var scratchPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 512)
+		return &b
+	},
+}
+
+func getScratch(n int) *[]byte {
+	bp := scratchPool.Get().(*[]byte)
+	if cap(*bp) < n {
+		*bp = make([]byte, n)
+	}
+	*bp = (*bp)[:n]
+	return bp
+}
+
+//putFixed encodes a fixed-size value into b which must be exactly p.size long
+func putFixed(b []byte, v reflect.Value, p *typePlan, order binary.ByteOrder) {
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			b[0] = 1
+		} else {
+			b[0] = 0
+		}
+	case reflect.Int8:
+		b[0] = uint8(v.Int())
+	case reflect.Int16:
+		order.PutUint16(b, uint16(v.Int()))
+	case reflect.Int32:
+		order.PutUint32(b, uint32(v.Int()))
+	case reflect.Int64:
+		order.PutUint64(b, uint64(v.Int()))
+	case reflect.Uint8:
+		b[0] = uint8(v.Uint())
+	case reflect.Uint16:
+		order.PutUint16(b, uint16(v.Uint()))
+	case reflect.Uint32:
+		order.PutUint32(b, uint32(v.Uint()))
+	case reflect.Uint64:
+		order.PutUint64(b, v.Uint())
+	case reflect.Float32:
+		order.PutUint32(b, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		order.PutUint64(b, math.Float64bits(v.Float()))
+	case reflect.Complex64:
+		x := v.Complex()
+		order.PutUint32(b, math.Float32bits(float32(real(x))))
+		order.PutUint32(b[4:], math.Float32bits(float32(imag(x))))
+	case reflect.Complex128:
+		x := v.Complex()
+		order.PutUint64(b, math.Float64bits(real(x)))
+		order.PutUint64(b[8:], math.Float64bits(imag(x)))
+	case reflect.Array:
+		if bs := byteView(v); bs != nil {
+			copy(b, bs)
+			return
+		}
+		if bs := nativeView(v, order); bs != nil {
+			copy(b, bs)
+			return
+		}
+		if bs, size := swapView(v, order); bs != nil {
+			swapBytes(b, bs, size)
+			return
+		}
+		sz := p.elem.size
+		for i := 0; i < v.Len(); i++ {
+			putFixed(b[i*sz:(i+1)*sz], v.Index(i), p.elem, order)
+		}
+	case reflect.Struct:
+		for i := range p.fields {
+			f := &p.fields[i]
+			putFixed(b[f.offset:f.offset+f.plan.size], v.Field(f.index), f.plan, order)
+		}
+	}
+}
+
+//getFixed decodes a fixed-size value from b which must be exactly p.size long
+func getFixed(b []byte, v reflect.Value, p *typePlan, order binary.ByteOrder) {
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(b[0] != 0)
+	case reflect.Int8:
+		v.SetInt(int64(int8(b[0])))
+	case reflect.Int16:
+		v.SetInt(int64(int16(order.Uint16(b))))
+	case reflect.Int32:
+		v.SetInt(int64(int32(order.Uint32(b))))
+	case reflect.Int64:
+		v.SetInt(int64(order.Uint64(b)))
+	case reflect.Uint8:
+		v.SetUint(uint64(b[0]))
+	case reflect.Uint16:
+		v.SetUint(uint64(order.Uint16(b)))
+	case reflect.Uint32:
+		v.SetUint(uint64(order.Uint32(b)))
+	case reflect.Uint64:
+		v.SetUint(order.Uint64(b))
+	case reflect.Float32:
+		v.SetFloat(float64(math.Float32frombits(order.Uint32(b))))
+	case reflect.Float64:
+		v.SetFloat(math.Float64frombits(order.Uint64(b)))
+	case reflect.Complex64:
+		v.SetComplex(complex(
+			float64(math.Float32frombits(order.Uint32(b))),
+			float64(math.Float32frombits(order.Uint32(b[4:]))),
+		))
+	case reflect.Complex128:
+		v.SetComplex(complex(
+			math.Float64frombits(order.Uint64(b)),
+			math.Float64frombits(order.Uint64(b[8:])),
+		))
+	case reflect.Array:
+		if bs := byteView(v); bs != nil {
+			copy(bs, b)
+			return
+		}
+		if bs := nativeView(v, order); bs != nil {
+			copy(bs, b)
+			return
+		}
+		if bs, size := swapView(v, order); bs != nil {
+			swapBytes(bs, b, size)
+			return
+		}
+		sz := p.elem.size
+		for i := 0; i < v.Len(); i++ {
+			getFixed(b[i*sz:(i+1)*sz], v.Index(i), p.elem, order)
+		}
+	case reflect.Struct:
+		for i := range p.fields {
+			f := &p.fields[i]
+			getFixed(b[f.offset:f.offset+f.plan.size], v.Field(f.index), f.plan, order)
+		}
+	}
+}
+
+//putFixedAt is putFixed for the value of plan p at ptr, it reads the memory of the
+//value instead of going through reflect.Value for each field and element
+func putFixedAt(b []byte, ptr unsafe.Pointer, p *typePlan, order binary.ByteOrder) {
+	switch p.kind {
+	case reflect.Bool:
+		if *(*bool)(ptr) {
+			b[0] = 1
+		} else {
+			b[0] = 0
+		}
+	case reflect.Int8, reflect.Uint8:
+		b[0] = *(*uint8)(ptr)
+	case reflect.Int16, reflect.Uint16:
+		order.PutUint16(b, *(*uint16)(ptr))
+	case reflect.Int32, reflect.Uint32, reflect.Float32:
+		order.PutUint32(b, *(*uint32)(ptr))
+	case reflect.Int64, reflect.Uint64, reflect.Float64:
+		order.PutUint64(b, *(*uint64)(ptr))
+	case reflect.Complex64:
+		order.PutUint32(b, *(*uint32)(ptr))
+		order.PutUint32(b[4:], *(*uint32)(unsafe.Add(ptr, 4)))
+	case reflect.Complex128:
+		order.PutUint64(b, *(*uint64)(ptr))
+		order.PutUint64(b[8:], *(*uint64)(unsafe.Add(ptr, 8)))
+	case reflect.Array:
+		if p.size == 0 {
+			return
+		}
+		mem := unsafe.Slice((*byte)(ptr), p.mem)
+		switch k := p.elem.kind; {
+		case k == reflect.Uint8 || k == reflect.Int8, p.word > 0 && isNative(order):
+			copy(b, mem)
+			return
+		case p.word > 0 && isSwapped(order):
+			swapBytes(b, mem, p.word)
+			return
+		}
+		sz := p.elem.size
+		for i := 0; i < p.size/sz; i++ {
+			putFixedAt(b[i*sz:(i+1)*sz], unsafe.Add(ptr, uintptr(i)*p.elem.mem), p.elem, order)
+		}
+	case reflect.Struct:
+		for i := range p.fields {
+			f := &p.fields[i]
+			putFixedAt(b[f.offset:f.offset+f.plan.size], unsafe.Add(ptr, f.addr), f.plan, order)
+		}
+	}
+}
+
+//getFixedAt is getFixed for the value of plan p at ptr, which must be settable
+func getFixedAt(b []byte, ptr unsafe.Pointer, p *typePlan, order binary.ByteOrder) {
+	switch p.kind {
+	case reflect.Bool:
+		*(*bool)(ptr) = b[0] != 0
+	case reflect.Int8, reflect.Uint8:
+		*(*uint8)(ptr) = b[0]
+	case reflect.Int16, reflect.Uint16:
+		*(*uint16)(ptr) = order.Uint16(b)
+	case reflect.Int32, reflect.Uint32, reflect.Float32:
+		*(*uint32)(ptr) = order.Uint32(b)
+	case reflect.Int64, reflect.Uint64, reflect.Float64:
+		*(*uint64)(ptr) = order.Uint64(b)
+	case reflect.Complex64:
+		*(*uint32)(ptr) = order.Uint32(b)
+		*(*uint32)(unsafe.Add(ptr, 4)) = order.Uint32(b[4:])
+	case reflect.Complex128:
+		*(*uint64)(ptr) = order.Uint64(b)
+		*(*uint64)(unsafe.Add(ptr, 8)) = order.Uint64(b[8:])
+	case reflect.Array:
+		if p.size == 0 {
+			return
+		}
+		mem := unsafe.Slice((*byte)(ptr), p.mem)
+		switch k := p.elem.kind; {
+		case k == reflect.Uint8 || k == reflect.Int8, p.word > 0 && isNative(order):
+			copy(mem, b)
+			return
+		case p.word > 0 && isSwapped(order):
+			swapBytes(mem, b, p.word)
+			return
+		}
+		sz := p.elem.size
+		for i := 0; i < p.size/sz; i++ {
+			getFixedAt(b[i*sz:(i+1)*sz], unsafe.Add(ptr, uintptr(i)*p.elem.mem), p.elem, order)
+		}
+	case reflect.Struct:
+		for i := range p.fields {
+			f := &p.fields[i]
+			getFixedAt(b[f.offset:f.offset+f.plan.size], unsafe.Add(ptr, f.addr), f.plan, order)
+		}
+	}
+}
+
+//byteView returns the memory of an addressable byte sized array or a byte sized slice,
+//nil when v can't be viewed as bytes
+func byteView(v reflect.Value) []byte {
+	if k := v.Type().Elem().Kind(); k != reflect.Uint8 && k != reflect.Int8 {
+		return nil
+	}
+	if v.Len() == 0 || (v.Kind() == reflect.Array && !v.CanAddr()) {
+		return nil
+	}
+	return unsafe.Slice((*byte)(v.Index(0).Addr().UnsafePointer()), v.Len())
+}
@@ -0,0 +1,173 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func TestPlanSize(t *testing.T) {
+	cases := []struct {
+		v    interface{}
+		size int
+	}{
+		{uint16(0), 2},
+		{[3]uint32{}, 12},
+		{Pod{}, binary.Size(Pod{})},
+		{struct{ A, B int8 }{}, 2},
+		{bar{}, -1},
+		{Foo{}, -1},
+		{[]uint8{}, -1},
+		{struct{ A int }{}, -1},
+	}
+	for _, c := range cases {
+		if s := planFor(reflect.TypeOf(c.v)).size; s != c.size {
+			t.Errorf("%T: size %d, want %d", c.v, s, c.size)
+		}
+	}
+}
+
+func TestFixedSingleWrite(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		w := &countingWriter{}
+		if e := Marshal(createPodObject(), w, order, BlobLength32); e != nil {
+			t.Fatalf("marshal: %v", e)
+		}
+		if w.writes != 1 {
+			t.Errorf("%v: %d writes, want 1", order, w.writes)
+		}
+		expected := new(bytes.Buffer)
+		binary.Write(expected, order, createPodObject())
+		if !bytes.Equal(w.Bytes(), expected.Bytes()) {
+			t.Errorf("%v: encoding differs from encoding/binary", order)
+		}
+		var readBack Pod
+		if e := Unmarshal(&readBack, bytes.NewReader(w.Bytes()), order, BlobLength32); e != nil {
+			t.Fatalf("unmarshal: %v", e)
+		}
+		if readBack != *createPodObject() {
+			t.Errorf("%v: proto and readBack are NOT equal", order)
+		}
+	}
+}
+
+type fixedKinds struct {
+	B    bool
+	I8   int8
+	I16  int16
+	I32  int32
+	I64  int64
+	U8   uint8
+	U16  uint16
+	U32  uint32
+	U64  uint64
+	F32  float32
+	F64  float64
+	C64  complex64
+	C128 complex128
+	Bars [2]fixedBar
+	Bits [3]bool
+	Odd  [3]uint16
+	None [0]uint32
+}
+
+type fixedBar struct {
+	Tag uint8
+	X   int32
+	C   complex64
+}
+
+func TestFixedAt(t *testing.T) {
+	v := fixedKinds{true, -2, -300, -70000, -1 << 40, 0xfe, 0xfedc, 0xfedcba98, 1<<64 - 2, 1.5, -2.25, complex(1, -2), complex(-3, 4),
+		[2]fixedBar{{1, -1, complex(5, 6)}, {2, 1 << 30, 0}}, [3]bool{true, false, true}, [3]uint16{1, 0x102, 0xffff}, [0]uint32{}}
+	if p := planFor(reflect.TypeOf(v)); p.size <= 0 || !p.settable {
+		t.Fatalf("plan size %d, settable %v", p.size, p.settable)
+	}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian, PDPEndian} {
+		//the value isn't addressable when it isn't behind a pointer and is read
+		//through reflect.Value
+		want, err := MarshalBytes(v, order, BlobLength8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := MarshalBytes(&v, order, BlobLength8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, want) {
+			t.Errorf("%v: encoded % x, want % x", order, b, want)
+		}
+		var readBack fixedKinds
+		if err := UnmarshalBytes(&readBack, b, order, BlobLength8); err != nil {
+			t.Fatal(err)
+		}
+		if readBack != v {
+			t.Errorf("%v: read back %+v, want %+v", order, readBack, v)
+		}
+	}
+}
+
+func TestFixedAtUnexported(t *testing.T) {
+	type hidden struct {
+		A uint16
+		b uint16
+	}
+	if planFor(reflect.TypeOf(hidden{})).settable {
+		t.Errorf("struct with an unexported field is settable")
+	}
+	b, err := MarshalBytes(&hidden{1, 2}, binary.BigEndian, BlobLength8)
+	if err != nil || !bytes.Equal(b, []byte{0, 1, 0, 2}) {
+		t.Errorf("encoded % x, %v", b, err)
+	}
+	var readBack hidden
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("decoded into an unexported field: %+v", readBack)
+	}
+}
+
+func BenchmarkFixedFields(b *testing.B) {
+	v := fixedKinds{I32: 1, U64: 2, F64: 3, Bars: [2]fixedBar{{Tag: 1}, {Tag: 2}}}
+	buf, err := MarshalBytes(&v, binary.BigEndian, BlobLength8)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Run("encode", func(b *testing.B) {
+		b.ReportAllocs()
+		w := &bytes.Buffer{}
+		for i := 0; i < b.N; i++ {
+			w.Reset()
+			Marshal(&v, w, binary.BigEndian, BlobLength8)
+		}
+	})
+	b.Run("decode", func(b *testing.B) {
+		b.ReportAllocs()
+		var readBack fixedKinds
+		for i := 0; i < b.N; i++ {
+			UnmarshalBytes(&readBack, buf, binary.BigEndian, BlobLength8)
+		}
+	})
+}
+
+func BenchmarkMarshalPod(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 2; j++ {
+			for k := 0; k < 8; k++ {
+				result := new(bytes.Buffer)
+				Marshal(createPodObject(), result, binary.LittleEndian, BlobLength32)
+				var readBack Pod
+				Unmarshal(&readBack, result, binary.LittleEndian, BlobLength32)
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"sync"
+)
+
+//pooled buffers come in size classes of powers of two from 1<<minPoolShift to
+//1<<maxPoolShift bytes, larger ones are left to the garbage collector
+const (
+	minPoolShift = 6
+	maxPoolShift = 24
+)
+
+var bufferPools [maxPoolShift - minPoolShift + 1]sync.Pool
+
+//pooledBuffer is an io.Writer appending to a buffer of a size class, it moves
+//to a larger class as it fills
+type pooledBuffer struct {
+	b []byte
+	//release is bound once so handing it out doesn't allocate
+	release func()
+}
+
+//poolClass is the class of buffers holding n bytes, -1 when it is too large
+func poolClass(n int) int {
+	if n <= 1<<minPoolShift {
+		return 0
+	}
+	c := bits.Len(uint(n-1)) - minPoolShift
+	if c >= len(bufferPools) {
+		return -1
+	}
+	return c
+}
+
+func getPooledBuffer(n int) *pooledBuffer {
+	c := poolClass(n)
+	if c < 0 {
+		p := &pooledBuffer{b: make([]byte, 0, n)}
+		p.release = p.put
+		return p
+	}
+	if p, ok := bufferPools[c].Get().(*pooledBuffer); ok {
+		return p
+	}
+	p := &pooledBuffer{b: make([]byte, 0, 1<<(c+minPoolShift))}
+	p.release = p.put
+	return p
+}
+
+//put returns p to the pool of its class
+func (p *pooledBuffer) put() {
+	c := poolClass(cap(p.b))
+	if c < 0 || cap(p.b) != 1<<(c+minPoolShift) {
+		return
+	}
+	p.b = p.b[:0]
+	bufferPools[c].Put(p)
+}
+
+func (p *pooledBuffer) Write(b []byte) (int, error) {
+	if need := len(p.b) + len(b); need > cap(p.b) {
+		//swap in the bytes of a larger buffer, which goes back to the pool with ours
+		q := getPooledBuffer(max(need, 2*cap(p.b)))
+		q.b = append(q.b, p.b...)
+		p.b, q.b = q.b, p.b
+		q.put()
+	}
+	p.b = append(p.b, b...)
+	return len(b), nil
+}
+
+//noRelease is the release func of failed calls
+func noRelease() {}
+
+//MarshalPooled returns binary presentation of v in a buffer taken from an
+//internal pool, release hands the buffer back. buf must not be used after
+//release. In steady state neither call allocates
+func MarshalPooled(v interface{}, order binary.ByteOrder, length LengthType, opts ...Option) (buf []byte, release func(), err error) {
+	return marshalPooled(v, order, length(), newOptions(opts))
+}
+
+func marshalPooled(v interface{}, order binary.ByteOrder, length LengthTypeInstance, o *options) ([]byte, func(), error) {
+	p := getPooledBuffer(0)
+	if _, err := encode(v, p, order, length, o); err != nil {
+		p.put()
+		return nil, noRelease, err
+	}
+	return p.b, p.release, nil
+}
+
+//EncodeToPooled is MarshalPooled with the settings of e, the stream of e is
+//left alone
+func (e *Encoder) EncodeToPooled(v interface{}) (buf []byte, release func(), err error) {
+	return marshalPooled(v, e.order, e.length, e.o)
+}
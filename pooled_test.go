@@ -0,0 +1,57 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestMarshalPooled(t *testing.T) {
+	v := createStableObject()
+	expected, err := MarshalBytes(v, binary.BigEndian, BlobLength32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		buf, release, err := MarshalPooled(v, binary.BigEndian, BlobLength32)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf, expected) {
+			t.Errorf("pooled % x, want % x", buf, expected)
+		}
+		release()
+	}
+	//a message growing through several size classes
+	big := bytes.Repeat([]byte{7}, 5000)
+	buf, release, err := MarshalPooled(big, binary.BigEndian, BlobLength32)
+	if err != nil || len(buf) != 5004 || !bytes.Equal(buf[4:], big) {
+		t.Errorf("pooled %d bytes, %v", len(buf), err)
+	}
+	release()
+	e := NewEncoder(nil, binary.BigEndian, BlobLength32)
+	buf, release, err = e.EncodeToPooled(v)
+	if err != nil || !bytes.Equal(buf, expected) {
+		t.Errorf("encoded % x, %v, want % x", buf, err, expected)
+	}
+	release()
+	if _, release, err := MarshalPooled("too long", binary.BigEndian, Bound32(2)); err == nil {
+		t.Errorf("expected an error for a string over the bound")
+	} else {
+		release()
+	}
+}
+
+func TestMarshalPooledAllocs(t *testing.T) {
+	v := &Pod{}
+	plain := testing.AllocsPerRun(100, func() {
+		MarshalBytes(v, binary.BigEndian, BlobLength32)
+	})
+	pooled := testing.AllocsPerRun(100, func() {
+		_, release, _ := MarshalPooled(v, binary.BigEndian, BlobLength32)
+		release()
+	})
+	if pooled >= plain {
+		t.Errorf("MarshalPooled allocates %v times, MarshalBytes %v", pooled, plain)
+	}
+}
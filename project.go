@@ -0,0 +1,112 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+//selection is a tree of requested field names, a nil selection takes the whole value
+type selection map[string]selection
+
+//UnmarshalFields decodes only the named fields of one message from r into the struct
+//m points to and skips the bytes of all others, see Skip. Nested fields are named with
+//dots, e.g. "Header.Kind". Fields not requested are left untouched
+func UnmarshalFields(r io.Reader, m interface{}, order binary.ByteOrder, length LengthType, fields ...string) (err error) {
+	v := reflect.ValueOf(m)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("unmarshal: UnmarshalFields into %T, want a pointer to a struct", m)
+	}
+	sel := selection{}
+	for _, f := range fields {
+		if err := sel.add(v.Elem().Type(), f); err != nil {
+			return err
+		}
+	}
+	u := getUnmarshaler(r, noOptions)
+	defer putUnmarshaler(u)
+	defer recoverError(&err)
+	u.push(rootElem(v.Type()))
+	u.project(v.Elem(), sel, order, length())
+	return
+}
+
+//add records the dotted path name, checking it against the fields of t
+func (s selection) add(t reflect.Type, name string) error {
+	first, rest, nested := strings.Cut(name, ".")
+	f, ok := t.FieldByName(first)
+	if !ok || len(f.Index) != 1 {
+		return fmt.Errorf("unmarshal: %s has no field %s", t, first)
+	}
+	if !nested {
+		s[first] = nil
+		return nil
+	}
+	if f.Type.Kind() != reflect.Struct {
+		return fmt.Errorf("unmarshal: field %s.%s is not a struct", t, first)
+	}
+	child, ok := s[first]
+	if ok && child == nil {
+		//the whole field is already requested
+		return nil
+	}
+	if child == nil {
+		child = selection{}
+		s[first] = child
+	}
+	return child.add(f.Type, rest)
+}
+
+func (u *unmarshaler) project(v reflect.Value, sel selection, order binary.ByteOrder, length LengthTypeInstance) {
+	t := v.Type()
+	p := planFor(t)
+	if p.err != nil {
+		panic(p.err)
+	}
+	//scratch receives tagged fields not requested and the counts later fields depend on
+	scratch := reflect.New(t).Elem()
+	if p.regions || p.bitfields {
+		//payloads are placed by the header and bit groups share bytes, decode all
+		//and keep the selection
+		u.unmarshal(scratch, order, length)
+		sel.copy(v, scratch)
+		return
+	}
+	for i := range p.fields {
+		f := &p.fields[i]
+		child, want := sel[f.name]
+		u.push(fieldElem(f.name))
+		switch {
+		case want && child != nil && f.tag == nil && !f.plan.custom && !f.plan.optional:
+			u.project(v.Field(f.index), child, order, length)
+		case want && f.tag != nil:
+			u.unmarshalTagged(v.Field(f.index), scratch, f, order, length)
+		case want:
+			u.unmarshal(v.Field(f.index), order, length)
+		case f.tag != nil:
+			u.unmarshalTagged(scratch.Field(f.index), scratch, f, order, length)
+		case f.countedBy != nil || f.scales != nil:
+			u.unmarshal(scratch.Field(f.index), order, length)
+		default:
+			u.skip(t.Field(f.index).Type, order, length)
+		}
+		if want && (f.countedBy != nil || f.scales != nil) {
+			scratch.Field(f.index).Set(v.Field(f.index))
+		}
+		u.pop()
+	}
+}
+
+//copy sets the selected fields of dst from src
+func (s selection) copy(dst, src reflect.Value) {
+	for name, child := range s {
+		d, v := dst.FieldByName(name), src.FieldByName(name)
+		if child == nil {
+			d.Set(v)
+		} else {
+			child.copy(d, v)
+		}
+	}
+}
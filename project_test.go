@@ -0,0 +1,55 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+func TestUnmarshalFields(t *testing.T) {
+	proto := createTestObject()
+	b, err := MarshalBytes(proto, binary.BigEndian, BlobLength16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//two messages back to back, the first must be consumed exactly
+	r := bytes.NewReader(append(b, b...))
+	var head Foo
+	if err := UnmarshalFields(r, &head, binary.BigEndian, BlobLength16, "Ssid", "Uid", "Bar.Id"); err != nil {
+		t.Fatal(err)
+	}
+	if head.Ssid != proto.Ssid || head.Uid != proto.Uid || head.Bar.Id != proto.Bar.Id {
+		t.Errorf("selected fields: %d %d %q", head.Ssid, head.Uid, head.Bar.Id)
+	}
+	if head.Version != nil || head.Bar.Prop != nil || head.Tick != 0 || head.OK {
+		t.Errorf("unselected fields were decoded: %+v", head)
+	}
+	if r.Len() != len(b) {
+		t.Errorf("consumed %d bytes, want %d", 2*len(b)-r.Len(), len(b))
+	}
+
+	var whole Foo
+	if err := UnmarshalFields(onlyReader{r}, &whole, binary.BigEndian, BlobLength16, "Bar", "Bar.Prop"); err != nil {
+		t.Fatal(err)
+	}
+	if whole.Bar.Id != proto.Bar.Id || len(whole.Bar.Prop) != len(proto.Bar.Prop) {
+		t.Errorf("Bar: %+v", whole.Bar)
+	}
+	if r.Len() != 0 {
+		t.Errorf("%d bytes left", r.Len())
+	}
+}
+
+func TestUnmarshalFieldsErrors(t *testing.T) {
+	var v Foo
+	if err := UnmarshalFields(bytes.NewReader(nil), &v, binary.BigEndian, BlobLength16, "Nope"); err == nil {
+		t.Errorf("expected an error for an unknown field")
+	}
+	if err := UnmarshalFields(bytes.NewReader(nil), &v, binary.BigEndian, BlobLength16, "Ssid.X"); err == nil {
+		t.Errorf("expected an error for a path through a non-struct")
+	}
+	if err := UnmarshalFields(bytes.NewReader(nil), &v, binary.BigEndian, BlobLength16, "Ssid"); err != io.EOF {
+		t.Errorf("empty input: %v, want io.EOF", err)
+	}
+}
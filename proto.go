@@ -0,0 +1,416 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//ProtobufWire makes Marshal and Unmarshal write a struct in the protobuf wire
+//format instead, so protobuf parsers can read it. Fields numbered with a
+//`pb:"n"` tag are written as the varint key n<<3|wire type and their value:
+//
+//	bool, integers          varint, negative int32 and int64 alike take 10 bytes
+//	pb:"n,zigzag"           signed integer as a zigzag varint, sint32 and sint64
+//	pb:"n,fixed"            32 or 64 bit integer as fixed32, fixed64, sfixed32 or sfixed64
+//	float32, float64        fixed32 and fixed64, float and double
+//	string, []byte          length-delimited
+//	struct, *struct         length-delimited nested message
+//	*T                      T, written when the pointer isn't nil even if T is zero
+//	[]T                     repeated T, packed for numbers
+//
+//Zero values are left out like proto3 does, nil pointers and empty slices too.
+//Exported fields without a pb tag are an error, pb:"-" leaves a field out. The
+//byte order, length type and marshal tags don't apply, and the message isn't
+//delimited: Unmarshal reads it to the end of the input. Decoding skips fields
+//of unknown numbers, accepts repeated numbers packed or not and merges a
+//message seen twice
+func ProtobufWire() Option {
+	return func(o *options) {
+		o.proto = true
+	}
+}
+
+//protobuf wire types
+const (
+	pbVarint  = 0
+	pbFixed64 = 1
+	pbBytes   = 2
+	pbFixed32 = 5
+)
+
+//pbField is a struct field numbered with a pb tag
+type pbField struct {
+	index int
+	name  string
+	num   uint64
+	//wire is the wire type of the value, of every element of repeated fields
+	wire int
+	//zigzag and fixed select the sint and fixed encodings of integers
+	zigzag, fixed bool
+	//repeated marks slices other than []byte, packed the ones of numbers
+	repeated, packed bool
+}
+
+type pbMessage struct {
+	fields []pbField
+	byNum  map[uint64]*pbField
+}
+
+var pbMessages sync.Map //reflect.Type -> *pbMessage
+
+//pbMessageFor returns the fields of the struct t, panicking on a bad pb tag
+func pbMessageFor(t reflect.Type) *pbMessage {
+	if pm, ok := pbMessages.Load(t); ok {
+		return pm.(*pbMessage)
+	}
+	pm := &pbMessage{byNum: map[uint64]*pbField{}}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, ok := f.Tag.Lookup("pb")
+		if tag == "-" || (!ok && !f.IsExported()) {
+			continue
+		}
+		pf, err := parsePBField(f, tag, ok)
+		if err != nil {
+			panic(fmt.Errorf("marshal: %s.%s: %v", t, f.Name, err))
+		}
+		pf.index = i
+		pm.fields = append(pm.fields, pf)
+	}
+	for i := range pm.fields {
+		f := &pm.fields[i]
+		if prev, dup := pm.byNum[f.num]; dup {
+			panic(fmt.Errorf("marshal: %s: fields %s and %s share pb number %d", t, prev.name, f.name, f.num))
+		}
+		pm.byNum[f.num] = f
+	}
+	stored, _ := pbMessages.LoadOrStore(t, pm)
+	return stored.(*pbMessage)
+}
+
+func parsePBField(f reflect.StructField, tag string, ok bool) (pbField, error) {
+	pf := pbField{name: f.Name}
+	if !ok {
+		return pf, errors.New(`no pb tag, use pb:"-" to leave the field out`)
+	}
+	if !f.IsExported() {
+		return pf, errors.New("pb tag on an unexported field")
+	}
+	num, opts, _ := strings.Cut(tag, ",")
+	n, err := strconv.ParseUint(num, 10, 32)
+	if err != nil || n == 0 || n > 1<<29-1 || (n >= 19000 && n <= 19999) {
+		return pf, fmt.Errorf("bad pb field number %q", num)
+	}
+	pf.num = n
+	for _, opt := range strings.Split(opts, ",") {
+		switch opt {
+		case "":
+		case "zigzag":
+			pf.zigzag = true
+		case "fixed":
+			pf.fixed = true
+		default:
+			return pf, fmt.Errorf("unknown pb option %q", opt)
+		}
+	}
+	t := f.Type
+	if t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8 {
+		pf.repeated = true
+		t = t.Elem()
+	}
+	if pf.wire, err = pf.wireType(t); err != nil {
+		return pf, err
+	}
+	pf.packed = pf.repeated && pf.wire != pbBytes
+	return pf, nil
+}
+
+//wireType is the wire type of values of type t written for f
+func (f *pbField) wireType(t reflect.Type) (int, error) {
+	if t.Kind() == reflect.Ptr && !f.repeated {
+		t = t.Elem()
+	}
+	k := t.Kind()
+	if f.zigzag && (k < reflect.Int || k > reflect.Int64) {
+		return 0, fmt.Errorf("zigzag on %s, want a signed integer", t)
+	}
+	if f.fixed && f.zigzag {
+		return 0, errors.New("zigzag and fixed together")
+	}
+	switch k {
+	case reflect.Int32, reflect.Uint32:
+		if f.fixed {
+			return pbFixed32, nil
+		}
+		return pbVarint, nil
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		if f.fixed {
+			return pbFixed64, nil
+		}
+		return pbVarint, nil
+	case reflect.Bool, reflect.Int8, reflect.Int16, reflect.Uint8, reflect.Uint16:
+		if f.fixed {
+			return 0, fmt.Errorf("fixed on %s, want a 32 or 64 bit integer", t)
+		}
+		return pbVarint, nil
+	case reflect.Float32:
+		return pbFixed32, nil
+	case reflect.Float64:
+		return pbFixed64, nil
+	case reflect.String, reflect.Struct:
+		return pbBytes, nil
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return pbBytes, nil
+		}
+	case reflect.Ptr:
+		if t.Elem().Kind() == reflect.Struct {
+			return pbBytes, nil
+		}
+	}
+	return 0, fmt.Errorf("%s has no protobuf wire format", t)
+}
+
+//pbMessage writes the struct v in the protobuf wire format
+func (m *marshaler) pbMessage(v reflect.Value) {
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		panic(errorf(ErrUnsupportedKind, "marshal: ProtobufWire needs a struct, not %s", v.Type()))
+	}
+	if _, err := m.w.Write(appendPBMessage(nil, v)); err != nil {
+		panic(err)
+	}
+}
+
+func appendPBMessage(b []byte, v reflect.Value) []byte {
+	pm := pbMessageFor(v.Type())
+	for i := range pm.fields {
+		f := &pm.fields[i]
+		fv := v.Field(f.index)
+		switch {
+		case f.packed:
+			if fv.Len() == 0 {
+				continue
+			}
+			var body []byte
+			for j := 0; j < fv.Len(); j++ {
+				body = f.appendValue(body, fv.Index(j))
+			}
+			b = binary.AppendUvarint(b, f.num<<3|pbBytes)
+			b = binary.AppendUvarint(b, uint64(len(body)))
+			b = append(b, body...)
+		case f.repeated:
+			for j := 0; j < fv.Len(); j++ {
+				b = binary.AppendUvarint(b, f.num<<3|uint64(f.wire))
+				b = f.appendValue(b, fv.Index(j))
+			}
+		default:
+			if fv.IsZero() || (fv.Kind() == reflect.Slice && fv.Len() == 0) {
+				continue
+			}
+			b = binary.AppendUvarint(b, f.num<<3|uint64(f.wire))
+			b = f.appendValue(b, fv)
+		}
+	}
+	return b
+}
+
+//appendValue appends the encoding of v without a key
+func (f *pbField) appendValue(b []byte, v reflect.Value) []byte {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			//a nil element of a repeated message is an empty message
+			return append(b, 0)
+		}
+		v = v.Elem()
+	}
+	switch k := v.Kind(); {
+	case k == reflect.Bool:
+		if v.Bool() {
+			return append(b, 1)
+		}
+		return append(b, 0)
+	case k >= reflect.Int && k <= reflect.Int64:
+		x := v.Int()
+		switch {
+		case f.zigzag:
+			return binary.AppendUvarint(b, uint64(x<<1^x>>63))
+		case f.wire == pbFixed32:
+			return binary.LittleEndian.AppendUint32(b, uint32(x))
+		case f.wire == pbFixed64:
+			return binary.LittleEndian.AppendUint64(b, uint64(x))
+		}
+		return binary.AppendUvarint(b, uint64(x))
+	case k >= reflect.Uint && k <= reflect.Uintptr:
+		switch f.wire {
+		case pbFixed32:
+			return binary.LittleEndian.AppendUint32(b, uint32(v.Uint()))
+		case pbFixed64:
+			return binary.LittleEndian.AppendUint64(b, v.Uint())
+		}
+		return binary.AppendUvarint(b, v.Uint())
+	case k == reflect.Float32:
+		return binary.LittleEndian.AppendUint32(b, math.Float32bits(float32(v.Float())))
+	case k == reflect.Float64:
+		return binary.LittleEndian.AppendUint64(b, math.Float64bits(v.Float()))
+	case k == reflect.String:
+		b = binary.AppendUvarint(b, uint64(v.Len()))
+		return append(b, v.String()...)
+	case k == reflect.Slice:
+		b = binary.AppendUvarint(b, uint64(v.Len()))
+		return append(b, v.Bytes()...)
+	case k == reflect.Struct:
+		body := appendPBMessage(nil, v)
+		b = binary.AppendUvarint(b, uint64(len(body)))
+		return append(b, body...)
+	}
+	panic(errorf(ErrUnsupportedKind, "marshal: %s has no protobuf wire format", v.Type()))
+}
+
+//pbMessage reads the rest of the input as the protobuf encoding of the struct v
+func (u *unmarshaler) pbMessage(v reflect.Value) {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		panic(errorf(ErrUnsupportedKind, "unmarshal: ProtobufWire needs a struct, not %s", v.Type()))
+	}
+	b, err := io.ReadAll(u.r)
+	if err != nil {
+		panic(err)
+	}
+	decodePBMessage(v, b)
+}
+
+//pbValue is one value of the input, x holds numbers and b length-delimited bytes
+type pbValue struct {
+	wire int
+	x    uint64
+	b    []byte
+}
+
+//nextPB splits the value of wire type wire off b
+func nextPB(b []byte, wire int) (pbValue, []byte) {
+	val := pbValue{wire: wire}
+	switch wire {
+	case pbVarint:
+		x, n := binary.Uvarint(b)
+		if n <= 0 {
+			panic(errorf(ErrMalformed, "unmarshal: bad protobuf varint"))
+		}
+		val.x, b = x, b[n:]
+	case pbFixed64:
+		if len(b) < 8 {
+			panic(io.ErrUnexpectedEOF)
+		}
+		val.x, b = binary.LittleEndian.Uint64(b), b[8:]
+	case pbFixed32:
+		if len(b) < 4 {
+			panic(io.ErrUnexpectedEOF)
+		}
+		val.x, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+	case pbBytes:
+		l, n := binary.Uvarint(b)
+		if n <= 0 {
+			panic(errorf(ErrMalformed, "unmarshal: bad protobuf length"))
+		}
+		if b = b[n:]; uint64(len(b)) < l {
+			panic(io.ErrUnexpectedEOF)
+		}
+		val.b, b = b[:l], b[l:]
+	default:
+		panic(errorf(ErrMalformed, "unmarshal: protobuf wire type %d isn't supported", wire))
+	}
+	return val, b
+}
+
+func decodePBMessage(v reflect.Value, b []byte) {
+	pm := pbMessageFor(v.Type())
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 || key>>3 == 0 {
+			panic(errorf(ErrMalformed, "unmarshal: bad protobuf field key"))
+		}
+		var val pbValue
+		val, b = nextPB(b[n:], int(key&7))
+		f := pm.byNum[key>>3]
+		if f == nil {
+			//unknown fields are skipped
+			continue
+		}
+		fv := v.Field(f.index)
+		switch {
+		case f.packed && val.wire == pbBytes:
+			for body := val.b; len(body) > 0; {
+				var elem pbValue
+				elem, body = nextPB(body, f.wire)
+				f.appendElem(fv, elem)
+			}
+		case f.repeated:
+			f.checkWire(v.Type(), val.wire)
+			f.appendElem(fv, val)
+		default:
+			f.checkWire(v.Type(), val.wire)
+			f.set(fv, val)
+		}
+	}
+}
+
+func (f *pbField) checkWire(t reflect.Type, wire int) {
+	if wire != f.wire {
+		panic(errorf(ErrMalformed, "unmarshal: %s.%s has wire type %d, want %d", t, f.name, wire, f.wire))
+	}
+}
+
+func (f *pbField) appendElem(s reflect.Value, val pbValue) {
+	e := reflect.New(s.Type().Elem()).Elem()
+	f.set(e, val)
+	s.Set(reflect.Append(s, e))
+}
+
+//set stores val in v, merging it into a message v already holds
+func (f *pbField) set(v reflect.Value, val pbValue) {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	switch k := v.Kind(); {
+	case k == reflect.Bool:
+		v.SetBool(val.x != 0)
+	case k >= reflect.Int && k <= reflect.Int64:
+		x := int64(val.x)
+		if f.zigzag {
+			x = int64(val.x>>1) ^ -int64(val.x&1)
+		} else if val.wire == pbFixed32 {
+			x = int64(int32(val.x))
+		}
+		//like protobuf, wider values are truncated
+		v.SetInt(x)
+	case k >= reflect.Uint && k <= reflect.Uintptr:
+		v.SetUint(val.x)
+	case k == reflect.Float32:
+		v.SetFloat(float64(math.Float32frombits(uint32(val.x))))
+	case k == reflect.Float64:
+		v.SetFloat(math.Float64frombits(val.x))
+	case k == reflect.String:
+		v.SetString(string(val.b))
+	case k == reflect.Slice:
+		v.SetBytes(append([]byte(nil), val.b...))
+	case k == reflect.Struct:
+		decodePBMessage(v, val.b)
+	}
+}
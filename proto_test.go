@@ -0,0 +1,157 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// the messages of the protobuf encoding guide, as protoc generates them from
+//
+//	message Test1 { int32 a = 1; }
+//	message Test2 { string b = 2; }
+//	message Test3 { Test1 c = 3; }
+//	message Test4 { string d = 1; repeated int32 e = 6; }
+type pbTest1 struct {
+	A int32 `pb:"1"`
+}
+
+type pbTest2 struct {
+	B string `pb:"2"`
+}
+
+type pbTest3 struct {
+	C pbTest1 `pb:"3"`
+}
+
+type pbTest4 struct {
+	D string  `pb:"1"`
+	E []int32 `pb:"6"`
+}
+
+type pbRecord struct {
+	ID    int32      `pb:"1"`
+	Delta int64      `pb:"2,zigzag"`
+	Mask  uint32     `pb:"3,fixed"`
+	Ratio float64    `pb:"4"`
+	Ok    bool       `pb:"5"`
+	Blob  []byte     `pb:"6"`
+	Tags  []string   `pb:"7"`
+	Kids  []*pbTest1 `pb:"8"`
+	Opt   *uint32    `pb:"9"`
+	Local string     `pb:"-"`
+}
+
+func TestProtobufWire(t *testing.T) {
+	var zero uint32
+	for _, c := range []struct {
+		v        interface{}
+		expected []byte
+	}{
+		{&pbTest1{150}, []byte{0x08, 0x96, 0x01}},
+		{&pbTest2{"testing"}, []byte{0x12, 0x07, 't', 'e', 's', 't', 'i', 'n', 'g'}},
+		{&pbTest3{pbTest1{150}}, []byte{0x1a, 0x03, 0x08, 0x96, 0x01}},
+		{&pbTest4{"hello", []int32{1, 2, 3}}, []byte{0x0a, 0x05, 'h', 'e', 'l', 'l', 'o', 0x32, 0x03, 0x01, 0x02, 0x03}},
+		{&pbTest4{E: []int32{3, 270, 86942}}, []byte{0x32, 0x06, 0x03, 0x8e, 0x02, 0x9e, 0xa7, 0x05}},
+		{&pbTest3{}, []byte{}},
+		{&pbRecord{ID: -1, Delta: -2, Mask: 0xdeadbeef, Ratio: 1.5, Ok: true, Blob: []byte{1, 2},
+			Tags: []string{"a", "b"}, Kids: []*pbTest1{{1}}, Opt: &zero}, []byte{
+			0x08, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01,
+			0x10, 0x03,
+			0x1d, 0xef, 0xbe, 0xad, 0xde,
+			0x21, 0, 0, 0, 0, 0, 0, 0xf8, 0x3f,
+			0x28, 0x01,
+			0x32, 0x02, 0x01, 0x02,
+			0x3a, 0x01, 'a', 0x3a, 0x01, 'b',
+			0x42, 0x02, 0x08, 0x01,
+			0x48, 0x00,
+		}},
+	} {
+		b, err := MarshalBytes(c.v, binary.BigEndian, BlobLength8, ProtobufWire())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, c.expected) {
+			t.Errorf("%T encoded % x, want % x", c.v, b, c.expected)
+		}
+		readBack := reflect.New(reflect.TypeOf(c.v).Elem())
+		if err := UnmarshalBytes(readBack.Interface(), b, binary.BigEndian, BlobLength8, ProtobufWire()); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(readBack.Interface(), c.v) {
+			t.Errorf("decoded %+v, want %+v", readBack.Elem(), reflect.ValueOf(c.v).Elem())
+		}
+	}
+}
+
+func TestProtobufWireDecoding(t *testing.T) {
+	//unpacked repeated numbers, and fields of every wire type this message doesn't know
+	in := []byte{
+		0x30, 0x01, 0x30, 0x02,
+		0x10, 0x05,
+		0x19, 1, 2, 3, 4, 5, 6, 7, 8,
+		0x25, 1, 2, 3, 4,
+		0x2a, 0x02, 0xff, 0xff,
+		0x32, 0x01, 0x03,
+		0x0a, 0x01, 'x',
+	}
+	var v pbTest4
+	if err := UnmarshalBytes(&v, in, binary.BigEndian, BlobLength8, ProtobufWire()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v, pbTest4{"x", []int32{1, 2, 3}}) {
+		t.Errorf("decoded %+v", v)
+	}
+	var m struct {
+		C struct {
+			A, B int32 `pb:"1"`
+		} `pb:"3"`
+	}
+	if err := UnmarshalBytes(&m, []byte{0x1a, 0x02, 0x08, 0x01}, binary.BigEndian, BlobLength8, ProtobufWire()); err == nil {
+		t.Errorf("expected an error for two fields numbered 1")
+	}
+	//a message seen twice is merged, the last scalar wins
+	var t3 pbTest3
+	if err := UnmarshalBytes(&t3, []byte{0x1a, 0x02, 0x08, 0x01, 0x1a, 0x02, 0x08, 0x07}, binary.BigEndian, BlobLength8, ProtobufWire()); err != nil || t3.C.A != 7 {
+		t.Errorf("decoded %+v, %v", t3, err)
+	}
+	for _, bad := range [][]byte{
+		{0x08},            //varint cut short
+		{0x0a, 0x05, 'h'}, //string cut short
+		{0x0a, 0x00},      //string for an int32
+		{0x0b, 0x0c},      //group
+		{0x00, 0x00},      //field number 0
+	} {
+		var v pbTest1
+		if err := UnmarshalBytes(&v, bad, binary.BigEndian, BlobLength8, ProtobufWire()); err == nil {
+			t.Errorf("% x: expected an error", bad)
+		}
+	}
+}
+
+func TestProtobufWireTags(t *testing.T) {
+	for _, v := range []interface{}{
+		&struct{ A int32 }{},
+		&struct {
+			A int32 `pb:"0"`
+		}{},
+		&struct {
+			A string `pb:"1,zigzag"`
+		}{},
+		&struct {
+			A int16 `pb:"1,fixed"`
+		}{},
+		&struct {
+			A map[string]int `pb:"1"`
+		}{},
+		&struct {
+			A int32 `pb:"1,packed"`
+		}{},
+		[]int32{1},
+	} {
+		if _, err := MarshalBytes(v, binary.BigEndian, BlobLength8, ProtobufWire()); err == nil {
+			t.Errorf("%T: expected an error", v)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+//quantizeKinds are the integer types quantize= writes floats as
+var quantizeKinds = map[string]reflect.Kind{
+	"int8": reflect.Int8, "int16": reflect.Int16, "int32": reflect.Int32,
+	"uint8": reflect.Uint8, "uint16": reflect.Uint16, "uint32": reflect.Uint32,
+}
+
+//quantizeRange is the smallest and largest value of the integer kind k
+func quantizeRange(k reflect.Kind) (lo, hi float64) {
+	bits := 8 * quantizeSize(k)
+	if k >= reflect.Uint8 {
+		return 0, math.Ldexp(1, bits) - 1
+	}
+	return -math.Ldexp(1, bits-1), math.Ldexp(1, bits-1) - 1
+}
+
+//quantizeSize is the wire size of a quantized integer of kind k
+func quantizeSize(k reflect.Kind) int {
+	if k >= reflect.Uint8 {
+		return 1 << (k - reflect.Uint8)
+	}
+	return 1 << (k - reflect.Int8)
+}
+
+//resolveScales links scale= and bias= tags of the struct t to the fields they
+//name, which must come first so they are decoded before the values they scale
+func (p *typePlan) resolveScales(t reflect.Type) error {
+	for i := range p.fields {
+		f := &p.fields[i]
+		if f.tag == nil || f.tag.scale == "" {
+			continue
+		}
+		for _, ref := range []struct {
+			name  string
+			index *int
+		}{{f.tag.scale, &f.tag.scaleIndex}, {f.tag.bias, &f.tag.biasIndex}} {
+			j := 0
+			for j < i && p.fields[j].name != ref.name {
+				j++
+			}
+			if j == i {
+				return fmt.Errorf("marshal: %s.%s: quantize field %s must be an earlier field", t, f.name, ref.name)
+			}
+			c := &p.fields[j]
+			if k := t.Field(c.index).Type.Kind(); k != reflect.Float32 && k != reflect.Float64 {
+				return fmt.Errorf("marshal: %s.%s: quantize field %s must be a float", t, f.name, c.name)
+			}
+			*ref.index = c.index
+			c.scales = f
+		}
+		//like counts, the scale lives in other fields
+		p.counted = true
+	}
+	return nil
+}
+
+//quantize writes the float slice or array v as integers of the kind ft.quantize,
+//each the rounded (x-bias)/scale, a slice behind its length prefix. Without
+//scale= and bias= fields the scale and bias mapping the smallest and largest
+//value onto the integer range are written as float64 before the integers
+func (m *marshaler) quantize(v, parent reflect.Value, ft *fieldTag, length LengthTypeInstance) {
+	l := v.Len()
+	if v.Kind() == reflect.Slice {
+		m.putLength(length, v.Type(), l)
+		if l == 0 {
+			return
+		}
+	}
+	lo, hi := quantizeRange(ft.quantize)
+	saturate := ft.saturate
+	var scale, bias float64
+	if ft.scale != "" {
+		scale, bias = parent.Field(ft.scaleIndex).Float(), parent.Field(ft.biasIndex).Float()
+		if scale == 0 || math.IsNaN(scale) || math.IsInf(scale, 0) {
+			panic(errorf(ErrMalformed, "marshal: quantize scale %v", scale))
+		}
+	} else {
+		scale, bias = quantizeScale(v, lo, hi)
+		//rounding errors may put the extremes a hair outside the range
+		saturate = true
+		b := m.buf[:8]
+		m.order.PutUint64(b, math.Float64bits(scale))
+		m.write(b)
+		m.order.PutUint64(b, math.Float64bits(bias))
+		m.write(b)
+	}
+	size := quantizeSize(ft.quantize)
+	bp := getScratch(l * size)
+	defer scratchPool.Put(bp)
+	b := *bp
+	for i := 0; i < l; i++ {
+		x := v.Index(i).Float()
+		if math.IsNaN(x) {
+			panic(errorf(ErrMalformed, "marshal: can't quantize NaN at element %d", i))
+		}
+		q := math.Round((x - bias) / scale)
+		if q < lo || q > hi {
+			if !saturate {
+				panic(errorf(ErrLengthOverflow, "marshal: element %d, %v, is out of the %s range", i, x, ft.quantize))
+			}
+			q = max(lo, min(hi, q))
+		}
+		e := b[i*size : (i+1)*size]
+		switch size {
+		case 1:
+			e[0] = byte(int64(q))
+		case 2:
+			m.order.PutUint16(e, uint16(int64(q)))
+		default:
+			m.order.PutUint32(e, uint32(int64(q)))
+		}
+	}
+	m.write(b)
+}
+
+//quantizeScale is the scale and bias mapping the range of the values of v onto [lo, hi]
+func quantizeScale(v reflect.Value, lo, hi float64) (scale, bias float64) {
+	vmin, vmax := math.Inf(1), math.Inf(-1)
+	for i := 0; i < v.Len(); i++ {
+		x := v.Index(i).Float()
+		if math.IsInf(x, 0) {
+			panic(errorf(ErrMalformed, "marshal: can't quantize %v at element %d without a scale", x, i))
+		}
+		vmin, vmax = min(vmin, x), max(vmax, x)
+	}
+	if math.IsNaN(vmin) || math.IsNaN(vmax) {
+		//reported with its index by the caller
+		return 1, 0
+	}
+	if vmax == vmin {
+		return 1, vmin - lo
+	}
+	scale = (vmax - vmin) / (hi - lo)
+	return scale, vmin - lo*scale
+}
+
+func (m *marshaler) write(b []byte) {
+	if _, err := m.w.Write(b); err != nil {
+		panic(err)
+	}
+}
+
+//quantize reads a float slice or array written by marshaler.quantize into v, the
+//scale and bias come from the fields of parent named by the tag
+func (u *unmarshaler) quantize(v, parent reflect.Value, ft *fieldTag, order binary.ByteOrder, length LengthTypeInstance) {
+	if v.Kind() == reflect.Slice {
+		l := u.getLength(length, order, v.Type())
+		if l == 0 {
+			return
+		}
+		v.Set(reflect.MakeSlice(v.Type(), l, l))
+	}
+	var scale, bias float64
+	if ft.scale == "" {
+		scale = math.Float64frombits(order.Uint64(u.fetch(8)))
+		bias = math.Float64frombits(order.Uint64(u.fetch(8)))
+	} else {
+		scale, bias = parent.Field(ft.scaleIndex).Float(), parent.Field(ft.biasIndex).Float()
+	}
+	signed := ft.quantize < reflect.Uint8
+	size := quantizeSize(ft.quantize)
+	for i := 0; i < v.Len(); i++ {
+		b := u.fetch(size)
+		var q float64
+		switch {
+		case size == 1 && signed:
+			q = float64(int8(b[0]))
+		case size == 1:
+			q = float64(b[0])
+		case size == 2 && signed:
+			q = float64(int16(order.Uint16(b)))
+		case size == 2:
+			q = float64(order.Uint16(b))
+		case signed:
+			q = float64(int32(order.Uint32(b)))
+		default:
+			q = float64(order.Uint32(b))
+		}
+		v.Index(i).SetFloat(q*scale + bias)
+	}
+}
@@ -0,0 +1,106 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+type quantSeries struct {
+	Scale   float64
+	Bias    float32
+	Samples []float64 `marshal:"quantize=int16,scale=Scale,bias=Bias"`
+}
+
+type quantAuto struct {
+	Samples []float32  `marshal:"quantize=uint8"`
+	Fixed   [2]float64 `marshal:"quantize=int8,saturate"`
+}
+
+func TestQuantize(t *testing.T) {
+	v := quantSeries{Scale: 0.5, Bias: 100, Samples: []float64{100, 101.2, 99, 0}}
+	b, err := MarshalBytes(&v, binary.BigEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := []byte{4, 0, 0, 0, 2, 0xff, 0xfe, 0xff, 0x38}
+	if !bytes.HasSuffix(b, samples) {
+		t.Errorf("encoded % x, want it to end with % x", b, samples)
+	}
+	var readBack quantSeries
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, BlobLength8); err != nil {
+		t.Fatal(err)
+	}
+	for i, x := range []float64{100, 101, 99, 0} {
+		if readBack.Samples[i] != x {
+			t.Errorf("sample %d decoded %v, want %v", i, readBack.Samples[i], x)
+		}
+	}
+	n, err := Skip(bytes.NewReader(b), &v, binary.BigEndian, BlobLength8)
+	if err != nil || n != int64(len(b)) {
+		t.Errorf("skipped %d, %v, want %d", n, err, len(b))
+	}
+}
+
+func TestQuantizeAuto(t *testing.T) {
+	v := quantAuto{Samples: []float32{-1, 0, 0.5, 1}, Fixed: [2]float64{3, 3}}
+	b, err := MarshalBytes(&v, binary.LittleEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the length, the scale and bias, 4 bytes, then the same for the array
+	if len(b) != 1+16+4+16+2 {
+		t.Errorf("encoded %d bytes: % x", len(b), b)
+	}
+	var readBack quantAuto
+	if err := UnmarshalBytes(&readBack, b, binary.LittleEndian, BlobLength8); err != nil {
+		t.Fatal(err)
+	}
+	//the step is 2/255, values are off by half of it at most
+	for i, x := range v.Samples {
+		if math.Abs(float64(readBack.Samples[i]-x)) > 1.5/255 {
+			t.Errorf("sample %d decoded %v, want %v", i, readBack.Samples[i], x)
+		}
+	}
+	if readBack.Samples[0] != -1 || readBack.Samples[3] != 1 || readBack.Fixed != v.Fixed {
+		t.Errorf("decoded %+v, want the extremes exact", readBack)
+	}
+	s, err := Describe(quantAuto{})
+	if err != nil || s.Fields[1].Size != 18 {
+		t.Errorf("described %+v, %v, want an 18 byte array", s, err)
+	}
+}
+
+func TestQuantizeErrors(t *testing.T) {
+	v := quantSeries{Scale: 1, Samples: []float64{40000}}
+	if _, err := MarshalBytes(&v, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for a value out of the int16 range")
+	}
+	v.Samples = []float64{math.NaN()}
+	if _, err := MarshalBytes(&v, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for NaN")
+	}
+	v = quantSeries{Samples: []float64{1}}
+	if _, err := MarshalBytes(&v, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for a zero scale")
+	}
+	type saturated struct {
+		Scale, Bias float64
+		V           []float32 `marshal:"quantize=int8,scale=Scale,bias=Bias,saturate"`
+	}
+	s := saturated{Scale: 1, V: []float32{-1000, 5, 1000}}
+	b, err := MarshalBytes(&s, binary.BigEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(b, []byte{3, 0x80, 5, 0x7f}) {
+		t.Errorf("encoded % x, want clamped values", b)
+	}
+	var bad struct {
+		V []float32 `marshal:"quantize=int16,scale=Missing,bias=Missing"`
+	}
+	if _, err := MarshalBytes(&bad, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for a missing scale field")
+	}
+}
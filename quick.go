@@ -0,0 +1,73 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing/quick"
+)
+
+//QuickCheck runs quick.Check on the property that the values Generate makes of the
+//type of v pass RoundTrip with order, length and opts, a one line test per type:
+//
+//	if err := marshal.QuickCheck(Login{}, binary.BigEndian, marshal.BlobLength16, nil); err != nil {
+//		t.Fatal(err)
+//	}
+//
+//config is passed to quick.Check, its Values are ignored. quick.Check draws a seed
+//per value, the value of a failing seed is generated again with fewer elements
+//until it passes and the smallest failing one is reported with its DumpHex
+func QuickCheck(v interface{}, order binary.ByteOrder, length LengthType, config *quick.Config, opts ...Option) error {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return errors.New("marshal: QuickCheck(nil)")
+	}
+	if p := planFor(t); p.err != nil {
+		return p.err
+	}
+	if config != nil && config.Values != nil {
+		c := *config
+		c.Values = nil
+		config = &c
+	}
+	err := quick.Check(func(seed int64) bool {
+		g, err := Generate(t, rand.New(rand.NewSource(seed)), length, opts...)
+		return err == nil && RoundTrip(g, order, length, opts...) == nil
+	}, config)
+	var ce *quick.CheckError
+	if !errors.As(err, &ce) {
+		return err
+	}
+	seed := ce.In[0].(int64)
+	for limit := 0; limit <= generateMax; limit = max(1, 2*limit) {
+		g, err := generateOnce(t, rand.New(rand.NewSource(seed)), length, limit, opts)
+		if err != nil && limit > 0 && (errors.Is(err, ErrLengthOverflow) || errors.Is(err, ErrBoundExceeded)) {
+			continue
+		}
+		if err == nil {
+			err = RoundTrip(g, order, length, opts...)
+		}
+		if err != nil {
+			return quickError(ce.Count, seed, g, err, order, length, opts)
+		}
+	}
+	return err
+}
+
+//quickError reports the value g of seed failing the property of QuickCheck with err
+//on the count-th check, with its dump when it encodes
+func quickError(count int, seed int64, g interface{}, err error, order binary.ByteOrder, length LengthType, opts []Option) error {
+	if g == nil {
+		return fmt.Errorf("marshal: QuickCheck #%d, seed %d: %w", count, seed, err)
+	}
+	s := fmt.Sprintf("%+v", g)
+	var dump bytes.Buffer
+	if DumpHex(g, order, length, &dump, opts...) == nil {
+		s += "\n" + strings.TrimSuffix(dump.String(), "\n")
+	}
+	return fmt.Errorf("marshal: QuickCheck #%d, seed %d: %w\n%s", count, seed, err, s)
+}
@@ -0,0 +1,48 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+//quickDrift grows by one every time it is written, it never round trips
+type quickDrift uint8
+
+func (d quickDrift) MarshalWire(w *Writer) error {
+	return w.PutUint8(uint8(d) + 1)
+}
+
+func (d *quickDrift) UnmarshalWire(r *Reader) error {
+	x, err := r.Uint8()
+	*d = quickDrift(x)
+	return err
+}
+
+type quickBroken struct {
+	Names []string
+	Drift quickDrift
+}
+
+func TestQuickCheck(t *testing.T) {
+	if err := QuickCheck(genMsg{}, binary.LittleEndian, BlobLength16, nil, Deterministic()); err != nil {
+		t.Fatal(err)
+	}
+	err := QuickCheck(quickBroken{}, binary.BigEndian, BlobLength8, &quick.Config{MaxCount: 5})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	//the failing value is shrunk to no elements and dumped
+	msg := err.Error()
+	if !strings.Contains(msg, "quickBroken.Drift: sent 1, received 2") || !strings.Contains(msg, "{Names:[] Drift:1}") || !strings.Contains(msg, "Drift") {
+		t.Errorf("got %v", err)
+	}
+	type pointers struct {
+		P *uint8
+	}
+	if err := QuickCheck(pointers{}, binary.BigEndian, BlobLength8, nil); !errors.Is(err, ErrUnsupportedKind) || !strings.Contains(err.Error(), "seed") {
+		t.Errorf("got %v, want ErrUnsupportedKind", err)
+	}
+}
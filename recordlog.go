@@ -0,0 +1,214 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"reflect"
+)
+
+//A record log is a sequence of frames, each an 8 byte header holding the body
+//size and the CRC-32C of the size and the body, both uint32 in the log's byte
+//order, then the body, a marshaled value
+
+const logHeaderSize = 8
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+//LogWriter appends records to a log, see LogReader
+type LogWriter struct {
+	w      io.Writer
+	order  binary.ByteOrder
+	length LengthType
+	o      *options
+	buf    bytes.Buffer
+	n      int64
+}
+
+//NewLogWriter returns a LogWriter appending to w, e.g. a file opened with O_APPEND
+func NewLogWriter(w io.Writer, order binary.ByteOrder, length LengthType, opts ...Option) *LogWriter {
+	return &LogWriter{w: w, order: order, length: length, o: newOptions(opts)}
+}
+
+//Append writes v as a record. The frame is written with a single Write, so a
+//crash leaves at most the last record torn, records reach the writer in order
+//and are durable once Sync returns
+func (l *LogWriter) Append(v interface{}) error {
+	l.buf.Reset()
+	l.buf.Write(make([]byte, logHeaderSize))
+	if _, err := encode(v, &l.buf, l.order, l.length(), l.o); err != nil {
+		return err
+	}
+	frame := l.buf.Bytes()
+	size := len(frame) - logHeaderSize
+	if uint64(size) > 0xffffffff {
+		return fmt.Errorf("marshal: record of %d bytes is too large for a log", size)
+	}
+	l.order.PutUint32(frame, uint32(size))
+	l.order.PutUint32(frame[4:], logChecksum(frame))
+	n, err := l.w.Write(frame)
+	l.n += int64(n)
+	return err
+}
+
+//Sync commits the records written so far to stable storage when the writer
+//supports it, like *os.File
+func (l *LogWriter) Sync() error {
+	if s, ok := l.w.(interface{ Sync() error }); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+//Offset returns the number of bytes written by l
+func (l *LogWriter) Offset() int64 {
+	return l.n
+}
+
+//logChecksum is the checksum of the frame's size and body
+func logChecksum(frame []byte) uint32 {
+	crc := crc32.Checksum(frame[:4], crcTable)
+	return crc32.Update(crc, crcTable, frame[logHeaderSize:])
+}
+
+//LogReader reads the records of a log written by LogWriter. A last frame that is
+//cut short or fails its checksum is a torn tail, which Scan stops at quietly and
+//Recover cuts. A frame failing its checksum with more of the log after it is
+//corrupt rather than torn, an error wrapping ErrChecksum
+type LogReader struct {
+	r      readCounter
+	order  binary.ByteOrder
+	length LengthType
+	o      *options
+	buf    bytes.Buffer
+	//valid is the end of the last valid record
+	valid int64
+	torn  bool
+	//err is the error of a corrupt frame, returned again by later reads
+	err error
+}
+
+//NewLogReader returns a LogReader reading the log from r, which is at its start
+func NewLogReader(r io.Reader, order binary.ByteOrder, length LengthType, opts ...Option) *LogReader {
+	return &LogReader{r: readCounter{r: r}, order: order, length: length, o: newOptions(opts)}
+}
+
+//Scan decodes the records one at a time into what m points to, which is zeroed
+//first, and calls fn, which may use it until it returns. It stops with nil at
+//the end of the log or at a torn tail, see Torn. A record that can't be decoded
+//or fails its checksum, or an error returned by fn, is a *BatchError carrying
+//the number of records fn accepted
+func (l *LogReader) Scan(m interface{}, fn func() error) error {
+	v := reflect.ValueOf(m)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("unmarshal: Scan into %T, want a pointer", m)
+	}
+	count := 0
+	for {
+		body, err := l.frame()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return &BatchError{count, err}
+		}
+		v.Elem().SetZero()
+		if _, err := decode(m, &sliceReader{b: body}, l.order, l.length(), l.o); err != nil {
+			return &BatchError{count, err}
+		}
+		if err := fn(); err != nil {
+			return &BatchError{count, err}
+		}
+		count++
+	}
+}
+
+//frame reads the next frame and returns its body, io.EOF marks the end of the
+//valid records
+func (l *LogReader) frame() ([]byte, error) {
+	if l.torn {
+		return nil, io.EOF
+	} else if l.err != nil {
+		return nil, l.err
+	}
+	l.buf.Reset()
+	_, err := io.CopyN(&l.buf, &l.r, logHeaderSize)
+	if err == io.EOF && l.buf.Len() == 0 {
+		return nil, io.EOF
+	}
+	if err == nil {
+		size := int64(l.order.Uint32(l.buf.Bytes()))
+		//the body is copied as it arrives, a garbled size can't allocate more than the log holds
+		_, err = io.CopyN(&l.buf, &l.r, size)
+	}
+	if err == io.EOF {
+		l.torn = true
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, err
+	}
+	frame := l.buf.Bytes()
+	if l.order.Uint32(frame[4:]) != logChecksum(frame) {
+		//only a frame the log ends with can be torn by a crash
+		var next [1]byte
+		if _, err := io.ReadFull(&l.r, next[:]); err == io.EOF {
+			l.torn = true
+			return nil, io.EOF
+		} else if err != nil {
+			return nil, err
+		}
+		l.err = errorf(ErrChecksum, "unmarshal: log record at offset %d fails its checksum", l.valid)
+		return nil, l.err
+	}
+	l.valid = l.r.n
+	return frame[logHeaderSize:], nil
+}
+
+//Torn reports whether Scan or Recover met a torn tail
+func (l *LogReader) Torn() bool {
+	return l.torn
+}
+
+//Offset returns the end of the last valid record read
+func (l *LogReader) Offset() int64 {
+	return l.valid
+}
+
+//Recover reads the records left without decoding them, then cuts a torn tail:
+//when the reader has a Truncate method, like *os.File, the log is truncated
+//after the last valid record, and when it is an io.Seeker it is positioned
+//there so a LogWriter can continue the log. It reports the number of bytes of
+//the torn tail, which are dropped unless the reader can't be truncated. A
+//corrupt record before the end of the log is an error wrapping ErrChecksum,
+//and nothing is cut
+func (l *LogReader) Recover() (dropped int64, err error) {
+	for {
+		if _, err := l.frame(); err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	if !l.torn {
+		return 0, nil
+	}
+	if _, err := io.Copy(io.Discard, &l.r); err != nil {
+		return 0, err
+	}
+	dropped = l.r.n - l.valid
+	t, ok := l.r.r.(interface{ Truncate(size int64) error })
+	if !ok {
+		return dropped, errors.New("marshal: log reader can't be truncated")
+	}
+	if err := t.Truncate(l.valid); err != nil {
+		return dropped, err
+	}
+	if s, ok := l.r.r.(io.Seeker); ok {
+		if _, err := s.Seek(l.valid, io.SeekStart); err != nil {
+			return dropped, err
+		}
+	}
+	return dropped, nil
+}
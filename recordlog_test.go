@@ -0,0 +1,169 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeLog(t *testing.T, l *LogWriter, values []bar) {
+	for i := range values {
+		if err := l.Append(&values[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func scanLog(t *testing.T, l *LogReader) []bar {
+	var got []bar
+	var v bar
+	if err := l.Scan(&v, func() error {
+		got = append(got, v)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+//recordEnds returns the offsets the records of log end at
+func recordEnds(t *testing.T, log []byte) []int64 {
+	var ends []int64
+	r := NewLogReader(bytes.NewReader(log), binary.LittleEndian, BlobLength16)
+	for {
+		if _, err := r.frame(); err == io.EOF {
+			return ends
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		ends = append(ends, r.Offset())
+	}
+}
+
+func TestRecordLog(t *testing.T) {
+	values := []bar{{"a", 1, map[string]uint32{"a": 1}}, {"b", 2, nil}, {"c", 3, map[string]uint32{"b": 2, "c": 3}}}
+	var buf bytes.Buffer
+	w := NewLogWriter(&buf, binary.LittleEndian, BlobLength16)
+	writeLog(t, w, values)
+	if w.Offset() != int64(buf.Len()) {
+		t.Errorf("offset %d, wrote %d", w.Offset(), buf.Len())
+	}
+	log := buf.Bytes()
+	r := NewLogReader(bytes.NewReader(log), binary.LittleEndian, BlobLength16)
+	if got := scanLog(t, r); !reflect.DeepEqual(got, values) || r.Torn() || r.Offset() != int64(len(log)) {
+		t.Errorf("scanned %+v, torn %v, offset %d", got, r.Torn(), r.Offset())
+	}
+	//the last record cut short
+	r = NewLogReader(bytes.NewReader(log[:len(log)-3]), binary.LittleEndian, BlobLength16)
+	if got := scanLog(t, r); !reflect.DeepEqual(got, values[:2]) || !r.Torn() {
+		t.Errorf("torn tail scanned %+v, torn %v", got, r.Torn())
+	}
+	//a flipped bit in the second record is corrupt, not a torn tail
+	ends := recordEnds(t, log)
+	bad := bytes.Clone(log)
+	bad[ends[0]+logHeaderSize] ^= 1
+	r = NewLogReader(bytes.NewReader(bad), binary.LittleEndian, BlobLength16)
+	var v bar
+	err := r.Scan(&v, func() error { return nil })
+	var be *BatchError
+	if !errors.As(err, &be) || be.Count != 1 || !errors.Is(err, ErrChecksum) || r.Torn() {
+		t.Errorf("corrupt log: got %v, torn %v", err, r.Torn())
+	}
+	//in the last record it is a torn tail
+	bad = bytes.Clone(log)
+	bad[ends[1]+logHeaderSize] ^= 1
+	r = NewLogReader(bytes.NewReader(bad), binary.LittleEndian, BlobLength16)
+	if got := scanLog(t, r); !reflect.DeepEqual(got, values[:2]) || !r.Torn() {
+		t.Errorf("corrupt tail scanned %+v, torn %v", got, r.Torn())
+	}
+	stop := errors.New("stop")
+	r = NewLogReader(bytes.NewReader(log), binary.LittleEndian, BlobLength16)
+	count := 0
+	err = r.Scan(&v, func() error {
+		if count++; count == 2 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.As(err, &be) || be.Count != 1 || !errors.Is(err, stop) {
+		t.Errorf("expected the error of fn after 1 record, got %v", err)
+	}
+}
+
+func TestRecordLogRecover(t *testing.T) {
+	values := []bar{{"a", 1, nil}, {"b", 2, map[string]uint32{"x": 9}}}
+	path := filepath.Join(t.TempDir(), "log")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := NewLogWriter(f, binary.BigEndian, BlobLength8)
+	writeLog(t, w, values)
+	valid := w.Offset()
+	//a crash in the middle of a record
+	if _, err := f.Write([]byte{0, 0, 0, 40, 1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	r := NewLogReader(f, binary.BigEndian, BlobLength8)
+	dropped, err := r.Recover()
+	if err != nil || dropped != 6 || r.Offset() != valid {
+		t.Fatalf("recovered %d dropped, %v, offset %d, want 6, %d", dropped, err, r.Offset(), valid)
+	}
+	if fi, _ := f.Stat(); fi.Size() != valid {
+		t.Errorf("log is %d bytes, want %d", fi.Size(), valid)
+	}
+	w = NewLogWriter(f, binary.BigEndian, BlobLength8)
+	writeLog(t, w, values[:1])
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	r = NewLogReader(f, binary.BigEndian, BlobLength8)
+	if got := scanLog(t, r); !reflect.DeepEqual(got, append(values, values[0])) || r.Torn() {
+		t.Errorf("scanned %+v after recovery, torn %v", got, r.Torn())
+	}
+	if dropped, err := r.Recover(); dropped != 0 || err != nil {
+		t.Errorf("recovered an intact log: %d, %v", dropped, err)
+	}
+	r = NewLogReader(bytes.NewReader([]byte{0, 0}), binary.BigEndian, BlobLength8)
+	if dropped, err := r.Recover(); dropped != 2 || err == nil {
+		t.Errorf("expected 2 bytes dropped and an error for a reader that can't be truncated, got %d, %v", dropped, err)
+	}
+}
+
+func TestRecordLogRecoverCorrupt(t *testing.T) {
+	values := []bar{{"a", 1, nil}, {"b", 2, nil}, {"c", 3, nil}}
+	path := filepath.Join(t.TempDir(), "log")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var buf bytes.Buffer
+	writeLog(t, NewLogWriter(&buf, binary.LittleEndian, BlobLength16), values)
+	log := buf.Bytes()
+	//a flipped byte in record 2 of 3
+	log[recordEnds(t, log)[0]+logHeaderSize+1] ^= 0xff
+	if _, err := f.Write(log); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	r := NewLogReader(f, binary.LittleEndian, BlobLength16)
+	if dropped, err := r.Recover(); dropped != 0 || !errors.Is(err, ErrChecksum) || r.Torn() {
+		t.Errorf("recovered %d dropped, %v, torn %v, want an ErrChecksum", dropped, err, r.Torn())
+	}
+	//the records after the corrupt one are kept
+	if fi, _ := f.Stat(); fi.Size() != int64(len(log)) {
+		t.Errorf("log is %d bytes, want %d", fi.Size(), len(log))
+	}
+}
@@ -0,0 +1,284 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+)
+
+//resolveRegions links offset= and size= tags of the struct t to the fields they name.
+//Every other field forms the header, which must be fixed-size so payload offsets are
+//known before the header is written
+func (p *typePlan) resolveRegions(t reflect.Type) error {
+	find := func(f *fieldPlan, name string) (*fieldPlan, error) {
+		for i := range p.fields {
+			c := &p.fields[i]
+			if c.name != name {
+				continue
+			}
+			if c.tag != nil || !isInteger(t.Field(c.index).Type.Kind()) {
+				return nil, fmt.Errorf("marshal: %s.%s: region field %s must be an untagged integer", t, f.name, name)
+			}
+			if c.offsetFor != nil || c.sizeFor != nil {
+				return nil, fmt.Errorf("marshal: %s.%s: region field %s is already used", t, f.name, name)
+			}
+			return c, nil
+		}
+		return nil, fmt.Errorf("marshal: %s.%s: no region field %s", t, f.name, name)
+	}
+	for i := range p.fields {
+		f := &p.fields[i]
+		if f.tag == nil || f.tag.offset == "" {
+			continue
+		}
+		off, err := find(f, f.tag.offset)
+		if err != nil {
+			return err
+		}
+		size, err := find(f, f.tag.size)
+		if err != nil {
+			return err
+		}
+		off.offsetFor, size.sizeFor = f, f
+		p.regions = true
+	}
+	if !p.regions {
+		return nil
+	}
+	for i := range p.fields {
+		f := &p.fields[i]
+		if f.tag != nil && f.tag.offset != "" {
+			continue
+		}
+		if f.tag != nil || f.plan.size < 0 {
+			return fmt.Errorf("marshal: %s.%s: header fields before region payloads must be fixed-size", t, f.name)
+		}
+		p.header += f.plan.size
+	}
+	return nil
+}
+
+//setInt stores n in the integer value v, failing when it doesn't fit
+func setInt(v reflect.Value, n int64, what string) {
+	if k := v.Kind(); k >= reflect.Int && k <= reflect.Int64 {
+		if v.OverflowInt(n) {
+			panic(errorf(ErrLengthOverflow, "marshal: %s %d overflows %s", what, n, v.Type()))
+		}
+		v.SetInt(n)
+		return
+	}
+	if n < 0 || v.OverflowUint(uint64(n)) {
+		panic(errorf(ErrLengthOverflow, "marshal: %s %d overflows %s", what, n, v.Type()))
+	}
+	v.SetUint(uint64(n))
+}
+
+//getInt reads the integer value v, values beyond int64 read as -1
+func getInt(v reflect.Value) int64 {
+	if k := v.Kind(); k >= reflect.Int && k <= reflect.Int64 {
+		return v.Int()
+	}
+	if x := v.Uint(); x <= 1<<63-1 {
+		return int64(x)
+	}
+	return -1
+}
+
+//regions writes the fixed header of v followed by its region payloads, offsets
+//count from the start of the message and are back-filled with the sizes
+func (m *marshaler) regions(v reflect.Value, p *typePlan, length LengthTypeInstance) {
+	payloads := map[*fieldPlan][]byte{}
+	for i := range p.fields {
+		f := &p.fields[i]
+		if f.tag != nil && f.tag.offset != "" {
+			payloads[f] = encodePayload(v.Field(f.index), m.order, length)
+		}
+	}
+	//payloads follow the header in field order
+	offsets := map[*fieldPlan]int64{}
+	next := m.cw.n + int64(p.header)
+	for i := range p.fields {
+		if b, ok := payloads[&p.fields[i]]; ok {
+			offsets[&p.fields[i]] = next
+			next += int64(len(b))
+		}
+	}
+	for i := range p.fields {
+		f := &p.fields[i]
+		if _, ok := payloads[f]; ok {
+			continue
+		}
+		fv := v.Field(f.index)
+		switch {
+		case f.offsetFor != nil:
+			fv = reflect.New(fv.Type()).Elem()
+			setInt(fv, offsets[f.offsetFor], "offset")
+		case f.sizeFor != nil:
+			fv = reflect.New(fv.Type()).Elem()
+			setInt(fv, int64(len(payloads[f.sizeFor])), "size")
+		}
+		m.push(fieldElem(f.name))
+		m.marshal(fv, length)
+		m.pop()
+	}
+	for i := range p.fields {
+		f := &p.fields[i]
+		if b, ok := payloads[f]; ok {
+			m.push(fieldElem(f.name))
+			if _, err := m.w.Write(b); err != nil {
+				panic(err)
+			}
+			m.pop()
+		}
+	}
+}
+
+type region struct {
+	//f is the payload field, nil for the header
+	f         *fieldPlan
+	off, size int64
+}
+
+func (r *region) name() string {
+	if r.f == nil {
+		return "header"
+	}
+	return r.f.name
+}
+
+//regions decodes the fixed header of v, then reads every payload from the region
+//it names, which needs a seekable source. The input is left after the furthest region
+func (u *unmarshaler) regions(v reflect.Value, p *typePlan, order binary.ByteOrder, length LengthTypeInstance) {
+	s, ok := u.cr.r.(io.Seeker)
+	if !ok {
+		panic(fmt.Errorf("unmarshal: %s has offset fields and needs an io.Seeker source", v.Type()))
+	}
+	headerStart := u.cr.n
+	for i := range p.fields {
+		f := &p.fields[i]
+		if f.tag != nil && f.tag.offset != "" {
+			continue
+		}
+		u.push(fieldElem(f.name))
+		u.unmarshal(v.Field(f.index), order, length)
+		u.pop()
+	}
+	cur, err := s.Seek(0, io.SeekCurrent)
+	if err != nil {
+		panic(err)
+	}
+	end, err := s.Seek(0, io.SeekEnd)
+	if err != nil {
+		panic(err)
+	}
+	msgStart := cur - u.cr.n
+	var rs []region
+	for i := range p.fields {
+		f := &p.fields[i]
+		if f.offsetFor != nil {
+			r := region{f: f.offsetFor, off: getInt(v.Field(f.index))}
+			for j := range p.fields {
+				if p.fields[j].sizeFor == f.offsetFor {
+					r.size = getInt(v.Field(p.fields[j].index))
+				}
+			}
+			if r.off < 0 || r.size < 0 || msgStart+r.off+r.size > end {
+				panic(errorf(ErrMalformed, "unmarshal: %s region [%d, +%d) is out of bounds", f.offsetFor.name, r.off, r.size))
+			}
+			rs = append(rs, r)
+		}
+	}
+	//the header is a region too, payloads may not overlap it or each other
+	all := append([]region{{off: headerStart, size: int64(p.header)}}, rs...)
+	sort.Slice(all, func(i, j int) bool { return all[i].off < all[j].off })
+	var prev *region
+	last := int64(0)
+	for i := range all {
+		r := &all[i]
+		if r.size == 0 {
+			continue
+		}
+		if prev != nil && r.off < prev.off+prev.size {
+			panic(errorf(ErrMalformed, "unmarshal: region %s [%d, +%d) overlaps %s [%d, +%d)", r.name(), r.off, r.size, prev.name(), prev.off, prev.size))
+		}
+		prev = r
+		last = max(last, r.off+r.size)
+	}
+	last = max(last, headerStart+int64(p.header))
+	for _, r := range rs {
+		if _, err := s.Seek(msgStart+r.off, io.SeekStart); err != nil {
+			panic(err)
+		}
+		b := make([]byte, r.size)
+		if _, err := io.ReadFull(u.cr.r, b); err != nil {
+			panic(err)
+		}
+		if err := decodePayload(v.Field(r.f.index), b, order, length); err != nil {
+			panic(fmt.Errorf("unmarshal: region %s: %w", r.f.name, err))
+		}
+	}
+	if _, err := s.Seek(msgStart+last, io.SeekStart); err != nil {
+		panic(err)
+	}
+	u.cr.n = last
+}
+
+//encodePayload encodes the payload of a region, strings and slices go without a
+//length prefix since the size field bounds them
+func encodePayload(v reflect.Value, order binary.ByteOrder, length LengthTypeInstance) []byte {
+	var buf bytes.Buffer
+	switch v.Kind() {
+	case reflect.String:
+		return []byte(v.String())
+	case reflect.Slice:
+		m := getMarshaler(&buf, order, noOptions)
+		defer putMarshaler(m)
+		m.elements(v, length)
+	default:
+		if _, err := encode(v.Interface(), &buf, order, length, noOptions); err != nil {
+			panic(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+//decodePayload decodes b, the whole region of a payload, into v
+func decodePayload(v reflect.Value, b []byte, order binary.ByteOrder, length LengthTypeInstance) (err error) {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(string(b))
+		return nil
+	case reflect.Slice:
+		r := &sliceReader{b: b}
+		u := getUnmarshaler(r, noOptions)
+		defer putUnmarshaler(u)
+		defer recoverError(&err)
+		if size := planFor(v.Type().Elem()).size; size > 0 {
+			if len(b)%size != 0 {
+				return errorf(ErrMalformed, "%d bytes is not a whole number of %d byte elements", len(b), size)
+			}
+			v.Set(reflect.Zero(v.Type()))
+			if len(b) > 0 {
+				u.makeSlice(v, len(b)/size)
+				u.elements(v, order, length)
+			}
+			return nil
+		}
+		s := reflect.Zero(v.Type())
+		for r.off < len(r.b) {
+			e := reflect.New(v.Type().Elem()).Elem()
+			u.unmarshal(e, order, length)
+			s = reflect.Append(s, e)
+		}
+		v.Set(s)
+		return nil
+	}
+	n, err := decode(v.Addr().Interface(), &sliceReader{b: b}, order, length, noOptions)
+	if err == nil && n != int64(len(b)) {
+		err = errorf(ErrTrailingBytes, "decoded %d of %d bytes", n, len(b))
+	}
+	return err
+}
@@ -0,0 +1,113 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type firmware struct {
+	Magic    uint32
+	KernOff  uint32
+	KernSize uint32
+	NameOff  uint16
+	NameSize uint16
+	Kernel   []byte `marshal:"offset=KernOff,size=KernSize"`
+	Name     string `marshal:"offset=NameOff,size=NameSize"`
+}
+
+func TestRegions(t *testing.T) {
+	v := firmware{Magic: 0xfeedface, Kernel: []byte{1, 2, 3}, Name: "boot"}
+	b, err := MarshalBytes(&v, binary.BigEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{
+		0xfe, 0xed, 0xfa, 0xce,
+		0, 0, 0, 16, 0, 0, 0, 3,
+		0, 19, 0, 4,
+		1, 2, 3,
+		'b', 'o', 'o', 't',
+	}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("encoded % x, want % x", b, expected)
+	}
+	var readBack firmware
+	r := bytes.NewReader(append(b, 0xaa))
+	if _, err := r.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := Unmarshal(&readBack, r, binary.BigEndian, BlobLength8); err != nil {
+		t.Fatal(err)
+	}
+	v.KernOff, v.KernSize, v.NameOff, v.NameSize = 16, 3, 19, 4
+	if !reflect.DeepEqual(v, readBack) {
+		t.Errorf("decoded %+v, want %+v", readBack, v)
+	}
+	if r.Len() != 1 {
+		t.Errorf("%d bytes left, want 1", r.Len())
+	}
+
+	//payloads may come in any order and leave gaps
+	swapped := append([]byte{}, expected[:16]...)
+	swapped[7], swapped[13] = 21, 16
+	swapped = append(swapped, 'b', 'o', 'o', 't', 0xff, 1, 2, 3)
+	if err := UnmarshalBytes(&readBack, swapped, binary.BigEndian, BlobLength8); err != nil {
+		t.Fatal(err)
+	}
+	v.KernOff, v.NameOff = 21, 16
+	if !reflect.DeepEqual(v, readBack) {
+		t.Errorf("swapped: decoded %+v, want %+v", readBack, v)
+	}
+}
+
+func TestRegionErrors(t *testing.T) {
+	base, _ := MarshalBytes(&firmware{Kernel: []byte{1, 2, 3}, Name: "boot"}, binary.BigEndian, BlobLength8)
+	for _, tt := range []struct {
+		name    string
+		at      int
+		val     byte
+		message string
+	}{
+		{"out of bounds", 11, 30, "out of bounds"},
+		{"overlap", 13, 17, "overlaps Kernel"},
+		{"header", 7, 2, "overlaps header"},
+	} {
+		b := append([]byte{}, base...)
+		b[tt.at] = tt.val
+		var v firmware
+		err := UnmarshalBytes(&v, b, binary.BigEndian, BlobLength8)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+	var v firmware
+	if err := Unmarshal(&v, onlyReader{bytes.NewReader(base)}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error without an io.Seeker")
+	}
+	type variableHeader struct {
+		Off, Size uint8
+		Name      string
+		Body      []byte `marshal:"offset=Off,size=Size"`
+	}
+	if _, err := MarshalBytes(&variableHeader{}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for a variable-length header")
+	}
+}
+
+func TestRegionFields(t *testing.T) {
+	b, _ := MarshalBytes(&firmware{Magic: 7, Kernel: []byte{1, 2, 3}, Name: "boot"}, binary.BigEndian, BlobLength8)
+	var v firmware
+	if err := UnmarshalFields(bytes.NewReader(b), &v, binary.BigEndian, BlobLength8, "Name"); err != nil {
+		t.Fatal(err)
+	}
+	if v.Name != "boot" || v.Magic != 0 || v.Kernel != nil {
+		t.Errorf("UnmarshalFields: %+v", v)
+	}
+	n, err := Skip(bytes.NewReader(b), firmware{}, binary.BigEndian, BlobLength8)
+	if err != nil || n != int64(len(b)) {
+		t.Errorf("Skip: %d, %v", n, err)
+	}
+}
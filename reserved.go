@@ -0,0 +1,57 @@
+package marshal
+
+import (
+	"io"
+	"reflect"
+)
+
+//reserved writes the n zero bytes of a reserved= placeholder of type t
+func (m *marshaler) reserved(t reflect.Type, n int) {
+	start := m.cw.n
+	bp := getScratch(n)
+	defer scratchPool.Put(bp)
+	clear(*bp)
+	if _, err := m.w.Write(*bp); err != nil {
+		panic(err)
+	}
+	if m.trace != nil {
+		e := m.event(t, start, false)
+		e.Reserved = true
+		m.trace(e)
+	}
+}
+
+//reserved consumes the n bytes of a reserved= placeholder of type t, with Strict
+//they must all be zero, with OnWarning others are reported
+func (u *unmarshaler) reserved(t reflect.Type, n int) {
+	start := u.cr.n
+	if !u.strict && u.warn == nil {
+		if _, err := io.CopyN(io.Discard, u.r, int64(n)); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			panic(err)
+		}
+	} else {
+		bp := getScratch(n)
+		defer scratchPool.Put(bp)
+		if _, err := io.ReadFull(u.r, *bp); err != nil {
+			panic(err)
+		}
+		for i, c := range *bp {
+			if c == 0 {
+				continue
+			}
+			if u.strict {
+				panic(errorf(ErrMalformed, "unmarshal: reserved byte at offset %d is 0x%02x, not zero", start+int64(i), c))
+			}
+			u.warning(WarnReservedNotZero, start+int64(i), "byte 0x%02x", c)
+			break
+		}
+	}
+	if u.trace != nil {
+		e := u.event(t, start, false)
+		e.Reserved = true
+		u.trace(e)
+	}
+}
@@ -0,0 +1,65 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+type reservedHeader struct {
+	Kind uint8
+	_    struct{} `marshal:"reserved=3"`
+	Len  uint16
+}
+
+func TestReserved(t *testing.T) {
+	v := reservedHeader{Kind: 1, Len: 0x0203}
+	b, err := MarshalBytes(&v, binary.BigEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{1, 0, 0, 0, 2, 3}; !bytes.Equal(b, expected) {
+		t.Errorf("encoded % x, want % x", b, expected)
+	}
+	dirty := []byte{1, 0, 9, 0, 2, 3}
+	var readBack reservedHeader
+	if err := UnmarshalBytes(&readBack, dirty, binary.BigEndian, BlobLength8); err != nil || readBack != v {
+		t.Errorf("lenient decode: %+v, %v", readBack, err)
+	}
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, BlobLength8, Strict()); err != nil || readBack != v {
+		t.Errorf("strict decode: %+v, %v", readBack, err)
+	}
+	err = UnmarshalBytes(&readBack, dirty, binary.BigEndian, BlobLength8, Strict())
+	if err == nil || !strings.Contains(err.Error(), "offset 2") {
+		t.Errorf("expected an error naming offset 2, got %v", err)
+	}
+	if err := UnmarshalBytes(&readBack, b[:2], binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for a truncated reserved region")
+	}
+}
+
+func TestReservedDump(t *testing.T) {
+	var out strings.Builder
+	if err := DumpHex(&reservedHeader{Kind: 1}, binary.BigEndian, BlobLength8, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "_ reserved len 3") {
+		t.Errorf("dump doesn't show the reserved field:\n%s", out.String())
+	}
+}
+
+func TestReservedErrors(t *testing.T) {
+	type notEmpty struct {
+		X uint32 `marshal:"reserved=4"`
+	}
+	if _, err := MarshalBytes(&notEmpty{}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for reserved on a non-empty field")
+	}
+	type zero struct {
+		_ struct{} `marshal:"reserved=0"`
+	}
+	if _, err := MarshalBytes(&zero{}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for reserved=0")
+	}
+}
@@ -0,0 +1,131 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+)
+
+var crlf = []byte("\r\n")
+
+type respLength struct {
+	typ       byte
+	maxDigits int
+	trailer   bool
+	b         [24]byte
+}
+
+//RESPLength writes lengths as ASCII decimal digits between the type byte typ and
+//a CRLF, "$5\r\n" for typ '$' as in Redis bulk strings, typ 0 writes no type byte.
+//Lengths of more than maxDigits digits (1 to 18) are an error both ways. Like
+//NullableLength it writes a nil *string, *[]T or []T tagged nullable as -1. With
+//trailer every string and byte slice payload is followed by a CRLF, which decoding
+//verifies
+func RESPLength(typ byte, maxDigits int, trailer bool) LengthType {
+	if maxDigits < 1 || maxDigits > 18 {
+		panic(fmt.Errorf("marshal: RESPLength with %d digits, want 1 to 18", maxDigits))
+	}
+	return func() LengthTypeInstance {
+		return &respLength{typ: typ, maxDigits: maxDigits, trailer: trailer}
+	}
+}
+
+func (d *respLength) PutLength(w io.Writer, order binary.ByteOrder, k reflect.Kind, v int) {
+	bs := d.b[:0]
+	if d.typ != 0 {
+		bs = append(bs, d.typ)
+	}
+	if v == nullLength {
+		bs = append(bs, "-1"...)
+	} else {
+		checkLength(v, 64)
+		n := len(bs)
+		bs = strconv.AppendInt(bs, int64(v), 10)
+		if len(bs)-n > d.maxDigits {
+			panic(fmt.Errorf("%w: %d has more than %d digits", ErrLengthTooLarge, v, d.maxDigits))
+		}
+	}
+	if _, err := w.Write(append(bs, crlf...)); err != nil {
+		panic(err)
+	}
+}
+
+func (d *respLength) Length(r io.Reader, order binary.ByteOrder, k reflect.Kind) int {
+	bs := d.b[:1]
+	read := func(i int) byte {
+		if _, err := io.ReadFull(r, bs); err != nil {
+			if i > 0 && err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			panic(err)
+		}
+		return bs[0]
+	}
+	i := 0
+	if d.typ != 0 {
+		if c := read(i); c != d.typ {
+			panic(errorf(ErrMalformed, "resp length: type byte %q, want %q", c, d.typ))
+		}
+		i++
+	}
+	c := read(i)
+	if c == '-' {
+		if read(i+1) != '1' || read(i+2) != '\r' || read(i+3) != '\n' {
+			panic(errorf(ErrMalformed, "resp length: negative length other than -1"))
+		}
+		return nullLength
+	}
+	l, digits := 0, 0
+	for ; c != '\r'; c = read(i) {
+		if c < '0' || c > '9' {
+			panic(errorf(ErrMalformed, "resp length: %q is not a digit", c))
+		}
+		if digits++; digits > d.maxDigits {
+			panic(errorf(ErrMalformed, "resp length: more than %d digits", d.maxDigits))
+		}
+		l = l*10 + int(c-'0')
+		i++
+	}
+	if digits == 0 {
+		panic(errorf(ErrMalformed, "resp length: no digits"))
+	}
+	if c = read(i); c != '\n' {
+		panic(errorf(ErrMalformed, "resp length: %q after CR, want LF", c))
+	}
+	return l
+}
+
+//hasTrailer reports whether a value of type t written with length is followed by a CRLF
+func hasTrailer(length LengthTypeInstance, t reflect.Type) bool {
+	d, ok := length.(*respLength)
+	return ok && d.trailer && (t.Kind() == reflect.String || (t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8))
+}
+
+//trailer writes the CRLF RESPLength puts after a string or byte slice payload
+func (m *marshaler) trailer(length LengthTypeInstance, t reflect.Type) {
+	if !hasTrailer(length, t) {
+		return
+	}
+	if _, err := m.w.Write(crlf); err != nil {
+		panic(err)
+	}
+}
+
+//trailer reads and checks the CRLF RESPLength puts after a string or byte slice payload
+func (u *unmarshaler) trailer(length LengthTypeInstance, t reflect.Type) {
+	if !hasTrailer(length, t) {
+		return
+	}
+	bs := u.buf[:2]
+	if _, err := io.ReadFull(u.r, bs); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		panic(err)
+	}
+	if bs[0] != '\r' || bs[1] != '\n' {
+		panic(errorf(ErrMalformed, "unmarshal: % x after %s, want CRLF", bs, t))
+	}
+}
@@ -0,0 +1,79 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+type respCommand struct {
+	Name  string
+	Key   []byte
+	Value *string
+	Args  []string
+}
+
+func TestRESPLength(t *testing.T) {
+	bulk := RESPLength('$', 9, true)
+	v := respCommand{Name: "SET", Key: []byte("k"), Args: []string{"", "EX"}}
+	b, err := MarshalBytes(&v, binary.BigEndian, bulk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "$3\r\nSET\r\n$1\r\nk\r\n$-1\r\n$2\r\n$0\r\n\r\n$2\r\nEX\r\n"
+	if string(b) != want {
+		t.Errorf("encoded %q, want %q", b, want)
+	}
+	var readBack respCommand
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, bulk); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(readBack, v) {
+		t.Errorf("decoded %+v, want %+v", readBack, v)
+	}
+	if n, err := Skip(bytes.NewReader(b), &v, binary.BigEndian, bulk); err != nil || n != int64(len(b)) {
+		t.Errorf("skipped %d of %d bytes, %v", n, len(b), err)
+	}
+	s := "hi"
+	v.Value = &s
+	if b, err = MarshalBytes(&v, binary.BigEndian, bulk); err != nil || !bytes.Contains(b, []byte("$2\r\nhi\r\n$2\r\n$0")) {
+		t.Errorf("encoded %q, %v", b, err)
+	}
+
+	//no type byte and no trailer
+	plain := RESPLength(0, 3, false)
+	if b, err = MarshalBytes("hello", binary.BigEndian, plain); err != nil || string(b) != "5\r\nhello" {
+		t.Errorf("encoded %q, %v", b, err)
+	}
+	if _, err := MarshalBytes(string(make([]byte, 1000)), binary.BigEndian, plain); !errors.Is(err, ErrLengthTooLarge) {
+		t.Errorf("got %v, want ErrLengthTooLarge", err)
+	}
+}
+
+func TestRESPLengthRejects(t *testing.T) {
+	bulk := RESPLength('$', 4, true)
+	for _, c := range []struct {
+		in  string
+		err error
+	}{
+		{"*1\r\nx\r\n", nil},
+		{"$12345\r\n", nil},
+		{"$1x\r\n", nil},
+		{"$\r\n\r\n", nil},
+		{"$-2\r\n", nil},
+		{"$1\rx", nil},
+		{"$1\r\nxAB", nil},
+		{"$-1\r\n", nil},
+		{"$1\r\nx", io.ErrUnexpectedEOF},
+		{"$1", io.ErrUnexpectedEOF},
+	} {
+		var s string
+		err := UnmarshalBytes(&s, []byte(c.in), binary.BigEndian, bulk)
+		if err == nil || (c.err != nil && !errors.Is(err, c.err)) {
+			t.Errorf("%q: got %v", c.in, err)
+		}
+	}
+}
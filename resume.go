@@ -0,0 +1,61 @@
+package marshal
+
+import (
+	"errors"
+	"io"
+)
+
+//ResumeOnTimeout makes a Decoder keep the bytes of a value whose decoding was cut
+//short by a timeout, such as a read deadline of a net.Conn expiring, and decode
+//that value again from its first byte on the next call, so no input is lost.
+//
+//An error is resumable when it or an error it wraps has a Timeout() bool method
+//returning true, as os.ErrDeadlineExceeded and net.Error timeouts do. Decode,
+//DecodeAll, Messages and Values resume, any other error drops the kept bytes.
+//DecodeMap never resumes since fn has already seen the entries decoded so far
+func ResumeOnTimeout() Option {
+	return func(o *options) {
+		o.resume = true
+	}
+}
+
+//replay is the input of a Decoder with ResumeOnTimeout, it records the bytes the
+//value being decoded takes from r and replays them when decoding starts over
+type replay struct {
+	r   io.Reader
+	buf []byte
+	pos int
+}
+
+func (p *replay) Read(b []byte) (int, error) {
+	if p.pos < len(p.buf) {
+		n := copy(b, p.buf[p.pos:])
+		p.pos += n
+		return n, nil
+	}
+	n, err := p.r.Read(b)
+	p.buf = append(p.buf, b[:n]...)
+	p.pos += n
+	return n, err
+}
+
+//settle ends a decoding attempt that failed with err, the bytes read are kept for
+//the next attempt after a timeout and dropped otherwise
+func (d *Decoder) settle(err error) {
+	p := d.replay
+	if p == nil {
+		return
+	}
+	if isTimeout(err) && d.o.resume {
+		p.pos = 0
+		return
+	}
+	n := copy(p.buf, p.buf[p.pos:])
+	p.buf, p.pos = p.buf[:n], 0
+}
+
+//isTimeout reports whether err is a resumable timeout, see ResumeOnTimeout
+func isTimeout(err error) bool {
+	var t interface{ Timeout() bool }
+	return errors.As(err, &t) && t.Timeout()
+}
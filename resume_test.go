@@ -0,0 +1,105 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+)
+
+//timeoutReader hands out its input in chunks, failing with a deadline error between them
+type timeoutReader struct {
+	chunks [][]byte
+	//timedOut is set once the current chunk has failed a read
+	timedOut bool
+}
+
+func (r *timeoutReader) Read(p []byte) (int, error) {
+	for len(r.chunks) > 0 && len(r.chunks[0]) == 0 {
+		r.chunks = r.chunks[1:]
+		r.timedOut = false
+	}
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	if !r.timedOut {
+		r.timedOut = true
+		return 0, os.ErrDeadlineExceeded
+	}
+	n := copy(p, r.chunks[0])
+	r.chunks[0] = r.chunks[0][n:]
+	return n, nil
+}
+
+//chunked returns a timeoutReader handing out b in chunks of n bytes
+func chunked(b []byte, n int) *timeoutReader {
+	r := &timeoutReader{}
+	for i := 0; i < len(b); i += n {
+		r.chunks = append(r.chunks, b[i:min(i+n, len(b))])
+	}
+	return r
+}
+
+func TestResumeOnTimeout(t *testing.T) {
+	foos, stream := fooStream(t, 4, binary.BigEndian, BlobLength16)
+	d := NewDecoder(chunked(stream, 7), binary.BigEndian, BlobLength16, ResumeOnTimeout())
+	var got []Foo
+	timeouts := 0
+	for len(got) < len(foos) {
+		var v Foo
+		err := d.Decode(&v)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			timeouts++
+			continue
+		}
+		if err != nil {
+			t.Fatalf("after %d values: %v", len(got), err)
+		}
+		got = append(got, v)
+	}
+	if chunks := (len(stream) + 6) / 7; timeouts < chunks {
+		t.Errorf("only %d timeouts for %d chunks", timeouts, chunks)
+	}
+	if d := firstDiff(reflect.ValueOf(got), reflect.ValueOf(foos)); d != nil {
+		t.Errorf("decoded values differ: %v", d)
+	}
+	var v Foo
+	if err := d.Decode(&v); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+	//DecodeAll picks up where the timeout left it
+	d = NewDecoder(chunked(stream, 5), binary.BigEndian, BlobLength16, ResumeOnTimeout())
+	var all []Foo
+	for {
+		err := d.DecodeAll(&all, 0)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatal(err)
+		}
+	}
+	if d := firstDiff(reflect.ValueOf(all), reflect.ValueOf(foos)); d != nil {
+		t.Errorf("DecodeAll values differ: %v", d)
+	}
+	//the kept bytes come first in Buffered
+	d = NewDecoder(&timeoutReader{chunks: [][]byte{stream[:3], stream[3:5]}}, binary.BigEndian, BlobLength16, ResumeOnTimeout())
+	for i := 0; i < 2; i++ {
+		if err := d.Decode(&v); !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("expected a timeout, got %v", err)
+		}
+	}
+	if rest, _ := io.ReadAll(d.Buffered()); !bytes.Equal(rest, stream[:3]) {
+		t.Errorf("buffered % x, want % x", rest, stream[:3])
+	}
+	//and in Peek
+	if b, err := d.Peek(4); !bytes.Equal(b, stream[:4]) || err != nil {
+		t.Errorf("peeked % x, %v, want % x", b, err, stream[:4])
+	}
+	if b, _ := d.Peek(2); !bytes.Equal(b, stream[:2]) {
+		t.Errorf("peeked % x, want % x", b, stream[:2])
+	}
+}
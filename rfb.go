@@ -0,0 +1,94 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"io"
+	"reflect"
+)
+
+//RFB is a Codec for the Remote Framebuffer protocol used by VNC (RFC 6143):
+//big-endian, strings carry a 32 bit length and everything else the compact
+//length of the Tight encoding. RFB strings are Latin-1, tag them charset=latin1
+var RFB = NewCodec(binary.BigEndian, RFBLength)
+
+//RFBLength writes strings with a 32 bit length and other values with CompactLength
+func RFBLength() LengthTypeInstance {
+	return &rfbLength{}
+}
+
+type rfbLength struct {
+	str     blobLength32
+	compact compactLength
+}
+
+func (d *rfbLength) Length(r io.Reader, order binary.ByteOrder, k reflect.Kind) int {
+	if k == reflect.String {
+		return d.str.Length(r, order, k)
+	}
+	return d.compact.Length(r, order, k)
+}
+
+func (d *rfbLength) PutLength(w io.Writer, order binary.ByteOrder, k reflect.Kind, v int) {
+	if k == reflect.String {
+		d.str.PutLength(w, order, k, v)
+	} else {
+		d.compact.PutLength(w, order, k, v)
+	}
+}
+
+//RFB encoding types carried in RFBRectangle.Encoding
+const (
+	RFBEncodingRaw      int32 = 0
+	RFBEncodingCopyRect int32 = 1
+	RFBEncodingRRE      int32 = 2
+	RFBEncodingHextile  int32 = 5
+	RFBEncodingTight    int32 = 7
+	RFBEncodingZRLE     int32 = 16
+)
+
+//RFBPixelFormat describes how pixel values are laid out, 16 bytes on the wire
+type RFBPixelFormat struct {
+	BitsPerPixel uint8
+	Depth        uint8
+	BigEndian    bool
+	TrueColor    bool
+	RedMax       uint16
+	GreenMax     uint16
+	BlueMax      uint16
+	RedShift     uint8
+	GreenShift   uint8
+	BlueShift    uint8
+	Padding      [3]uint8
+}
+
+//RFBServerInit is the server's reply to ClientInit
+type RFBServerInit struct {
+	Width       uint16
+	Height      uint16
+	PixelFormat RFBPixelFormat
+	Name        string `marshal:"charset=latin1"`
+}
+
+//RFBFramebufferUpdate is the header of a FramebufferUpdate message,
+//Rectangles headers each followed by their pixel data come after it
+type RFBFramebufferUpdate struct {
+	Type       uint8
+	Padding    uint8
+	Rectangles uint16
+}
+
+//RFBRectangle is the header of one rectangle in a FramebufferUpdate
+type RFBRectangle struct {
+	X        uint16
+	Y        uint16
+	Width    uint16
+	Height   uint16
+	Encoding int32
+}
+
+//RFBCutText is a ServerCutText (type 3) or ClientCutText (type 6) message
+type RFBCutText struct {
+	Type    uint8
+	Padding [3]uint8
+	Text    string `marshal:"charset=latin1"`
+}
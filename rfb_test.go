@@ -0,0 +1,92 @@
+package marshal
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+//rfbPixelFormat32 is the 32bpp depth 24 true colour format of RFC 6143 7.4
+var rfbPixelFormat32 = RFBPixelFormat{
+	BitsPerPixel: 32, Depth: 24, TrueColor: true,
+	RedMax: 255, GreenMax: 255, BlueMax: 255,
+	RedShift: 16, GreenShift: 8, BlueShift: 0,
+}
+
+func TestRFBConformance(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+		wire []byte
+	}{
+		{"ServerInit", &RFBServerInit{1024, 768, rfbPixelFormat32, "Café"}, []byte{
+			0x04, 0x00, 0x03, 0x00, //framebuffer-width, framebuffer-height
+			32, 24, 0, 1, 0x00, 0xff, 0x00, 0xff, 0x00, 0xff, 16, 8, 0, 0, 0, 0, //server-pixel-format
+			0x00, 0x00, 0x00, 0x04, 'C', 'a', 'f', 0xe9, //name-length, name-string in Latin-1
+		}},
+		{"FramebufferUpdate", &RFBFramebufferUpdate{Rectangles: 2}, []byte{
+			0, 0, 0x00, 0x02,
+		}},
+		{"Rectangle", &RFBRectangle{10, 20, 640, 480, RFBEncodingTight}, []byte{
+			0x00, 0x0a, 0x00, 0x14, 0x02, 0x80, 0x01, 0xe0, 0x00, 0x00, 0x00, 0x07,
+		}},
+		{"ServerCutText", &RFBCutText{Type: 3, Text: "ü"}, []byte{
+			3, 0, 0, 0, 0x00, 0x00, 0x00, 0x01, 0xfc,
+		}},
+	}
+	for _, tt := range tests {
+		b, err := RFB.MarshalBytes(tt.v)
+		if err != nil {
+			t.Fatalf("%s: MarshalBytes: %v", tt.name, err)
+		}
+		if !bytes.Equal(b, tt.wire) {
+			t.Errorf("%s: encoded % x, want % x", tt.name, b, tt.wire)
+		}
+		readBack := reflect.New(reflect.TypeOf(tt.v).Elem())
+		if err := RFB.UnmarshalBytes(readBack.Interface(), tt.wire); err != nil {
+			t.Fatalf("%s: UnmarshalBytes: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(readBack.Interface(), tt.v) {
+			t.Errorf("%s: decoded %+v, want %+v", tt.name, readBack.Interface(), tt.v)
+		}
+	}
+}
+
+func TestRFBTightLength(t *testing.T) {
+	//compact length examples from the Tight encoding description
+	tests := []struct {
+		n    int
+		wire []byte
+	}{
+		{0x7f, []byte{0x7f}},
+		{10000, []byte{0x90, 0x4e}},
+		{0x3fffff, []byte{0xff, 0xff, 0xff}},
+	}
+	for _, tt := range tests {
+		b, err := RFB.MarshalBytes(make([]byte, tt.n))
+		if err != nil {
+			t.Fatalf("%d: MarshalBytes: %v", tt.n, err)
+		}
+		if !bytes.Equal(b[:len(tt.wire)], tt.wire) || len(b) != len(tt.wire)+tt.n {
+			t.Errorf("%d: prefix % x, want % x", tt.n, b[:len(tt.wire)], tt.wire)
+		}
+	}
+}
+
+func TestRFBLatin1(t *testing.T) {
+	if _, err := RFB.MarshalBytes(&RFBCutText{Type: 6, Text: "€"}); err == nil {
+		t.Errorf("expected an error for a rune outside Latin-1")
+	}
+	type bad struct {
+		N int32 `marshal:"charset=latin1"`
+	}
+	if _, err := RFB.MarshalBytes(&bad{}); err == nil {
+		t.Errorf("expected an error for charset on a non-string field")
+	}
+	type unknown struct {
+		S string `marshal:"charset=klingon"`
+	}
+	if _, err := RFB.MarshalBytes(&unknown{}); err == nil {
+		t.Errorf("expected an error for an unknown charset")
+	}
+}
@@ -0,0 +1,59 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+)
+
+//rle writes the slice or array of fixed-size elements v as runs of equal
+//elements, each a count written with the length type followed by the element.
+//A slice is preceded by its length, so the length type bounds the decoded size
+func (m *marshaler) rle(v reflect.Value, length LengthTypeInstance) {
+	if v.Kind() == reflect.Slice {
+		m.putLength(length, v.Type(), v.Len())
+	}
+	p := planFor(v.Type().Elem())
+	prev, cur := make([]byte, p.size), make([]byte, p.size)
+	for i := 0; i < v.Len(); {
+		putFixed(prev, v.Index(i), p, m.order)
+		j := i + 1
+		for ; j < v.Len(); j++ {
+			//elements are compared by their encoding, NaNs equal themselves
+			putFixed(cur, v.Index(j), p, m.order)
+			if !bytes.Equal(prev, cur) {
+				break
+			}
+		}
+		m.putLength(length, v.Type(), j-i)
+		m.push(indexElem(i))
+		m.marshal(v.Index(i), length)
+		m.pop()
+		i = j
+	}
+}
+
+//rle reads a slice or array written by marshaler.rle into v, runs must add up to
+//the length exactly
+func (u *unmarshaler) rle(v reflect.Value, order binary.ByteOrder, length LengthTypeInstance) {
+	if v.Kind() == reflect.Slice {
+		l := u.getLength(length, order, v.Type())
+		if l == 0 {
+			return
+		}
+		v.Set(reflect.MakeSlice(v.Type(), l, l))
+	}
+	for i := 0; i < v.Len(); {
+		n := u.getLength(length, order, v.Type())
+		if n <= 0 || n > v.Len()-i {
+			panic(errorf(ErrMalformed, "unmarshal: rle run of %d at element %d of %d", n, i, v.Len()))
+		}
+		u.push(indexElem(i))
+		u.unmarshal(v.Index(i), order, length)
+		u.pop()
+		for j := i + 1; j < i+n; j++ {
+			v.Index(j).Set(v.Index(i))
+		}
+		i += n
+	}
+}
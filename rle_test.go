@@ -0,0 +1,81 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+type rlePoint struct {
+	X, Y int16
+}
+
+type rleImage struct {
+	Flags  []uint8    `marshal:"rle"`
+	Points []rlePoint `marshal:"rle"`
+	Table  [6]float32 `marshal:"rle"`
+}
+
+func TestRLE(t *testing.T) {
+	nan := float32(math.NaN())
+	v := rleImage{
+		Flags:  []uint8{0, 0, 0, 0, 7, 0, 0},
+		Points: []rlePoint{{1, 2}, {1, 2}, {3, 4}},
+		Table:  [6]float32{nan, nan, 0, 0, 0, 1},
+	}
+	b, err := MarshalBytes(&v, binary.BigEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{
+		7, 4, 0, 1, 7, 2, 0,
+		3, 2, 0, 1, 0, 2, 1, 0, 3, 0, 4,
+		2, 0x7f, 0xc0, 0, 0, 3, 0, 0, 0, 0, 1, 0x3f, 0x80, 0, 0,
+	}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("encoded % x, want % x", b, expected)
+	}
+	var readBack rleImage
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, BlobLength8); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(readBack.Flags, v.Flags) || !reflect.DeepEqual(readBack.Points, v.Points) ||
+		!math.IsNaN(float64(readBack.Table[1])) || readBack.Table[5] != 1 {
+		t.Errorf("decoded %+v, want %+v", readBack, v)
+	}
+}
+
+func TestRLEErrors(t *testing.T) {
+	type flags struct {
+		F []uint8 `marshal:"rle"`
+	}
+	var v flags
+	//runs of 2 and 3 overshoot a length of 4
+	if err := UnmarshalBytes(&v, []byte{4, 2, 1, 3, 0}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for runs longer than the slice")
+	}
+	if err := UnmarshalBytes(&v, []byte{4, 0, 1}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for an empty run")
+	}
+	//a huge run from a few bytes is stopped by the bound of the length type
+	b := []byte{0, 0, 0, 0x10, 0, 0, 0, 0x10, 1}
+	if err := UnmarshalBytes(&v, b, binary.LittleEndian, Bound32(1<<16)); err == nil {
+		t.Errorf("expected the bound to reject the expanded size")
+	}
+	var bad struct {
+		S []string `marshal:"rle"`
+	}
+	if _, err := MarshalBytes(&bad, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for rle on variable size elements")
+	}
+}
+
+func TestRLEMaxSize(t *testing.T) {
+	n, bounded, err := MaxSize(reflect.TypeOf(rleImage{}), Bound32(10))
+	//4+10*(4+1), 4+10*(4+4), 6*(4+4)
+	if err != nil || !bounded || n != 54+84+48 {
+		t.Errorf("MaxSize %d, %v, %v, want %d", n, bounded, err, 54+84+48)
+	}
+}
@@ -0,0 +1,75 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+)
+
+//RoundTrip marshals v, unmarshals the result into a fresh value of the same type and
+//compares both. The error names the first differing field path with both values.
+//Nil and empty slices or maps compare equal since the wire can't tell them apart,
+//NaN compares equal to NaN
+func RoundTrip(v interface{}, order binary.ByteOrder, length LengthType, opts ...Option) error {
+	orig := reflect.ValueOf(v)
+	if !orig.IsValid() {
+		return errors.New("round trip: invalid type nil")
+	}
+	for orig.Kind() == reflect.Ptr {
+		if orig.IsNil() {
+			return errors.New("round trip: nil " + orig.Type().String())
+		}
+		orig = orig.Elem()
+	}
+	b, err := MarshalBytes(v, order, length, opts...)
+	if err != nil {
+		return fmt.Errorf("round trip: marshal: %w", err)
+	}
+	back := reflect.New(orig.Type())
+	n, err := decode(back.Interface(), &sliceReader{b: b}, order, length(), newOptions(opts))
+	if err != nil {
+		return fmt.Errorf("round trip: unmarshal: %w", err)
+	}
+	if err := firstDiff(orig, back.Elem()); err != nil {
+		return err
+	}
+	if n != int64(len(b)) {
+		return fmt.Errorf("round trip: unmarshal consumed %d of %d bytes", n, len(b))
+	}
+	return nil
+}
+
+//firstDiff reports the first difference between sent and received
+func firstDiff(sent, received reflect.Value) (diff error) {
+	compareValues([]pathElem{rootElem(sent.Type())}, sent, received, func(path []pathElem, a, b reflect.Value) bool {
+		diff = fmt.Errorf("round trip: %s: sent %s, received %s", formatPath(path), formatValue(a), formatValue(b))
+		return false
+	})
+	return
+}
+
+//RoundTripAll runs RoundTrip for every combination of orders and lengths and joins the failures
+func RoundTripAll(v interface{}, orders []binary.ByteOrder, lengths []LengthType, opts ...Option) error {
+	var errs []error
+	for _, o := range orders {
+		for _, l := range lengths {
+			if err := RoundTrip(v, o, l, opts...); err != nil {
+				errs = append(errs, fmt.Errorf("%v/%s: %w", o, lengthName(l), err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+//lengthName returns the name of the function implementing a LengthType, e.g. marshal.BlobLength8
+func lengthName(l LengthType) string {
+	f := runtime.FuncForPC(reflect.ValueOf(l).Pointer())
+	if f == nil {
+		return "unknown"
+	}
+	name := f.Name()
+	return name[strings.LastIndex(name, "/")+1:]
+}
@@ -0,0 +1,41 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	orders := []binary.ByteOrder{binary.LittleEndian, binary.BigEndian}
+	lengths := []LengthType{BlobLength8, BlobLength16, BlobLength32, BlobLength64, CompactLength, YYBlobType}
+	if e := RoundTripAll(createTestObject(), orders, lengths); e != nil {
+		t.Errorf("RoundTripAll: %v", e)
+	}
+	//nil and empty are indistinguishable on the wire, NaN is only equal to itself bitwise
+	v := struct {
+		Empty []uint32
+		Nil   map[string]string
+		F     float64
+	}{Empty: []uint32{}, F: math.NaN()}
+	if e := RoundTrip(&v, binary.BigEndian, BlobLength16); e != nil {
+		t.Errorf("RoundTrip: %v", e)
+	}
+}
+
+func TestRoundTripReportsField(t *testing.T) {
+	sent := *createTestObject()
+	received := *createTestObject()
+	received.Bar.Prop = map[string]uint32{"abc": 1, "def": 2, "ghi": 4}
+	e := firstDiff(reflect.ValueOf(sent), reflect.ValueOf(received))
+	if e == nil || e.Error() != "round trip: Foo.Bar.Prop[ghi]: sent 3, received 4" {
+		t.Errorf("firstDiff: %v", e)
+	}
+	received = *createTestObject()
+	received.Version = nil
+	e = firstDiff(reflect.ValueOf(sent), reflect.ValueOf(received))
+	if e == nil || e.Error() != "round trip: Foo.Version: sent [1 2 3 4 5], received []" {
+		t.Errorf("firstDiff: %v", e)
+	}
+}
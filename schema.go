@@ -0,0 +1,206 @@
+package marshal
+
+import (
+	"errors"
+	"reflect"
+)
+
+//Schema describes how values of a type are laid out on the wire, see Describe.
+//Schemas of recursive types refer back to themselves
+type Schema struct {
+	Type reflect.Type
+	Kind reflect.Kind
+	//Size is the encoded size of a fixed-size value, -1 when it varies
+	Size int
+	//Prefixed is set when the value starts with a length prefix of the LengthType
+	Prefixed bool
+	//Len is the element count of arrays
+	Len int
+	//Elem describes the elements of arrays and slices, the values of maps and
+	//the target of pointers
+	Elem *Schema
+	//Key describes the keys of maps
+	Key *Schema
+	//Fields are the fields of structs in wire order, blank fields included
+	Fields []SchemaField
+	//Custom values are encoded by a registered codec or their own methods
+	Custom bool
+	//Optional values are a presence byte and the value when present
+	Optional bool
+}
+
+//SchemaField describes a struct field
+type SchemaField struct {
+	Name string
+	//Tag is the marshal tag of the field, empty when there is none
+	Tag string
+	//Offset from the start of the struct, -1 when a variable size field comes
+	//before or the field is a payload stored after the header, see offset=
+	Offset int
+	//Bits is the width of a bits= field and BitOffset its first bit within the
+	//bytes of its group, counted from the most significant bit of the first byte
+	//with msb and from the least significant one with lsb
+	Bits, BitOffset int
+	*Schema
+}
+
+//Describe returns the Schema of v's type. Of the options only CLayout and
+//SharedPointers change the layout
+func Describe(v interface{}, opts ...Option) (s *Schema, err error) {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return nil, errors.New("describe: invalid type nil")
+	}
+	return describeType(t, newOptions(opts))
+}
+
+func describeType(t reflect.Type, o *options) (s *Schema, err error) {
+	defer recoverError(&err)
+	d := describer{pack: o.pack, shared: o.shared, seen: map[reflect.Type]*Schema{}}
+	if o.shared {
+		//the root pointers aren't encoded as tokens
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+	}
+	return d.describe(t), nil
+}
+
+type describer struct {
+	pack   int
+	shared bool
+	seen   map[reflect.Type]*Schema
+}
+
+func (d *describer) describe(t reflect.Type) *Schema {
+	if s, ok := d.seen[t]; ok {
+		return s
+	}
+	p := planFor(t)
+	if p.err != nil {
+		panic(p.err)
+	}
+	s := &Schema{Type: t, Kind: t.Kind(), Size: -1}
+	d.seen[t] = s
+	switch {
+	case p.custom:
+		s.Custom = true
+		return s
+	case p.optional:
+		s.Optional = true
+		return s
+	}
+	switch t.Kind() {
+	case reflect.String:
+		s.Prefixed = true
+	case reflect.Array:
+		s.Len = t.Len()
+		s.Elem = d.describe(t.Elem())
+		if s.Elem.Size >= 0 {
+			s.Size = s.Len * s.Elem.Size
+		}
+	case reflect.Slice:
+		s.Prefixed = true
+		s.Elem = d.describe(t.Elem())
+	case reflect.Map:
+		s.Prefixed = true
+		s.Key = d.describe(t.Key())
+		s.Elem = d.describe(t.Elem())
+	case reflect.Ptr:
+		s.Elem = d.describe(t.Elem())
+		if !d.shared {
+			s.Size = s.Elem.Size
+		}
+	case reflect.Struct:
+		d.fields(s, p)
+	case reflect.Interface:
+	default:
+		s.Size = p.size
+	}
+	return s
+}
+
+//fields fills in the fields of the struct s and its size when it is fixed
+func (d *describer) fields(s *Schema, p *typePlan) {
+	t := s.Type
+	off := 0
+	for i := range p.fields {
+		f := &p.fields[i]
+		sf := t.Field(f.index)
+		tag, _, _ := fieldTagOf(t, sf)
+		field := SchemaField{Name: f.name, Tag: tag, Offset: -1, Schema: d.describe(sf.Type)}
+		if f.tag != nil {
+			field.Schema = tagged(field.Schema, f.tag)
+		}
+		switch {
+		case f.bits != nil:
+			field.Bits = f.tag.bits
+			for _, g := range f.bits.fields {
+				if g == f {
+					break
+				}
+				field.BitOffset += g.tag.bits
+			}
+			field.Offset = off
+			if off >= 0 && f == f.bits.fields[len(f.bits.fields)-1] {
+				off += f.bits.size
+			}
+		case f.tag != nil && f.tag.offset != "":
+			//the payload is stored after the header
+		default:
+			if off >= 0 && d.pack != 0 {
+				off = alignUp(off, fieldAlign(t, f, d.pack))
+			}
+			field.Offset = off
+			if off >= 0 && field.Size >= 0 {
+				off += field.Size
+			} else {
+				off = -1
+			}
+		}
+		s.Fields = append(s.Fields, field)
+	}
+	if off >= 0 && !p.regions {
+		if d.pack != 0 {
+			off = alignUp(off, cAlign(t, d.pack))
+		}
+		s.Size = off
+	}
+}
+
+//tagged returns a copy of s changed by the field tag ft
+func tagged(s *Schema, ft *fieldTag) *Schema {
+	c := *s
+	switch {
+	case ft.codec != nil:
+		c = Schema{Type: s.Type, Kind: s.Kind, Size: -1, Custom: true}
+	case ft.reserved > 0:
+		c.Size = ft.reserved
+	case ft.magic != nil:
+		c.Size = len(ft.magic)
+	case ft.fixed > 0:
+		c.Size, c.Prefixed = ft.fixed, false
+	case ft.bcd > 0:
+		c.Size, c.Prefixed = bcdBytes(ft.bcd), false
+	case ft.bcdVar:
+		c.Size, c.Prefixed = -1, true
+	case ft.ascii > 0:
+		c.Size = ft.ascii
+	case ft.enum != nil:
+		c.Size, c.Prefixed = ft.enum.bits/8, false
+	case ft.count != "" || ft.offset != "" || ft.rest:
+		c.Size, c.Prefixed = -1, false
+	case ft.delimited, ft.trimZero:
+		c.Size, c.Prefixed = -1, true
+	case ft.packbits > 0 && s.Kind == reflect.Array:
+		c.Size = packbitsBytes(s.Len, ft.packbits)
+	case ft.quantize != reflect.Invalid && s.Kind == reflect.Array:
+		c.Size = s.Len * quantizeSize(ft.quantize)
+		if ft.scale == "" {
+			c.Size += 16
+		}
+	case ft.bits > 0, ft.bitmap, ft.delta, ft.rle, ft.packbits > 0, ft.quantize != reflect.Invalid:
+		c.Size = -1
+	}
+	return &c
+}
@@ -0,0 +1,79 @@
+package marshal
+
+import (
+	"reflect"
+	"testing"
+)
+
+type schemaFlags struct {
+	A uint8 `marshal:"bits=3"`
+	B bool  `marshal:"bits=1,lsb"`
+	C uint8 `marshal:"bits=4"`
+}
+
+type schemaMsg struct {
+	Kind  uint8
+	_     struct{} `marshal:"reserved=3"`
+	Flags schemaFlags
+	Code  string `marshal:"fixed=6"`
+	Name  string
+	Tail  uint32
+	Tree  []schemaMsg
+}
+
+func TestDescribe(t *testing.T) {
+	s, err := Describe(&schemaMsg{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Kind != reflect.Ptr || s.Size != -1 {
+		t.Fatalf("unexpected root %+v", s)
+	}
+	s = s.Elem
+	expected := []struct {
+		name     string
+		offset   int
+		size     int
+		prefixed bool
+	}{
+		{"Kind", 0, 1, false},
+		{"_", 1, 3, false},
+		{"Flags", 4, 1, false},
+		{"Code", 5, 6, false},
+		{"Name", 11, -1, true},
+		{"Tail", -1, 4, false},
+		{"Tree", -1, -1, true},
+	}
+	if len(s.Fields) != len(expected) {
+		t.Fatalf("got %d fields, want %d", len(s.Fields), len(expected))
+	}
+	for i, e := range expected {
+		f := s.Fields[i]
+		if f.Name != e.name || f.Offset != e.offset || f.Size != e.size || f.Prefixed != e.prefixed {
+			t.Errorf("field %d: got %s offset %d size %d prefixed %v, want %+v", i, f.Name, f.Offset, f.Size, f.Prefixed, e)
+		}
+	}
+	if s.Fields[6].Elem != s {
+		t.Errorf("recursive schema isn't shared")
+	}
+	flags := s.Fields[2].Fields
+	if flags[1].Bits != 1 || flags[1].BitOffset != 3 || flags[2].BitOffset != 4 || flags[2].Offset != 0 {
+		t.Errorf("unexpected bit fields %+v", flags)
+	}
+}
+
+func TestDescribeCLayout(t *testing.T) {
+	s, err := Describe(cNested{}, CLayout(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Size != 32 || s.Fields[1].Offset != 2 || s.Fields[2].Offset != 16 || s.Fields[3].Offset != 24 {
+		t.Errorf("unexpected layout %+v", s)
+	}
+	if s.Fields[1].Elem.Size != 4 {
+		t.Errorf("inner struct size %d, want 4", s.Fields[1].Elem.Size)
+	}
+	if _, err := Describe(nil); err == nil {
+		t.Errorf("expected an error for nil")
+	}
+}
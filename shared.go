@@ -0,0 +1,123 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"io"
+	"reflect"
+)
+
+//SharedPointers switches Marshal and Unmarshal to a dialect where every pointer inside
+//the top level value is written as a uvarint token: 0 for nil, 1 for a pointer seen for
+//the first time, followed by the value it points to, and n+2 for the pointer numbered n
+//in the order first seen. Values reachable from several pointers are written once and
+//decode to a single shared value, so cycles can be encoded too.
+//Both sides must use the option, the encoding differs from the default one
+func SharedPointers() Option {
+	return func(o *options) {
+		o.shared = true
+	}
+}
+
+//sharedKey identifies a pointer, pointers to a struct and to its first field share an address
+type sharedKey struct {
+	t reflect.Type
+	p uintptr
+}
+
+//sub returns a marshaler writing to w with the settings of m, used to measure parts
+//of the value m is encoding. The caller puts it back with putMarshaler
+func (m *marshaler) sub(w io.Writer) *marshaler {
+	s := getMarshaler(w, m.order, noOptions)
+	s.deterministic = m.deterministic
+	s.pack = m.pack
+	if m.shared != nil {
+		//the measured part sees the pointers written so far
+		s.shared = make(map[sharedKey]int, len(m.shared))
+		for k, n := range m.shared {
+			s.shared[k] = n
+		}
+	}
+	return s
+}
+
+func (m *marshaler) uvarint(x uint64) {
+	var b [binary.MaxVarintLen64]byte
+	if _, err := m.w.Write(b[:binary.PutUvarint(b[:], x)]); err != nil {
+		panic(err)
+	}
+}
+
+//sharedPointer writes the pointer v as a token, followed by its value the first time
+func (m *marshaler) sharedPointer(v reflect.Value, length LengthTypeInstance) {
+	start := m.cw.n
+	switch {
+	case v.IsNil():
+		m.uvarint(0)
+	default:
+		key := sharedKey{v.Type(), v.Pointer()}
+		if n, ok := m.shared[key]; ok {
+			m.uvarint(uint64(n) + 2)
+			break
+		}
+		m.shared[key] = len(m.shared)
+		m.uvarint(1)
+		m.marshal(v.Elem(), length)
+	}
+	if m.trace != nil {
+		m.emit(v.Type(), start, false)
+	}
+}
+
+//sharedPointer reads a token written by marshaler.sharedPointer into the pointer v
+func (u *unmarshaler) sharedPointer(v reflect.Value, order binary.ByteOrder, length LengthTypeInstance) {
+	start := u.cr.n
+	token := u.uvarint()
+	switch {
+	case token == 0:
+		v.Set(reflect.Zero(v.Type()))
+	case token == 1:
+		p := reflect.New(v.Type().Elem())
+		//numbered before its value is decoded, so the value can point back at it
+		u.shared = append(u.shared, p)
+		v.Set(p)
+		u.unmarshal(p.Elem(), order, length)
+	default:
+		n := token - 2
+		if n >= uint64(len(u.shared)) {
+			panic(errorf(ErrMalformed, "unmarshal: back-reference to pointer %d, only %d seen", n, len(u.shared)))
+		}
+		p := u.shared[n]
+		if p.Type() != v.Type() {
+			panic(errorf(ErrMalformed, "unmarshal: back-reference to pointer %d of type %s into %s", n, p.Type(), v.Type()))
+		}
+		v.Set(p)
+	}
+	if u.trace != nil {
+		u.emit(v.Type(), start, false)
+	}
+}
+
+func (u *unmarshaler) uvarint() uint64 {
+	start := u.cr.n
+	x, err := binary.ReadUvarint(byteReader{u})
+	if err != nil {
+		if err == io.EOF && u.cr.n > start {
+			err = io.ErrUnexpectedEOF
+		}
+		panic(err)
+	}
+	return x
+}
+
+//byteReader reads single bytes from the input of an unmarshaler
+type byteReader struct {
+	u *unmarshaler
+}
+
+func (r byteReader) ReadByte() (byte, error) {
+	b := r.u.buf[:1]
+	if _, err := io.ReadFull(r.u.r, b); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
@@ -0,0 +1,76 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestSharedPointers(t *testing.T) {
+	type pair struct {
+		A, B, C *uint16
+	}
+	x := uint16(5)
+	b, err := MarshalBytes(&pair{&x, &x, nil}, binary.BigEndian, BlobLength8, SharedPointers())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{1, 0, 5, 2, 0}; !bytes.Equal(b, expected) {
+		t.Errorf("encoded % x, want % x", b, expected)
+	}
+	var readBack pair
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, BlobLength8, SharedPointers()); err != nil {
+		t.Fatal(err)
+	}
+	if readBack.A == nil || *readBack.A != 5 || readBack.A != readBack.B || readBack.C != nil {
+		t.Errorf("decoded %v %v %v", readBack.A, readBack.B, readBack.C)
+	}
+	r := bytes.NewReader(append(b, 9))
+	if _, err := Skip(r, &pair{}, binary.BigEndian, BlobLength8, SharedPointers()); err != nil || r.Len() != 1 {
+		t.Errorf("skip left %d bytes, %v", r.Len(), err)
+	}
+	if err := UnmarshalBytes(&readBack, []byte{3}, binary.BigEndian, BlobLength8, SharedPointers()); err == nil {
+		t.Errorf("expected an error for a back-reference to an unknown pointer")
+	}
+}
+
+type sharedSymbols struct {
+	Names []string
+}
+
+type sharedNode struct {
+	Val  uint8
+	Syms *sharedSymbols
+	Next *sharedNode
+}
+
+func TestSharedPointersCycle(t *testing.T) {
+	syms := &sharedSymbols{[]string{"a", "b"}}
+	first := &sharedNode{Val: 1, Syms: syms}
+	second := &sharedNode{Val: 2, Syms: syms, Next: first}
+	first.Next = second
+	type graph struct {
+		Head *sharedNode
+		Size uint32 `marshal:"sizeof=Rest"`
+		Rest []*sharedNode
+	}
+	b, err := MarshalBytes(&graph{Head: first, Rest: []*sharedNode{second}}, binary.BigEndian, BlobLength8, SharedPointers())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var g graph
+	if err := UnmarshalBytes(&g, b, binary.BigEndian, BlobLength8, SharedPointers()); err != nil {
+		t.Fatal(err)
+	}
+	h := g.Head
+	if h.Val != 1 || h.Next.Val != 2 || h.Next.Next != h || h.Syms != h.Next.Syms || len(h.Syms.Names) != 2 {
+		t.Errorf("graph not rebuilt: %+v", h)
+	}
+	if len(g.Rest) != 1 || g.Rest[0] != h.Next {
+		t.Errorf("slice element lost its identity")
+	}
+	//a length prefix and a back-reference
+	if g.Size != 2 {
+		t.Errorf("sizeof measured %d bytes, want 2", g.Size)
+	}
+}
@@ -0,0 +1,64 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+//resolveSizes links sizeof= tags of the struct t to the later fields they measure
+func (p *typePlan) resolveSizes(t reflect.Type) error {
+	for i := range p.fields {
+		f := &p.fields[i]
+		if f.tag == nil || f.tag.sizeof == "" {
+			continue
+		}
+		if !isInteger(t.Field(f.index).Type.Kind()) {
+			return fmt.Errorf("marshal: %s.%s: sizeof field must be an integer", t, f.name)
+		}
+		j := i + 1
+		for j < len(p.fields) && p.fields[j].name != f.tag.sizeof {
+			j++
+		}
+		if j == len(p.fields) {
+			return fmt.Errorf("marshal: %s.%s: sizeof %s must name a later field", t, f.name, f.tag.sizeof)
+		}
+		target := &p.fields[j]
+		if target.sizedBy != nil {
+			return fmt.Errorf("marshal: %s: field %s is measured by both %s and %s", t, target.name, target.sizedBy.name, f.name)
+		}
+		f.sizeOf, target.sizedBy = target, f
+	}
+	return nil
+}
+
+//sizeValue returns a value of type t holding the encoded size of field f of parent
+func (m *marshaler) sizeValue(t reflect.Type, parent reflect.Value, f *fieldPlan, length LengthTypeInstance) reflect.Value {
+	sub := m.sub(io.Discard)
+	defer putMarshaler(sub)
+	sub.field(parent.Field(f.index), parent, f, length)
+	v := reflect.New(t).Elem()
+	setInt(v, sub.cw.n, "size")
+	return v
+}
+
+//sized decodes field f of parent, which must consume exactly the bytes its sizeof field holds
+func (u *unmarshaler) sized(v, parent reflect.Value, f *fieldPlan, order binary.ByteOrder, length LengthTypeInstance) {
+	size := getInt(parent.Field(f.sizedBy.index))
+	if size < 0 {
+		panic(errorf(ErrMalformed, "unmarshal: bad size %v of %s", parent.Field(f.sizedBy.index), f.name))
+	}
+	r := u.r
+	defer func() { u.r = r }()
+	u.r = &io.LimitedReader{R: r, N: size}
+	start := u.cr.n
+	u.field(v, parent, f, order, length)
+	if n := u.cr.n - start; n != size {
+		kind := ErrMalformed
+		if n < size {
+			kind = ErrTrailingBytes
+		}
+		panic(errorf(kind, "unmarshal: %s consumed %d bytes, sizeof says %d", f.name, n, size))
+	}
+}
@@ -0,0 +1,79 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type sizedBody struct {
+	Name string
+	Tags []uint16
+}
+
+type sizedMsg struct {
+	Kind    uint8
+	BodyLen uint32 `marshal:"sizeof=Body"`
+	Body    sizedBody
+	Tail    uint8
+}
+
+func TestSizeof(t *testing.T) {
+	v := sizedMsg{Kind: 1, BodyLen: 999, Body: sizedBody{"ab", []uint16{7, 8}}, Tail: 9}
+	b, err := MarshalBytes(&v, binary.BigEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{1, 0, 0, 0, 8, 2, 'a', 'b', 2, 0, 7, 0, 8, 9}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("encoded % x, want % x", b, expected)
+	}
+	var readBack sizedMsg
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, BlobLength8); err != nil {
+		t.Fatal(err)
+	}
+	v.BodyLen = 8
+	if !reflect.DeepEqual(v, readBack) {
+		t.Errorf("decoded %+v, want %+v", readBack, v)
+	}
+
+	//a size too small stops the body at the limit, one too large leaves bytes unread
+	for _, size := range []byte{7, 9} {
+		bad := append([]byte{}, expected...)
+		bad[4] = size
+		err := UnmarshalBytes(&readBack, bad, binary.BigEndian, BlobLength8)
+		if err == nil {
+			t.Errorf("size %d: expected an error", size)
+		}
+	}
+	bad := append([]byte{}, expected...)
+	bad[4] = 9
+	if err := UnmarshalBytes(&readBack, append(bad, 0), binary.BigEndian, BlobLength8); err == nil || !strings.Contains(err.Error(), "sizeof says 9") {
+		t.Errorf("oversized: %v", err)
+	}
+}
+
+func TestSizeofTagErrors(t *testing.T) {
+	type earlier struct {
+		Body []byte
+		Len  uint8 `marshal:"sizeof=Body"`
+	}
+	type notInt struct {
+		Len  string `marshal:"sizeof=Body"`
+		Body []byte
+	}
+	for _, v := range []interface{}{&earlier{}, &notInt{}} {
+		if _, err := MarshalBytes(v, binary.BigEndian, BlobLength8); err == nil {
+			t.Errorf("%T: expected a tag error", v)
+		}
+	}
+	type narrow struct {
+		Len  uint8 `marshal:"sizeof=Body"`
+		Body []byte
+	}
+	if _, err := MarshalBytes(&narrow{Body: make([]byte, 255)}, binary.BigEndian, BlobLength16); err == nil {
+		t.Errorf("expected an error for a size overflowing uint8")
+	}
+}
@@ -0,0 +1,162 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+)
+
+//Skip consumes exactly the bytes one value of v's type occupies in r and reports
+//how many there were. v is only used for its type, payloads are discarded without
+//being materialized, by seeking when r is an io.Seeker
+func Skip(r io.Reader, v interface{}, order binary.ByteOrder, length LengthType, opts ...Option) (n int64, err error) {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return 0, errors.New("skip: invalid type nil")
+	}
+	u := getUnmarshaler(r, newOptions(opts))
+	defer putUnmarshaler(u)
+	defer recoverError(&err)
+	defer func() { n = u.cr.n }()
+	if u.shared != nil {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+	}
+	u.skip(t, order, length())
+	return
+}
+
+func (u *unmarshaler) skip(t reflect.Type, order binary.ByteOrder, length LengthTypeInstance) {
+	p := planFor(t)
+	if p.custom {
+		//only the codec knows how long its encoding is
+		u.custom(reflect.New(t).Elem(), order, length)
+		return
+	}
+	if p.optional {
+		u.optional(reflect.New(t).Elem(), order, length)
+		return
+	}
+	if p.size >= 0 {
+		if u.pack != 0 {
+			u.discard(int64(cSize(t, u.pack)))
+		} else {
+			u.discard(int64(p.size))
+		}
+		return
+	}
+	kind := t.Kind()
+	switch kind {
+	case reflect.Ptr:
+		if u.shared != nil {
+			//skipped values are numbered like decoded ones
+			u.sharedPointer(reflect.New(t).Elem(), order, length)
+			return
+		}
+		if isNullable(kind, length) && p.nullable {
+			u.nullable(reflect.New(t).Elem(), order, length)
+			return
+		}
+		u.skip(t.Elem(), order, length)
+	case reflect.String:
+		l := u.regionLength(length, order, t)
+		if l == indefiniteLength {
+			u.segments(length, order, t, nil)
+			return
+		}
+		u.discard(int64(l))
+		u.trailer(length, t)
+	case reflect.Struct:
+		if p.err != nil {
+			panic(p.err)
+		}
+		if p.counted || p.regions || p.bitfields || u.pack != 0 {
+			//counts live in other fields, bit groups share bytes and C padding depends on
+			//the offset, decode into a throwaway value
+			u.unmarshal(reflect.New(t).Elem(), order, length)
+			return
+		}
+		for i := range p.fields {
+			f := &p.fields[i]
+			if f.tag != nil {
+				//tagged layouts are rare, decode them into a throwaway value
+				u.unmarshalTagged(reflect.New(t.Field(f.index).Type).Elem(), reflect.Value{}, f, order, fieldLength(f, length))
+			} else {
+				u.skip(t.Field(f.index).Type, order, length)
+			}
+		}
+	case reflect.Interface:
+		u.iface(reflect.New(t).Elem(), order, length)
+	case reflect.Map:
+		l := u.getLength(length, order, t)
+		elems := elemsOf(length)
+		for i := 0; i < l; i++ {
+			u.skip(t.Key(), order, elems)
+			u.skip(t.Elem(), order, elems)
+		}
+	case reflect.Slice, reflect.Array:
+		var l int
+		if kind == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			if l = u.regionLength(length, order, t); l == indefiniteLength {
+				u.segments(length, order, t, nil)
+				return
+			}
+		} else if kind == reflect.Slice {
+			l = u.getLength(length, order, t)
+		} else {
+			l = t.Len()
+		}
+		if size := p.elem.size; size >= 0 {
+			if u.pack != 0 {
+				size = cSize(t.Elem(), u.pack)
+			}
+			u.discard(int64(l) * int64(size))
+			if kind == reflect.Slice {
+				u.trailer(length, t)
+			}
+		} else {
+			elems := elemsOf(length)
+			for i := 0; i < l; i++ {
+				u.skip(t.Elem(), order, elems)
+			}
+		}
+	default:
+		panic(errorf(ErrUnsupportedKind, "unsupport type%s", t.Name()))
+	}
+}
+
+//discard drops n bytes of input
+func (u *unmarshaler) discard(n int64) {
+	if n == 0 {
+		return
+	}
+	if s, ok := u.cr.r.(io.Seeker); ok {
+		cur, err := s.Seek(0, io.SeekCurrent)
+		if err == nil {
+			var end int64
+			if end, err = s.Seek(0, io.SeekEnd); err == nil {
+				if cur+n > end {
+					s.Seek(end, io.SeekStart)
+					u.cr.n += end - cur
+					if cur == end {
+						panic(io.EOF)
+					}
+					panic(io.ErrUnexpectedEOF)
+				}
+				if _, err = s.Seek(cur+n, io.SeekStart); err == nil {
+					u.cr.n += n
+					return
+				}
+			}
+		}
+		panic(err)
+	}
+	if c, err := io.CopyN(io.Discard, u.r, n); err != nil {
+		if err == io.EOF && c > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		panic(err)
+	}
+}
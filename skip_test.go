@@ -0,0 +1,86 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func fooStream(t *testing.T, count int, order binary.ByteOrder, length LengthType) ([]Foo, []byte) {
+	foos := make([]Foo, count)
+	result := new(bytes.Buffer)
+	for i := range foos {
+		foos[i] = Foo{
+			Version: bytes.Repeat([]byte{byte(i)}, i*3),
+			Uid:     uint32(i),
+			Bar: bar{
+				Id:   strings.Repeat("x", i*7),
+				Prop: map[string]uint32{},
+			},
+		}
+		for j := 0; j < i; j++ {
+			foos[i].Bar.Prop[strings.Repeat("k", j+1)] = uint32(j)
+		}
+		if e := Marshal(&foos[i], result, order, length); e != nil {
+			t.Fatalf("marshal: %v", e)
+		}
+	}
+	return foos, result.Bytes()
+}
+
+type onlyReader struct {
+	io.Reader
+}
+
+func TestSkip(t *testing.T) {
+	foos, stream := fooStream(t, 6, binary.LittleEndian, BlobLength16)
+	readers := map[string]io.Reader{
+		"seeker": bytes.NewReader(stream),
+		"reader": onlyReader{bytes.NewReader(stream)},
+	}
+	for name, r := range readers {
+		var total int64
+		for i := range foos {
+			if i%2 == 0 {
+				n, e := Skip(r, Foo{}, binary.LittleEndian, BlobLength16)
+				if e != nil {
+					t.Fatalf("%s: skip %d: %v", name, i, e)
+				}
+				total += n
+				continue
+			}
+			var readBack Foo
+			n, e := ReaderFrom(&readBack, binary.LittleEndian, BlobLength16).ReadFrom(r)
+			if e != nil {
+				t.Fatalf("%s: decode %d: %v", name, i, e)
+			}
+			total += n
+			if !reflect.DeepEqual(foos[i], readBack) {
+				t.Errorf("%s: record %d differs after skipping", name, i)
+			}
+		}
+		if total != int64(len(stream)) {
+			t.Errorf("%s: consumed %d bytes, stream has %d", name, total, len(stream))
+		}
+	}
+}
+
+func TestSkipTruncated(t *testing.T) {
+	_, stream := fooStream(t, 3, binary.BigEndian, CompactLength)
+	//cut inside the last map value, and right before the trailing OK byte
+	cuts := map[int]error{len(stream) - 3: io.ErrUnexpectedEOF, len(stream) - 1: io.EOF}
+	for cut, want := range cuts {
+		for _, r := range []io.Reader{bytes.NewReader(stream[:cut]), onlyReader{bytes.NewReader(stream[:cut])}} {
+			var e error
+			for i := 0; i < 3 && e == nil; i++ {
+				_, e = Skip(r, &Foo{}, binary.BigEndian, CompactLength)
+			}
+			if e != want {
+				t.Errorf("skip of stream cut at %d: %v, want %v", cut, e, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+//MarshalSlice writes the elements of slice to w without the slice's own length prefix,
+//for element counts carried out of band. Variable length elements still use length
+func MarshalSlice(w io.Writer, slice interface{}, order binary.ByteOrder, length LengthType, opts ...Option) (err error) {
+	v := reflect.ValueOf(slice)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return fmt.Errorf("marshal: MarshalSlice of %T, want a slice", slice)
+	}
+	m := getMarshaler(w, order, newOptions(opts))
+	defer putMarshaler(m)
+	defer recoverError(&err)
+	m.push(rootElem(v.Type()))
+	m.elements(v, length())
+	return
+}
+
+//UnmarshalSlice decodes exactly n elements from r into the slice slicePtr points to,
+//reading no length prefix for it. The slice's capacity is reused when it is large enough
+func UnmarshalSlice(r io.Reader, slicePtr interface{}, n int, order binary.ByteOrder, length LengthType, opts ...Option) (err error) {
+	p := reflect.ValueOf(slicePtr)
+	if p.Kind() != reflect.Ptr || p.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("unmarshal: UnmarshalSlice into %T, want a pointer to a slice", slicePtr)
+	}
+	if n < 0 {
+		return errors.New("unmarshal: UnmarshalSlice: negative count")
+	}
+	v := p.Elem()
+	u := getUnmarshaler(r, newOptions(opts))
+	defer putUnmarshaler(u)
+	defer recoverError(&err)
+	u.push(rootElem(p.Type()))
+	if v.Cap() >= n {
+		v.SetLen(n)
+		if planFor(v.Type().Elem()).size < 0 {
+			//decoding into a reused element must not keep stale maps or slices
+			for i := 0; i < n; i++ {
+				v.Index(i).SetZero()
+			}
+		}
+	} else {
+		u.makeSlice(v, n)
+	}
+	if n != 0 {
+		u.elements(v, order, length())
+	}
+	return
+}
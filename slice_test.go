@@ -0,0 +1,59 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestMarshalSlice(t *testing.T) {
+	type rec struct {
+		ID   uint16
+		Name string
+	}
+	recs := []rec{{1, "a"}, {2, "bc"}, {3, ""}}
+	buf := new(bytes.Buffer)
+	if err := MarshalSlice(buf, recs, binary.BigEndian, BlobLength8); err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{0, 1, 1, 'a', 0, 2, 2, 'b', 'c', 0, 3, 0}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("encoded % x, want % x", buf.Bytes(), expected)
+	}
+
+	var readBack []rec
+	if err := UnmarshalSlice(bytes.NewReader(expected), &readBack, 3, binary.BigEndian, BlobLength8); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(recs, readBack) {
+		t.Errorf("decoded %v, want %v", readBack, recs)
+	}
+
+	//capacity is reused and stale contents are cleared
+	reuse := make([]rec, 5, 8)
+	reuse[2].Name = "stale"
+	if err := UnmarshalSlice(bytes.NewReader(expected), &reuse, 3, binary.BigEndian, BlobLength8); err != nil {
+		t.Fatal(err)
+	}
+	if cap(reuse) != 8 || !reflect.DeepEqual(recs, reuse) {
+		t.Errorf("reuse: decoded %v cap %d", reuse, cap(reuse))
+	}
+
+	if err := UnmarshalSlice(bytes.NewReader(expected[:5]), &readBack, 3, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error on short input")
+	}
+	if err := UnmarshalSlice(bytes.NewReader(nil), readBack, 3, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for a non-pointer")
+	}
+}
+
+func TestUnmarshalSliceBytes(t *testing.T) {
+	var b []byte
+	if err := UnmarshalSlice(bytes.NewReader([]byte{1, 2, 3, 4}), &b, 3, binary.BigEndian, BlobLength8); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, []byte{1, 2, 3}) {
+		t.Errorf("decoded % x", b)
+	}
+}
@@ -0,0 +1,91 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"io"
+	"reflect"
+	"sync"
+)
+
+//writeCounter counts bytes passed to the underlying writer
+type writeCounter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *writeCounter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+//readCounter counts bytes read from the underlying reader
+type readCounter struct {
+	r io.Reader
+	n int64
+}
+
+func (c *readCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+var marshalerPool = sync.Pool{
+	New: func() interface{} {
+		return &marshaler{}
+	},
+}
+
+func getMarshaler(w io.Writer, order binary.ByteOrder, o *options) *marshaler {
+	m := marshalerPool.Get().(*marshaler)
+	m.cw = writeCounter{w: w}
+	m.w = &m.cw
+	m.order = order
+	m.path = m.path[:0]
+	m.trace = o.trace
+	m.index = o.index
+	m.deterministic = o.deterministic
+	m.pack = o.pack
+	m.warn = o.warn
+	if o.shared {
+		m.shared = map[sharedKey]int{}
+	}
+	return m
+}
+
+func putMarshaler(m *marshaler) {
+	clear(m.path[:cap(m.path)])
+	*m = marshaler{path: m.path[:0], stage: stageWriter{m.stage.b[:0]}, frames: m.frames[:0]}
+	marshalerPool.Put(m)
+}
+
+var unmarshalerPool = sync.Pool{
+	New: func() interface{} {
+		return &unmarshaler{}
+	},
+}
+
+func getUnmarshaler(r io.Reader, o *options) *unmarshaler {
+	u := unmarshalerPool.Get().(*unmarshaler)
+	u.cr = readCounter{r: r}
+	u.r = &u.cr
+	u.alloc = o.alloc
+	u.path = u.path[:0]
+	u.trace = o.trace
+	u.strict = o.strict
+	u.unknown = o.unknown
+	u.pack = o.pack
+	u.warn = o.warn
+	u.src, _ = r.(*sliceReader)
+	if o.shared {
+		u.shared = []reflect.Value{}
+	}
+	return u
+}
+
+func putUnmarshaler(u *unmarshaler) {
+	clear(u.path[:cap(u.path)])
+	*u = unmarshaler{path: u.path[:0], frames: u.frames[:0]}
+	unmarshalerPool.Put(u)
+}
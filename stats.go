@@ -0,0 +1,62 @@
+package marshal
+
+import (
+	"reflect"
+	"time"
+)
+
+//Stats describes the encoding or decoding of one top-level value, see WithStats
+type Stats struct {
+	//Type is the type of the value, nil for a nil interface
+	Type reflect.Type
+	//Decode is set when the value was read, clear when it was written
+	Decode bool
+	//Bytes is the number of bytes written or read
+	Bytes int64
+	//Fields is the number of struct fields written or read, nested ones included
+	Fields   int
+	Duration time.Duration
+	//Err is what the call reports for the value, nil on success
+	Err error
+}
+
+//StatsCollector receives Stats, see WithStats. Collect runs on the goroutine
+//making the call before the call returns, so it should be quick
+type StatsCollector interface {
+	Collect(Stats)
+}
+
+//WithStats makes every Marshal and Unmarshal call report the Stats of its value
+//to c exactly once, failures included, and an Encoder or Decoder report one for
+//each value it writes or reads, batches included. Without it calls don't read the clock
+func WithStats(c StatsCollector) Option {
+	return func(o *options) {
+		o.stats = c
+	}
+}
+
+//collect reports the value of type t whose writing began at start
+func (m *marshaler) collect(c StatsCollector, t reflect.Type, start time.Time, err *error) {
+	c.Collect(Stats{Type: t, Bytes: m.cw.n, Fields: m.fields, Duration: time.Since(start), Err: *err})
+}
+
+//collect reports the value of type t whose reading began at start
+func (u *unmarshaler) collect(c StatsCollector, t reflect.Type, start time.Time, err *error) {
+	c.Collect(Stats{Type: t, Decode: true, Bytes: u.cr.n, Fields: u.fields, Duration: time.Since(start), Err: *err})
+}
+
+//fieldCount is the number of fields a fixed-size value of plan p holds,
+//counting those of nested structs and array elements
+func fieldCount(t reflect.Type, p *typePlan) int {
+	switch t.Kind() {
+	case reflect.Array:
+		return t.Len() * fieldCount(t.Elem(), p.elem)
+	case reflect.Struct:
+		n := len(p.fields)
+		for i := range p.fields {
+			n += fieldCount(t.Field(p.fields[i].index).Type, p.fields[i].plan)
+		}
+		return n
+	}
+	return 0
+}
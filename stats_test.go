@@ -0,0 +1,110 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+type statsLog []Stats
+
+func (l *statsLog) Collect(s Stats) {
+	*l = append(*l, s)
+}
+
+type statsInner struct {
+	A, B uint16
+}
+
+type statsMsg struct {
+	ID    uint32
+	Pair  statsInner
+	Pairs [2]statsInner
+	Name  string
+}
+
+func TestWithStats(t *testing.T) {
+	var log statsLog
+	v := statsMsg{ID: 1, Name: "abc"}
+	b, err := MarshalBytes(&v, binary.BigEndian, BlobLength8, WithStats(&log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var readBack statsMsg
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, BlobLength8, WithStats(&log)); err != nil {
+		t.Fatal(err)
+	}
+	if len(log) != 2 {
+		t.Fatalf("collected %d stats, want 2", len(log))
+	}
+	//4 fields, 2 in Pair and 4 in the elements of Pairs
+	for i, s := range log {
+		if s.Type != reflect.TypeOf(&v) || s.Decode != (i == 1) || s.Bytes != int64(len(b)) || s.Fields != 10 || s.Err != nil || s.Duration <= 0 {
+			t.Errorf("stats %d: %+v", i, s)
+		}
+	}
+	log = nil
+	if err := UnmarshalBytes(&readBack, b[:7], binary.BigEndian, BlobLength8, WithStats(&log)); err == nil {
+		t.Fatal("expected an error for short input")
+	}
+	if len(log) != 1 || log[0].Err == nil || log[0].Bytes != 7 {
+		t.Errorf("collected %+v, want one failure after 7 bytes", log)
+	}
+	//a bad destination is reported too
+	log = nil
+	if err := UnmarshalBytes(readBack, b, binary.BigEndian, BlobLength8, WithStats(&log)); err == nil {
+		t.Fatal("expected an error for a destination that isn't a pointer")
+	}
+	if len(log) != 1 || log[0].Err == nil || log[0].Type != reflect.TypeOf(readBack) || !log[0].Decode {
+		t.Errorf("collected %+v, want one failure of statsMsg", log)
+	}
+	log = nil
+	if err := UnmarshalBytes(nil, b, binary.BigEndian, BlobLength8, WithStats(&log)); !errors.Is(err, ErrNilPointer) {
+		t.Fatalf("got %v, want ErrNilPointer", err)
+	}
+	if len(log) != 1 || !errors.Is(log[0].Err, ErrNilPointer) || log[0].Type != nil {
+		t.Errorf("collected %+v, want one failure of a nil interface", log)
+	}
+}
+
+func TestStreamStats(t *testing.T) {
+	var log statsLog
+	var buf bytes.Buffer
+	e := NewEncoder(&buf, binary.BigEndian, BlobLength8, WithStats(&log))
+	if err := e.EncodeAll([]statsInner{{1, 2}, {3, 4}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Encode(&statsMsg{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.EncodeAll([]string{"x", string(make([]byte, 300))}); err == nil {
+		t.Fatal("expected an error for a string too long")
+	}
+	if len(log) != 5 || log[0].Bytes != 4 || log[0].Fields != 2 || log[2].Type != reflect.TypeOf(&statsMsg{}) ||
+		log[3].Err != nil || !errors.Is(log[4].Err, ErrLengthTooLarge) {
+		t.Errorf("encoding collected %+v", log)
+	}
+	log = nil
+	d := NewDecoder(&buf, binary.BigEndian, BlobLength8, WithStats(&log))
+	var pairs []statsInner
+	if err := d.DecodeAll(&pairs, 2); err == nil {
+		t.Fatal("expected the element limit to stop DecodeAll")
+	}
+	var m statsMsg
+	var s string
+	if err := d.Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Decode(&s); err != nil || s != "x" {
+		t.Fatalf("decoded %q, %v", s, err)
+	}
+	if err := d.Decode(&m); err != io.EOF {
+		t.Fatalf("got %v, want io.EOF", err)
+	}
+	if len(log) != 5 || log[1].Bytes != 4 || !log[2].Decode || log[2].Fields != 10 || log[3].Bytes != 2 || log[4].Err != io.EOF {
+		t.Errorf("decoding collected %+v", log)
+	}
+}
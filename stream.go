@@ -0,0 +1,476 @@
+package marshal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"reflect"
+	"time"
+)
+
+//ErrTooManyElements is reported by DecodeAll when the stream holds more elements than allowed
+var ErrTooManyElements error = &kindError{"marshal: too many elements", ErrBoundExceeded}
+
+//ErrSeqLength is reported by EncodeSeq when the sequence doesn't hold the count of
+//values it was given
+var ErrSeqLength = errors.New("marshal: sequence length differs from its count")
+
+//BatchError reports a failure in the middle of a sequence of values,
+//Count is the number of complete values decoded before it
+type BatchError struct {
+	Count int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("after %d elements: %v", e.Count, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+//Encoder writes a stream of values to an io.Writer using fixed settings
+type Encoder struct {
+	w     io.Writer
+	order binary.ByteOrder
+	//length is created once and used for every value
+	length LengthTypeInstance
+	o      *options
+	//index receives message offsets, see WithIndex
+	index *[]int64
+	n     int64
+}
+
+//NewEncoder returns an Encoder writing to w
+func NewEncoder(w io.Writer, order binary.ByteOrder, length LengthType, opts ...Option) *Encoder {
+	e := &Encoder{w: w, order: order, length: length(), o: newOptions(opts)}
+	if e.o.index != nil {
+		//index messages, not the elements of each message
+		e.index = e.o.index
+		o := *e.o
+		o.index = nil
+		e.o = &o
+	}
+	return e
+}
+
+//Encode writes binary presentation of v to the stream
+func (e *Encoder) Encode(v interface{}) error {
+	if e.index != nil {
+		*e.index = append(*e.index, e.n)
+	}
+	n, err := encode(v, e.w, e.order, e.length, e.o)
+	e.n += n
+	return err
+}
+
+//EncodeAll writes every element of the slice or array slice to the stream as a value
+//of its own, exactly like calling Encode for each. One marshaler is set up for
+//the whole batch. A failure is a *BatchError carrying the number of elements written
+func (e *Encoder) EncodeAll(slice interface{}) (err error) {
+	s := reflect.ValueOf(slice)
+	if s.Kind() != reflect.Slice && s.Kind() != reflect.Array {
+		return fmt.Errorf("marshal: EncodeAll of %T, want a slice", slice)
+	}
+	m := getMarshaler(e.w, e.order, e.o)
+	defer putMarshaler(m)
+	count := 0
+	var start time.Time
+	defer func() {
+		if err != nil && e.o.stats != nil {
+			m.collect(e.o.stats, s.Type().Elem(), start, &err)
+		}
+		e.n += m.cw.n
+		if err != nil {
+			err = &BatchError{count, err}
+		}
+	}()
+	defer recoverError(&err)
+	length := e.length
+	for ; count < s.Len(); count++ {
+		if e.index != nil {
+			*e.index = append(*e.index, e.n)
+		}
+		if e.o.stats != nil {
+			start = time.Now()
+			m.fields = 0
+		}
+		rv := s.Index(count)
+		m.path = m.path[:0]
+		m.push(rootElem(rv.Type()))
+		if e.o.fingerprint {
+			m.putFingerprint(rv.Type(), length, e.o)
+		}
+		if m.shared != nil {
+			//every value numbers its pointers from scratch, see SharedPointers
+			clear(m.shared)
+			for rv.Kind() == reflect.Ptr {
+				rv = rv.Elem()
+			}
+		}
+		m.marshal(rv, length)
+		if e.o.stats != nil {
+			m.collect(e.o.stats, s.Type().Elem(), start, &err)
+		}
+		e.n += m.cw.n
+		m.cw.n = 0
+	}
+	return nil
+}
+
+//EncodeSeq writes the n values of seq to the stream of e as one value of type []T,
+//in the same bytes as Encode of a slice holding them: the length prefix, then each
+//value as seq yields it, so the slice is never built. A seq that yields fewer or
+//more than n values fails with a *BatchError carrying the number of values written
+//and wrapping ErrSeqLength. The stream is broken then, a short one has a length
+//prefix promising values that never come
+func EncodeSeq[T any](e *Encoder, n int, seq iter.Seq[T]) (err error) {
+	t := reflect.TypeOf([]T(nil))
+	if e.index != nil {
+		*e.index = append(*e.index, e.n)
+	}
+	m := getMarshaler(e.w, e.order, e.o)
+	defer putMarshaler(m)
+	count := 0
+	if e.o.stats != nil {
+		defer m.collect(e.o.stats, t, time.Now(), &err)
+	}
+	defer func() {
+		e.n += m.cw.n
+		if err != nil {
+			err = &BatchError{count, err}
+		}
+	}()
+	defer recoverError(&err)
+	length := e.length
+	m.push(rootElem(t))
+	if e.o.fingerprint {
+		m.putFingerprint(t, length, e.o)
+	}
+	m.putLength(length, t, n)
+	more := false
+	for v := range seq {
+		if count == n {
+			more = true
+			break
+		}
+		m.push(indexElem(count))
+		m.marshal(reflect.ValueOf(&v).Elem(), length)
+		m.pop()
+		count++
+	}
+	switch {
+	case more:
+		return fmt.Errorf("%w: more than %d values", ErrSeqLength, n)
+	case count < n:
+		return fmt.Errorf("%w: %d of %d values", ErrSeqLength, count, n)
+	}
+	m.trailer(length, t)
+	return nil
+}
+
+//Flush pushes the values encoded so far onto the wire when the underlying writer
+//buffers them, such as a bufio.Writer. The Encoder itself doesn't buffer
+func (e *Encoder) Flush() error {
+	if f, ok := e.w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+//Reset makes e write to w as if it was just created, keeping its settings.
+//Message offsets recorded with WithIndex start from 0 again
+func (e *Encoder) Reset(w io.Writer) {
+	e.w = w
+	e.n = 0
+}
+
+//Decoder reads a stream of values from an io.Reader using fixed settings.
+//The Decoder buffers its input and may read data from r beyond the values requested
+type Decoder struct {
+	r     *bufio.Reader
+	order binary.ByteOrder
+	//length is created once and used for every value
+	length LengthTypeInstance
+	o      *options
+	//own is set when r was created by the Decoder and can be reset
+	own bool
+	//mem is the input of a Decoder made by NewBytesDecoder, r is nil then
+	mem *sliceReader
+	//replay keeps the bytes of a value cut short by a timeout, see ResumeOnTimeout
+	replay *replay
+	//last is the type of the value decoded last, see Resync
+	last reflect.Type
+	//failed holds the bytes of the last value that failed after starting with a
+	//magic, Resync searches them again. spare is a replay kept for reuse
+	failed []byte
+	spare  *replay
+}
+
+//NewDecoder returns a Decoder reading from r
+func NewDecoder(r io.Reader, order binary.ByteOrder, length LengthType, opts ...Option) *Decoder {
+	d := &Decoder{order: order, length: length(), o: newOptions(opts)}
+	d.Reset(r)
+	return d
+}
+
+//NewBytesDecoder returns a Decoder reading the values in b. It decodes straight
+//from b, without buffering or copying the input
+func NewBytesDecoder(b []byte, order binary.ByteOrder, length LengthType, opts ...Option) *Decoder {
+	return &Decoder{order: order, length: length(), o: newOptions(opts), mem: &sliceReader{b: b}}
+}
+
+//Reset makes d read from r as if it was just created, keeping its settings.
+//Input still buffered from the previous reader is dropped
+func (d *Decoder) Reset(r io.Reader) {
+	d.mem = nil
+	if br, ok := r.(*bufio.Reader); ok {
+		d.r, d.own = br, false
+	} else if d.own {
+		d.r.Reset(r)
+	} else {
+		d.r, d.own = bufio.NewReader(r), true
+	}
+	d.replay, d.failed = nil, nil
+	if d.o.resume {
+		d.replay = &replay{r: d.r}
+	}
+}
+
+//Decode reads the next value from the stream into m which must be a pointer
+func (d *Decoder) Decode(m interface{}) error {
+	d.last = reflect.TypeOf(m)
+	start := d.mark()
+	_, err := decode(m, d.input(), d.order, d.length, d.o)
+	d.fail(start, err)
+	d.settle(err)
+	return err
+}
+
+//input is what values are decoded from
+func (d *Decoder) input() io.Reader {
+	if d.mem != nil {
+		return d.mem
+	}
+	if d.replay != nil {
+		return d.replay
+	}
+	return d.r
+}
+
+//peek checks there is more input, it reports io.EOF at the end of the stream
+func (d *Decoder) peek() error {
+	if d.mem != nil {
+		if d.mem.off >= len(d.mem.b) {
+			return io.EOF
+		}
+		return nil
+	}
+	if d.replay != nil && len(d.replay.buf) > 0 {
+		return nil
+	}
+	_, err := d.r.Peek(1)
+	return err
+}
+
+//More reports whether there is another value to decode, it doesn't consume any input.
+//More returns false at the end of the stream and when the underlying reader fails
+func (d *Decoder) More() bool {
+	return d.peek() == nil
+}
+
+//Peek returns the next n bytes of the stream without consuming them, the next
+//value decoded or read starts with them. Dispatch code can look at a
+//discriminator with it, then Decode the type it selects from the same bytes.
+//Fewer than n bytes are returned with the error that cut them short, io.EOF at
+//the end of the stream, and bufio.ErrBufferFull when n is larger than the
+//buffer of the Decoder. The bytes are valid until the next call on d
+func (d *Decoder) Peek(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, bufio.ErrNegativeCount
+	}
+	if d.mem != nil {
+		b := d.mem.b[d.mem.off:]
+		if len(b) < n {
+			return b, io.EOF
+		}
+		return b[:n:n], nil
+	}
+	var kept []byte
+	if d.replay != nil {
+		//the start of the value a timeout cut short comes first
+		kept = d.replay.buf[d.replay.pos:]
+	}
+	if len(kept) >= n {
+		return kept[:n:n], nil
+	}
+	b, err := d.r.Peek(n - len(kept))
+	if len(kept) > 0 {
+		b = append(kept[:len(kept):len(kept)], b...)
+	}
+	return b, err
+}
+
+//Buffered returns a reader of the data remaining in the Decoder's buffer, or of
+//the rest of the input of a Decoder made by NewBytesDecoder
+func (d *Decoder) Buffered() io.Reader {
+	if d.mem != nil {
+		return bytes.NewReader(d.mem.b[d.mem.off:])
+	}
+	b, _ := d.r.Peek(d.r.Buffered())
+	if d.replay != nil && len(d.replay.buf) > 0 {
+		//the start of the value a timeout cut short comes first
+		return io.MultiReader(bytes.NewReader(d.replay.buf), bytes.NewReader(b))
+	}
+	return bytes.NewReader(b)
+}
+
+//DecodeAll decodes values until the stream ends and appends them to the slice slicePtr
+//points to. The stream must end at a value boundary, a value cut short is reported as
+//io.ErrUnexpectedEOF. max bounds the number of values appended, 0 means no limit.
+//The values are decoded in place at the end of the slice by one unmarshaler set
+//up for the whole batch. Any failure is a *BatchError carrying the number of values appended
+func (d *Decoder) DecodeAll(slicePtr interface{}, max int) error {
+	p := reflect.ValueOf(slicePtr)
+	if p.Kind() != reflect.Ptr || p.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("unmarshal: DecodeAll into %T, want a pointer to a slice", slicePtr)
+	}
+	s := p.Elem()
+	zero := reflect.Zero(s.Type().Elem())
+	d.last, d.failed = zero.Type(), nil
+	u := getUnmarshaler(d.input(), d.o)
+	defer putUnmarshaler(u)
+	length := d.length
+	for count := 0; ; count++ {
+		if err := d.peek(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return &BatchError{count, err}
+		}
+		if max > 0 && count == max {
+			return &BatchError{count, ErrTooManyElements}
+		}
+		s.Set(reflect.Append(s, zero))
+		err := decodeElem(u, s.Index(s.Len()-1), d.order, length, d.o)
+		d.settle(err)
+		if err != nil {
+			s.SetLen(s.Len() - 1)
+			if err == io.EOF && u.cr.n > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return &BatchError{count, err}
+		}
+	}
+}
+
+//UnmarshalN decodes exactly n values from r and appends them to the slice slicePtr
+//points to, it reads nothing past the nth value. The values are decoded as by
+//DecodeAll, in place at the end of the slice by one unmarshaler set up for the
+//whole batch. Any failure is a *BatchError carrying the number of values appended,
+//a stream that ends before the nth value is reported as io.ErrUnexpectedEOF. It
+//takes its arguments in the order of UnmarshalSlice, which overwrites the slice
+//instead of appending to it
+func UnmarshalN(r io.Reader, slicePtr interface{}, n int, order binary.ByteOrder, length LengthType, opts ...Option) error {
+	p := reflect.ValueOf(slicePtr)
+	if p.Kind() != reflect.Ptr || p.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("unmarshal: UnmarshalN into %T, want a pointer to a slice", slicePtr)
+	}
+	if n < 0 {
+		return fmt.Errorf("unmarshal: UnmarshalN of %d values", n)
+	}
+	s := p.Elem()
+	zero := reflect.Zero(s.Type().Elem())
+	o := newOptions(opts)
+	u := getUnmarshaler(r, o)
+	defer putUnmarshaler(u)
+	inst := length()
+	for count := 0; count < n; count++ {
+		s.Set(reflect.Append(s, zero))
+		if err := decodeElem(u, s.Index(s.Len()-1), order, inst, o); err != nil {
+			s.SetLen(s.Len() - 1)
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return &BatchError{count, err}
+		}
+	}
+	return nil
+}
+
+//decodeElem decodes the next value into v with the unmarshaler of a batch, as if
+//decoding it on its own
+func decodeElem(u *unmarshaler, v reflect.Value, order binary.ByteOrder, length LengthTypeInstance, o *options) (err error) {
+	u.cr.n = 0
+	u.fields = 0
+	if o.stats != nil {
+		defer u.collect(o.stats, v.Type(), time.Now(), &err)
+	}
+	defer recoverError(&err)
+	u.path = u.path[:0]
+	if u.shared != nil {
+		clear(u.shared)
+		u.shared = u.shared[:0]
+	}
+	u.push(rootElem(v.Type()))
+	if o.fingerprint {
+		u.checkFingerprint(v.Type(), order, length, o)
+	}
+	u.unmarshal(v, order, length)
+	return
+}
+
+//DecodeMap reads a map from the stream one entry at a time instead of building it.
+//Each key and value is decoded into what keyPtr and valPtr point to, which are
+//zeroed first, then fn is called and may use them until it returns. Any failure,
+//including an error returned by fn, is a *BatchError carrying the number of
+//entries fn accepted
+func (d *Decoder) DecodeMap(keyPtr, valPtr interface{}, fn func() error) (err error) {
+	k, v := reflect.ValueOf(keyPtr), reflect.ValueOf(valPtr)
+	if k.Kind() != reflect.Ptr || v.Kind() != reflect.Ptr {
+		return fmt.Errorf("unmarshal: DecodeMap into %T and %T, want pointers", keyPtr, valPtr)
+	}
+	k, v = k.Elem(), v.Elem()
+	if !k.Type().Comparable() {
+		return fmt.Errorf("unmarshal: DecodeMap with key %s, which isn't comparable", k.Type())
+	}
+	t := reflect.MapOf(k.Type(), v.Type())
+	u := getUnmarshaler(d.input(), d.o)
+	defer putUnmarshaler(u)
+	count := 0
+	defer func() {
+		//entries fn has seen can't be decoded again
+		d.settle(nil)
+		if err == nil {
+			return
+		}
+		if _, ok := err.(*BatchError); !ok {
+			if err == io.EOF && u.cr.n > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			err = &BatchError{count, err}
+		}
+	}()
+	defer recoverError(&err)
+	length := d.length
+	u.push(rootElem(t))
+	l := u.getLength(length, d.order, t)
+	for ; count < l; count++ {
+		k.SetZero()
+		v.SetZero()
+		u.push(pathElem{index: count, isKey: true})
+		u.unmarshal(k, d.order, length)
+		u.pop()
+		u.push(keyElem(k, false))
+		u.unmarshal(v, d.order, length)
+		u.pop()
+		if err := fn(); err != nil {
+			return &BatchError{count, err}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,452 @@
+package marshal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDecoderMore(t *testing.T) {
+	for _, count := range []int{0, 1, 3} {
+		stream := new(bytes.Buffer)
+		enc := NewEncoder(stream, binary.LittleEndian, BlobLength32)
+		for i := 0; i < count; i++ {
+			if e := enc.Encode(createTestObject()); e != nil {
+				t.Fatalf("Encode: %v", e)
+			}
+		}
+		dec := NewDecoder(stream, binary.LittleEndian, BlobLength32)
+		decoded := 0
+		for dec.More() {
+			var readBack Foo
+			if e := dec.Decode(&readBack); e != nil {
+				t.Fatalf("%d messages: Decode %d: %v", count, decoded, e)
+			}
+			if !reflect.DeepEqual(*createTestObject(), readBack) {
+				t.Errorf("%d messages: message %d: proto and readBack are NOT equal", count, decoded)
+			}
+			decoded++
+		}
+		if decoded != count {
+			t.Errorf("decoded %d messages, want %d", decoded, count)
+		}
+	}
+}
+
+func TestDecoderBuffered(t *testing.T) {
+	b := append(MustMarshalBytes(uint16(1), binary.BigEndian, BlobLength8), "rest"...)
+	dec := NewDecoder(bytes.NewReader(b), binary.BigEndian, BlobLength8)
+	var v uint16
+	if e := dec.Decode(&v); e != nil || v != 1 {
+		t.Fatalf("Decode: %d, %v", v, e)
+	}
+	rest := new(bytes.Buffer)
+	rest.ReadFrom(dec.Buffered())
+	if rest.String() != "rest" {
+		t.Errorf("Buffered: %q", rest.String())
+	}
+}
+
+type peekShape struct {
+	Kind uint8
+	Side uint16
+}
+
+type peekText struct {
+	Kind uint8
+	Text string
+}
+
+func TestDecoderPeek(t *testing.T) {
+	var stream bytes.Buffer
+	e := NewEncoder(&stream, binary.BigEndian, BlobLength8)
+	values := []interface{}{&peekShape{1, 7}, &peekText{2, "hi"}, &peekShape{1, 9}}
+	for _, v := range values {
+		if err := e.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	decoders := map[string]*Decoder{
+		"stream": NewDecoder(iotest.OneByteReader(bytes.NewReader(stream.Bytes())), binary.BigEndian, BlobLength8),
+		"bytes":  NewBytesDecoder(stream.Bytes(), binary.BigEndian, BlobLength8),
+	}
+	for name, d := range decoders {
+		for i, want := range values {
+			b, err := d.Peek(1)
+			if err != nil {
+				t.Fatalf("%s: Peek: %v", name, err)
+			}
+			//peeking again sees the same bytes
+			if again, _ := d.Peek(1); !bytes.Equal(again, b) {
+				t.Errorf("%s: Peek % x then % x", name, b, again)
+			}
+			var v interface{}
+			switch b[0] {
+			case 1:
+				v = &peekShape{}
+			case 2:
+				v = &peekText{}
+			default:
+				t.Fatalf("%s: value %d starts with %d", name, i, b[0])
+			}
+			if err := d.Decode(v); err != nil || !reflect.DeepEqual(v, want) {
+				t.Errorf("%s: decoded %+v, %v, want %+v", name, v, err, want)
+			}
+		}
+		if b, err := d.Peek(1); len(b) != 0 || err != io.EOF {
+			t.Errorf("%s: Peek at the end: % x, %v", name, b, err)
+		}
+		if _, err := d.Peek(-1); err == nil {
+			t.Errorf("%s: expected an error for a negative count", name)
+		}
+	}
+	d := NewDecoder(bytes.NewReader(stream.Bytes()), binary.BigEndian, BlobLength8)
+	if b, err := d.Peek(stream.Len() + 1); !bytes.Equal(b, stream.Bytes()) || err != io.EOF {
+		t.Errorf("Peek past the end: % x, %v", b, err)
+	}
+	if _, err := d.Peek(1 << 20); err != bufio.ErrBufferFull {
+		t.Errorf("expected bufio.ErrBufferFull, got %v", err)
+	}
+}
+
+func TestDecodeAll(t *testing.T) {
+	type record struct {
+		Seq  uint32
+		Text string
+	}
+	stream := new(bytes.Buffer)
+	enc := NewEncoder(stream, binary.BigEndian, BlobLength16)
+	var sent []record
+	for i := 0; i < 4; i++ {
+		r := record{uint32(i), strings.Repeat("x", i)}
+		sent = append(sent, r)
+		if e := enc.Encode(&r); e != nil {
+			t.Fatalf("Encode: %v", e)
+		}
+	}
+	b := stream.Bytes()
+
+	var got []record
+	if e := NewDecoder(bytes.NewReader(b), binary.BigEndian, BlobLength16).DecodeAll(&got, 0); e != nil {
+		t.Fatalf("DecodeAll: %v", e)
+	}
+	if !reflect.DeepEqual(sent, got) {
+		t.Errorf("decoded %v, want %v", got, sent)
+	}
+
+	//truncated inside the last record
+	got = got[:0]
+	e := NewDecoder(bytes.NewReader(b[:len(b)-1]), binary.BigEndian, BlobLength16).DecodeAll(&got, 0)
+	var be *BatchError
+	if !errors.As(e, &be) || be.Count != 3 || !errors.Is(e, io.ErrUnexpectedEOF) || len(got) != 3 {
+		t.Errorf("truncated: %v, %d records", e, len(got))
+	}
+
+	//cap on the number of records
+	got = nil
+	e = NewDecoder(bytes.NewReader(b), binary.BigEndian, BlobLength16).DecodeAll(&got, 2)
+	if !errors.Is(e, ErrTooManyElements) || len(got) != 2 {
+		t.Errorf("max: %v, %d records", e, len(got))
+	}
+
+	got = nil
+	if e := NewDecoder(bytes.NewReader(nil), binary.BigEndian, BlobLength16).DecodeAll(&got, 0); e != nil || len(got) != 0 {
+		t.Errorf("empty stream: %v, %d records", e, len(got))
+	}
+}
+
+func TestUnmarshalN(t *testing.T) {
+	type record struct {
+		Seq  uint32
+		Text string
+	}
+	stream := new(bytes.Buffer)
+	enc := NewEncoder(stream, binary.BigEndian, BlobLength16)
+	var sent []record
+	for i := 0; i < 4; i++ {
+		r := record{uint32(i), strings.Repeat("x", i)}
+		sent = append(sent, r)
+		if e := enc.Encode(&r); e != nil {
+			t.Fatalf("Encode: %v", e)
+		}
+	}
+	b := stream.Bytes()
+
+	//nothing past the third record is read, even from a reader that would give more
+	r := bytes.NewReader(b)
+	got := []record{{9, "kept"}}
+	if e := UnmarshalN(iotest.HalfReader(r), &got, 3, binary.BigEndian, BlobLength16); e != nil {
+		t.Fatalf("UnmarshalN: %v", e)
+	}
+	if !reflect.DeepEqual(got[1:], sent[:3]) || got[0].Text != "kept" {
+		t.Errorf("decoded %v, want %v after the kept record", got, sent[:3])
+	}
+	//the fourth record is left
+	if rest := r.Len(); rest != 4+2+3 {
+		t.Errorf("%d bytes left of %d", rest, len(b))
+	}
+
+	//the stream ends at a record boundary before the nth
+	got = nil
+	e := UnmarshalN(bytes.NewReader(b), &got, 5, binary.BigEndian, BlobLength16)
+	var be *BatchError
+	if !errors.As(e, &be) || be.Count != 4 || !errors.Is(e, io.ErrUnexpectedEOF) || len(got) != 4 {
+		t.Errorf("short: %v, %d records", e, len(got))
+	}
+
+	//truncated inside a record
+	got = nil
+	e = UnmarshalN(bytes.NewReader(b[:len(b)-1]), &got, 4, binary.BigEndian, BlobLength16)
+	if !errors.As(e, &be) || be.Count != 3 || !errors.Is(e, io.ErrUnexpectedEOF) || len(got) != 3 {
+		t.Errorf("truncated: %v, %d records", e, len(got))
+	}
+
+	got = nil
+	if e := UnmarshalN(iotest.ErrReader(io.ErrClosedPipe), &got, 0, binary.BigEndian, BlobLength16); e != nil || len(got) != 0 {
+		t.Errorf("no records: %v, %d records", e, len(got))
+	}
+	if e := UnmarshalN(bytes.NewReader(b), got, 1, binary.BigEndian, BlobLength16); e == nil {
+		t.Errorf("expected an error for a slice that isn't a pointer")
+	}
+}
+
+func TestEncoderReset(t *testing.T) {
+	var index []int64
+	first, second := new(bytes.Buffer), new(bytes.Buffer)
+	enc := NewEncoder(first, binary.BigEndian, BlobLength8, WithIndex(&index))
+	for _, s := range []string{"ab", "cde"} {
+		if err := enc.Encode(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	enc.Reset(second)
+	if err := enc.Encode("f"); err != nil {
+		t.Fatal(err)
+	}
+	if first.String() != "\x02ab\x03cde" || second.String() != "\x01f" {
+		t.Errorf("writers hold %q and %q", first.String(), second.String())
+	}
+	if expected := []int64{0, 3, 0}; !reflect.DeepEqual(index, expected) {
+		t.Errorf("index %v, want %v", index, expected)
+	}
+}
+
+func TestEncoderFlush(t *testing.T) {
+	var out bytes.Buffer
+	bw := bufio.NewWriter(&out)
+	enc := NewEncoder(bw, binary.BigEndian, BlobLength8)
+	if err := enc.Encode(uint16(1)); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 {
+		t.Fatalf("bufio.Writer flushed early")
+	}
+	if err := enc.Flush(); err != nil || !bytes.Equal(out.Bytes(), []byte{0, 1}) {
+		t.Errorf("flushed % x, %v", out.Bytes(), err)
+	}
+	if err := NewEncoder(&out, binary.BigEndian, BlobLength8).Flush(); err != nil {
+		t.Errorf("Flush on an unbuffered writer: %v", err)
+	}
+}
+
+func TestDecoderReset(t *testing.T) {
+	//the first stream holds more than one value, the surplus must not leak
+	dec := NewDecoder(bytes.NewReader([]byte{0, 1, 0, 2}), binary.BigEndian, BlobLength8)
+	var v uint16
+	if err := dec.Decode(&v); err != nil || v != 1 {
+		t.Fatalf("Decode: %d, %v", v, err)
+	}
+	dec.Reset(bytes.NewReader([]byte{0, 3}))
+	if err := dec.Decode(&v); err != nil || v != 3 {
+		t.Errorf("after Reset: %d, %v", v, err)
+	}
+	if dec.More() {
+		t.Errorf("More after the second stream ended")
+	}
+	//a caller's bufio.Reader is used as is, never reset
+	br := bufio.NewReader(bytes.NewReader([]byte{0, 4, 0, 5}))
+	dec.Reset(br)
+	if err := dec.Decode(&v); err != nil || v != 4 {
+		t.Fatalf("Decode: %d, %v", v, err)
+	}
+	dec.Reset(bytes.NewReader([]byte{0, 6}))
+	if br.Buffered() != 2 {
+		t.Errorf("caller's reader was reset")
+	}
+	if err := dec.Decode(&v); err != nil || v != 6 {
+		t.Errorf("after Reset: %d, %v", v, err)
+	}
+}
+
+func TestDecodeMap(t *testing.T) {
+	m := map[string]bar{}
+	for i := 0; i < 50; i++ {
+		m[strings.Repeat("k", i)] = bar{Id: strings.Repeat("v", 50-i), Prop: map[string]uint32{"n": uint32(i)}}
+	}
+	b, err := MarshalBytes(m, binary.BigEndian, BlobLength16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b = append(b, 0xAA)
+	var key string
+	var val bar
+	got := map[string]bar{}
+	d := NewDecoder(bytes.NewReader(b), binary.BigEndian, BlobLength16)
+	err = d.DecodeMap(&key, &val, func() error {
+		got[key] = val
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("decoded entries differ")
+	}
+	//the rest of the stream is untouched
+	if rest, _ := io.ReadAll(d.Buffered()); !bytes.Equal(rest, []byte{0xAA}) {
+		t.Errorf("left % x, want aa", rest)
+	}
+	stop := errors.New("stop")
+	d = NewBytesDecoder(b, binary.BigEndian, BlobLength16)
+	seen := 0
+	err = d.DecodeMap(&key, &val, func() error {
+		if seen++; seen == 3 {
+			return stop
+		}
+		return nil
+	})
+	var be *BatchError
+	if !errors.As(err, &be) || be.Count != 2 || !errors.Is(err, stop) {
+		t.Errorf("expected the callback's error after 2 entries, got %v", err)
+	}
+	d = NewBytesDecoder(b[:20], binary.BigEndian, BlobLength16)
+	if err = d.DecodeMap(&key, &val, func() error { return nil }); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	if err = d.DecodeMap(key, &val, func() error { return nil }); err == nil {
+		t.Errorf("expected an error for a non-pointer key")
+	}
+}
+
+func TestEncodeAll(t *testing.T) {
+	foos, stream := fooStream(t, 5, binary.LittleEndian, BlobLength16)
+	var index []int64
+	w := new(bytes.Buffer)
+	e := NewEncoder(w, binary.LittleEndian, BlobLength16, WithIndex(&index))
+	if err := e.EncodeAll(foos); err != nil {
+		t.Fatal(err)
+	}
+	//map entries may come in any order, compare the sizes
+	if w.Len() != len(stream) {
+		t.Errorf("EncodeAll wrote %d bytes, want %d", w.Len(), len(stream))
+	}
+	if len(index) != len(foos) || index[0] != 0 {
+		t.Errorf("unexpected index %v", index)
+	}
+	for i := 1; i < len(index); i++ {
+		if size, _ := Size(&foos[i-1], binary.LittleEndian, BlobLength16); index[i]-index[i-1] != int64(size) {
+			t.Errorf("value %d at %d, want %d after the previous one", i, index[i], size)
+		}
+	}
+	var back []Foo
+	if err := NewDecoder(bytes.NewReader(w.Bytes()), binary.LittleEndian, BlobLength16).DecodeAll(&back, 0); err != nil {
+		t.Fatal(err)
+	}
+	if d := firstDiff(reflect.ValueOf(back), reflect.ValueOf(foos)); d != nil {
+		t.Errorf("round trip differs: %v", d)
+	}
+	names := []string{"a", "bb", strings.Repeat("c", 300), "d"}
+	err := NewEncoder(io.Discard, binary.LittleEndian, Bound32(10)).EncodeAll(names)
+	var be *BatchError
+	if !errors.As(err, &be) || be.Count != 2 {
+		t.Errorf("expected a failure after 2 elements, got %v", err)
+	}
+	if err := NewEncoder(io.Discard, binary.LittleEndian, BlobLength8).EncodeAll(names[0]); err == nil {
+		t.Errorf("expected an error for a non-slice")
+	}
+}
+
+//seqEqual checks that EncodeSeq of the values of s writes what Encode of s does
+func seqEqual[T any](t *testing.T, s []T, order binary.ByteOrder, length LengthType, opts ...Option) {
+	t.Helper()
+	want, err := MarshalBytes(s, order, length, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := new(bytes.Buffer)
+	if err := EncodeSeq(NewEncoder(w, order, length, opts...), len(s), slices.Values(s)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(w.Bytes(), want) {
+		t.Errorf("%T: EncodeSeq wrote % x, want % x", s, w.Bytes(), want)
+	}
+}
+
+func TestEncodeSeq(t *testing.T) {
+	type record struct {
+		Seq  uint32
+		Text string
+	}
+	records := []record{{1, "a"}, {2, ""}, {3, "ccc"}}
+	seqEqual(t, records, binary.BigEndian, BlobLength16)
+	seqEqual(t, records, binary.LittleEndian, VarintLength(3), Fingerprint())
+	seqEqual(t, []uint32{1, 2, 0xdeadbeef}, binary.LittleEndian, BlobLength8)
+	seqEqual(t, [][]uint16{{1}, nil, {2, 3}}, binary.BigEndian, BlobLength8)
+	seqEqual(t, []record(nil), binary.BigEndian, BlobLength16)
+	//a byte slice takes the trailer of a bulk string
+	seqEqual(t, []byte("hello"), binary.BigEndian, RESPLength('$', 10, true))
+
+	var index []int64
+	w := new(bytes.Buffer)
+	e := NewEncoder(w, binary.BigEndian, BlobLength16, WithIndex(&index))
+	if err := e.Encode(uint8(7)); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncodeSeq(e, 3, slices.Values(records)); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(index, []int64{0, 1}) {
+		t.Errorf("index %v, want [0 1]", index)
+	}
+	var back []record
+	if err := UnmarshalBytes(&back, w.Bytes()[1:], binary.BigEndian, BlobLength16); err != nil || !reflect.DeepEqual(back, records) {
+		t.Errorf("decoded %v, %v, want %v", back, err, records)
+	}
+
+	var be *BatchError
+	err := EncodeSeq(NewEncoder(io.Discard, binary.BigEndian, BlobLength16), 4, slices.Values(records))
+	if !errors.As(err, &be) || be.Count != 3 || !errors.Is(err, ErrSeqLength) {
+		t.Errorf("short: got %v", err)
+	}
+	w.Reset()
+	err = EncodeSeq(NewEncoder(w, binary.BigEndian, BlobLength16), 2, slices.Values(records))
+	if !errors.As(err, &be) || be.Count != 2 || !errors.Is(err, ErrSeqLength) {
+		t.Errorf("long: got %v", err)
+	}
+	err = EncodeSeq(NewEncoder(io.Discard, binary.BigEndian, Bound32(2)), 2, slices.Values([]string{"a", "bbb"}))
+	if !errors.As(err, &be) || be.Count != 1 || !errors.Is(err, ErrBoundExceeded) {
+		t.Errorf("bound: got %v", err)
+	}
+}
+
+func BenchmarkDecodeAll(b *testing.B) {
+	pods := make([]Pod, 100)
+	for i := range pods {
+		pods[i] = *createPodObject()
+	}
+	w := new(bytes.Buffer)
+	if err := NewEncoder(w, binary.LittleEndian, BlobLength32).EncodeAll(pods); err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < b.N; i++ {
+		var back []Pod
+		NewBytesDecoder(w.Bytes(), binary.LittleEndian, BlobLength32).DecodeAll(&back, 0)
+	}
+}
@@ -0,0 +1,127 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"io"
+	"reflect"
+	"unicode/utf8"
+)
+
+//trimMode selects the padding of fixed-width strings
+type trimMode int
+
+const (
+	//trimNul pads with NUL and strips trailing NULs, the default
+	trimNul trimMode = iota
+	//trimSpace pads with spaces and strips trailing spaces
+	trimSpace
+	//trimBoth pads with NUL and strips any mix of trailing NULs and spaces
+	trimBoth
+	//trimNone pads with NUL and keeps every byte
+	trimNone
+)
+
+var trimModes = map[string]trimMode{"nul": trimNul, "space": trimSpace, "both": trimBoth, "none": trimNone}
+
+//pad and trim take the wire byte of a space, see spaceByte
+func (t trimMode) pad(space byte) byte {
+	if t == trimSpace {
+		return space
+	}
+	return 0
+}
+
+func (t trimMode) trim(b []byte, space byte) []byte {
+	n := len(b)
+	for n > 0 {
+		c := b[n-1]
+		if !(c == 0 && (t == trimNul || t == trimBoth)) && !(c == space && (t == trimSpace || t == trimBoth)) {
+			break
+		}
+		n--
+	}
+	return b[:n]
+}
+
+//stringTagged reports whether ft changes how a string field is written
+func (ft *fieldTag) stringTagged() bool {
+	return ft.charset != nil || ft.max > 0 || ft.fixed > 0
+}
+
+//taggedString writes a string field with its charset and length limit applied
+func (m *marshaler) taggedString(v reflect.Value, ft *fieldTag, length LengthTypeInstance) {
+	s := v.String()
+	if ft.max > 0 && len(s) > ft.max {
+		if !ft.truncate {
+			panic(errorf(ErrBoundExceeded, "marshal: string of %d bytes exceeds max=%d", len(s), ft.max))
+		}
+		m.warning(WarnTruncated, m.cw.n, "string of %d bytes clipped to max=%d", len(s), ft.max)
+		s = clipString(s, ft.max)
+	}
+	b := stringBytes(s)
+	if ft.charset != nil {
+		var err error
+		if b, err = ft.charset.Encode(s); err != nil {
+			panic(err)
+		}
+	}
+	if ft.fixed > 0 {
+		if len(b) > ft.fixed {
+			if !ft.truncate {
+				panic(errorf(ErrBoundExceeded, "marshal: string of %d bytes exceeds fixed=%d", len(b), ft.fixed))
+			}
+			m.warning(WarnTruncated, m.cw.n, "string of %d bytes clipped to fixed=%d", len(b), ft.fixed)
+			if ft.charset != nil {
+				b = b[:ft.fixed]
+			} else {
+				b = []byte(clipString(s, ft.fixed))
+			}
+		}
+		for len(b) < ft.fixed {
+			b = append(b, ft.trim.pad(ft.space))
+		}
+	} else {
+		m.payload(length, v.Type(), b)
+		return
+	}
+	if _, err := m.w.Write(b); err != nil {
+		panic(err)
+	}
+}
+
+//clipString cuts s to at most n bytes without splitting a UTF-8 sequence
+func clipString(s string, n int) string {
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+func (u *unmarshaler) taggedString(v reflect.Value, ft *fieldTag, order binary.ByteOrder, length LengthTypeInstance) {
+	l := ft.fixed
+	if l == 0 {
+		l = u.getLength(length, order, v.Type())
+	}
+	var b []byte
+	if ft.charset == nil {
+		b = u.scratch(l)
+	} else {
+		//the charset decodes b into a string of its own
+		b = u.staged(l)
+	}
+	if _, e := io.ReadFull(u.r, b); e != nil {
+		panic(e)
+	}
+	if ft.fixed > 0 {
+		b = ft.trim.trim(b, ft.space)
+	}
+	if ft.charset == nil {
+		v.SetString(u.adopt(b))
+		return
+	}
+	s, err := ft.charset.Decode(b)
+	if err != nil {
+		panic(err)
+	}
+	v.SetString(s)
+}
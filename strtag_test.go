@@ -0,0 +1,108 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestMaxTruncate(t *testing.T) {
+	type profile struct {
+		Display string `marshal:"max=6,truncate"`
+		Login   string `marshal:"max=4"`
+	}
+	//"é" is 2 bytes and straddles the 6 byte limit
+	b, err := MarshalBytes(&profile{"héllé!", "bob"}, binary.BigEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := append([]byte{5}, "héll"...)
+	expected = append(expected, 3, 'b', 'o', 'b')
+	if !bytes.Equal(b, expected) {
+		t.Errorf("encoded % x, want % x", b, expected)
+	}
+	if _, err := MarshalBytes(&profile{"x", "alice"}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for a string over max without truncate")
+	}
+
+	//decoding doesn't apply the limit
+	var v profile
+	if err := UnmarshalBytes(&v, append([]byte{7}, "abcdefg\x00"...), binary.BigEndian, BlobLength8); err != nil || v.Display != "abcdefg" {
+		t.Errorf("decode: %+v, %v", v, err)
+	}
+}
+
+func TestClipString(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"abcdef", 3, "abc"},
+		{"a€b", 2, "a"},
+		{"a€b", 3, "a"},
+		{"a€b", 4, "a€"},
+		{"€", 1, ""},
+	}
+	for _, tt := range tests {
+		if got := clipString(tt.s, tt.n); got != tt.want {
+			t.Errorf("clipString(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestFixedTrim(t *testing.T) {
+	type record struct {
+		Nul   string `marshal:"fixed=6"`
+		Space string `marshal:"fixed=6,trim=space"`
+		Both  string `marshal:"fixed=6,trim=both"`
+		None  string `marshal:"fixed=4,trim=none"`
+	}
+	v := record{"a\x00b", "a b", "ab", "xy"}
+	b, err := MarshalBytes(&v, binary.BigEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte("a\x00b\x00\x00\x00a b   ab\x00\x00\x00\x00xy\x00\x00")
+	if !bytes.Equal(b, expected) {
+		t.Errorf("encoded %q, want %q", b, expected)
+	}
+	var readBack record
+	if err := UnmarshalBytes(&readBack, expected, binary.BigEndian, BlobLength8); err != nil {
+		t.Fatal(err)
+	}
+	if want := (record{"a\x00b", "a b", "ab", "xy\x00\x00"}); readBack != want {
+		t.Errorf("decoded %q, want %q", readBack, want)
+	}
+
+	//padding written by other encoders
+	wire := []byte("ab \x00\x00\x00cd\x00   ef \x00 \x00wxyz")
+	if err := UnmarshalBytes(&readBack, wire, binary.BigEndian, BlobLength8); err != nil {
+		t.Fatal(err)
+	}
+	if want := (record{"ab ", "cd\x00", "ef", "wxyz"}); readBack != want {
+		t.Errorf("decoded %q, want %q", readBack, want)
+	}
+}
+
+func TestFixedOverflow(t *testing.T) {
+	type strict struct {
+		S string `marshal:"fixed=3"`
+	}
+	type clipped struct {
+		S string `marshal:"fixed=3,truncate"`
+	}
+	if _, err := MarshalBytes(&strict{"abcd"}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for a string over fixed")
+	}
+	b, err := MarshalBytes(&clipped{"a€"}, binary.BigEndian, BlobLength8)
+	if err != nil || !bytes.Equal(b, []byte{'a', 0, 0}) {
+		t.Errorf("truncate: %q, %v", b, err)
+	}
+	type badTrim struct {
+		S string `marshal:"trim=space"`
+	}
+	if _, err := MarshalBytes(&badTrim{}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for trim without fixed")
+	}
+}
@@ -0,0 +1,114 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"reflect"
+	"unsafe"
+)
+
+//swapChunk is the number of bytes swapped at a time, small enough to stay in cache
+//between swapping and writing them
+const swapChunk = 4096
+
+//isSwapped reports whether order stores numbers with their bytes the other way
+//round from the host
+func isSwapped(order binary.ByteOrder) bool {
+	switch order {
+	case binary.LittleEndian:
+		return !hostLittle
+	case binary.BigEndian:
+		return hostLittle
+	}
+	return false
+}
+
+//swapView is nativeView for the order opposite to the host's, it also returns the
+//size of the words whose bytes are reversed
+func swapView(v reflect.Value, order binary.ByteOrder) ([]byte, int) {
+	if !isSwapped(order) {
+		return nil, 0
+	}
+	return numberMemory(v)
+}
+
+//swapBytes copies src to dst, which may be the same memory, reversing the bytes
+//of each word of size bytes. Memory inside fixed-size values needn't be aligned,
+//its words are then loaded and stored through the byte slices
+func swapBytes(dst, src []byte, size int) {
+	if len(src) == 0 {
+		return
+	}
+	if aligned(dst, size) && aligned(src, size) {
+		switch size {
+		case 2:
+			swapWords(words[uint16](dst), words[uint16](src), bits.ReverseBytes16)
+		case 4:
+			swapWords(words[uint32](dst), words[uint32](src), bits.ReverseBytes32)
+		case 8:
+			swapWords(words[uint64](dst), words[uint64](src), bits.ReverseBytes64)
+		}
+		return
+	}
+	ne := binary.NativeEndian
+	switch size {
+	case 2:
+		for i := 0; i+2 <= len(src); i += 2 {
+			ne.PutUint16(dst[i:], bits.ReverseBytes16(ne.Uint16(src[i:])))
+		}
+	case 4:
+		for i := 0; i+4 <= len(src); i += 4 {
+			ne.PutUint32(dst[i:], bits.ReverseBytes32(ne.Uint32(src[i:])))
+		}
+	case 8:
+		for i := 0; i+8 <= len(src); i += 8 {
+			ne.PutUint64(dst[i:], bits.ReverseBytes64(ne.Uint64(src[i:])))
+		}
+	}
+}
+
+//aligned reports whether b starts at a multiple of size
+func aligned(b []byte, size int) bool {
+	return uintptr(unsafe.Pointer(unsafe.SliceData(b)))%uintptr(size) == 0
+}
+
+//words views the aligned memory b as words of type T
+func words[T uint16 | uint32 | uint64](b []byte) []T {
+	var w T
+	return unsafe.Slice((*T)(unsafe.Pointer(unsafe.SliceData(b))), len(b)/int(unsafe.Sizeof(w)))
+}
+
+//swapWords sets the words of dst to those of src reversed
+func swapWords[T uint16 | uint32 | uint64](dst, src []T, reverse func(T) T) {
+	dst = dst[:len(src)]
+	for i, x := range src {
+		dst[i] = reverse(x)
+	}
+}
+
+//swapped writes the numbers in the memory bs with the bytes of their words of
+//size bytes reversed, a chunk at a time
+func (m *marshaler) swapped(bs []byte, size int) {
+	bp := getScratch(min(len(bs), swapChunk))
+	defer scratchPool.Put(bp)
+	for len(bs) > 0 {
+		n := min(len(bs), swapChunk)
+		chunk := (*bp)[:n]
+		swapBytes(chunk, bs[:n], size)
+		if _, err := m.w.Write(chunk); err != nil {
+			panic(err)
+		}
+		bs = bs[n:]
+	}
+}
+
+//swapped reads into the memory bs numbers whose words of size bytes have their
+//bytes reversed, swapping each chunk as soon as it is read
+func (u *unmarshaler) swapped(bs []byte, size int) {
+	for len(bs) > 0 {
+		n := min(len(bs), swapChunk)
+		u.readInto(bs[:n])
+		swapBytes(bs[:n], bs[:n], size)
+		bs = bs[n:]
+	}
+}
@@ -0,0 +1,92 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"testing"
+)
+
+type swapSamples struct {
+	Native nativeSamples
+	//the array starts at offset 1 of the fixed-size struct
+	Odd struct {
+		A uint8
+		B [3]uint32
+		C [2]int16
+	}
+	Long []uint64
+}
+
+func TestSwappedNumbers(t *testing.T) {
+	swapped := binary.ByteOrder(binary.LittleEndian)
+	if hostLittle {
+		swapped = binary.BigEndian
+	}
+	if !isSwapped(swapped) || isSwapped(NativeEndian) || isSwapped(PDPEndian) {
+		t.Errorf("isSwapped is wrong for %v", swapped)
+	}
+	v := swapSamples{Native: nativeSamples{ID: 7, Samples: []float32{1.5, -2}, Wide: []int64{-1, 1 << 40},
+		Pair: [2]complex128{complex(1, -2), 3}, Counts: [3]uint32{1, 2, 0xffffffff}, Levels: []level{1, 2}}}
+	v.Odd.A, v.Odd.B, v.Odd.C = 9, [3]uint32{0x01020304, 5, 6}, [2]int16{-2, 0x0102}
+	//more than a chunk
+	v.Long = make([]uint64, swapChunk/8*2+3)
+	for i := range v.Long {
+		v.Long[i] = uint64(i) * 0x0102030405060708
+	}
+	b, err := MarshalBytes(&v, swapped, BlobLength32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the same bytes as encoding one number at a time
+	want, err := MarshalBytes(&v, swapped, BlobLength32, WithTrace(func(TraceEvent) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, want) {
+		t.Errorf("encoded % x, want % x", b, want)
+	}
+	var readBack swapSamples
+	if err := Unmarshal(&readBack, bytes.NewReader(b), swapped, BlobLength32); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(readBack, v) {
+		t.Errorf("read back %+v, want %+v", readBack.Odd, v.Odd)
+	}
+	readBack = swapSamples{}
+	if err := UnmarshalBytes(&readBack, b, swapped, BlobLength32); err != nil || !reflect.DeepEqual(readBack, v) {
+		t.Errorf("read back from memory %+v, %v", readBack.Odd, err)
+	}
+}
+
+func BenchmarkUint32Slice(b *testing.B) {
+	v := make([]uint32, 1<<20)
+	for i := range v {
+		v[i] = uint32(i)
+	}
+	orders := []struct {
+		name  string
+		order binary.ByteOrder
+	}{{"little", binary.LittleEndian}, {"big", binary.BigEndian}}
+	for _, o := range orders {
+		buf, err := MarshalBytes(v, o.order, BlobLength32)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run("encode/"+o.name, func(b *testing.B) {
+			b.SetBytes(int64(len(buf)))
+			for i := 0; i < b.N; i++ {
+				Marshal(v, io.Discard, o.order, BlobLength32)
+			}
+		})
+		b.Run("decode/"+o.name, func(b *testing.B) {
+			b.SetBytes(int64(len(buf)))
+			readBack := make([]uint32, 0, len(v))
+			for i := 0; i < b.N; i++ {
+				readBack = readBack[:0]
+				Unmarshal(&readBack, bytes.NewReader(buf), o.order, BlobLength32)
+			}
+		})
+	}
+}
@@ -0,0 +1,585 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+//fieldTag is the parsed form of a `marshal:"..."` struct tag, the tag is a comma
+//separated list of flags and key=value settings
+type fieldTag struct {
+	//columnar lays a slice or array of structs out column by column
+	columnar bool
+	//charset converts a string field to and from a wire character set
+	charset Charset
+	//enum writes a string field as its code in a registered mapping
+	enum *enumMap
+	//fallback passes values missing from the enum through as decimal codes
+	fallback bool
+	//max limits the UTF-8 length of a string, 0 is no limit
+	max int
+	//truncate clips strings longer than max or fixed instead of failing
+	truncate bool
+	//fixed writes a string in exactly that many bytes without a length prefix
+	fixed int
+	//trim is the padding stripped from fixed strings on decode, see trimNul
+	trim trimMode
+	//space is the wire byte of a space in the charset, see spaceByte
+	space byte
+	//bcd writes an integer or digit string as that many packed BCD digits
+	bcd int
+	//bcdVar writes packed BCD digits behind a length prefix of their count
+	bcdVar bool
+	//alignLeft puts the pad nibble of an odd bcd digit count last instead of first
+	alignLeft bool
+	//padF fills the pad nibble of an odd bcd digit count with 0xF instead of 0
+	padF bool
+	//ascii writes an integer as that many decimal characters
+	ascii int
+	//padSpace pads ascii numbers with spaces instead of zeros
+	padSpace bool
+	//count names an earlier field holding the element count of a slice
+	count string
+	//countIndex is the index of the count field, set when the plan is built
+	countIndex int
+	//unit is how much the count field grows per element, 0 means 1
+	unit int
+	//offset and size name the fields locating a payload stored after the header
+	offset, size string
+	//sizeof names a later field whose encoded size this integer holds
+	sizeof string
+	//reserved is the number of zero bytes a struct{} placeholder stands for
+	reserved int
+	//magic are the bytes a struct{} placeholder stands for, checked on decode
+	magic []byte
+	//bits packs an integer or bool into that many bits of a group of adjacent
+	//bits fields, msb and lsb select the bit order of the group
+	bits     int
+	msb, lsb bool
+	//delimited writes a struct behind a length prefix holding its encoded size
+	delimited bool
+	//trimZero writes a struct as the count of its leading fields up to the last
+	//non-zero one, then those fields
+	trimZero bool
+	//parallel writes a map as a block of sorted keys followed by a block of values
+	parallel bool
+	//codec is a codec registered with RegisterNamedCodec that encodes the field
+	codec *customCodec
+	//rest writes the SentinelLength sentinel, the value runs to the end of its region
+	rest bool
+	//nullable writes a nil slice as the NullableLength sentinel
+	nullable bool
+	//bitmap writes the nil elements of a slice or array of pointers as clear bits
+	bitmap bool
+	//delta writes an integer slice or array as varint differences between elements
+	delta bool
+	//rle writes a slice or array of fixed-size elements as runs of equal elements
+	rle bool
+	//packbits writes every element of an integer slice or array in that many bits
+	packbits int
+	//quantize writes a float slice or array as integers of that kind
+	quantize reflect.Kind
+	//scale and bias name the fields holding the quantize mapping, their indexes
+	//are set when the plan is built
+	scale, bias           string
+	scaleIndex, biasIndex int
+	//saturate clamps quantized values outside the integer range instead of failing
+	saturate bool
+	//skip leaves the field out of the encoding
+	skip bool
+	//length replaces the length type of the call for the field and what it holds,
+	//elemLength that of the elements, keys and values inside it, see ElemLength
+	length, elemLength LengthType
+}
+
+//tagLengths are the length types len= and elemlen= name
+var tagLengths = map[string]LengthType{
+	"8":       BlobLength8,
+	"16":      BlobLength16,
+	"32":      BlobLength32,
+	"64":      BlobLength64,
+	"compact": CompactLength,
+}
+
+func parseTag(tag string) (*fieldTag, error) {
+	ft := &fieldTag{}
+	for _, item := range strings.Split(tag, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, val, _ := strings.Cut(item, "=")
+		switch key {
+		case "columnar":
+			ft.columnar = true
+		case "charset":
+			cs, err := lookupCharset(val)
+			if err != nil {
+				return nil, err
+			}
+			ft.charset = cs
+		case "enum":
+			e, err := lookupEnum(val)
+			if err != nil {
+				return nil, err
+			}
+			ft.enum = e
+		case "fallback":
+			ft.fallback = true
+		case "max":
+			n, err := strconv.Atoi(val)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("bad max %q", val)
+			}
+			ft.max = n
+		case "truncate":
+			ft.truncate = true
+		case "fixed":
+			n, err := strconv.Atoi(val)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("bad fixed %q", val)
+			}
+			ft.fixed = n
+		case "trim":
+			t, ok := trimModes[val]
+			if !ok {
+				return nil, fmt.Errorf("bad trim %q, want nul, space, both or none", val)
+			}
+			ft.trim = t
+		case "bcd":
+			if val == "" {
+				ft.bcdVar = true
+				continue
+			}
+			n, err := strconv.Atoi(val)
+			if err != nil || n <= 0 || n > 20 {
+				return nil, fmt.Errorf("bad bcd %q, want 1 to 20 digits", val)
+			}
+			ft.bcd = n
+		case "align":
+			switch val {
+			case "left":
+				ft.alignLeft = true
+			case "right":
+				ft.alignLeft = false
+			default:
+				return nil, fmt.Errorf("bad align %q, want left or right", val)
+			}
+		case "ascii":
+			n, err := strconv.Atoi(val)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("bad ascii %q", val)
+			}
+			ft.ascii = n
+		case "pad":
+			switch val {
+			case "space":
+				ft.padSpace = true
+			case "zero":
+				ft.padSpace = false
+			case "f":
+				ft.padF = true
+			default:
+				return nil, fmt.Errorf("bad pad %q, want zero, space or f", val)
+			}
+		case "count":
+			if val == "" {
+				return nil, fmt.Errorf("count needs a field name")
+			}
+			ft.count = val
+		case "reserved":
+			n, err := strconv.Atoi(val)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("bad reserved %q", val)
+			}
+			ft.reserved = n
+		case "magic":
+			b, err := hex.DecodeString(val)
+			if err != nil || len(b) == 0 {
+				return nil, fmt.Errorf("bad magic %q, want hex bytes", val)
+			}
+			ft.magic = b
+		case "bits":
+			n, err := strconv.Atoi(val)
+			if err != nil || n <= 0 || n > 64 {
+				return nil, fmt.Errorf("bad bits %q, want 1 to 64", val)
+			}
+			ft.bits = n
+		case "codec":
+			c, err := lookupNamedCodec(val)
+			if err != nil {
+				return nil, err
+			}
+			ft.codec = c
+		case "bitmap":
+			ft.bitmap = true
+		case "delta":
+			ft.delta = true
+		case "rle":
+			ft.rle = true
+		case "quantize":
+			k, ok := quantizeKinds[val]
+			if !ok {
+				return nil, fmt.Errorf("bad quantize %q, want int8, int16, int32, uint8, uint16 or uint32", val)
+			}
+			ft.quantize = k
+		case "scale":
+			ft.scale = val
+		case "bias":
+			ft.bias = val
+		case "saturate":
+			ft.saturate = true
+		case "packbits":
+			n, err := strconv.Atoi(val)
+			if err != nil || n <= 0 || n > 64 {
+				return nil, fmt.Errorf("bad packbits %q, want 1 to 64", val)
+			}
+			ft.packbits = n
+		case "nullable":
+			ft.nullable = true
+		case "rest":
+			ft.rest = true
+		case "parallel":
+			ft.parallel = true
+		case "delimited":
+			ft.delimited = true
+		case "trimzero":
+			ft.trimZero = true
+		case "skip":
+			ft.skip = true
+		case "len", "elemlen":
+			l, ok := tagLengths[val]
+			if !ok {
+				return nil, fmt.Errorf("bad %s %q, want 8, 16, 32, 64 or compact", key, val)
+			}
+			if key == "len" {
+				ft.length = l
+			} else {
+				ft.elemLength = l
+			}
+		case "msb":
+			ft.msb = true
+		case "lsb":
+			ft.lsb = true
+		case "sizeof":
+			if val == "" {
+				return nil, fmt.Errorf("sizeof needs a field name")
+			}
+			ft.sizeof = val
+		case "offset":
+			ft.offset = val
+		case "size":
+			ft.size = val
+		case "unit":
+			n, err := strconv.Atoi(val)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("bad unit %q", val)
+			}
+			ft.unit = n
+		default:
+			return nil, fmt.Errorf("unknown marshal tag option %q", key)
+		}
+	}
+	ft.space = spaceByte(ft.charset)
+	return ft, nil
+}
+
+//integers reports whether ft writes the integers of its field in a layout of its
+//own, which suits int, uint and uintptr too
+func (ft *fieldTag) integers() bool {
+	return ft.bits > 0 || ft.bcd > 0 || ft.bcdVar || ft.ascii > 0 || ft.delta || ft.packbits > 0
+}
+
+//check validates tag options against the type of the field carrying them
+func (ft *fieldTag) check(f reflect.StructField, p *typePlan) error {
+	if ft.columnar {
+		k := f.Type.Kind()
+		if (k != reflect.Slice && k != reflect.Array) || f.Type.Elem().Kind() != reflect.Struct {
+			return fmt.Errorf("columnar field %s must be a slice or array of structs", f.Name)
+		}
+		if p.elem.size < 0 {
+			return fmt.Errorf("columnar field %s: element %s is not fixed-size", f.Name, f.Type.Elem())
+		}
+	}
+	if ft.stringTagged() && f.Type.Kind() != reflect.String {
+		return fmt.Errorf("charset, max and fixed field %s must be a string", f.Name)
+	}
+	if ft.truncate && ft.max == 0 && ft.fixed == 0 {
+		return fmt.Errorf("truncate on field %s needs max or fixed", f.Name)
+	}
+	if ft.trim != trimNul && ft.fixed == 0 {
+		return fmt.Errorf("trim on field %s needs fixed", f.Name)
+	}
+	if (ft.bcd > 0 || ft.bcdVar) && !isInteger(f.Type.Kind()) && f.Type.Kind() != reflect.String {
+		return fmt.Errorf("bcd field %s must be an integer or a digit string", f.Name)
+	}
+	if (ft.bcd > 0 || ft.bcdVar) && ft.stringTagged() {
+		return fmt.Errorf("bcd field %s can't have charset, max or fixed", f.Name)
+	}
+	if ft.alignLeft && ft.bcd == 0 && !ft.bcdVar {
+		return fmt.Errorf("align on field %s needs bcd", f.Name)
+	}
+	if ft.padF && ft.bcd == 0 && !ft.bcdVar {
+		return fmt.Errorf("pad=f on field %s needs bcd", f.Name)
+	}
+	if ft.ascii > 0 && !isInteger(f.Type.Kind()) {
+		return fmt.Errorf("ascii field %s must be an integer", f.Name)
+	}
+	if ft.padSpace && ft.ascii == 0 {
+		return fmt.Errorf("pad on field %s needs ascii", f.Name)
+	}
+	if ft.count != "" && f.Type.Kind() != reflect.Slice {
+		return fmt.Errorf("count field %s must be a slice", f.Name)
+	}
+	if ft.unit > 0 && ft.count == "" {
+		return fmt.Errorf("unit on field %s needs count", f.Name)
+	}
+	if ft.reserved > 0 && (f.Type.Kind() != reflect.Struct || f.Type.Size() != 0) {
+		return fmt.Errorf("reserved field %s must be a struct{} placeholder", f.Name)
+	}
+	if ft.magic != nil && (f.Type.Kind() != reflect.Struct || f.Type.Size() != 0 || ft.reserved > 0) {
+		return fmt.Errorf("magic field %s must be a struct{} placeholder without reserved", f.Name)
+	}
+	if k := f.Type.Kind(); ft.elemLength != nil && k != reflect.Map && k != reflect.Slice && k != reflect.Array {
+		return fmt.Errorf("elemlen field %s must be a map, slice or array", f.Name)
+	}
+	if (ft.offset == "") != (ft.size == "") {
+		return fmt.Errorf("field %s needs both offset and size", f.Name)
+	}
+	if ft.bits > 0 {
+		switch k := f.Type.Kind(); {
+		case k == reflect.Bool:
+		case !isInteger(k):
+			return fmt.Errorf("bits field %s must be an integer or bool", f.Name)
+		case ft.bits > f.Type.Bits():
+			return fmt.Errorf("bits field %s: %d bits don't fit in %s", f.Name, ft.bits, f.Type)
+		}
+	}
+	if k := f.Type.Kind(); ft.rest && k != reflect.String && (k != reflect.Slice || p.elem.size <= 0) {
+		return fmt.Errorf("rest field %s must be a string or a slice of fixed-size elements", f.Name)
+	}
+	if k := f.Type.Kind(); ft.bitmap && ((k != reflect.Slice && k != reflect.Array) || f.Type.Elem().Kind() != reflect.Ptr) {
+		return fmt.Errorf("bitmap field %s must be a slice or array of pointers", f.Name)
+	}
+	if k := f.Type.Kind(); ft.delta && ((k != reflect.Slice && k != reflect.Array) || !isInteger(f.Type.Elem().Kind())) {
+		return fmt.Errorf("delta field %s must be a slice or array of integers", f.Name)
+	}
+	if k := f.Type.Kind(); ft.rle && ((k != reflect.Slice && k != reflect.Array) || p.elem.size <= 0) {
+		return fmt.Errorf("rle field %s must be a slice or array of fixed-size elements", f.Name)
+	}
+	if ft.packbits > 0 {
+		if k := f.Type.Kind(); (k != reflect.Slice && k != reflect.Array) || !isInteger(f.Type.Elem().Kind()) {
+			return fmt.Errorf("packbits field %s must be a slice or array of integers", f.Name)
+		}
+		if ft.packbits > f.Type.Elem().Bits() {
+			return fmt.Errorf("packbits field %s: %d bits don't fit in %s", f.Name, ft.packbits, f.Type.Elem())
+		}
+	}
+	if k := f.Type.Kind(); ft.quantize != reflect.Invalid && ((k != reflect.Slice && k != reflect.Array) ||
+		(f.Type.Elem().Kind() != reflect.Float32 && f.Type.Elem().Kind() != reflect.Float64)) {
+		return fmt.Errorf("quantize field %s must be a slice or array of floats", f.Name)
+	}
+	if (ft.scale == "") != (ft.bias == "") {
+		return fmt.Errorf("field %s needs both scale and bias", f.Name)
+	}
+	if (ft.scale != "" || ft.saturate) && ft.quantize == reflect.Invalid {
+		return fmt.Errorf("scale, bias and saturate on field %s need quantize", f.Name)
+	}
+	if ft.nullable && f.Type.Kind() != reflect.Slice {
+		return fmt.Errorf("nullable field %s must be a slice", f.Name)
+	}
+	if ft.parallel && f.Type.Kind() != reflect.Map {
+		return fmt.Errorf("parallel field %s must be a map", f.Name)
+	}
+	if ft.delimited && f.Type.Kind() != reflect.Struct {
+		return fmt.Errorf("delimited field %s must be a struct", f.Name)
+	}
+	if ft.trimZero && (f.Type.Kind() != reflect.Struct || p.custom || p.optional) {
+		return fmt.Errorf("trimzero field %s must be a struct without a codec of its own", f.Name)
+	}
+	if ft.trimZero && p.placed() {
+		return fmt.Errorf("trimzero field %s: %s has offset=, size= or sizeof= fields, which need every field written", f.Name, f.Type)
+	}
+	if (ft.msb || ft.lsb) && ft.bits == 0 {
+		return fmt.Errorf("msb and lsb on field %s need bits", f.Name)
+	}
+	if ft.msb && ft.lsb {
+		return fmt.Errorf("field %s can't be both msb and lsb", f.Name)
+	}
+	if ft.enum != nil && f.Type.Kind() != reflect.String {
+		return fmt.Errorf("enum field %s must be a string", f.Name)
+	}
+	if ft.fallback && ft.enum == nil {
+		return fmt.Errorf("fallback on field %s needs an enum", f.Name)
+	}
+	return nil
+}
+
+//fieldValue is the value written for field f of the struct v, the field itself
+//unless it carries the count or size of another field
+func (m *marshaler) fieldValue(v reflect.Value, f *fieldPlan, length LengthTypeInstance) reflect.Value {
+	fv := v.Field(f.index)
+	if f.countedBy != nil {
+		return countValue(fv.Type(), v.Field(f.countedBy.index).Len(), f.countedBy.tag)
+	}
+	if f.sizeOf != nil {
+		return m.sizeValue(fv.Type(), v, f.sizeOf, length)
+	}
+	return fv
+}
+
+//fieldLength is the length type of the struct field f in a struct written with length
+func fieldLength(f *fieldPlan, length LengthTypeInstance) LengthTypeInstance {
+	if f.tag == nil {
+		return length
+	}
+	if f.tag.length != nil {
+		length = f.tag.length()
+	}
+	if f.tag.elemLength != nil {
+		length = &elemLength{outer: length, elems: f.tag.elemLength()}
+	}
+	return length
+}
+
+//field writes struct field f of parent with its tag applied, v is usually parent's field
+func (m *marshaler) field(v, parent reflect.Value, f *fieldPlan, length LengthTypeInstance) {
+	length = fieldLength(f, length)
+	if f.tag != nil {
+		m.marshalTagged(v, parent, f, length)
+	} else if isNullable(v.Kind(), length) && f.plan.nullable {
+		m.nullable(v, length)
+	} else {
+		m.marshal(v, length)
+	}
+}
+
+//marshalTagged writes field f of the struct parent, v, in the layout its tag
+//selects, tracing it like marshal does
+func (m *marshaler) marshalTagged(v, parent reflect.Value, f *fieldPlan, length LengthTypeInstance) {
+	start := m.cw.n
+	switch {
+	case f.tag.reserved > 0:
+		m.reserved(v.Type(), f.tag.reserved)
+		return
+	case f.tag.magic != nil:
+		m.magic(v.Type(), f.tag.magic)
+		return
+	case f.tag.codec != nil:
+		m.namedCodec(v, f.tag.codec, length)
+	case f.tag.nullable:
+		//the prefix and the value trace themselves
+		m.nullable(v, length)
+		return
+	case f.tag.count != "":
+		m.elements(v, length)
+	case f.tag.bitmap:
+		m.bitmap(v, length)
+	case f.tag.delta:
+		m.delta(v, length)
+	case f.tag.rle:
+		m.rle(v, length)
+	case f.tag.packbits > 0:
+		m.packbits(v, f.tag.packbits, length)
+	case f.tag.quantize != reflect.Invalid:
+		m.quantize(v, parent, f.tag, length)
+	case f.tag.rest:
+		m.rest(v, length)
+	case f.tag.parallel:
+		m.parallel(v, length)
+	case f.tag.delimited:
+		//the prefix and the struct trace themselves
+		m.delimited(v, length)
+		return
+	case f.tag.trimZero:
+		m.trimZero(v, length)
+	case f.tag.columnar:
+		m.columnar(v, f.plan, length)
+	case f.tag.bcd > 0, f.tag.bcdVar:
+		m.bcd(v, f.tag, length)
+	case f.tag.stringTagged():
+		m.taggedString(v, f.tag, length)
+	case f.tag.enum != nil:
+		m.enum(v, f.tag.enum, f.tag.fallback)
+	case f.tag.ascii > 0:
+		m.ascii(v, f.tag)
+	default:
+		m.marshal(v, length)
+		return
+	}
+	if m.trace != nil {
+		m.emit(v.Type(), start, false)
+	}
+}
+
+//field decodes struct field f of parent into v with its tag applied
+func (u *unmarshaler) field(v, parent reflect.Value, f *fieldPlan, order binary.ByteOrder, length LengthTypeInstance) {
+	length = fieldLength(f, length)
+	if f.tag != nil {
+		u.unmarshalTagged(v, parent, f, order, length)
+	} else if isNullable(v.Kind(), length) && f.plan.nullable {
+		u.nullable(v, order, length)
+	} else {
+		u.unmarshal(v, order, length)
+	}
+}
+
+//unmarshalTagged decodes field f of the struct parent into v, which is usually parent's field
+func (u *unmarshaler) unmarshalTagged(v, parent reflect.Value, f *fieldPlan, order binary.ByteOrder, length LengthTypeInstance) {
+	start := u.cr.n
+	switch {
+	case f.tag.reserved > 0:
+		u.reserved(v.Type(), f.tag.reserved)
+		return
+	case f.tag.magic != nil:
+		u.magic(v.Type(), f.tag.magic)
+		return
+	case f.tag.codec != nil:
+		u.namedCodec(v, f.tag.codec, order, length)
+	case f.tag.nullable:
+		if !hasNull(length) {
+			panic(fmt.Errorf("unmarshal: nullable %s needs a NullableLength or RESPLength", v.Type()))
+		}
+		u.nullable(v, order, length)
+		return
+	case f.tag.count != "":
+		u.counted(v, parent.Field(f.tag.countIndex), f.tag, order, length)
+	case f.tag.bitmap:
+		u.bitmap(v, order, length)
+	case f.tag.delta:
+		u.delta(v, order, length)
+	case f.tag.rle:
+		u.rle(v, order, length)
+	case f.tag.packbits > 0:
+		u.packbits(v, f.tag.packbits, order, length)
+	case f.tag.quantize != reflect.Invalid:
+		u.quantize(v, parent, f.tag, order, length)
+	case f.tag.parallel:
+		u.parallel(v, order, length)
+	case f.tag.delimited:
+		u.delimited(v, order, length)
+		return
+	case f.tag.trimZero:
+		u.trimZero(v, order, length)
+	case f.tag.columnar:
+		u.columnar(v, f.plan, order, length)
+	case f.tag.bcd > 0, f.tag.bcdVar:
+		u.bcd(v, f.tag, order, length)
+	case f.tag.stringTagged():
+		u.taggedString(v, f.tag, order, length)
+	case f.tag.enum != nil:
+		u.enum(v, f.tag.enum, f.tag.fallback, order)
+	case f.tag.ascii > 0:
+		u.ascii(v, f.tag)
+	default:
+		u.unmarshal(v, order, length)
+		return
+	}
+	if u.trace != nil {
+		u.emit(v.Type(), start, false)
+	}
+}
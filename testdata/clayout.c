@@ -0,0 +1,87 @@
+/* Generates the C layout test vectors in clayout_test.go:
+ *
+ *	cc -o clayout testdata/clayout.c && ./clayout
+ *
+ * Structs are zeroed before being filled so padding reads as zero. */
+#include <stdint.h>
+#include <stdio.h>
+#include <string.h>
+
+#define DEFINE(suffix)                                                         \
+	struct mixed##suffix {                                                 \
+		uint8_t c;                                                     \
+		uint32_t i;                                                    \
+		uint16_t s;                                                    \
+	};                                                                     \
+	struct wide##suffix {                                                  \
+		uint8_t c;                                                     \
+		double d;                                                      \
+		int8_t e[3];                                                   \
+	};                                                                     \
+	struct inner##suffix {                                                 \
+		int16_t s;                                                     \
+		uint8_t c;                                                     \
+	};                                                                     \
+	struct nested##suffix {                                                \
+		uint8_t c;                                                     \
+		struct inner##suffix in[2];                                    \
+		uint64_t q;                                                    \
+		float f;                                                       \
+	};
+
+DEFINE()
+#pragma pack(push, 1)
+DEFINE(1)
+#pragma pack(pop)
+#pragma pack(push, 2)
+DEFINE(2)
+#pragma pack(pop)
+#pragma pack(push, 4)
+DEFINE(4)
+#pragma pack(pop)
+
+static void dump(const char *name, int pack, const void *p, size_t n)
+{
+	const unsigned char *b = p;
+	printf("{\"%s\", %d, \"", name, pack);
+	for (size_t i = 0; i < n; i++)
+		printf("%02x", b[i]);
+	printf("\"},\n");
+}
+
+#define DUMP(suffix, pack)                                                     \
+	do {                                                                   \
+		struct mixed##suffix m;                                        \
+		struct wide##suffix w;                                         \
+		struct nested##suffix n;                                       \
+		memset(&m, 0, sizeof m);                                       \
+		memset(&w, 0, sizeof w);                                       \
+		memset(&n, 0, sizeof n);                                       \
+		m.c = 0x11;                                                    \
+		m.i = 0x22334455;                                              \
+		m.s = 0x6677;                                                  \
+		w.c = 0x11;                                                    \
+		w.d = 1.5;                                                     \
+		w.e[0] = 1;                                                    \
+		w.e[1] = -2;                                                   \
+		w.e[2] = 3;                                                    \
+		n.c = 0x11;                                                    \
+		n.in[0].s = -2;                                                \
+		n.in[0].c = 0x22;                                              \
+		n.in[1].s = 0x3344;                                            \
+		n.in[1].c = 0x55;                                              \
+		n.q = 0x0102030405060708;                                      \
+		n.f = 2.0f;                                                    \
+		dump("mixed", pack, &m, sizeof m);                             \
+		dump("wide", pack, &w, sizeof w);                              \
+		dump("nested", pack, &n, sizeof n);                            \
+	} while (0)
+
+int main(void)
+{
+	DUMP(, 0);
+	DUMP(1, 1);
+	DUMP(2, 2);
+	DUMP(4, 4);
+	return 0;
+}
@@ -0,0 +1,184 @@
+package marshal
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+//TraceEvent describes the bytes of one value or length prefix processed by
+//Marshal or Unmarshal, events are reported once the value is complete so
+//nested values are reported before the value containing them
+type TraceEvent struct {
+	//Path names the value, e.g. Foo.Bar.Prop[abc] or Foo.Version[2]
+	Path string
+	//Name is the last element of Path, e.g. Prop[abc] or [2]
+	Name string
+	Kind reflect.Kind
+	Type reflect.Type
+	//Offset of the first byte from the start of the top level value
+	Offset int64
+	Len    int64
+	//Prefix is set for the length prefix of the value at Path
+	Prefix bool
+	//Reserved is set for the zero bytes of a reserved= placeholder field
+	Reserved bool
+	//Magic is set for the bytes of a magic= placeholder field
+	Magic bool
+	//Depth is the nesting level of Path, the top level value has depth 0
+	Depth int
+}
+
+//String formats e like "Foo.Ssid uint16 @ 0x0102 len 2"
+func (e TraceEvent) String() string {
+	return fmt.Sprintf("%s %s @ 0x%04x len %d", e.Path, e.what(), e.Offset, e.Len)
+}
+
+//what describes the value of e, its type or what the bytes stand for
+func (e TraceEvent) what() string {
+	switch {
+	case e.Prefix:
+		return "length"
+	case e.Reserved:
+		return "reserved"
+	case e.Magic:
+		return "magic"
+	}
+	return e.Type.String()
+}
+
+//WithTrace calls fn for every value and length prefix processed, see TraceEvent
+func WithTrace(fn func(TraceEvent)) Option {
+	return func(o *options) {
+		o.trace = fn
+	}
+}
+
+//TraceTo writes a line for every value and length prefix processed to w, see TraceEvent.String
+func TraceTo(w io.Writer) Option {
+	return WithTrace(func(e TraceEvent) {
+		fmt.Fprintln(w, e.String())
+	})
+}
+
+//pathElem is one step of the path from the top level value to the value being processed
+type pathElem struct {
+	//name of a struct field or of the top level type
+	name string
+	//index of a slice or array element, -1 otherwise
+	index int
+	//key of a map entry
+	key   reflect.Value
+	isKey bool
+}
+
+func fieldElem(name string) pathElem {
+	return pathElem{name: name, index: -1}
+}
+
+func indexElem(i int) pathElem {
+	return pathElem{index: i}
+}
+
+func keyElem(k reflect.Value, isKey bool) pathElem {
+	return pathElem{index: -1, key: k, isKey: isKey}
+}
+
+func rootElem(t reflect.Type) pathElem {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	name := t.Name()
+	if name == "" {
+		name = t.String()
+	}
+	return fieldElem(name)
+}
+
+func formatPath(path []pathElem) string {
+	var b strings.Builder
+	for i, e := range path {
+		writePathElem(&b, e, i == 0)
+	}
+	return b.String()
+}
+
+//formatName formats the last element of path
+func formatName(path []pathElem) string {
+	var b strings.Builder
+	if n := len(path); n > 0 {
+		writePathElem(&b, path[n-1], true)
+	}
+	return b.String()
+}
+
+func writePathElem(b *strings.Builder, e pathElem, first bool) {
+	switch {
+	case e.key.IsValid():
+		fmt.Fprintf(b, "[%v]", e.key.Interface())
+	case e.index >= 0:
+		b.WriteByte('[')
+		b.WriteString(strconv.Itoa(e.index))
+		b.WriteByte(']')
+	default:
+		if !first {
+			b.WriteByte('.')
+		}
+		b.WriteString(e.name)
+	}
+	if e.isKey {
+		b.WriteString("#key")
+	}
+}
+
+func (m *marshaler) push(e pathElem) {
+	m.path = append(m.path, e)
+}
+
+func (m *marshaler) pop() {
+	m.path = m.path[:len(m.path)-1]
+}
+
+func (m *marshaler) emit(t reflect.Type, start int64, prefix bool) {
+	m.trace(m.event(t, start, prefix))
+}
+
+func (m *marshaler) event(t reflect.Type, start int64, prefix bool) TraceEvent {
+	return TraceEvent{
+		Path:   formatPath(m.path),
+		Name:   formatName(m.path),
+		Kind:   t.Kind(),
+		Type:   t,
+		Offset: start,
+		Len:    m.cw.n - start,
+		Prefix: prefix,
+		Depth:  len(m.path) - 1,
+	}
+}
+
+func (u *unmarshaler) push(e pathElem) {
+	u.path = append(u.path, e)
+}
+
+func (u *unmarshaler) pop() {
+	u.path = u.path[:len(u.path)-1]
+}
+
+func (u *unmarshaler) emit(t reflect.Type, start int64, prefix bool) {
+	u.trace(u.event(t, start, prefix))
+}
+
+func (u *unmarshaler) event(t reflect.Type, start int64, prefix bool) TraceEvent {
+	return TraceEvent{
+		Path:   formatPath(u.path),
+		Name:   formatName(u.path),
+		Kind:   t.Kind(),
+		Type:   t,
+		Offset: start,
+		Len:    u.cr.n - start,
+		Prefix: prefix,
+		Depth:  len(u.path) - 1,
+	}
+}
@@ -0,0 +1,81 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	var events []TraceEvent
+	collect := WithTrace(func(e TraceEvent) { events = append(events, e) })
+	b, e := MarshalBytes(createStableObject(), binary.BigEndian, BlobLength8, collect)
+	if e != nil {
+		t.Fatalf("marshal: %v", e)
+	}
+	expected := map[string]string{
+		"Foo.Uri":           "Foo.Uri [255]uint8 @ 0x0000 len 255",
+		"Foo.Version":       "Foo.Version []uint8 @ 0x0102 len 6",
+		"Foo.Ssid":          "Foo.Ssid uint16 @ 0x0108 len 2",
+		"Foo.Bar.Id":        "Foo.Bar.Id string @ 0x011a len 4",
+		"Foo.Bar.Prop[abc]": "Foo.Bar.Prop[abc] uint32 @ 0x012b len 4",
+		"Foo.OK":            "Foo.OK bool @ 0x012f len 1",
+		"Foo":               "Foo marshal.Foo @ 0x0000 len 304",
+	}
+	prefixes := map[string]string{
+		"Foo.Version":           "Foo.Version length @ 0x0102 len 1",
+		"Foo.Bar.Id":            "Foo.Bar.Id length @ 0x011a len 1",
+		"Foo.Bar.Prop":          "Foo.Bar.Prop length @ 0x0126 len 1",
+		"Foo.Bar.Prop[abc]#key": "Foo.Bar.Prop[abc]#key length @ 0x0127 len 1",
+	}
+	for _, ev := range events {
+		if ev.Offset < 0 || ev.Offset+ev.Len > int64(len(b)) {
+			t.Errorf("%v: out of range of %d encoded bytes", ev, len(b))
+		}
+		want := expected
+		if ev.Prefix {
+			want = prefixes
+		}
+		if s, ok := want[ev.Path]; ok {
+			if ev.String() != s {
+				t.Errorf("got %q, want %q", ev.String(), s)
+			}
+			delete(want, ev.Path)
+		}
+	}
+	for _, s := range expected {
+		t.Errorf("missing event %q", s)
+	}
+	for _, s := range prefixes {
+		t.Errorf("missing prefix event %q", s)
+	}
+
+	var decoded []string
+	var readBack Foo
+	e = Unmarshal(&readBack, bytes.NewReader(b), binary.BigEndian, BlobLength8, TraceTo(writerFunc(func(p []byte) {
+		decoded = append(decoded, strings.TrimSpace(string(p)))
+	})))
+	if e != nil {
+		t.Fatalf("unmarshal: %v", e)
+	}
+	if len(decoded) != len(events) {
+		t.Fatalf("unmarshal traced %d events, marshal %d", len(decoded), len(events))
+	}
+	for i, ev := range events {
+		if strings.Contains(ev.Path, "#key") {
+			//map keys are only known after they are decoded
+			continue
+		}
+		if decoded[i] != ev.String() {
+			t.Errorf("unmarshal traced %q, marshal %q", decoded[i], ev.String())
+		}
+	}
+}
+
+type writerFunc func(p []byte)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	f(p)
+	return len(p), nil
+}
@@ -0,0 +1,74 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"reflect"
+)
+
+//placed reports whether the struct of plan p has fields whose value depends on
+//where later fields are or how big they are, see offset= and sizeof=
+func (p *typePlan) placed() bool {
+	if p.regions {
+		return true
+	}
+	for i := range p.fields {
+		if p.fields[i].sizeOf != nil {
+			return true
+		}
+	}
+	return false
+}
+
+//trimmedFields is the number of leading fields of the struct v of plan p up to
+//its last non-zero one, a bits group is kept whole
+func trimmedFields(v reflect.Value, p *typePlan) int {
+	for i := len(p.fields) - 1; i >= 0; i-- {
+		f := &p.fields[i]
+		if v.Field(f.index).IsZero() {
+			continue
+		}
+		//the group's fields follow each other in p.fields, skipped fields
+		//aren't there, so struct field indexes don't count them
+		for f.bits != nil && i+1 < len(p.fields) && p.fields[i+1].bits == f.bits {
+			i++
+		}
+		return i + 1
+	}
+	return 0
+}
+
+//trimZero writes the struct v as the count of its fields up to the last non-zero
+//one, then those fields
+func (m *marshaler) trimZero(v reflect.Value, length LengthTypeInstance) {
+	p := planFor(v.Type())
+	if p.err != nil {
+		panic(p.err)
+	}
+	n := trimmedFields(v, p)
+	m.putLength(length, v.Type(), n)
+	m.fields += n
+	m.structFields(v, p, n, length)
+}
+
+//trimZero decodes the fields of the struct v the count before them holds and
+//zeroes the others
+func (u *unmarshaler) trimZero(v reflect.Value, order binary.ByteOrder, length LengthTypeInstance) {
+	p := planFor(v.Type())
+	if p.err != nil {
+		panic(p.err)
+	}
+	n := u.getLength(length, order, v.Type())
+	if n < 0 || n > len(p.fields) {
+		panic(errorf(ErrMalformed, "unmarshal: %s: %d of %d fields", formatPath(u.path), n, len(p.fields)))
+	}
+	if f := &p.fields[max(n-1, 0)]; n > 0 && f.bits != nil && f != f.bits.fields[len(f.bits.fields)-1] {
+		panic(errorf(ErrMalformed, "unmarshal: %s: %d fields end inside the bits group of %s", formatPath(u.path), n, f.name))
+	}
+	u.fields += n
+	u.structFields(v, p, n, order, length)
+	for i := n; i < len(p.fields); i++ {
+		if fv := v.Field(p.fields[i].index); fv.CanSet() {
+			fv.SetZero()
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type trimInner struct {
+	X, Y uint8
+}
+
+type trimSnapshot struct {
+	A     uint16
+	B     string
+	Inner trimInner
+	Flags uint8 `marshal:"bits=3"`
+	Mode  uint8 `marshal:"bits=5"`
+	D     []uint16
+}
+
+type trimMsg struct {
+	Snap trimSnapshot `marshal:"trimzero"`
+	Tail uint8
+}
+
+func TestTrimZero(t *testing.T) {
+	cases := []struct {
+		snap trimSnapshot
+		want []byte
+	}{
+		{trimSnapshot{}, []byte{0, 9}},
+		{trimSnapshot{A: 0x102}, []byte{1, 1, 2, 9}},
+		{trimSnapshot{B: "x"}, []byte{2, 0, 0, 1, 'x', 9}},
+		//structs inside are written whole
+		{trimSnapshot{Inner: trimInner{X: 7}}, []byte{3, 0, 0, 0, 7, 0, 9}},
+		//a bits group is kept whole
+		{trimSnapshot{Flags: 5}, []byte{5, 0, 0, 0, 0, 0, 0xa0, 9}},
+		{trimSnapshot{D: []uint16{3}}, []byte{6, 0, 0, 0, 0, 0, 0, 1, 0, 3, 9}},
+	}
+	for _, c := range cases {
+		v := trimMsg{c.snap, 9}
+		b, err := MarshalBytes(&v, binary.BigEndian, BlobLength8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, c.want) {
+			t.Errorf("%+v: encoded % x, want % x", c.snap, b, c.want)
+		}
+		//the fields left out are zeroed
+		readBack := trimMsg{trimSnapshot{1, "", trimInner{1, 1}, 1, 1, []uint16{1}}, 1}
+		if err := UnmarshalBytes(&readBack, b, binary.BigEndian, BlobLength8); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(readBack, v) {
+			t.Errorf("read back %+v, want %+v", readBack, v)
+		}
+	}
+	var readBack trimMsg
+	if err := UnmarshalBytes(&readBack, []byte{7, 0, 0, 0, 0, 0, 0, 0, 9}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected an error for more fields than the struct has")
+	}
+	if err := UnmarshalBytes(&readBack, []byte{4, 0, 0, 0, 0, 0, 0, 9}, binary.BigEndian, BlobLength8); err == nil || !strings.Contains(err.Error(), "bits group") {
+		t.Errorf("expected an error for a count ending inside a bits group, got %v", err)
+	}
+	//the count prefix comes on top of the fields
+	whole, _, _ := MaxSize(reflect.TypeOf(trimSnapshot{}), BlobLength8)
+	if n, bounded, err := MaxSize(reflect.TypeOf(trimMsg{}), BlobLength8); err != nil || !bounded || n != whole+2 {
+		t.Errorf("MaxSize %d, %v, %v, want %d", n, bounded, err, whole+2)
+	}
+	type fixed struct {
+		S trimInner `marshal:"trimzero"`
+	}
+	if n, bounded, err := MaxSize(reflect.TypeOf(fixed{}), VarintLength(5)); err != nil || !bounded || n != 3 {
+		t.Errorf("MaxSize %d, %v, %v, want 3", n, bounded, err)
+	}
+}
+
+func TestTrimZeroSkip(t *testing.T) {
+	type skipped struct {
+		X uint32 `marshal:"skip"`
+		A uint8
+		F uint8 `marshal:"bits=3"`
+		M uint8 `marshal:"bits=5"`
+	}
+	type msg struct {
+		S skipped `marshal:"trimzero"`
+	}
+	//the count is of the fields on the wire, the skipped one isn't
+	b, err := MarshalBytes(&msg{skipped{X: 7, F: 5}}, binary.BigEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{3, 0, 0xa0}; !bytes.Equal(b, want) {
+		t.Errorf("encoded % x, want % x", b, want)
+	}
+	var readBack msg
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, BlobLength8); err != nil {
+		t.Fatal(err)
+	}
+	if want := (msg{skipped{F: 5}}); readBack != want {
+		t.Errorf("read back %+v, want %+v", readBack, want)
+	}
+}
+
+func TestTrimZeroTag(t *testing.T) {
+	type notStruct struct {
+		A []uint8 `marshal:"trimzero"`
+	}
+	type sized struct {
+		S struct {
+			N uint8 `marshal:"sizeof=B"`
+			B string
+		} `marshal:"trimzero"`
+	}
+	for _, v := range []interface{}{notStruct{}, sized{}} {
+		if err := Validate(v); err == nil {
+			t.Errorf("%T: expected an error", v)
+		}
+	}
+}
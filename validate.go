@@ -0,0 +1,145 @@
+package marshal
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+//Validate checks that values of v's type can be encoded: every marshal tag in it
+//parses, names a registered enum, charset or codec and suits its field, no
+//field has a kind the package can't encode, such as int, uint, uintptr, chan or
+//func, or can't decode, a pointer below the top level, and map keys round trip,
+//see checkMapKey. Pointers below the top level decode with SharedPointers among
+//opts or in bitmap fields, CompileType also knows the length types writing null
+//lengths, which pointer fields decode with. Marshal and Unmarshal would otherwise
+//only report these problems once they reach the field
+func Validate(v interface{}, opts ...Option) error {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return errors.New("marshal: Validate(nil)")
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return newValidator(opts, nil).check(t)
+}
+
+//validator checks types, see Validate
+type validator struct {
+	seen map[reflect.Type]bool
+	//shared is set when pointers decode with SharedPointers
+	shared bool
+	//null is set when the length type writes null lengths, which pointer fields
+	//decode with, see nullable
+	null bool
+}
+
+//newValidator returns a validator for values encoded with opts and length, which
+//may be nil when it isn't known
+func newValidator(opts []Option, length LengthType) *validator {
+	o := newOptions(opts)
+	return &validator{seen: map[reflect.Type]bool{}, shared: o.shared, null: length != nil && hasNull(length())}
+}
+
+func (c *validator) check(t reflect.Type) error {
+	if c.seen[t] {
+		return nil
+	}
+	c.seen[t] = true
+	if !encodable(t) {
+		return errorf(ErrUnsupportedKind, "marshal: can't encode %s", t)
+	}
+	p := planFor(t)
+	if p.err != nil {
+		return p.err
+	}
+	if p.custom {
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		if !c.shared {
+			return errorf(ErrUnsupportedKind, "marshal: can't decode %s below the top level without SharedPointers", t)
+		}
+		return c.check(t.Elem())
+	case reflect.Slice, reflect.Array:
+		return c.check(t.Elem())
+	case reflect.Map:
+		if err := c.check(t.Key()); err != nil {
+			return err
+		}
+		return c.check(t.Elem())
+	case reflect.Struct:
+		for i := range p.fields {
+			f := &p.fields[i]
+			ft := t.Field(f.index).Type
+			if ft.Kind() == reflect.Ptr && c.null && f.plan.nullable {
+				//decoded by nullable
+				ft = ft.Elem()
+			}
+			if f.tag != nil {
+				switch {
+				case f.tag.codec != nil || f.tag.integers():
+					//the codec takes any type, the tag encodes the integers
+					continue
+				case f.tag.bitmap && ft.Elem().Kind() == reflect.Ptr:
+					//the bitmap decodes the pointers
+					ft = ft.Elem().Elem()
+				}
+			}
+			if !encodable(ft) {
+				return errorf(ErrUnsupportedKind, "marshal: %s.%s: can't encode %s", t, f.name, ft)
+			}
+			if err := c.check(ft); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//encodable reports whether the package has an encoding for values of kind t, int,
+//uint and uintptr have no size of their own
+func encodable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer, reflect.Int, reflect.Uint, reflect.Uintptr:
+		return isCustom(t)
+	}
+	return true
+}
+
+//checkMapKey reports whether map keys of type t round trip. Keys may be booleans,
+//integers, floats, complex numbers, strings, pointers, which decode as new
+//pointers with SharedPointers, interfaces and custom types, and arrays and structs of those. Struct
+//fields must be exported, Unmarshal can't set the others. floats reports whether
+//keys may hold floats, Marshal rejects NaN keys, which no lookup finds
+func checkMapKey(t reflect.Type) (floats bool, err error) {
+	if isCustom(t) {
+		return false, nil
+	}
+	switch t.Kind() {
+	case reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128, reflect.Interface:
+		return true, nil
+	case reflect.Ptr:
+		return checkMapKey(t.Elem())
+	case reflect.Array:
+		return checkMapKey(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				return false, fmt.Errorf("key %s has unexported field %s", t, f.Name)
+			}
+			ff, err := checkMapKey(f.Type)
+			if err != nil {
+				return false, err
+			}
+			floats = floats || ff
+		}
+		return floats, nil
+	case reflect.Chan, reflect.UnsafePointer:
+		return false, errorf(ErrUnsupportedKind, "can't encode key %s", t)
+	}
+	return false, nil
+}
@@ -0,0 +1,152 @@
+package marshal
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func init() {
+	RegisterNamedCodec("test.zlib", func(w *Writer, v interface{}) error {
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		zw.Write(v.([]byte))
+		zw.Close()
+		return w.PutBytes(buf.Bytes())
+	}, func(r *Reader, v interface{}) error {
+		b, err := r.Bytes()
+		if err != nil {
+			return err
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		*v.(*[]byte), err = io.ReadAll(zr)
+		return err
+	})
+}
+
+type namedCodecMsg struct {
+	Raw    []byte
+	Packed []byte `marshal:"codec=test.zlib"`
+}
+
+func TestNamedCodec(t *testing.T) {
+	v := namedCodecMsg{Raw: []byte("raw"), Packed: bytes.Repeat([]byte("tile"), 64)}
+	b, err := MarshalBytes(&v, binary.BigEndian, BlobLength16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) >= 2+3+2+len(v.Packed) {
+		t.Errorf("codec didn't compress the field, %d bytes", len(b))
+	}
+	if !bytes.HasPrefix(b, []byte{0, 3, 'r', 'a', 'w'}) {
+		t.Errorf("untagged field changed: % x", b[:5])
+	}
+	var readBack namedCodecMsg
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, BlobLength16); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readBack.Raw, v.Raw) || !bytes.Equal(readBack.Packed, v.Packed) {
+		t.Errorf("decoded %q", readBack)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Validate(&namedCodecMsg{}); err != nil {
+		t.Errorf("valid type: %v", err)
+	}
+	if err := Validate(createStableObject()); err != nil {
+		t.Errorf("Foo: %v", err)
+	}
+	type unknownCodec struct {
+		B []byte `marshal:"codec=nope"`
+	}
+	type nested struct {
+		Items []map[string]unknownCodec
+	}
+	if err := Validate(nested{}); err == nil || !strings.Contains(err.Error(), "nope") {
+		t.Errorf("expected an unknown codec error, got %v", err)
+	}
+	type badKind struct {
+		C chan int
+	}
+	if err := Validate(badKind{}); err == nil || !strings.Contains(err.Error(), "badKind.C") {
+		t.Errorf("expected an error naming the chan field, got %v", err)
+	}
+	//a codec takes any field type
+	type coded struct {
+		C chan int `marshal:"codec=test.zlib"`
+	}
+	if err := Validate(coded{}); err != nil {
+		t.Errorf("codec field: %v", err)
+	}
+	if err := Validate(nil); err == nil {
+		t.Errorf("expected an error for nil")
+	}
+}
+
+type mapKey struct {
+	A uint16
+	B [2]float32
+}
+
+type hiddenKey struct {
+	A uint16
+	b uint16
+}
+
+func TestMapKeys(t *testing.T) {
+	for _, v := range []interface{}{
+		map[mapKey]string{}, map[float64]bool{}, map[interface{}]int32{}, map[[3]int8]int32{},
+	} {
+		if err := Validate(v); err != nil {
+			t.Errorf("%T: %v", v, err)
+		}
+	}
+	//pointer keys decode as new pointers with SharedPointers
+	if err := Validate(map[*string]int32{}, SharedPointers()); err != nil {
+		t.Errorf("pointer keys: %v", err)
+	}
+	if err := Validate(map[*string]int32{}); !errors.Is(err, ErrUnsupportedKind) {
+		t.Errorf("pointer keys: got %v, want ErrUnsupportedKind", err)
+	}
+	m := map[hiddenKey]int{{1, 2}: 3}
+	if err := Validate(m); err == nil || !strings.Contains(err.Error(), "unexported field b") {
+		t.Errorf("expected an unexported field error, got %v", err)
+	}
+	if _, err := MarshalBytes(m, binary.BigEndian, BlobLength8); err == nil || !strings.Contains(err.Error(), "unexported field b") {
+		t.Errorf("expected Marshal to fail up front, got %v", err)
+	}
+	if err := UnmarshalBytes(&m, []byte{0}, binary.BigEndian, BlobLength8); err == nil {
+		t.Errorf("expected Unmarshal to fail up front")
+	}
+	type withMap struct {
+		M map[mapKey]string
+		P map[float64]string `marshal:"parallel"`
+	}
+	v := withMap{M: map[mapKey]string{{1, [2]float32{0, float32(math.NaN())}}: "x"}}
+	if _, err := MarshalBytes(&v, binary.BigEndian, BlobLength8); !errors.Is(err, ErrUnsupportedKind) || !strings.Contains(err.Error(), "withMap.M: NaN key") {
+		t.Errorf("expected a NaN key error naming the field, got %v", err)
+	}
+	v = withMap{P: map[float64]string{1: "a", math.NaN(): "b"}}
+	if _, err := MarshalBytes(&v, binary.BigEndian, BlobLength8); !errors.Is(err, ErrUnsupportedKind) || !strings.Contains(err.Error(), "NaN key") {
+		t.Errorf("expected a NaN key error for a parallel map, got %v", err)
+	}
+	v = withMap{M: map[mapKey]string{{1, [2]float32{2, 3}}: "x"}, P: map[float64]string{1.5: "a"}}
+	b, err := MarshalBytes(&v, binary.BigEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var readBack withMap
+	if err := UnmarshalBytes(&readBack, b, binary.BigEndian, BlobLength8); err != nil || !reflect.DeepEqual(readBack, v) {
+		t.Errorf("decoded %+v, %v", readBack, err)
+	}
+}
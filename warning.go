@@ -0,0 +1,75 @@
+package marshal
+
+import "fmt"
+
+//WarningCode classifies a Warning
+type WarningCode int
+
+const (
+	//WarnReservedNotZero: a reserved= placeholder held bytes other than zero
+	WarnReservedNotZero WarningCode = iota + 1
+	//WarnPaddingNotZero: the padding bits after packbits= values weren't zero
+	WarnPaddingNotZero
+	//WarnTrailingBytes: a delimited value left bytes of its region, they were skipped
+	WarnTrailingBytes
+	//WarnUnknownEnum: an enum code missing from the mapping passed through as a
+	//decimal string under fallback
+	WarnUnknownEnum
+	//WarnUnknownType: an interface value of an unregistered type id was kept as
+	//a placeholder, see KeepUnknown
+	WarnUnknownType
+	//WarnTruncated: a string longer than max= or fixed= was clipped under truncate
+	WarnTruncated
+)
+
+var warningNames = [...]string{
+	WarnReservedNotZero: "reserved not zero",
+	WarnPaddingNotZero:  "padding not zero",
+	WarnTrailingBytes:   "trailing bytes",
+	WarnUnknownEnum:     "unknown enum",
+	WarnUnknownType:     "unknown type",
+	WarnTruncated:       "truncated",
+}
+
+func (c WarningCode) String() string {
+	if c > 0 && int(c) < len(warningNames) {
+		return warningNames[c]
+	}
+	return fmt.Sprintf("WarningCode(%d)", int(c))
+}
+
+//Warning reports input Unmarshal accepted, or output Marshal adjusted, that a
+//stricter reading would refuse. With Strict the decoding ones are errors instead
+type Warning struct {
+	Code WarningCode
+	//Path names the value as TraceEvent.Path does
+	Path string
+	//Offset of the first byte concerned from the start of the top level value
+	Offset int64
+	Detail string
+}
+
+//String formats w like "Msg.Flags @ 0x0004: reserved not zero: byte 0x01"
+func (w Warning) String() string {
+	return fmt.Sprintf("%s @ 0x%04x: %s: %s", w.Path, w.Offset, w.Code, w.Detail)
+}
+
+//OnWarning calls fn for every Warning of a call, Marshal and Unmarshal otherwise
+//proceed silently
+func OnWarning(fn func(Warning)) Option {
+	return func(o *options) {
+		o.warn = fn
+	}
+}
+
+func (m *marshaler) warning(code WarningCode, offset int64, format string, args ...interface{}) {
+	if m.warn != nil {
+		m.warn(Warning{Code: code, Path: formatPath(m.path), Offset: offset, Detail: fmt.Sprintf(format, args...)})
+	}
+}
+
+func (u *unmarshaler) warning(code WarningCode, offset int64, format string, args ...interface{}) {
+	if u.warn != nil {
+		u.warn(Warning{Code: code, Path: formatPath(u.path), Offset: offset, Detail: fmt.Sprintf(format, args...)})
+	}
+}
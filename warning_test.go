@@ -0,0 +1,94 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+type warnEnum struct {
+	Status string `marshal:"enum=testStatus,fallback"`
+}
+
+type warnTruncated struct {
+	S string `marshal:"fixed=3,truncate"`
+}
+
+func TestOnWarning(t *testing.T) {
+	var got []Warning
+	collect := OnWarning(func(w Warning) { got = append(got, w) })
+	check := func(name string, code WarningCode, path string, offset int64) {
+		t.Helper()
+		if len(got) != 1 || got[0].Code != code || got[0].Path != path || got[0].Offset != offset {
+			t.Errorf("%s: got %v, want one %s at %s @ %d", name, got, code, path, offset)
+		}
+		got = nil
+	}
+
+	var reserved reservedHeader
+	if err := UnmarshalBytes(&reserved, []byte{1, 0, 9, 0, 2, 3}, binary.BigEndian, BlobLength8, collect); err != nil {
+		t.Fatal(err)
+	}
+	check("reserved", WarnReservedNotZero, "reservedHeader._", 2)
+
+	b, err := MarshalBytes(&packedSamples{S: []uint16{1}}, binary.BigEndian, BlobLength8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[2] |= 0x05
+	var packed packedSamples
+	if err := UnmarshalBytes(&packed, b, binary.BigEndian, BlobLength8, collect); err != nil {
+		t.Fatal(err)
+	}
+	check("packbits", WarnPaddingNotZero, "packedSamples.S", 2)
+
+	var v2 delimitedV2
+	v2.Ext.B = "xy"
+	if b, err = MarshalBytes(&v2, binary.BigEndian, BlobLength8); err != nil {
+		t.Fatal(err)
+	}
+	var v1 delimitedV1
+	if err := UnmarshalBytes(&v1, b, binary.BigEndian, BlobLength8, collect); err != nil {
+		t.Fatal(err)
+	}
+	check("delimited", WarnTrailingBytes, "delimitedV1.Ext", 4)
+
+	var e warnEnum
+	if err := UnmarshalBytes(&e, []byte{3}, binary.BigEndian, BlobLength8, collect); err != nil || e.Status != "3" {
+		t.Fatalf("decoded %+v, %v", e, err)
+	}
+	check("enum", WarnUnknownEnum, "warnEnum.Status", 0)
+
+	var any []interface{}
+	if err := UnmarshalBytes(&any, []byte{1, 9, 2, 0xee, 0xff}, binary.BigEndian, BlobLength8, KeepUnknown(nil), collect); err != nil {
+		t.Fatal(err)
+	}
+	check("unknown type", WarnUnknownType, "[]interface {}[0]", 3)
+
+	if _, err := MarshalBytes(&warnTruncated{"abcd"}, binary.BigEndian, BlobLength8, collect); err != nil {
+		t.Fatal(err)
+	}
+	check("truncate", WarnTruncated, "warnTruncated.S", 0)
+
+	//Strict turns the decoding ones into errors
+	if err := UnmarshalBytes(&reserved, []byte{1, 0, 9, 0, 2, 3}, binary.BigEndian, BlobLength8, Strict(), collect); err == nil || len(got) != 0 {
+		t.Errorf("strict: %v, %v", err, got)
+	}
+	//clean input warns about nothing
+	if b, err = MarshalBytes(&reservedHeader{Kind: 1}, binary.BigEndian, BlobLength8); err != nil {
+		t.Fatal(err)
+	}
+	if err := UnmarshalBytes(&reserved, b, binary.BigEndian, BlobLength8, collect); err != nil || len(got) != 0 {
+		t.Errorf("clean input: %v, %v", err, got)
+	}
+}
+
+func TestWarningString(t *testing.T) {
+	w := Warning{Code: WarnReservedNotZero, Path: "Msg.Flags", Offset: 4, Detail: "byte 0x01"}
+	if s := w.String(); s != "Msg.Flags @ 0x0004: reserved not zero: byte 0x01" {
+		t.Errorf("formatted %q", s)
+	}
+	if s := WarningCode(99).String(); !strings.Contains(s, "99") {
+		t.Errorf("formatted %q", s)
+	}
+}
@@ -0,0 +1,256 @@
+package marshal
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+)
+
+//Writer writes primitive values with a byte order and length type, for codecs
+//written by hand. Its methods return errors instead of panicking
+type Writer struct {
+	w      io.Writer
+	order  binary.ByteOrder
+	length LengthTypeInstance
+	buf    [8]byte
+	//m is the enclosing marshaler when w is passed to a custom codec
+	m *marshaler
+}
+
+//NewWriter returns a Writer putting values into w
+func NewWriter(w io.Writer, order binary.ByteOrder, length LengthTypeInstance) *Writer {
+	return &Writer{w: w, order: order, length: length}
+}
+
+//Order returns the byte order of w
+func (w *Writer) Order() binary.ByteOrder {
+	return w.order
+}
+
+//LengthInstance returns the length type instance of w
+func (w *Writer) LengthInstance() LengthTypeInstance {
+	return w.length
+}
+
+func (w *Writer) put(n int) error {
+	_, err := w.w.Write(w.buf[:n])
+	return err
+}
+
+//PutUint8 writes a single byte
+func (w *Writer) PutUint8(v uint8) error {
+	w.buf[0] = v
+	return w.put(1)
+}
+
+//PutUint16 writes v in 2 bytes
+func (w *Writer) PutUint16(v uint16) error {
+	w.order.PutUint16(w.buf[:], v)
+	return w.put(2)
+}
+
+//PutUint32 writes v in 4 bytes
+func (w *Writer) PutUint32(v uint32) error {
+	w.order.PutUint32(w.buf[:], v)
+	return w.put(4)
+}
+
+//PutUint64 writes v in 8 bytes
+func (w *Writer) PutUint64(v uint64) error {
+	w.order.PutUint64(w.buf[:], v)
+	return w.put(8)
+}
+
+//PutBool writes v as a byte of 0 or 1
+func (w *Writer) PutBool(v bool) error {
+	if v {
+		return w.PutUint8(1)
+	}
+	return w.PutUint8(0)
+}
+
+//PutFloat32 writes the IEEE 754 bits of v
+func (w *Writer) PutFloat32(v float32) error {
+	return w.PutUint32(math.Float32bits(v))
+}
+
+//PutFloat64 writes the IEEE 754 bits of v
+func (w *Writer) PutFloat64(v float64) error {
+	return w.PutUint64(math.Float64bits(v))
+}
+
+//PutLength writes a length prefix for a value of kind k
+func (w *Writer) PutLength(k reflect.Kind, l int) (err error) {
+	defer recoverError(&err)
+	w.length.PutLength(w.w, w.order, k, l)
+	return
+}
+
+//PutString writes s with its length prefix
+func (w *Writer) PutString(s string) error {
+	if err := w.PutLength(reflect.String, len(s)); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w.w, s)
+	return err
+}
+
+//PutBytes writes b with its length prefix
+func (w *Writer) PutBytes(b []byte) error {
+	if err := w.PutLength(reflect.Slice, len(b)); err != nil {
+		return err
+	}
+	_, err := w.w.Write(b)
+	return err
+}
+
+//Marshal writes v with the reflective encoder, see Marshal. Inside a custom
+//codec v is encoded as part of the enclosing call, with its options and path
+func (w *Writer) Marshal(v interface{}) (err error) {
+	if w.m == nil {
+		_, err = encode(v, w.w, w.order, w.length, noOptions)
+		return
+	}
+	depth := len(w.m.path)
+	defer func() { w.m.path = w.m.path[:depth] }()
+	defer recoverError(&err)
+	w.m.marshal(reflect.ValueOf(v), w.length)
+	return
+}
+
+//Reader reads primitive values with a byte order and length type, for codecs
+//written by hand. Its methods return errors instead of panicking
+type Reader struct {
+	r      io.Reader
+	order  binary.ByteOrder
+	length LengthTypeInstance
+	buf    [8]byte
+	//u is the enclosing unmarshaler when r is passed to a custom codec
+	u *unmarshaler
+}
+
+//NewReader returns a Reader getting values from r
+func NewReader(r io.Reader, order binary.ByteOrder, length LengthTypeInstance) *Reader {
+	return &Reader{r: r, order: order, length: length}
+}
+
+//Order returns the byte order of r
+func (r *Reader) Order() binary.ByteOrder {
+	return r.order
+}
+
+//LengthInstance returns the length type instance of r
+func (r *Reader) LengthInstance() LengthTypeInstance {
+	return r.length
+}
+
+func (r *Reader) get(n int) ([]byte, error) {
+	_, err := io.ReadFull(r.r, r.buf[:n])
+	return r.buf[:n], err
+}
+
+//Uint8 reads a single byte
+func (r *Reader) Uint8() (uint8, error) {
+	b, err := r.get(1)
+	return b[0], err
+}
+
+//Uint16 reads 2 bytes
+func (r *Reader) Uint16() (uint16, error) {
+	b, err := r.get(2)
+	return r.order.Uint16(b), err
+}
+
+//Uint32 reads 4 bytes
+func (r *Reader) Uint32() (uint32, error) {
+	b, err := r.get(4)
+	return r.order.Uint32(b), err
+}
+
+//Uint64 reads 8 bytes
+func (r *Reader) Uint64() (uint64, error) {
+	b, err := r.get(8)
+	return r.order.Uint64(b), err
+}
+
+//Bool reads a byte, any value but 0 is true
+func (r *Reader) Bool() (bool, error) {
+	v, err := r.Uint8()
+	return v != 0, err
+}
+
+//Float32 reads IEEE 754 bits
+func (r *Reader) Float32() (float32, error) {
+	v, err := r.Uint32()
+	return math.Float32frombits(v), err
+}
+
+//Float64 reads IEEE 754 bits
+func (r *Reader) Float64() (float64, error) {
+	v, err := r.Uint64()
+	return math.Float64frombits(v), err
+}
+
+//Length reads a length prefix for a value of kind k
+func (r *Reader) Length(k reflect.Kind) (l int, err error) {
+	defer recoverError(&err)
+	l = r.length.Length(r.r, r.order, k)
+	return
+}
+
+//String reads a length prefixed string
+func (r *Reader) String() (string, error) {
+	b, err := r.payload(reflect.String)
+	return string(b), err
+}
+
+//Bytes reads a length prefixed byte slice
+func (r *Reader) Bytes() ([]byte, error) {
+	return r.payload(reflect.Slice)
+}
+
+func (r *Reader) payload(k reflect.Kind) ([]byte, error) {
+	l, err := r.Length(k)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, l)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+//Unmarshal reads into m with the reflective decoder, see Unmarshal. Inside a
+//custom codec m is decoded as part of the enclosing call, with its options and path
+func (r *Reader) Unmarshal(m interface{}) (err error) {
+	if r.u == nil {
+		_, err = decode(m, r.r, r.order, r.length, noOptions)
+		return
+	}
+	v, err := destination(m)
+	if err != nil {
+		return err
+	}
+	depth := len(r.u.path)
+	defer func() { r.u.path = r.u.path[:depth] }()
+	defer recoverError(&err)
+	r.u.unmarshal(v.Elem(), r.order, r.length)
+	return
+}
+
+//recoverError turns a panic carrying an error into *err, other panics continue
+func recoverError(err *error) {
+	if e := recover(); e != nil {
+		switch v := e.(type) {
+		case error:
+			*err = v
+		case string:
+			*err = errors.New("marshal error:" + v)
+		default:
+			panic(e)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestWriterReader(t *testing.T) {
+	type msg struct {
+		A uint8
+		B uint16
+		C uint32
+		D uint64
+		E bool
+		F float32
+		G float64
+		S string
+		P []byte
+	}
+	v := msg{1, 2, 3, 4, true, 1.5, -2.25, "hello", []byte{9, 8, 7}}
+	expected, err := MarshalBytes(&v, binary.LittleEndian, YYBlobType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf, binary.LittleEndian, YYBlobType())
+	for _, err := range []error{
+		w.PutUint8(v.A), w.PutUint16(v.B), w.PutUint32(v.C), w.PutUint64(v.D),
+		w.PutBool(v.E), w.PutFloat32(v.F), w.PutFloat64(v.G),
+		w.PutString(v.S), w.PutBytes(v.P),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("Writer wrote % x, want % x", buf.Bytes(), expected)
+	}
+
+	r := NewReader(bytes.NewReader(expected), binary.LittleEndian, YYBlobType())
+	var got msg
+	got.A, _ = r.Uint8()
+	got.B, _ = r.Uint16()
+	got.C, _ = r.Uint32()
+	got.D, _ = r.Uint64()
+	got.E, _ = r.Bool()
+	got.F, _ = r.Float32()
+	got.G, _ = r.Float64()
+	got.S, _ = r.String()
+	got.P, err = r.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := firstDiff(reflect.ValueOf(v), reflect.ValueOf(got)); err != nil {
+		t.Error(err)
+	}
+	if _, err := r.Uint8(); err != io.EOF {
+		t.Errorf("read past end: %v, want io.EOF", err)
+	}
+}
+
+func TestWriterLengthError(t *testing.T) {
+	w := NewWriter(io.Discard, binary.BigEndian, CompactLength())
+	if err := w.PutBytes(make([]byte, 0x400000)); err == nil {
+		t.Errorf("expected compact length overflow error")
+	}
+	r := NewReader(bytes.NewReader([]byte{0, 0, 0, 5, 'a'}), binary.BigEndian, BlobLength32())
+	if _, err := r.String(); err != io.ErrUnexpectedEOF {
+		t.Errorf("short string: %v, want io.ErrUnexpectedEOF", err)
+	}
+}
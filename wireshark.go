@@ -0,0 +1,573 @@
+package marshal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//Wireshark writes a Wireshark Lua dissector named protocol for packets holding
+//one value of v's type, written by Marshal with order, length and opts. Each
+//struct is a subtree with a field per struct field, numbers with their width and
+//byte order, strings and byte slices after their length prefix. Encodings it
+//doesn't follow, such as bcd=, are shown as bytes when their size is fixed;
+//otherwise, like after a length prefix of variable width, the rest of the packet
+//is. CLayout and SharedPointers aren't supported
+func Wireshark(v interface{}, protocol string, order binary.ByteOrder, length LengthType, w io.Writer, opts ...Option) error {
+	g, err := newWireshark(protocol, order, length, opts)
+	if err != nil {
+		return err
+	}
+	s, err := Describe(v, opts...)
+	if err != nil {
+		return err
+	}
+	for s.Kind == reflect.Ptr {
+		s = s.Elem
+	}
+	root, err := g.root(s)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	g.header(bw, s.Type.String())
+	fmt.Fprintf(bw, "function proto.dissector(buf, pinfo, tree)\n")
+	fmt.Fprintf(bw, "\tpinfo.cols.protocol = proto.name\n")
+	fmt.Fprintf(bw, "\t%s(buf, tree:add(proto, buf()), 0, %q)\n", root, s.Type.Name())
+	fmt.Fprintf(bw, "end\n")
+	return bw.Flush()
+}
+
+//WiresharkAny is Wireshark for the messages of WriteAny: packets hold a message
+//id then the message of the type registered under it with RegisterMessage. The
+//id is shown with the name of its type, the body of an unregistered id as bytes
+func WiresharkAny(protocol string, order binary.ByteOrder, length LengthType, w io.Writer, opts ...Option) error {
+	g, err := newWireshark(protocol, order, length, opts)
+	if err != nil {
+		return err
+	}
+	messageLock.RLock()
+	ids := make([]uint32, 0, len(messageTypes))
+	types := make(map[uint32]reflect.Type, len(messageTypes))
+	for id, t := range messageTypes {
+		ids = append(ids, id)
+		types[id] = t
+	}
+	messageLock.RUnlock()
+	if len(ids) == 0 {
+		return fmt.Errorf("wireshark: no message is registered with RegisterMessage")
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	roots := make([]string, len(ids))
+	for i, id := range ids {
+		s, err := describeType(types[id], g.o)
+		if err != nil {
+			return err
+		}
+		if roots[i], err = g.root(s); err != nil {
+			return err
+		}
+	}
+	width, idOrder := messageIDFormat(order, g.o)
+	endian, err := kaitaiEndian(idOrder)
+	if err != nil {
+		return fmt.Errorf("wireshark: %s byte order isn't supported", orderName(idOrder))
+	}
+	add, read := "add", "uint"
+	if endian == "le" {
+		add, read = "add_le", "le_uint"
+	}
+	var names strings.Builder
+	names.WriteString("local message_names = {\n")
+	for _, n := range ids {
+		fmt.Fprintf(&names, "\t[%d] = %q,\n", n, types[n].Name())
+	}
+	names.WriteString("}\n")
+	g.prelude = names.String()
+	id := g.field(g.protocol+".message_id", "uint%d(%q, %q, base.DEC, message_names)", width*8, g.protocol+".message_id", "Message ID")
+	body := g.field(g.protocol+".body", "bytes(%q, %q)", g.protocol+".body", "Body")
+
+	bw := bufio.NewWriter(w)
+	g.header(bw, "the messages registered with marshal.RegisterMessage")
+	fmt.Fprintf(bw, "local messages = {\n")
+	for i, n := range ids {
+		fmt.Fprintf(bw, "\t[%d] = {message_names[%d], %s},\n", n, n, roots[i])
+	}
+	fmt.Fprintf(bw, "}\n\n")
+	fmt.Fprintf(bw, "function proto.dissector(buf, pinfo, tree)\n")
+	fmt.Fprintf(bw, "\tpinfo.cols.protocol = proto.name\n")
+	fmt.Fprintf(bw, "\ttree = tree:add(proto, buf())\n")
+	fmt.Fprintf(bw, "\ttree:%s(%s, buf(0, %d))\n", add, id, width)
+	fmt.Fprintf(bw, "\tlocal m = messages[buf(0, %d):%s()]\n", width, read)
+	fmt.Fprintf(bw, "\tif m == nil then\n")
+	fmt.Fprintf(bw, "\t\ttree:add(%s, buf(%d))\n", body, width)
+	fmt.Fprintf(bw, "\t\treturn\n")
+	fmt.Fprintf(bw, "\tend\n")
+	fmt.Fprintf(bw, "\tpinfo.cols.info = m[1]\n")
+	fmt.Fprintf(bw, "\tm[2](buf, tree, %d, m[1])\n", width)
+	fmt.Fprintf(bw, "end\n")
+	return bw.Flush()
+}
+
+type wireshark struct {
+	o        *options
+	protocol string
+	endian   string
+	length   LengthType
+	//names are the names of the dissect functions of Go types, used the names taken
+	names map[reflect.Type]string
+	used  map[string]bool
+	//fields are the ProtoField declarations by key, keys those keys by declaration
+	fields []string
+	keys   map[string]string
+	funcs  []*luaFunc
+	//prelude is Lua the fields refer to, written before them
+	prelude string
+}
+
+type luaFunc struct {
+	name   string
+	b      strings.Builder
+	indent int
+}
+
+func (l *luaFunc) line(format string, args ...interface{}) {
+	l.b.WriteString(strings.Repeat("\t", l.indent))
+	fmt.Fprintf(&l.b, format, args...)
+	l.b.WriteByte('\n')
+}
+
+func newWireshark(protocol string, order binary.ByteOrder, length LengthType, opts []Option) (*wireshark, error) {
+	o := newOptions(opts)
+	if o.pack != 0 || o.shared {
+		return nil, fmt.Errorf("wireshark: CLayout and SharedPointers aren't supported")
+	}
+	for i, c := range protocol {
+		if !(c >= 'a' && c <= 'z' || c == '_' || i > 0 && c >= '0' && c <= '9') {
+			return nil, fmt.Errorf("wireshark: protocol name %q, want lower case letters, digits and underscores", protocol)
+		}
+	}
+	if protocol == "" {
+		return nil, fmt.Errorf("wireshark: empty protocol name")
+	}
+	endian, err := kaitaiEndian(order)
+	if err != nil {
+		return nil, fmt.Errorf("wireshark: %s byte order isn't supported", orderName(order))
+	}
+	return &wireshark{o: o, protocol: protocol, endian: endian, length: length,
+		names: map[reflect.Type]string{}, used: map[string]bool{}, keys: map[string]string{}}, nil
+}
+
+//header writes the comment, the protocol, its fields and the dissect functions
+func (g *wireshark) header(w io.Writer, what string) {
+	order := "big-endian"
+	if g.endian == "le" {
+		order = "little-endian"
+	}
+	fmt.Fprintf(w, "-- Generated from %s by marshal.Wireshark, DO NOT EDIT.\n", what)
+	fmt.Fprintf(w, "-- Integers are %s. Register the dissector for a port, e.g.\n", order)
+	fmt.Fprintf(w, "-- DissectorTable.get(\"tcp.port\"):add(9000, Dissector.get(%q))\n\n", g.protocol)
+	fmt.Fprintf(w, "local proto = Proto(%q, %q)\n", g.protocol, g.protocol)
+	fmt.Fprintf(w, "local f = proto.fields\n")
+	w.Write([]byte(g.prelude))
+	for _, decl := range g.fields {
+		fmt.Fprintf(w, "%s\n", decl)
+	}
+	fmt.Fprintf(w, "\n")
+	names := make([]string, len(g.funcs))
+	for i, l := range g.funcs {
+		names[i] = l.name
+	}
+	fmt.Fprintf(w, "local %s\n\n", strings.Join(names, ", "))
+	for _, l := range g.funcs {
+		fmt.Fprintf(w, "%s\n", l.b.String())
+	}
+}
+
+//root returns the dissect function of the struct s
+func (g *wireshark) root(s *Schema) (string, error) {
+	for s.Kind == reflect.Ptr {
+		s = s.Elem
+	}
+	if s.Kind != reflect.Struct || s.Custom || s.Optional {
+		return "", fmt.Errorf("wireshark: %s is not a struct", s.Type)
+	}
+	return g.dissector(s, s.Type.Name()), nil
+}
+
+//field declares a ProtoField with the constructor and arguments of format under
+//a key made from abbr and returns it, a field declared alike is reused
+func (g *wireshark) field(abbr, format string, args ...interface{}) string {
+	ctor := fmt.Sprintf(format, args...)
+	if key, ok := g.keys[ctor]; ok {
+		return "f." + key
+	}
+	key := g.unique(strings.NewReplacer(".", "_").Replace(strings.TrimPrefix(abbr, g.protocol+".")))
+	g.keys[ctor] = key
+	g.fields = append(g.fields, fmt.Sprintf("f.%s = ProtoField.%s", key, ctor))
+	return "f." + key
+}
+
+//unique returns name, or name with a number when it is taken
+func (g *wireshark) unique(name string) string {
+	n := name
+	for i := 2; g.used[n]; i++ {
+		n = fmt.Sprintf("%s%d", name, i)
+	}
+	g.used[n] = true
+	return n
+}
+
+//dissector returns the name of the dissect function of the struct s, writing it
+//on first use. Anonymous structs are named after name
+func (g *wireshark) dissector(s *Schema, name string) string {
+	if n, ok := g.names[s.Type]; ok {
+		return "dissect_" + n
+	}
+	if s.Type.Name() != "" {
+		name = s.Type.Name()
+	}
+	n := g.unique(snakeCase(name))
+	g.names[s.Type] = n
+	l := &luaFunc{name: "dissect_" + n}
+	g.funcs = append(g.funcs, l)
+	l.line("function %s(buf, tree, offset, label)", l.name)
+	l.indent++
+	l.line("local start = offset")
+	l.line("tree = tree:add(buf(offset), label)")
+	if g.fieldsOf(l, s, n) {
+		l.line("tree:set_len(offset - start)")
+		l.line("return offset")
+	}
+	l.indent--
+	l.line("end")
+	return l.name
+}
+
+//fieldsOf writes the statements dissecting the fields of the struct s whose
+//fields are named under path, it reports false when they ended the function
+func (g *wireshark) fieldsOf(l *luaFunc, s *Schema, path string) bool {
+	for i := 0; i < len(s.Fields); i++ {
+		f := &s.Fields[i]
+		id := snakeCase(f.Name)
+		label := f.Name
+		if f.Name == "_" {
+			id, label = fmt.Sprintf("reserved%d", i), "Reserved"
+		}
+		if f.Bits > 0 {
+			i = g.bits(l, s.Fields, i, path) - 1
+			continue
+		}
+		var ft *fieldTag
+		if f.Tag != "" {
+			//the tag was checked when the plan was built
+			ft, _ = parseTag(f.Tag)
+		}
+		if !g.value(l, f.Schema, ft, f.Tag, path+"."+id, label, "", s.Type.Name()+"_"+f.Name) {
+			return false
+		}
+	}
+	return true
+}
+
+//bits writes the statements dissecting the group of bit fields starting at
+//fields[i] and returns the index of the field after it
+func (g *wireshark) bits(l *luaFunc, fields []SchemaField, i int, path string) int {
+	j := i + 1
+	for j < len(fields) && fields[j].Bits > 0 && fields[j].BitOffset > 0 {
+		j++
+	}
+	last := fields[j-1]
+	n := (last.BitOffset + last.Bits + 7) / 8
+	lsb := strings.Contains(bitOrder(fields[i:j]), "least")
+	if n > 4 {
+		names := make([]string, j-i)
+		for k := i; k < j; k++ {
+			names[k-i] = fields[k].Name
+		}
+		abbr := fmt.Sprintf("%s.bits%d", path, i)
+		f := g.field(abbr, "bytes(%q, %q)", g.abbr(abbr), strings.Join(names, ", "))
+		l.line("tree:add(%s, buf(offset, %d))", f, n)
+		l.line("offset = offset + %d", n)
+		return j
+	}
+	add := "add"
+	if lsb {
+		//the first byte holds the least significant bits
+		add = "add_le"
+	}
+	for k := i; k < j; k++ {
+		b := fields[k]
+		mask := uint64(1)<<uint(b.Bits) - 1
+		if lsb {
+			mask <<= uint(b.BitOffset)
+		} else {
+			mask <<= uint(n*8 - b.BitOffset - b.Bits)
+		}
+		id := snakeCase(b.Name)
+		f := g.field(path+"."+id, "uint%d(%q, %q, base.DEC, nil, 0x%x)", n*8, g.abbr(path+"."+id), b.Name, mask)
+		l.line("tree:%s(%s, buf(offset, %d))", add, f, n)
+	}
+	l.line("offset = offset + %d", n)
+	return j
+}
+
+func (g *wireshark) abbr(path string) string {
+	return g.protocol + "." + path
+}
+
+//add is the method adding numbers in the byte order of the message to a tree
+func (g *wireshark) add() string {
+	if g.endian == "le" {
+		return "add_le"
+	}
+	return "add"
+}
+
+//opaque writes the statements showing size bytes as the value at path, or the
+//rest of the packet ending the function when size is negative
+func (g *wireshark) opaque(l *luaFunc, path, label string, size int, why string) bool {
+	f := g.field(path, "bytes(%q, %q)", g.abbr(path), label)
+	if size < 0 {
+		l.line("tree:add(%s, buf(offset)):append_text(%q)", f, " ("+why+", up to the end of the packet)")
+		l.line("return buf:len()")
+		return false
+	}
+	l.line("tree:add(%s, buf(offset, %d)):append_text(%q)", f, size, " ("+why+")")
+	l.line("offset = offset + %d", size)
+	return true
+}
+
+//fixedPrefix reports whether length prefixes of values of kind k have a width
+//Lua reads as a number
+func (g *wireshark) fixedPrefix(k reflect.Kind) bool {
+	switch lengthWidth(g.length, k) {
+	case 1, 2, 4, 8:
+		return true
+	}
+	return false
+}
+
+//prefix writes the statements reading the length prefix of a value of kind k
+//at path into n, see fixedPrefix
+func (g *wireshark) prefix(l *luaFunc, path, label string, k reflect.Kind) {
+	w := lengthWidth(g.length, k)
+	read := "uint"
+	if w == 8 {
+		read = "uint64"
+	}
+	if g.endian == "le" {
+		read = "le_" + read
+	}
+	if w == 8 {
+		read += "():tonumber("
+	}
+	f := g.field(path+"_len", "uint%d(%q, %q, base.DEC)", w*8, g.abbr(path+"_len"), label+" length")
+	l.line("local n = buf(offset, %d):%s()", w, read)
+	l.line("tree:%s(%s, buf(offset, %d))", g.add(), f, w)
+	l.line("offset = offset + %d", w)
+}
+
+//value writes the statements dissecting the value s at path, written with the
+//field tag ft parsed from tag, into tree. index is the Lua variable holding its
+//index in an array, slice or map, name names anonymous structs. It reports
+//false when the statements ended the function
+func (g *wireshark) value(l *luaFunc, s *Schema, ft *fieldTag, tag, path, label, index, name string) bool {
+	sub := strconv.Quote(label)
+	if index != "" {
+		sub += ` .. "[" .. ` + index + ` .. "]"`
+	}
+	if ft != nil {
+		switch {
+		case ft.reserved > 0:
+			return g.opaque(l, path, label, ft.reserved, "reserved, zero")
+		case ft.magic != nil:
+			return g.opaque(l, path, label, len(ft.magic), fmt.Sprintf("magic % x", ft.magic))
+		case ft.fixed > 0:
+			f := g.field(path, "string(%q, %q)", g.abbr(path), label)
+			l.line("tree:add_packet_field(%s, buf(offset, %d), %s)", f, ft.fixed, luaEncoding(ft))
+			l.line("offset = offset + %d", ft.fixed)
+			return true
+		case ft.delimited && g.fixedPrefix(reflect.Struct):
+			l.line("do")
+			l.indent++
+			g.prefix(l, path, label, reflect.Struct)
+			l.line("%s(buf, tree, offset, %s)", g.dissector(s, name), sub)
+			l.line("offset = offset + n")
+			l.indent--
+			l.line("end")
+			return true
+		case opaqueTag(ft):
+			return g.opaque(l, path, label, s.Size, "marshal:\""+tag+"\"")
+		}
+	}
+	if s.Custom || s.Optional {
+		why := "encoded by its codec"
+		if s.Optional {
+			why = "optional value"
+		}
+		return g.opaque(l, path, label, s.Size, why)
+	}
+	if t := luaScalar(s.Kind); t != "" {
+		format := "%s(%q, %q)"
+		if strings.HasPrefix(t, "int") || strings.HasPrefix(t, "uint") {
+			format = "%s(%q, %q, base.DEC)"
+		}
+		f := g.field(path, format, t, g.abbr(path), label)
+		l.line("tree:%s(%s, buf(offset, %d))", g.add(), f, s.Size)
+		l.line("offset = offset + %d", s.Size)
+		return true
+	}
+	switch s.Kind {
+	case reflect.Complex64, reflect.Complex128:
+		return g.opaque(l, path, label, s.Size, "real and imaginary parts")
+	case reflect.String:
+		if !g.fixedPrefix(reflect.String) {
+			return g.opaque(l, path, label, -1, "length prefix of variable width")
+		}
+		l.line("do")
+		l.indent++
+		g.prefix(l, path, label, reflect.String)
+		f := g.field(path, "string(%q, %q)", g.abbr(path), label)
+		l.line("tree:add_packet_field(%s, buf(offset, n), %s)", f, luaEncoding(ft))
+		l.line("offset = offset + n")
+		l.indent--
+		l.line("end")
+		return true
+	case reflect.Struct:
+		l.line("offset = %s(buf, tree, offset, %s)", g.dissector(s, name), sub)
+		return true
+	case reflect.Ptr:
+		return g.value(l, s.Elem, nil, "", path, label, index, name)
+	case reflect.Array:
+		if s.Elem.Kind == reflect.Uint8 && !s.Elem.Custom {
+			f := g.field(path, "bytes(%q, %q)", g.abbr(path), label)
+			l.line("tree:add(%s, buf(offset, %d))", f, s.Len)
+			l.line("offset = offset + %d", s.Len)
+			return true
+		}
+		if !g.walkable(s.Elem) {
+			return g.opaque(l, path, label, s.Size, "elements it can't walk")
+		}
+		l.line("do")
+		l.indent++
+		l.line("local n = %d", s.Len)
+		g.loop(l, s, path, label, sub, name)
+		return true
+	case reflect.Slice, reflect.Map:
+		if !g.fixedPrefix(s.Kind) {
+			return g.opaque(l, path, label, -1, "length prefix of variable width")
+		}
+		l.line("do")
+		l.indent++
+		g.prefix(l, path, label, s.Kind)
+		if s.Kind == reflect.Slice && s.Elem.Kind == reflect.Uint8 && !s.Elem.Custom {
+			f := g.field(path, "bytes(%q, %q)", g.abbr(path), label)
+			l.line("tree:add(%s, buf(offset, n))", f)
+			l.line("offset = offset + n")
+			l.indent--
+			l.line("end")
+			return true
+		}
+		if !g.walkable(s.Elem) || s.Key != nil && !g.walkable(s.Key) {
+			g.opaque(l, path, label, -1, "elements it can't walk")
+			l.indent--
+			l.line("end")
+			return false
+		}
+		g.loop(l, s, path, label, sub, name)
+		return true
+	}
+	return g.opaque(l, path, label, -1, s.Kind.String()+" value")
+}
+
+//loop writes the loop dissecting the n elements of the array, slice or map s
+//into a subtree labeled sub, then closes the block opened by the caller
+func (g *wireshark) loop(l *luaFunc, s *Schema, path, label, sub, name string) {
+	l.line("local start = offset")
+	l.line("local tree = tree:add(buf(offset), %s)", sub)
+	l.line("for i = 0, n - 1 do")
+	l.indent++
+	if s.Key != nil {
+		g.value(l, s.Key, nil, "", path+".key", label+" key", "i", name+"_key")
+		g.value(l, s.Elem, nil, "", path+".value", label+" value", "i", name+"_value")
+	} else {
+		g.value(l, s.Elem, nil, "", path, label, "i", name+"_item")
+	}
+	l.indent--
+	l.line("end")
+	l.line("tree:set_len(offset - start)")
+	l.indent--
+	l.line("end")
+}
+
+//walkable reports whether the values of s can be dissected one after another
+func (g *wireshark) walkable(s *Schema) bool {
+	switch {
+	case s.Custom || s.Optional:
+		return s.Size >= 0
+	case luaScalar(s.Kind) != "":
+		return true
+	}
+	switch s.Kind {
+	case reflect.Complex64, reflect.Complex128, reflect.Struct:
+		return true
+	case reflect.Ptr, reflect.Array:
+		return g.walkable(s.Elem)
+	case reflect.String, reflect.Slice, reflect.Map:
+		if !g.fixedPrefix(s.Kind) {
+			return false
+		}
+		return s.Kind == reflect.String || g.walkable(s.Elem) && (s.Key == nil || g.walkable(s.Key))
+	}
+	return false
+}
+
+//luaScalar is the ProtoField constructor of numbers and booleans of kind k
+func luaScalar(k reflect.Kind) string {
+	switch k {
+	case reflect.Bool:
+		return "bool"
+	case reflect.Uint8:
+		return "uint8"
+	case reflect.Uint16:
+		return "uint16"
+	case reflect.Uint32:
+		return "uint32"
+	case reflect.Uint64:
+		return "uint64"
+	case reflect.Int8:
+		return "int8"
+	case reflect.Int16:
+		return "int16"
+	case reflect.Int32:
+		return "int32"
+	case reflect.Int64:
+		return "int64"
+	case reflect.Float32:
+		return "float"
+	case reflect.Float64:
+		return "double"
+	}
+	return ""
+}
+
+//luaEncoding is the Wireshark encoding of a string written with the field tag ft
+func luaEncoding(ft *fieldTag) string {
+	if ft != nil {
+		switch cs := ft.charset.(type) {
+		case latin1:
+			return "ENC_ISO_8859_1"
+		case *singleByte:
+			if cs.name == "ebcdic037" {
+				return "ENC_EBCDIC_CP037"
+			}
+		}
+	}
+	return "ENC_UTF_8"
+}
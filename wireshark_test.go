@@ -0,0 +1,187 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestWireshark(t *testing.T) {
+	out := new(bytes.Buffer)
+	if err := Wireshark(&cHeaderMsg{}, "chdr", binary.LittleEndian, BlobLength16, out); err != nil {
+		t.Fatal(err)
+	}
+	expected := `-- Generated from marshal.cHeaderMsg by marshal.Wireshark, DO NOT EDIT.
+-- Integers are little-endian. Register the dissector for a port, e.g.
+-- DissectorTable.get("tcp.port"):add(9000, Dissector.get("chdr"))
+
+local proto = Proto("chdr", "chdr")
+local f = proto.fields
+f.c_header_msg_magic = ProtoField.bytes("chdr.c_header_msg.magic", "Magic")
+f.c_header_msg_version = ProtoField.uint16("chdr.c_header_msg.version", "Version", base.DEC)
+f.c_header_msg_reserved2 = ProtoField.bytes("chdr.c_header_msg.reserved2", "Reserved")
+f.schema_flags_a = ProtoField.uint8("chdr.schema_flags.a", "A", base.DEC, nil, 0x7)
+f.schema_flags_b = ProtoField.uint8("chdr.schema_flags.b", "B", base.DEC, nil, 0x8)
+f.schema_flags_c = ProtoField.uint8("chdr.schema_flags.c", "C", base.DEC, nil, 0xf0)
+f.c_header_msg_code = ProtoField.string("chdr.c_header_msg.code", "Code")
+f.c_inner_s = ProtoField.int16("chdr.c_inner.s", "S", base.DEC)
+f.c_inner_c = ProtoField.uint8("chdr.c_inner.c", "C", base.DEC)
+f.c_header_msg_scale = ProtoField.double("chdr.c_header_msg.scale", "Scale")
+f.c_header_msg_name_len = ProtoField.uint16("chdr.c_header_msg.name_len", "Name length", base.DEC)
+f.c_header_msg_name = ProtoField.string("chdr.c_header_msg.name", "Name")
+f.c_header_msg_items_len = ProtoField.uint16("chdr.c_header_msg.items_len", "Items length", base.DEC)
+f.c_header_msg_tail = ProtoField.uint32("chdr.c_header_msg.tail", "Tail", base.DEC)
+
+local dissect_c_header_msg, dissect_schema_flags, dissect_c_inner
+
+function dissect_c_header_msg(buf, tree, offset, label)
+	local start = offset
+	tree = tree:add(buf(offset), label)
+	tree:add(f.c_header_msg_magic, buf(offset, 4))
+	offset = offset + 4
+	tree:add_le(f.c_header_msg_version, buf(offset, 2))
+	offset = offset + 2
+	tree:add(f.c_header_msg_reserved2, buf(offset, 2)):append_text(" (reserved, zero)")
+	offset = offset + 2
+	offset = dissect_schema_flags(buf, tree, offset, "Flags")
+	tree:add_packet_field(f.c_header_msg_code, buf(offset, 6), ENC_UTF_8)
+	offset = offset + 6
+	offset = dissect_c_inner(buf, tree, offset, "Point")
+	tree:add_le(f.c_header_msg_scale, buf(offset, 8))
+	offset = offset + 8
+	do
+		local n = buf(offset, 2):le_uint()
+		tree:add_le(f.c_header_msg_name_len, buf(offset, 2))
+		offset = offset + 2
+		tree:add_packet_field(f.c_header_msg_name, buf(offset, n), ENC_UTF_8)
+		offset = offset + n
+	end
+	do
+		local n = buf(offset, 2):le_uint()
+		tree:add_le(f.c_header_msg_items_len, buf(offset, 2))
+		offset = offset + 2
+		local start = offset
+		local tree = tree:add(buf(offset), "Items")
+		for i = 0, n - 1 do
+			offset = dissect_c_inner(buf, tree, offset, "Items" .. "[" .. i .. "]")
+		end
+		tree:set_len(offset - start)
+	end
+	tree:add_le(f.c_header_msg_tail, buf(offset, 4))
+	offset = offset + 4
+	tree:set_len(offset - start)
+	return offset
+end
+
+function dissect_schema_flags(buf, tree, offset, label)
+	local start = offset
+	tree = tree:add(buf(offset), label)
+	tree:add_le(f.schema_flags_a, buf(offset, 1))
+	tree:add_le(f.schema_flags_b, buf(offset, 1))
+	tree:add_le(f.schema_flags_c, buf(offset, 1))
+	offset = offset + 1
+	tree:set_len(offset - start)
+	return offset
+end
+
+function dissect_c_inner(buf, tree, offset, label)
+	local start = offset
+	tree = tree:add(buf(offset), label)
+	tree:add_le(f.c_inner_s, buf(offset, 2))
+	offset = offset + 2
+	tree:add_le(f.c_inner_c, buf(offset, 1))
+	offset = offset + 1
+	tree:set_len(offset - start)
+	return offset
+end
+
+function proto.dissector(buf, pinfo, tree)
+	pinfo.cols.protocol = proto.name
+	dissect_c_header_msg(buf, tree:add(proto, buf()), 0, "cHeaderMsg")
+end
+`
+	if out.String() != expected {
+		t.Errorf("Wireshark output:\n%s\nwant:\n%s", out, expected)
+	}
+	if err := Wireshark(uint8(0), "chdr", binary.LittleEndian, BlobLength16, out); err == nil {
+		t.Errorf("expected an error for a non-struct")
+	}
+	if err := Wireshark(&cHeaderMsg{}, "Chdr", binary.LittleEndian, BlobLength16, out); err == nil {
+		t.Errorf("expected an error for an upper case protocol name")
+	}
+	if err := Wireshark(&cHeaderMsg{}, "chdr", PDPEndian, BlobLength16, out); err == nil {
+		t.Errorf("expected an error for PDPEndian")
+	}
+}
+
+func TestWiresharkAny(t *testing.T) {
+	out := new(bytes.Buffer)
+	if err := WiresharkAny("mux", binary.BigEndian, BlobLength8, out); err != nil {
+		t.Fatal(err)
+	}
+	expected := `-- Generated from the messages registered with marshal.RegisterMessage by marshal.Wireshark, DO NOT EDIT.
+-- Integers are big-endian. Register the dissector for a port, e.g.
+-- DissectorTable.get("tcp.port"):add(9000, Dissector.get("mux"))
+
+local proto = Proto("mux", "mux")
+local f = proto.fields
+local message_names = {
+	[1] = "muxLogin",
+	[2] = "muxPing",
+}
+f.mux_login_user_len = ProtoField.uint8("mux.mux_login.user_len", "User length", base.DEC)
+f.mux_login_user = ProtoField.string("mux.mux_login.user", "User")
+f.mux_login_code = ProtoField.uint16("mux.mux_login.code", "Code", base.DEC)
+f.mux_ping_seq = ProtoField.uint32("mux.mux_ping.seq", "Seq", base.DEC)
+f.message_id = ProtoField.uint16("mux.message_id", "Message ID", base.DEC, message_names)
+f.body = ProtoField.bytes("mux.body", "Body")
+
+local dissect_mux_login, dissect_mux_ping
+
+function dissect_mux_login(buf, tree, offset, label)
+	local start = offset
+	tree = tree:add(buf(offset), label)
+	do
+		local n = buf(offset, 1):uint()
+		tree:add(f.mux_login_user_len, buf(offset, 1))
+		offset = offset + 1
+		tree:add_packet_field(f.mux_login_user, buf(offset, n), ENC_UTF_8)
+		offset = offset + n
+	end
+	tree:add(f.mux_login_code, buf(offset, 2))
+	offset = offset + 2
+	tree:set_len(offset - start)
+	return offset
+end
+
+function dissect_mux_ping(buf, tree, offset, label)
+	local start = offset
+	tree = tree:add(buf(offset), label)
+	tree:add(f.mux_ping_seq, buf(offset, 4))
+	offset = offset + 4
+	tree:set_len(offset - start)
+	return offset
+end
+
+local messages = {
+	[1] = {message_names[1], dissect_mux_login},
+	[2] = {message_names[2], dissect_mux_ping},
+}
+
+function proto.dissector(buf, pinfo, tree)
+	pinfo.cols.protocol = proto.name
+	tree = tree:add(proto, buf())
+	tree:add(f.message_id, buf(0, 2))
+	local m = messages[buf(0, 2):uint()]
+	if m == nil then
+		tree:add(f.body, buf(2))
+		return
+	end
+	pinfo.cols.info = m[1]
+	m[2](buf, tree, 2, m[1])
+end
+`
+	if out.String() != expected {
+		t.Errorf("WiresharkAny output:\n%s\nwant:\n%s", out, expected)
+	}
+}